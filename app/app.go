@@ -3,28 +3,184 @@ package Dingo
 
 import (
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/covrom/dingo/app/handler"
 	"github.com/covrom/dingo/app/model"
 	"github.com/dinever/golf"
 )
 
+const (
+	// schedulerInterval is how often the scheduled posts are checked.
+	schedulerInterval = time.Minute
+	// backupInterval is how often the scheduled backups are checked.
+	backupInterval = time.Hour
+	// backupDir is the directory of the scheduled backups.
+	backupDir = "backup"
+)
+
 // Init loads a public and private key pair used to create and validate JSON
 // web tokens, or creates a new pair if they don't exist. It also initializes
-// the database connection.
-func Init(dbPath, privKey, pubKey string) {
+// the database connection and the media store of the files uploaded to
+// uploadDir, starts publishing the scheduled posts and backing up the blog.
+func Init(dbPath, privKey, pubKey, mediaUrl, uploadDir string) {
 	model.InitializeKey(privKey, pubKey)
 	if err := model.Initialize(dbPath, false); err != nil {
 		err = fmt.Errorf("failed to intialize db: %v", err)
 		panic(err)
 	}
 	fmt.Printf("Database is used at %s\n", dbPath)
+	if err := model.InitializeMedia(mediaUrl, uploadDir); err != nil {
+		panic(fmt.Errorf("failed to initialize media store: %v", err))
+	}
+	go model.RunScheduler(schedulerInterval)
+	go model.RunBackups(backupInterval, backupDir, uploadDir)
+}
+
+// Backup writes a backup archive of the blog and the given upload directory
+// to file, or to a new dated file of the working directory if file is empty.
+// It returns the path of the archive.
+func Backup(dbPath, file, uploadDir string) (string, error) {
+	if err := model.Initialize(dbPath, false); err != nil {
+		return "", fmt.Errorf("failed to intialize db: %v", err)
+	}
+	if file == "" {
+		return model.BackupToDir(".", uploadDir)
+	}
+	b, err := model.NewBackup()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	if err := b.WriteArchive(f, uploadDir); err != nil {
+		f.Close()
+		return "", err
+	}
+	return file, f.Close()
+}
+
+// Restore restores the backup archive file into the blog and the given upload
+// directory. The content of the blog is replaced, unless merge is set.
+func Restore(dbPath, file, uploadDir string, merge bool) error {
+	if err := model.Initialize(dbPath, false); err != nil {
+		return fmt.Errorf("failed to intialize db: %v", err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	b, err := model.ReadBackup(f, info.Size())
+	if err != nil {
+		return err
+	}
+	return b.Restore(uploadDir, merge)
+}
+
+// Import imports the export file of the named blog engine, guessed from the
+// content of the file if engine is empty. Nothing is saved with a dry run.
+func Import(dbPath, file, engine string, dryRun bool) (*model.ImportReport, error) {
+	if err := model.Initialize(dbPath, false); err != nil {
+		return nil, fmt.Errorf("failed to intialize db: %v", err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	i, err := model.ReadImport(f, engine)
+	if err != nil {
+		return nil, err
+	}
+	return i.Run(dryRun)
+}
+
+// ExportStatic renders the public pages of the blog to static files in dir,
+// along with the assets of the theme and the given upload directory.
+func ExportStatic(dbPath, dir, uploadDir string) (*handler.StaticReport, error) {
+	if err := model.Initialize(dbPath, false); err != nil {
+		return nil, fmt.Errorf("failed to intialize db: %v", err)
+	}
+	return handler.ExportStatic(dir, uploadDir)
+}
+
+// MigrateMedia moves the files of the given upload directory to the media
+// store of the URL, and rewrites the references to them in the posts. The
+// local files are kept if keep is set.
+func MigrateMedia(dbPath, mediaUrl, uploadDir string, keep bool) (*model.MediaMigrationReport, error) {
+	if err := model.Initialize(dbPath, false); err != nil {
+		return nil, fmt.Errorf("failed to intialize db: %v", err)
+	}
+	to, err := model.NewMediaStore(mediaUrl, uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := to.(*model.LocalMediaStore); ok {
+		return nil, fmt.Errorf("the media store to migrate to must not be local")
+	}
+	from := &model.LocalMediaStore{Dir: uploadDir, BaseUrl: "/upload/"}
+	return model.MigrateMedia(from, to, keep)
+}
+
+// ResizeImages generates the missing sizes of the images of the given upload
+// directory, and returns their keys.
+func ResizeImages(dbPath, uploadDir string) ([]string, error) {
+	if err := model.Initialize(dbPath, false); err != nil {
+		return nil, fmt.Errorf("failed to intialize db: %v", err)
+	}
+	return model.ResizeImages(&model.LocalMediaStore{Dir: uploadDir, BaseUrl: "/upload/"})
+}
+
+// IndexMedia records the files of the given upload directory missing from the
+// media library, and the files used by every post. It returns the keys of
+// the files recorded.
+func IndexMedia(dbPath, uploadDir string) ([]string, error) {
+	if err := model.Initialize(dbPath, false); err != nil {
+		return nil, fmt.Errorf("failed to intialize db: %v", err)
+	}
+	return model.IndexMedia(&model.LocalMediaStore{Dir: uploadDir, BaseUrl: "/upload/"})
+}
+
+// RenderPosts renders the HTML of every post and page again with the Markdown
+// pipeline of the settings, the links to the media pointing to the given
+// store. It returns the slugs of the posts whose HTML changed.
+func RenderPosts(dbPath, mediaUrl, uploadDir string) ([]string, error) {
+	if err := model.Initialize(dbPath, false); err != nil {
+		return nil, fmt.Errorf("failed to intialize db: %v", err)
+	}
+	if err := model.InitializeMedia(mediaUrl, uploadDir); err != nil {
+		return nil, fmt.Errorf("failed to initialize media store: %v", err)
+	}
+	return model.RenderPosts()
 }
 
-// Run starts our HTTP server on the given port.
-func Run(portNumber string) {
+// Run starts our HTTP server on the given port, serving the files of the
+// given upload directory. The page views counted in memory are saved when the
+// server is interrupted or terminated.
+func Run(portNumber, uploadDir string) {
 	app := golf.New()
+	app.Config.Set("app/upload_dir", uploadDir)
 	app = handler.Initialize(app)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		if err := handler.FlushStats(); err != nil {
+			log.Printf("[Error]: Can not save page views: %v", err)
+		}
+		os.Exit(0)
+	}()
 	fmt.Printf("Application Started on port %s\n", portNumber)
 	app.Run(":" + portNumber)
 }
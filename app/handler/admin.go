@@ -2,6 +2,8 @@ package handler
 
 import (
 	"fmt"
+	"log"
+	"net/http"
 	"strconv"
 
 	"github.com/covrom/dingo/app/model"
@@ -76,6 +78,9 @@ func PostSaveHandler(ctx *golf.Context) {
 		p.Id = bson.ObjectIdHex(id)
 	}
 	p.UpdateFromRequest(ctx.Request)
+	if !canEditPost(ctx, u, p.Id) {
+		return
+	}
 	p.CreatedBy = u.Id.Hex()
 	p.UpdatedBy = u.Id.Hex()
 	p.IsPage = false
@@ -91,6 +96,7 @@ func PostSaveHandler(ctx *golf.Context) {
 		})
 		return
 	}
+	model.DeleteAutosave(p.Id.Hex(), u.Id.Hex())
 	ctx.JSON(map[string]interface{}{
 		"status":  "success",
 		"content": p,
@@ -112,14 +118,30 @@ func ContentSaveHandler(ctx *golf.Context) {
 		return
 	}
 	p.Id = bson.ObjectIdHex(id)
+	if !canEditPost(ctx, u, p.Id) {
+		return
+	}
 	p.GetPostById()
 	p.UpdateFromRequest(ctx.Request)
-	p.Html = utils.Markdown2Html(p.Markdown)
+	if p.Id.Hex() != id && !canEditPost(ctx, u, p.Id) {
+		return
+	}
+	p.Html = model.RenderMarkdown(p.Markdown)
 	p.UpdatedBy = u.Id.Hex()
 	p.Hits = 1
 	tags := model.GenerateTagsFromCommaString(ctx.Request.FormValue("tag"))
 	var e error
 	e = p.Save(tags...)
+	if conflict, ok := e.(*model.ConflictError); ok {
+		ctx.SendStatus(http.StatusConflict)
+		ctx.JSON(map[string]interface{}{
+			"status":  "error",
+			"msg":     "This post was changed by " + conflict.Current.Editor().Name + " since you opened it.",
+			"content": p,
+			"current": conflict.Current,
+		})
+		return
+	}
 	if e != nil {
 		ctx.SendStatus(400)
 		ctx.JSON(map[string]interface{}{
@@ -128,6 +150,7 @@ func ContentSaveHandler(ctx *golf.Context) {
 		})
 		return
 	}
+	model.DeleteAutosave(p.Id.Hex(), u.Id.Hex())
 	ctx.JSON(map[string]interface{}{
 		"status":  "success",
 		"content": p,
@@ -144,8 +167,9 @@ func AdminPostHandler(ctx *golf.Context) {
 	} else {
 		page, _ = strconv.Atoi(p)
 	}
+	q := ctx.Request.FormValue("q")
 	posts := new(model.Posts)
-	pager, err := posts.GetPostList(int64(page), 10, false, false, "created_at DESC")
+	pager, err := listPosts(posts, q, int64(page), false, "created_at DESC")
 	if err != nil {
 		panic(err)
 	}
@@ -154,9 +178,19 @@ func AdminPostHandler(ctx *golf.Context) {
 		"Posts": posts,
 		"User":  u,
 		"Pager": pager,
+		"Query": q,
 	})
 }
 
+// listPosts gets a page of the posts or pages listed in the admin panel,
+// filtered by the search query if it is not empty.
+func listPosts(posts *model.Posts, q string, page int64, isPage bool, orderBy string) (*utils.Pager, error) {
+	if q == "" {
+		return posts.GetPostList(page, 10, isPage, false, orderBy)
+	}
+	return posts.SearchPostList(q, page, 10, &isPage, false, orderBy)
+}
+
 func ContentEditHandler(ctx *golf.Context) {
 	userObj, _ := ctx.Session.Get("user")
 	u := userObj.(*model.User)
@@ -168,16 +202,126 @@ func ContentEditHandler(ctx *golf.Context) {
 		ctx.Redirect("/admin/posts/")
 		return
 	}
-	ctx.Loader("admin").Render("edit_post.html", map[string]interface{}{
-		"Title": "Edit Post",
-		"Post":  p,
-		"User":  u,
+	if !u.CanEditPost(p) {
+		ctx.Abort(http.StatusForbidden)
+		return
+	}
+	revisions, _ := p.GetRevisions()
+	data := map[string]interface{}{
+		"Title":         "Edit Post",
+		"Post":          p,
+		"User":          u,
+		"Revisions":     revisions,
+		"Previews":      true,
+		"PreviewRevoke": u.Can(model.PermPreviewRevoke),
+	}
+	if a, err := model.GetAutosave(p.Id.Hex(), u.Id.Hex()); err == nil && a.IsNewer(p) {
+		data["Autosave"] = a
+	}
+	ctx.Loader("admin").Render("edit_post.html", data)
+}
+
+// getEditedPost gets the post being edited from the id in the URL, and
+// answers with an error if it does not exist or the user is not allowed to
+// edit it.
+func getEditedPost(ctx *golf.Context, u *model.User) *model.Post {
+	id := ctx.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		ctx.SendStatus(http.StatusNotFound)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Post not found.",
+		})
+		return nil
+	}
+	p := &model.Post{Id: bson.ObjectIdHex(id)}
+	if err := p.GetPostById(); err != nil {
+		ctx.SendStatus(http.StatusNotFound)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Post not found.",
+		})
+		return nil
+	}
+	if !canEditPost(ctx, u, p.Id) {
+		return nil
+	}
+	return p
+}
+
+// RevisionDiffHandler answers with the line diff between the two revisions
+// given by the `from` and `to` query parameters.
+func RevisionDiffHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	p := getEditedPost(ctx, u)
+	if p == nil {
+		return
+	}
+	from, err := p.GetRevision(ctx.Request.FormValue("from"))
+	if err != nil {
+		ctx.SendStatus(http.StatusNotFound)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Revision not found.",
+		})
+		return
+	}
+	to, err := p.GetRevision(ctx.Request.FormValue("to"))
+	if err != nil {
+		ctx.SendStatus(http.StatusNotFound)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Revision not found.",
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"diff":   to.Diff(from),
+	})
+}
+
+// RevisionRestoreHandler brings the post back to the revision given by the
+// `rev` form value.
+func RevisionRestoreHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	p := getEditedPost(ctx, u)
+	if p == nil {
+		return
+	}
+	r, err := p.GetRevision(ctx.Request.FormValue("rev"))
+	if err != nil {
+		ctx.SendStatus(http.StatusNotFound)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Revision not found.",
+		})
+		return
+	}
+	if err := p.RestoreRevision(r, u.Id.Hex()); err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status":  "success",
+		"content": p,
 	})
 }
 
 func ContentRemoveHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
 	id := ctx.Param("id")
 	// postId, _ := strconv.Atoi(id)
+	if bson.IsObjectIdHex(id) && !canEditPost(ctx, u, bson.ObjectIdHex(id)) {
+		return
+	}
 	err := model.DeletePostById(id)
 	if err != nil {
 		ctx.JSON(map[string]interface{}{
@@ -190,6 +334,27 @@ func ContentRemoveHandler(ctx *golf.Context) {
 	}
 }
 
+// canEditPost checks that the user is allowed to edit the post with the given
+// id, and answers with 403 Forbidden if they are not. Posts that do not exist
+// yet can be written by anyone allowed to get to the editor; any other error
+// denies the edit.
+func canEditPost(ctx *golf.Context, u *model.User, id bson.ObjectId) bool {
+	p := &model.Post{Id: id}
+	err := p.GetPostById()
+	if err == model.ErrNotFound {
+		return true
+	}
+	if err != nil || !u.CanEditPost(p) {
+		ctx.SendStatus(http.StatusForbidden)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "You are not allowed to edit this post.",
+		})
+		return false
+	}
+	return true
+}
+
 func PageCreateHandler(ctx *golf.Context) {
 	userObj, _ := ctx.Session.Get("user")
 	u := userObj.(*model.User)
@@ -211,8 +376,9 @@ func AdminPageHandler(ctx *golf.Context) {
 	} else {
 		page, _ = strconv.Atoi(p)
 	}
+	q := ctx.Request.FormValue("q")
 	posts := new(model.Posts)
-	pager, err := posts.GetPostList(int64(page), 10, true, false, `created_at`)
+	pager, err := listPosts(posts, q, int64(page), true, `created_at`)
 	if err != nil {
 		panic(err)
 	}
@@ -221,6 +387,7 @@ func AdminPageHandler(ctx *golf.Context) {
 		"Pages": posts,
 		"User":  u,
 		"Pager": pager,
+		"Query": q,
 	})
 }
 
@@ -236,7 +403,7 @@ func PageSaveHandler(ctx *golf.Context) {
 		return
 	}
 	p.UpdateFromRequest(ctx.Request)
-	p.Html = utils.Markdown2Html(p.Markdown)
+	p.Html = model.RenderMarkdown(p.Markdown)
 	p.CreatedBy = u.Id.Hex()
 	p.UpdatedBy = u.Id.Hex()
 	p.IsPage = true
@@ -251,6 +418,7 @@ func PageSaveHandler(ctx *golf.Context) {
 		})
 		return
 	}
+	model.DeleteAutosave(p.Id.Hex(), u.Id.Hex())
 	ctx.JSON(map[string]interface{}{
 		"status":  "success",
 		"content": p,
@@ -266,8 +434,18 @@ func CommentViewHandler(ctx *golf.Context) {
 	} else {
 		page, _ = strconv.Atoi(p)
 	}
+	// The tab is one of the moderation states, or empty for all the
+	// comments neither spam nor trashed.
+	tab := ctx.Request.FormValue("tab")
+	if !validCommentStatus(tab) {
+		tab = ""
+	}
 	comments := new(model.Comments)
-	pager, err := comments.GetCommentList(int64(page), 10, false)
+	pager, err := comments.GetCommentListByStatus(tab, int64(page), 10)
+	if err != nil {
+		panic(err)
+	}
+	counts, err := model.CountCommentsByStatus()
 	if err != nil {
 		panic(err)
 	}
@@ -276,34 +454,102 @@ func CommentViewHandler(ctx *golf.Context) {
 		"Comments": comments,
 		"User":     user,
 		"Pager":    pager,
+		"Tab":      tab,
+		"Statuses": model.CommentStatuses,
+		"Counts":   counts,
+		"SpamNum":  counts[model.CommentSpam],
+	})
+}
+
+// validCommentStatus reports whether the status is one of the moderation
+// states of the comments.
+func validCommentStatus(status string) bool {
+	for _, s := range model.CommentStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// CommentNotSpamHandler clears the spam flag of a comment, and reports it as
+// ham to Akismet when it is set up.
+func CommentNotSpamHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	id := ctx.Request.FormValue("id")
+	c := new(model.Comment)
+	if bson.IsObjectIdHex(id) {
+		c.Id = bson.ObjectIdHex(id)
+	}
+	if len(c.Id) == 0 || c.GetCommentById() != nil {
+		ctx.SendStatus(http.StatusNotFound)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Comment not found.",
+		})
+		return
+	}
+	if a := model.NewAkismetFilter(); a != nil && c.IsSpam() {
+		if err := a.SubmitHam(c); err != nil {
+			log.Printf("[Error]: Can not report comment %v as ham: %v", c.Id.Hex(), err)
+		}
+	}
+	if err := c.NotSpam(u); err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
+
+// CommentPurgeSpamHandler deletes every comment flagged as spam.
+func CommentPurgeSpamHandler(ctx *golf.Context) {
+	if err := model.PurgeSpamComments(); err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
 	})
 }
 
 func CommentAddHandler(ctx *golf.Context) {
 	userObj, _ := ctx.Session.Get("user")
 	u := userObj.(*model.User)
-	pid := ctx.Request.FormValue("pid")
-	parent := &model.Comment{Id: bson.ObjectIdHex(pid)}
-	err := parent.GetCommentById()
-	if err != nil {
-		panic(err)
+	parents, ok := formComments(ctx, []string{ctx.Request.FormValue("pid")})
+	if !ok {
+		return
 	}
-	if !parent.Approved {
-		parent.Approved = true
-		parent.Save()
+	parent := parents[0]
+	if !parent.IsApproved() {
+		if err := parent.Moderate(model.CommentApproved, "", u); err != nil {
+			panic(err)
+		}
 	}
 	c := model.NewComment()
 	c.Author = u.Name
 	c.Email = u.Email
 	c.Website = u.Website
-	c.Content = ctx.Request.FormValue("content")
+	c.Content = model.CommentContent(ctx.Request.FormValue("content"))
 	c.Avatar = utils.Gravatar(c.Email, "50")
 	c.Parent = parent.Id.Hex()
 	c.PostId = parent.PostId
-	c.Ip = ctx.Request.RemoteAddr
+	c.Ip = remoteIP(ctx.Request)
 	c.UserAgent = ctx.Request.UserAgent()
 	c.UserId = u.Id.Hex()
-	c.Approved = true
+	c.Status = model.CommentApproved
+	c.ModeratedBy = u.Id.Hex()
+	c.ModeratedAt = c.CreatedAt
 	if err := c.Save(); err != nil {
 		panic(err)
 	}
@@ -314,43 +560,143 @@ func CommentAddHandler(ctx *golf.Context) {
 	if err := model.NewMessage("comment", c).Insert(); err != nil {
 		panic(err)
 	}
+	c.NotifyParent()
 }
 
+// CommentUpdateHandler moderates the comments of the "id" values, setting
+// their status, approved by default, and the reason for it. Given the
+// content of a single comment, it edits its text instead.
 func CommentUpdateHandler(ctx *golf.Context) {
-	// FIXME:
-	id := ctx.Request.FormValue("id")
-	c := &model.Comment{Id: bson.ObjectIdHex(id)}
-	err := c.GetCommentById()
-	if err != nil {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	ctx.Request.ParseForm()
+	comments, ok := formComments(ctx, ctx.Request.Form["id"])
+	if !ok {
+		return
+	}
+	if content, edit := ctx.Request.Form["content"]; edit {
+		if len(comments) > 1 || len(content) > 1 {
+			ctx.SendStatus(http.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    "Only one comment can be edited at a time.",
+			})
+			return
+		}
+		// The comment is edited as it is stored, the HTML being kept to what
+		// the comments allow.
+		c := comments[0]
+		if err := c.Edit(content[0]); err == model.ErrEmptyComment {
+			ctx.SendStatus(http.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    "The comment is empty.",
+			})
+			return
+		} else if err != nil {
+			ctx.SendStatus(http.StatusInternalServerError)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    err.Error(),
+			})
+			return
+		}
+		ctx.JSON(map[string]interface{}{
+			"status":  "success",
+			"comment": c.ToJson(),
+		})
+		return
+	}
+	status := ctx.Request.FormValue("status")
+	if status == "" {
+		status = model.CommentApproved
+	}
+	if !validCommentStatus(status) {
+		ctx.SendStatus(http.StatusBadRequest)
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
-			"msg":    err.Error(),
+			"msg":    "Unknown comment status.",
 		})
+		return
 	}
-	c.Approved = true
-	if err := c.Save(); err != nil {
-		panic(err)
+	var approved []*model.Comment
+	for _, c := range comments {
+		wasApproved := c.IsApproved()
+		if err := c.Moderate(status, ctx.Request.FormValue("reason"), u); err != nil {
+			ctx.SendStatus(http.StatusInternalServerError)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    err.Error(),
+			})
+			return
+		}
+		if !wasApproved && c.IsApproved() {
+			approved = append(approved, c)
+		}
 	}
 	ctx.JSON(map[string]interface{}{
 		"status": "success",
+		"count":  len(comments),
 	})
+	for _, c := range approved {
+		c.NotifyParent()
+	}
 }
 
+// CommentRemoveHandler deletes the comments of the "id" values for good, along
+// with their replies.
 func CommentRemoveHandler(ctx *golf.Context) {
-	// FIXME:
-	id := ctx.Request.FormValue("id")
-	err := model.DeleteComment(id)
-	if err != nil {
-		ctx.JSON(map[string]interface{}{
-			"status": "success",
-			"msg":    err.Error(),
-		})
+	ctx.Request.ParseForm()
+	comments, ok := formComments(ctx, ctx.Request.Form["id"])
+	if !ok {
+		return
+	}
+	for _, c := range comments {
+		if err := model.DeleteComment(c.Id.Hex()); err != nil {
+			ctx.SendStatus(http.StatusInternalServerError)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    err.Error(),
+			})
+			return
+		}
 	}
 	ctx.JSON(map[string]interface{}{
 		"status": "success",
+		"count":  len(comments),
 	})
 }
 
+// formComments loads the comments with the ids. It answers with an error and
+// returns false if there is none, or one of them is not found.
+func formComments(ctx *golf.Context, ids []string) ([]*model.Comment, bool) {
+	if len(ids) == 0 {
+		ctx.SendStatus(http.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "No comment selected.",
+		})
+		return nil, false
+	}
+	comments := make([]*model.Comment, 0, len(ids))
+	for _, id := range ids {
+		c := new(model.Comment)
+		if bson.IsObjectIdHex(id) {
+			c.Id = bson.ObjectIdHex(id)
+		}
+		if len(c.Id) == 0 || c.GetCommentById() != nil {
+			ctx.SendStatus(http.StatusNotFound)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    "Comment not found.",
+			})
+			return nil, false
+		}
+		comments = append(comments, c)
+	}
+	return comments, true
+}
+
 func SettingViewHandler(ctx *golf.Context) {
 	user, _ := ctx.Session.Get("user")
 	ctx.Loader("admin").Render("setting.html", map[string]interface{}{
@@ -442,3 +788,116 @@ func AdminMonitorPage(ctx *golf.Context) {
 		"Monitor": utils.ReadMemStats(),
 	})
 }
+
+func UserViewHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	p := ctx.Request.FormValue("page")
+	var page int
+	if p == "" {
+		page = 1
+	} else {
+		page, _ = strconv.Atoi(p)
+	}
+	users := new(model.Users)
+	pager, err := users.GetUserList(int64(page), 10)
+	if err != nil {
+		panic(err)
+	}
+	authors, err := model.GetAllUsers(0, 0)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Loader("admin").Render("users.html", map[string]interface{}{
+		"Title":   "Users",
+		"Users":   users,
+		"Authors": authors,
+		"User":    u,
+		"Pager":   pager,
+		"Roles": []int{
+			model.RoleAdministrator,
+			model.RoleEditor,
+			model.RoleAuthor,
+		},
+	})
+}
+
+func UserInviteHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	role, _ := strconv.Atoi(ctx.Request.FormValue("role"))
+	invite, err := inviteUser(u, ctx.Request.FormValue("email"), role)
+	if err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"url":    model.GetSettingValue("site_url") + invite.Url(),
+	})
+}
+
+func UserUpdateHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	id := ctx.Request.FormValue("id")
+	if !bson.IsObjectIdHex(id) {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Incorrect user id",
+		})
+		return
+	}
+	target := &model.User{Id: bson.ObjectIdHex(id)}
+	err := target.GetUserById()
+	if err == nil {
+		role, _ := strconv.Atoi(ctx.Request.FormValue("role"))
+		err = updateUser(u, target, role, ctx.Request.FormValue("status"))
+	}
+	if err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
+
+func UserRemoveHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	id := ctx.Request.FormValue("id")
+	if !bson.IsObjectIdHex(id) {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Incorrect user id",
+		})
+		return
+	}
+	target := &model.User{Id: bson.ObjectIdHex(id)}
+	err := target.GetUserById()
+	if err == nil {
+		err = deleteUser(u, target, ctx.Request.FormValue("reassign"))
+	}
+	if err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
@@ -19,6 +19,7 @@ import (
 func authenticatedContext(form url.Values, method, path string) *golf.Context {
 	u := model.NewUser(email, name)
 	u.Id = model.Tmp_id_1
+	u.Role = model.RoleOwner
 	u.Create(password)
 	ctx := mockLogInPostContext()
 	ctx.App.ServeHTTP(ctx.Response, ctx.Request)
@@ -462,7 +463,7 @@ func TestCommentHandler(t *testing.T) {
 				c := &model.Comment{Id: model.Tmp_id_1}
 				err := c.GetCommentById()
 				So(err, ShouldBeNil)
-				So(c.Approved, ShouldBeFalse)
+				So(c.IsApproved(), ShouldBeFalse)
 
 				Convey("Approve the comment", func() {
 					form := url.Values{}
@@ -477,7 +478,7 @@ func TestCommentHandler(t *testing.T) {
 						err = c.GetCommentById()
 
 						So(err, ShouldBeNil)
-						So(c.Approved, ShouldBeTrue)
+						So(c.IsApproved(), ShouldBeTrue)
 					})
 				})
 
@@ -495,7 +496,7 @@ func TestCommentHandler(t *testing.T) {
 						err = c.GetCommentById()
 
 						So(err, ShouldBeNil)
-						So(c.Approved, ShouldBeTrue)
+						So(c.IsApproved(), ShouldBeTrue)
 					})
 
 					Convey("Get the reply comment", func() {
@@ -504,7 +505,7 @@ func TestCommentHandler(t *testing.T) {
 
 						So(err, ShouldBeNil)
 						So(c.Parent, ShouldEqual, 1)
-						So(c.Approved, ShouldBeTrue)
+						So(c.IsApproved(), ShouldBeTrue)
 					})
 				})
 
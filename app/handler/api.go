@@ -39,19 +39,23 @@ func NewAPISuccessResponse(data interface{}, msgs ...string) APIResponseBodyJSON
 
 // APIDocumentationHandler shows which routes match with what functionality,
 // similar to https://api.github.com
-func APIDocumentationHandler(routes map[string]map[string]interface{}) golf.HandlerFunc {
+func APIDocumentationHandler(api *APIRouter) golf.HandlerFunc {
 	return func(ctx *golf.Context) {
-		routes["GET"]["api_documentation_url"] = "/api"
-		ctx.JSONIndent(map[string]interface{}{"request_method": routes}, "", "  ")
+		ctx.JSONIndent(map[string]interface{}{"request_method": api.index()}, "", "  ")
 	}
 }
 
-// handleErr sends the staus code and error message formatted as JSON.
-func handleErr(ctx *golf.Context, statusCode int, err error) {
-	ctx.JSONIndent(map[string]interface{}{
-		"statusCode": statusCode,
-		"error":      err.Error(),
-	}, "", "  ")
+// sendAPIError sends the status code, with the error message in an
+// APIResponseBodyJSON.
+func sendAPIError(ctx *golf.Context, statusCode int, msgs ...string) {
+	ctx.SendStatus(statusCode)
+	ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(msgs...)})
+}
+
+// readJSONBody decodes the json-formatted request body into v.
+func readJSONBody(ctx *golf.Context, v interface{}) error {
+	defer ctx.Request.Body.Close()
+	return json.NewDecoder(ctx.Request.Body).Decode(v)
 }
 
 func (status APIStatusJSON) Serialize() []byte {
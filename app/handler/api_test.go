@@ -1,55 +1,450 @@
 package handler
 
 import (
-	// "net/http/httptest"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
-	// . "github.com/smartystreets/goconvey/convey"
-)
+	"time"
 
-func TestAPICommentsHandler(t *testing.T) {
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+	. "github.com/smartystreets/goconvey/convey"
+)
 
+// apiResponse serves the API request, and decodes the data of the response
+// into data. It returns the status code.
+func apiResponse(ctx *golf.Context, data interface{}) int {
+	code := serve(ctx)
+	json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &APIResponseBodyJSON{Data: data})
+	return code
 }
 
-func TestAPICommentHandler(t *testing.T) {
+func TestAPIPosts(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
 
-}
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		editor := mockRoleUser(model.RoleEditor)
+		published := mockPost()
+		published.CreatedBy = editor.Id.Hex()
+		published.IsPublished = true
+		So(published.Save(), ShouldBeNil)
+		scheduled := mockPost()
+		scheduled.Slug = "scheduled"
+		scheduled.IsPublished = true
+		later := time.Now().Add(time.Hour)
+		scheduled.PublishedAt = &later
+		So(scheduled.Save(), ShouldBeNil)
+		draft := mockPost()
+		draft.Slug = "draft"
+		So(draft.Save(), ShouldBeNil)
 
-func TestAPICommentPostHandler(t *testing.T) {
+		listed := func(u *model.User, query string) []string {
+			ctx := mockContext(nil, "GET", "/api/posts"+query)
+			if u != nil {
+				ctx = jwtContext(u, "", "GET", "/api/posts"+query)
+			}
+			var posts []*model.Post
+			So(apiResponse(ctx, &posts), ShouldEqual, 200)
+			slugs := []string{}
+			for _, p := range posts {
+				slugs = append(slugs, p.Slug)
+			}
+			return slugs
+		}
 
-}
+		Convey("Show the public posts only", func() {
+			post := new(model.Post)
+			So(apiResponse(mockContext(nil, "GET", "/api/posts/"+published.Id.Hex()), post), ShouldEqual, 200)
+			So(post.Slug, ShouldEqual, published.Slug)
+			So(serve(mockContext(nil, "GET", "/api/posts/slug/"+published.Slug)), ShouldEqual, 200)
+			So(serve(mockContext(nil, "GET", "/api/posts/"+published.Id.Hex()+"/excerpt")), ShouldEqual, 200)
 
-func TestAPIPostHandler(t *testing.T) {
+			for _, p := range []*model.Post{scheduled, draft} {
+				So(serve(mockContext(nil, "GET", "/api/posts/"+p.Id.Hex())), ShouldEqual, 404)
+				So(serve(mockContext(nil, "GET", "/api/posts/slug/"+p.Slug)), ShouldEqual, 404)
+				So(serve(mockContext(nil, "GET", "/api/posts/"+p.Id.Hex()+"/excerpt")), ShouldEqual, 404)
+				So(serve(mockContext(nil, "GET", "/api/comments/post/"+p.Id.Hex())), ShouldEqual, 404)
+			}
 
-}
+			var author map[string]interface{}
+			So(apiResponse(mockContext(nil, "GET", "/api/posts/"+published.Id.Hex()+"/author"), &author), ShouldEqual, 200)
+			So(author["Name"], ShouldEqual, editor.Name)
+			So(author, ShouldNotContainKey, "Email")
 
-func TestAPIPostSlugHandler(t *testing.T) {
+			So(listed(nil, ""), ShouldResemble, []string{published.Slug})
+			So(listed(nil, "?published=true"), ShouldResemble, []string{published.Slug})
+			So(listed(nil, "?published=false"), ShouldBeEmpty)
+		})
 
-}
+		Convey("Show the drafts to the users who may edit posts", func() {
+			for _, p := range []*model.Post{scheduled, draft} {
+				post := new(model.Post)
+				So(apiResponse(jwtContext(editor, "", "GET", "/api/posts/"+p.Id.Hex()), post), ShouldEqual, 200)
+				So(post.Slug, ShouldEqual, p.Slug)
+				So(serve(jwtContext(editor, "", "GET", "/api/posts/slug/"+p.Slug)), ShouldEqual, 200)
+			}
 
-func TestAPITagsHandler(t *testing.T) {
+			So(listed(editor, ""), ShouldResemble, []string{published.Slug, scheduled.Slug, draft.Slug})
+			So(listed(editor, "?published=true"), ShouldResemble, []string{published.Slug})
+			So(listed(editor, "?published=false"), ShouldResemble, []string{scheduled.Slug, draft.Slug})
+		})
 
+		Reset(func() {
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
 }
 
-func TestAPITagHandler(t *testing.T) {
+func TestAPIComments(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
 
-}
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		author := mockRoleUser(model.RoleAuthor)
+		editor := mockRoleUser(model.RoleEditor)
+		p := mockPost()
+		p.IsPublished = true
+		So(p.Save(), ShouldBeNil)
+		postId := p.Id.Hex()
 
-func TestAPITagSlugHandler(t *testing.T) {
+		create := func(u *model.User, body string) (int, *model.Comment) {
+			c := new(model.Comment)
+			return apiResponse(jwtContext(u, body, "POST", "/api/comments"), c), c
+		}
 
-}
+		Convey("Comment as the user of the token", func() {
+			So(serve(mockContext(nil, "POST", "/api/comments")), ShouldEqual, 401)
+
+			code, c := create(author, `{"post_id": "`+postId+`", "content": "**Nice** post"}`)
+			So(code, ShouldEqual, 201)
+			So(c.Author, ShouldEqual, author.Name)
+			So(c.UserId, ShouldEqual, author.Id.Hex())
+			So(c.Content, ShouldEqual, "<p><strong>Nice</strong> post</p>")
+			So(c.Status, ShouldEqual, model.CommentPending)
+
+			code, reply := create(editor, `{"post_id": "`+postId+`", "parent": "`+c.Id.Hex()+`", "content": "Thanks"}`)
+			So(code, ShouldEqual, 201)
+			So(reply.Parent, ShouldEqual, c.Id.Hex())
+			So(reply.Status, ShouldEqual, model.CommentApproved)
+
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.CommentNum, ShouldEqual, 2)
+
+			var public map[string]interface{}
+			So(apiResponse(mockContext(nil, "GET", "/api/comments/"+reply.Id.Hex()), &public), ShouldEqual, 200)
+			So(public["Content"], ShouldEqual, reply.Content)
+			for _, key := range []string{"Email", "Ip", "UserAgent"} {
+				So(public, ShouldNotContainKey, key)
+			}
+			So(c.Moderate(model.CommentApproved, "", editor), ShouldBeNil)
+			var thread []map[string]interface{}
+			So(apiResponse(mockContext(nil, "GET", "/api/comments/post/"+postId), &thread), ShouldEqual, 200)
+			So(thread, ShouldNotBeEmpty)
+			So(thread[0], ShouldNotContainKey, "Email")
+			var page struct {
+				Comments []map[string]interface{} `json:"comments"`
+			}
+			So(apiResponse(mockContext(nil, "GET", "/api/posts/"+postId+"/comments"), &page), ShouldEqual, 200)
+			So(page.Comments, ShouldHaveLength, 2)
+			So(page.Comments[0], ShouldNotContainKey, "Email")
+			So(page.Comments[0], ShouldNotContainKey, "Ip")
+		})
+
+		Convey("Check the comments of the users for spam", func() {
+			So(model.NewSetting("comment_blocklist", "casino", "comment").Save(), ShouldBeNil)
+			code, c := create(author, `{"post_id": "`+postId+`", "content": "Best casino"}`)
+			So(code, ShouldEqual, 201)
+			So(c.Status, ShouldEqual, model.CommentPending)
+			So(c.Reason, ShouldBeEmpty)
+			So(c.GetCommentById(), ShouldBeNil)
+			So(c.Status, ShouldEqual, model.CommentSpam)
+
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.CommentNum, ShouldEqual, 0)
+		})
+
+		Convey("Refuse the comments that can not be made", func() {
+			code, _ := create(author, `{"post_id": "`+postId+`", "content": "  "}`)
+			So(code, ShouldEqual, 400)
+			code, _ = create(author, `{"post_id": "`+postId+`", "parent": "nope", "content": "Hi"}`)
+			So(code, ShouldEqual, 400)
+			code, _ = create(author, `not json`)
+			So(code, ShouldEqual, 400)
+			code, _ = create(author, `{"post_id": "nope", "content": "Hi"}`)
+			So(code, ShouldEqual, 404)
+
+			p.AllowComment = false
+			So(p.Save(), ShouldBeNil)
+			code, _ = create(author, `{"post_id": "`+postId+`", "content": "Hi"}`)
+			So(code, ShouldEqual, 403)
+		})
+
+		Convey("Moderate the comments", func() {
+			_, c := create(author, `{"post_id": "`+postId+`", "content": "Hello"}`)
+			path := "/api/comments/" + c.Id.Hex()
+
+			So(serve(mockContext(nil, "GET", path)), ShouldEqual, 404)
+			So(serve(jwtContext(author, "", "GET", path)), ShouldEqual, 404)
+			So(serve(jwtContext(editor, "", "GET", path)), ShouldEqual, 200)
+			So(serve(mockContext(nil, "GET", "/api/comments/nope")), ShouldEqual, 404)
+
+			body := `{"status": "rejected", "reason": "off topic"}`
+			So(serve(jwtContext(author, body, "PUT", path)), ShouldEqual, 403)
+			updated := new(model.Comment)
+			So(apiResponse(jwtContext(editor, body, "PUT", path), updated), ShouldEqual, 200)
+			So(updated.Status, ShouldEqual, model.CommentRejected)
+			So(updated.Reason, ShouldEqual, "off topic")
+			So(updated.ModeratedBy, ShouldEqual, editor.Id.Hex())
+
+			So(apiResponse(jwtContext(editor, `{"status": "approved", "content": "<p>Hi <script>x</script></p>"}`, "PUT", path), updated), ShouldEqual, 200)
+			So(updated.Content, ShouldEqual, "<p>Hi </p>")
+			So(serve(mockContext(nil, "GET", path)), ShouldEqual, 200)
+
+			So(serve(jwtContext(editor, `{"status": "deleted"}`, "PUT", path)), ShouldEqual, 400)
+			So(serve(jwtContext(editor, `{"content": "<script></script>"}`, "PUT", path)), ShouldEqual, 400)
+			So(serve(jwtContext(editor, body, "PUT", "/api/comments/nope")), ShouldEqual, 404)
+
+			So(serve(jwtContext(author, "", "DELETE", path)), ShouldEqual, 403)
+			So(serve(jwtContext(editor, "", "DELETE", path)), ShouldEqual, 200)
+			So(serve(jwtContext(editor, "", "DELETE", path)), ShouldEqual, 404)
+		})
+
+		Convey("List the comments by status", func() {
+			create(author, `{"post_id": "`+postId+`", "content": "Pending"}`)
+			create(editor, `{"post_id": "`+postId+`", "content": "Approved"}`)
+
+			So(serve(mockContext(nil, "GET", "/api/comments")), ShouldEqual, 401)
+			So(serve(jwtContext(author, "", "GET", "/api/comments")), ShouldEqual, 403)
+			var list APICommentListJSON
+			So(apiResponse(jwtContext(editor, "", "GET", "/api/comments?limit=1"), &list), ShouldEqual, 200)
+			So(list.Comments, ShouldHaveLength, 1)
+			So(list.Total, ShouldEqual, 2)
+			So(list.Pages, ShouldEqual, 2)
+
+			list = APICommentListJSON{}
+			So(apiResponse(jwtContext(editor, "", "GET", "/api/comments?status=pending"), &list), ShouldEqual, 200)
+			So(list.Comments, ShouldHaveLength, 1)
+			So(list.Comments[0].Content, ShouldEqual, "<p>Pending</p>")
 
-func TestAPIUsersHandler(t *testing.T) {
+			for _, query := range []string{"?status=deleted", "?page=0", "?limit=1000"} {
+				So(serve(jwtContext(editor, "", "GET", "/api/comments"+query)), ShouldEqual, 400)
+			}
 
+			var comments []*model.Comment
+			So(apiResponse(mockContext(nil, "GET", "/api/comments/post/"+postId), &comments), ShouldEqual, 200)
+			So(comments, ShouldHaveLength, 1)
+			So(serve(mockContext(nil, "GET", "/api/comments/post/nope")), ShouldEqual, 404)
+		})
+
+		Reset(func() {
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
 }
 
-func TestAPIUserHandler(t *testing.T) {
+func TestAPITags(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		author := mockRoleUser(model.RoleAuthor)
+		editor := mockRoleUser(model.RoleEditor)
+		first := mockPost()
+		So(first.Save(model.NewTag("Go", "go"), model.NewTag("Golang", "golang")), ShouldBeNil)
+		second := mockPost()
+		second.Slug = "second"
+		So(second.Save(model.NewTag("Golang", "golang"), model.NewTag("News", "news")), ShouldBeNil)
+
+		tagSlugs := func(p *model.Post) []string {
+			So(p.GetPostById(), ShouldBeNil)
+			slugs := []string{}
+			for _, t := range p.Tags {
+				slugs = append(slugs, t.Slug)
+			}
+			return slugs
+		}
+
+		Convey("Get the tags by slug", func() {
+			var tags model.Tags
+			So(apiResponse(mockContext(nil, "GET", "/api/tags"), &tags), ShouldEqual, 200)
+			So(tags, ShouldHaveLength, 3)
+
+			tag := new(model.Tag)
+			So(apiResponse(mockContext(nil, "GET", "/api/tags/golang"), tag), ShouldEqual, 200)
+			So(tag.Name, ShouldEqual, "Golang")
+			So(serve(mockContext(nil, "GET", "/api/tags/slug/news")), ShouldEqual, 200)
+			So(serve(mockContext(nil, "GET", "/api/tags/nope")), ShouldEqual, 404)
+		})
+
+		Convey("Rename a tag", func() {
+			So(serve(jwtContext(author, `{"name": "Go Lang"}`, "PUT", "/api/tags/golang")), ShouldEqual, 403)
+			open := &model.Post{Id: first.Id}
+			So(open.GetPostById(), ShouldBeNil)
+			tag := new(model.Tag)
+			So(apiResponse(jwtContext(editor, `{"name": "Go Lang"}`, "PUT", "/api/tags/golang"), tag), ShouldEqual, 200)
+			So(tag.Slug, ShouldEqual, "go-lang")
+			So(tagSlugs(first), ShouldResemble, []string{"go", "go-lang"})
+			So(tagSlugs(second), ShouldResemble, []string{"go-lang", "news"})
+			So(first.Version, ShouldEqual, open.Version+1)
+			So(open.Save(open.Tags...), ShouldHaveSameTypeAs, &model.ConflictError{})
+			revisions, err := first.GetRevisions()
+			So(err, ShouldBeNil)
+			So(revisions.Len(), ShouldEqual, 2)
 
+			So(serve(jwtContext(editor, `{"name": "News"}`, "PUT", "/api/tags/go")), ShouldEqual, 409)
+			So(serve(jwtContext(editor, `{"name": ""}`, "PUT", "/api/tags/go")), ShouldEqual, 400)
+			So(serve(jwtContext(editor, `{"name": "Nope"}`, "PUT", "/api/tags/nope")), ShouldEqual, 404)
+		})
+
+		Convey("Merge a tag into another", func() {
+			So(serve(jwtContext(author, `{"into": "go"}`, "POST", "/api/tags/golang/merge")), ShouldEqual, 403)
+			So(serve(jwtContext(editor, `{"into": "go"}`, "POST", "/api/tags/golang/merge")), ShouldEqual, 200)
+			So(tagSlugs(first), ShouldResemble, []string{"go"})
+			So(tagSlugs(second), ShouldResemble, []string{"go", "news"})
+
+			So(serve(jwtContext(editor, `{"into": "nope"}`, "POST", "/api/tags/news/merge")), ShouldEqual, 400)
+			So(serve(jwtContext(editor, `{"into": "news"}`, "POST", "/api/tags/news/merge")), ShouldEqual, 400)
+			So(serve(jwtContext(editor, `{"into": "go"}`, "POST", "/api/tags/nope/merge")), ShouldEqual, 404)
+		})
+
+		Convey("Delete a tag", func() {
+			So(serve(jwtContext(author, "", "DELETE", "/api/tags/golang")), ShouldEqual, 403)
+			So(serve(jwtContext(editor, "", "DELETE", "/api/tags/golang")), ShouldEqual, 200)
+			So(tagSlugs(first), ShouldResemble, []string{"go"})
+			So(tagSlugs(second), ShouldResemble, []string{"news"})
+			So(serve(jwtContext(editor, "", "DELETE", "/api/tags/golang")), ShouldEqual, 404)
+		})
+
+		Reset(func() {
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
 }
 
-func TestAPIUserSlugHandler(t *testing.T) {
+func TestAPIUsers(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		admin := mockRoleUser(model.RoleAdministrator)
+		author := mockRoleUser(model.RoleAuthor)
+
+		Convey("Get the users", func() {
+			So(serve(mockContext(nil, "GET", "/api/users")), ShouldEqual, 401)
+			So(serve(jwtContext(author, "", "GET", "/api/users")), ShouldEqual, 403)
+			So(serve(jwtContext(admin, "", "GET", "/api/users?limit=x")), ShouldEqual, 400)
 
+			var u map[string]interface{}
+			So(apiResponse(mockContext(nil, "GET", "/api/users/"+author.Id.Hex()), &u), ShouldEqual, 200)
+			So(u["Name"], ShouldEqual, author.Name)
+			So(u, ShouldNotContainKey, "Email")
+			So(u, ShouldNotContainKey, "Role")
+			So(serve(mockContext(nil, "GET", "/api/users/nope")), ShouldEqual, 404)
+			u = nil
+			So(apiResponse(mockContext(nil, "GET", "/api/users/slug/"+author.Slug), &u), ShouldEqual, 200)
+			So(u["Slug"], ShouldEqual, author.Slug)
+			So(u, ShouldNotContainKey, "Email")
+			So(serve(mockContext(nil, "GET", "/api/users/slug/nope")), ShouldEqual, 404)
+
+			So(serve(mockContext(nil, "GET", "/api/users/email/"+author.Email)), ShouldEqual, 401)
+			So(serve(jwtContext(author, "", "GET", "/api/users/email/"+author.Email)), ShouldEqual, 403)
+			full := new(model.User)
+			So(apiResponse(jwtContext(admin, "", "GET", "/api/users/email/"+author.Email), full), ShouldEqual, 200)
+			So(full.Email, ShouldEqual, author.Email)
+			So(serve(jwtContext(admin, "", "GET", "/api/users/email/nope@example.com")), ShouldEqual, 404)
+		})
+
+		Convey("Invite, change and delete a user", func() {
+			body := `{"email": "new@example.com", "role": 3}`
+			So(serve(jwtContext(author, body, "POST", "/api/users")), ShouldEqual, 403)
+			So(serve(jwtContext(admin, body, "POST", "/api/users")), ShouldEqual, 201)
+			So(serve(jwtContext(admin, `{"email": "nope"}`, "POST", "/api/users")), ShouldEqual, 400)
+
+			path := "/api/users/" + author.Id.Hex()
+			So(serve(jwtContext(admin, `{"role": 2}`, "PUT", path)), ShouldEqual, 200)
+			So(serve(jwtContext(admin, `{"status": "gone"}`, "PUT", path)), ShouldEqual, 400)
+			So(serve(jwtContext(admin, `{"role": 2}`, "PUT", "/api/users/nope")), ShouldEqual, 404)
+
+			So(serve(jwtContext(admin, "", "DELETE", path)), ShouldEqual, 200)
+			So(serve(jwtContext(admin, "", "DELETE", path)), ShouldEqual, 404)
+			So(serve(jwtContext(admin, "", "DELETE", "/api/users/"+admin.Id.Hex())), ShouldEqual, 400)
+		})
+
+		Reset(func() {
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
 }
 
-func TestAPIUserEmailHandler(t *testing.T) {
+func TestAPISettings(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		admin := mockRoleUser(model.RoleAdministrator)
+		editor := mockRoleUser(model.RoleEditor)
+
+		Convey("Create, change and delete a setting", func() {
+			So(serve(jwtContext(editor, "", "GET", "/api/settings")), ShouldEqual, 403)
+			s := new(model.Setting)
+			So(apiResponse(jwtContext(admin, `{"key": "twitter", "value": "@dingo"}`, "POST", "/api/settings"), s), ShouldEqual, 201)
+			So(s.Type, ShouldEqual, "custom")
+			So(serve(jwtContext(admin, `{"key": "twitter"}`, "POST", "/api/settings")), ShouldEqual, 409)
+			So(serve(jwtContext(admin, `{"value": "x"}`, "POST", "/api/settings")), ShouldEqual, 400)
+
+			var settings model.Settings
+			So(apiResponse(jwtContext(admin, "", "GET", "/api/settings?type=custom"), &settings), ShouldEqual, 200)
+			So(settings, ShouldHaveLength, 1)
+			settings = nil
+			So(apiResponse(jwtContext(admin, "", "GET", "/api/settings"), &settings), ShouldEqual, 200)
+			So(len(settings), ShouldBeGreaterThan, 1)
+
+			So(apiResponse(jwtContext(admin, `{"value": "@blog"}`, "PUT", "/api/settings/twitter"), s), ShouldEqual, 200)
+			So(s.Value, ShouldEqual, "@blog")
+			So(s.Type, ShouldEqual, "custom")
+			So(model.GetSettingValue("twitter"), ShouldEqual, "@blog")
+			So(serve(jwtContext(admin, "", "GET", "/api/settings/twitter")), ShouldEqual, 200)
+			So(serve(jwtContext(admin, `{"value": "x"}`, "PUT", "/api/settings/nope")), ShouldEqual, 404)
+
+			So(serve(jwtContext(admin, "", "DELETE", "/api/settings/theme")), ShouldEqual, 400)
+			So(serve(jwtContext(admin, "", "DELETE", "/api/settings/twitter")), ShouldEqual, 200)
+			So(serve(jwtContext(admin, "", "GET", "/api/settings/twitter")), ShouldEqual, 404)
+			So(serve(jwtContext(admin, "", "DELETE", "/api/settings/twitter")), ShouldEqual, 404)
+		})
+
+		Convey("Replace the navigation menu", func() {
+			body := `[{"label": "Home", "url": "/"}, {"label": "About", "url": "/about/"}]`
+			So(serve(jwtContext(editor, body, "PUT", "/api/navigation")), ShouldEqual, 403)
+			So(serve(jwtContext(admin, body, "PUT", "/api/navigation")), ShouldEqual, 200)
+			var navs []*model.Navigator
+			So(apiResponse(mockContext(nil, "GET", "/api/navigation"), &navs), ShouldEqual, 200)
+			So(navs, ShouldHaveLength, 2)
+			So(navs[1].Label, ShouldEqual, "About")
+
+			So(serve(jwtContext(admin, `[{"label": "", "url": "/"}]`, "PUT", "/api/navigation")), ShouldEqual, 400)
+			So(serve(jwtContext(admin, `{}`, "PUT", "/api/navigation")), ShouldEqual, 400)
+		})
 
+		Reset(func() {
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
 }
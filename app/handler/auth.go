@@ -19,6 +19,17 @@ func AuthLoginPageHandler(ctx *golf.Context) {
 }
 
 func AuthSignUpPageHandler(ctx *golf.Context) {
+	if token, _ := ctx.Query("token"); token != "" {
+		invite := &model.Invite{Value: token}
+		if err := invite.GetInviteByValue(); err != nil || !invite.IsValid() {
+			ctx.Abort(404)
+			return
+		}
+		ctx.Loader("admin").Render("signup.html", map[string]interface{}{
+			"Invite": invite,
+		})
+		return
+	}
 	userNum, err := model.GetNumberOfUsers()
 	if err != nil {
 		ctx.Abort(404)
@@ -32,14 +43,26 @@ func AuthSignUpPageHandler(ctx *golf.Context) {
 	}
 }
 
+// AuthSignUpHandler signs up the first user of the blog, who becomes its
+// owner, or a user who got an invite.
 func AuthSignUpHandler(ctx *golf.Context) {
-	userNum, err := model.GetNumberOfUsers()
-	if err != nil || userNum != 0 {
-		ctx.Abort(403)
-		return
+	var invite *model.Invite
+	email := ctx.Request.FormValue("email")
+	if token := ctx.Request.FormValue("token"); token != "" {
+		invite = &model.Invite{Value: token}
+		if err := invite.GetInviteByValue(); err != nil || !invite.IsValid() {
+			ctx.Abort(403)
+			return
+		}
+		email = invite.Email
+	} else {
+		userNum, err := model.GetNumberOfUsers()
+		if err != nil || userNum != 0 {
+			ctx.Abort(403)
+			return
+		}
 	}
 
-	email := ctx.Request.FormValue("email")
 	if !rxEmail.MatchString(email) {
 		ctx.SendStatus(400)
 		ctx.JSON(map[string]interface{}{
@@ -83,7 +106,23 @@ func AuthSignUpHandler(ctx *golf.Context) {
 		})
 		return
 	}
-	err = model.NewUser(email, name).Create(password)
+	var err error
+	if invite != nil {
+		if (&model.User{Email: email}).UserEmailExist() {
+			ctx.SendStatus(400)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    "A user with that email address already exists.",
+			})
+			return
+		}
+		_, err = invite.Accept(name, password)
+	} else {
+		// The first user to sign up is the owner of the blog.
+		owner := model.NewUser(email, name)
+		owner.Role = model.RoleOwner
+		err = owner.Create(password)
+	}
 	if err != nil {
 		ctx.Abort(500)
 		return
@@ -131,6 +170,10 @@ func AuthLoginHandler(ctx *golf.Context) {
 		ctx.JSON(map[string]interface{}{"status": "error"})
 		return
 	}
+	if user.IsSuspended() {
+		ctx.JSON(map[string]interface{}{"status": "error", "msg": "This account has been suspended."})
+		return
+	}
 	var (
 		exp int
 		t   *model.Token
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+	"github.com/globalsign/mgo/bson"
+)
+
+// autosavedPostId returns the id of the post in the URL, and answers with an
+// error if it is not valid or the user is not allowed to edit the post. Posts
+// not saved yet have drafts too.
+func autosavedPostId(ctx *golf.Context, u *model.User) string {
+	id := ctx.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		ctx.SendStatus(http.StatusNotFound)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Post not found.",
+		})
+		return ""
+	}
+	if !canEditPost(ctx, u, bson.ObjectIdHex(id)) {
+		return ""
+	}
+	return id
+}
+
+// AutosaveHandler saves the draft of the post sent periodically by the
+// editor. The post itself is left as it is.
+func AutosaveHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	id := autosavedPostId(ctx, u)
+	if id == "" {
+		return
+	}
+	a := model.NewAutosaveFromRequest(id, u.Id.Hex(), ctx.Request)
+	if err := a.Save(); err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status":   "success",
+		"saved_at": a.SavedAt,
+	})
+}
+
+// AutosaveDiscardHandler discards the draft of the post by the user.
+func AutosaveDiscardHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	id := autosavedPostId(ctx, u)
+	if id == "" {
+		return
+	}
+	if err := model.DeleteAutosave(id, u.Id.Hex()); err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
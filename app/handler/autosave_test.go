@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAutosave(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		editor := mockRoleUser(model.RoleEditor)
+		admin := mockRoleUser(model.RoleAdministrator)
+		p := mockPost()
+		p.CreatedBy = editor.Id.Hex()
+		So(p.Save(), ShouldBeNil)
+		editorUrl := "/admin/editor/" + p.Id.Hex() + "/"
+
+		saveForm := func(content string, version int64) url.Values {
+			form := url.Values{}
+			form.Add("title", p.Title)
+			form.Add("slug", p.Slug)
+			form.Add("content", content)
+			form.Add("version", strconv.FormatInt(version, 10))
+			return form
+		}
+
+		Convey("Saving an old version of the post conflicts", func() {
+			So(serve(roleContext(admin, saveForm("admin change", 0), "POST", editorUrl)), ShouldEqual, 200)
+
+			ctx := roleContext(editor, saveForm("editor change", 0), "POST", editorUrl)
+			So(serve(ctx), ShouldEqual, 409)
+			var resp struct {
+				Status  string
+				Msg     string
+				Content *model.Post
+				Current *model.Post
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Msg, ShouldContainSubstring, admin.Name)
+			So(resp.Content.Markdown, ShouldEqual, "editor change")
+			So(resp.Current.Markdown, ShouldEqual, "admin change")
+			So(resp.Current.Version, ShouldEqual, 1)
+
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.Markdown, ShouldEqual, "admin change")
+
+			ctx = roleContext(editor, saveForm("editor change", 1), "POST", editorUrl)
+			So(serve(ctx), ShouldEqual, 200)
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.Markdown, ShouldEqual, "editor change")
+		})
+
+		Convey("Saving an old version through the API conflicts", func() {
+			So(serve(roleContext(admin, saveForm("admin change", 0), "POST", editorUrl)), ShouldEqual, 200)
+
+			p.Markdown = "api change"
+			body, _ := json.Marshal(p)
+			ctx := jwtContext(editor, string(body), "PUT", "/api/posts")
+			So(serve(ctx), ShouldEqual, 409)
+			var resp struct {
+				Data struct {
+					Post    *model.Post
+					Current *model.Post
+				}
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Data.Post.Markdown, ShouldEqual, "api change")
+			So(resp.Data.Current.Markdown, ShouldEqual, "admin change")
+		})
+
+		Convey("Autosave a draft without changing the post", func() {
+			ctx := roleContext(editor, saveForm("draft", 0), "POST", editorUrl+"autosave/")
+			So(serve(ctx), ShouldEqual, 200)
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.Markdown, ShouldEqual, "sample content")
+
+			ctx = roleContext(editor, nil, "GET", editorUrl)
+			So(serve(ctx), ShouldEqual, 200)
+			So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, "autosave-restore")
+
+			ctx = roleContext(admin, nil, "GET", editorUrl)
+			So(serve(ctx), ShouldEqual, 200)
+			So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldNotContainSubstring, "autosave-restore")
+
+			Convey("Saving the post discards the draft", func() {
+				So(serve(roleContext(editor, saveForm("saved", 0), "POST", editorUrl)), ShouldEqual, 200)
+				_, err := model.GetAutosave(p.Id.Hex(), editor.Id.Hex())
+				So(err, ShouldEqual, model.ErrNotFound)
+			})
+
+			Convey("Discard the draft", func() {
+				So(serve(roleContext(editor, nil, "DELETE", editorUrl+"autosave/")), ShouldEqual, 200)
+				_, err := model.GetAutosave(p.Id.Hex(), editor.Id.Hex())
+				So(err, ShouldEqual, model.ErrNotFound)
+			})
+		})
+
+		Convey("Only the ones allowed to edit the post autosave it", func() {
+			author := mockRoleUser(model.RoleAuthor)
+			ctx := roleContext(author, saveForm("draft", 0), "POST", editorUrl+"autosave/")
+			So(serve(ctx), ShouldEqual, 403)
+		})
+	})
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+)
+
+// BackupHandler sends a backup archive of the whole blog.
+func BackupHandler(ctx *golf.Context) {
+	uploadDir, _ := ctx.App.Config.GetString("app/upload_dir", "upload")
+	b, err := model.NewBackup()
+	if err != nil {
+		panic(err)
+	}
+	var buf bytes.Buffer
+	if err := b.WriteArchive(&buf, uploadDir); err != nil {
+		panic(err)
+	}
+	ctx.SetHeader("Content-Type", "application/zip")
+	ctx.SetHeader("Content-Disposition", `attachment; filename="`+b.FileName()+`"`)
+	ctx.Send(&buf)
+}
+
+// RestoreHandler restores the uploaded backup archive. The content of the
+// blog is replaced by the backup, unless the mode is "merge".
+func RestoreHandler(ctx *golf.Context) {
+	uploadDir, _ := ctx.App.Config.GetString("app/upload_dir", "upload")
+	f, _, err := ctx.Request.FormFile("file")
+	if err != nil {
+		ctx.SendStatus(http.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Please choose a backup archive.",
+		})
+		return
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		panic(err)
+	}
+	b, err := model.ReadBackup(f, size)
+	if err != nil {
+		ctx.SendStatus(http.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	if err := b.Restore(uploadDir, ctx.Request.FormValue("mode") == "merge"); err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status":     "success",
+		"created_at": b.CreatedAt.Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// restoreContext returns a context uploading the backup archive to restore
+// as the given user, with the upload directory set to uploadDir.
+func restoreContext(u *model.User, archive []byte, mode, uploadDir string) *golf.Context {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("mode", mode)
+	f, _ := w.CreateFormFile("file", "backup.zip")
+	f.Write(archive)
+	w.Close()
+
+	ctx := roleContext(u, nil, "POST", "/admin/backup/restore/")
+	req := makeTestHTTPRequest(&body, "POST", "/admin/backup/restore/")
+	req.Header["Cookie"] = ctx.Request.Header["Cookie"]
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	ctx = golf.NewContext(req, httptest.NewRecorder(), ctx.App)
+	ctx.App.Config.Set("app/upload_dir", uploadDir)
+	return ctx
+}
+
+func TestBackup(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		uploadDir, _ := ioutil.TempDir("", "dingo-upload")
+
+		p := mockPost()
+		p.Save()
+		owner := mockRoleUser(model.RoleOwner)
+		admin := mockRoleUser(model.RoleAdministrator)
+		author := mockRoleUser(model.RoleAuthor)
+
+		Convey("Download a backup", func() {
+			file := filepath.Join(uploadDir, "photo.jpg")
+			So(ioutil.WriteFile(file, []byte("photo"), 0644), ShouldBeNil)
+			ctx := roleContext(admin, nil, "GET", "/admin/backup/")
+			ctx.App.Config.Set("app/upload_dir", uploadDir)
+			So(serve(ctx), ShouldEqual, 200)
+			rec := ctx.Response.(*httptest.ResponseRecorder)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/zip")
+			So(rec.Header().Get("Content-Disposition"), ShouldContainSubstring, "dingo-backup-")
+
+			Convey("Restore the backup", func() {
+				So(model.DeletePostById(p.Id.Hex()), ShouldBeNil)
+				So(os.Remove(file), ShouldBeNil)
+				So(serve(restoreContext(owner, rec.Body.Bytes(), "merge", uploadDir)), ShouldEqual, 200)
+				So(p.GetPostById(), ShouldBeNil)
+				_, err := os.Stat(file)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Only the owner can restore backups", func() {
+				So(serve(restoreContext(admin, rec.Body.Bytes(), "merge", uploadDir)), ShouldEqual, 403)
+				So(serve(restoreContext(author, rec.Body.Bytes(), "merge", uploadDir)), ShouldEqual, 403)
+			})
+		})
+
+		Convey("Invalid archives are refused", func() {
+			So(serve(restoreContext(owner, []byte("not a zip"), "replace", uploadDir)), ShouldEqual, 400)
+			So(p.GetPostById(), ShouldBeNil)
+		})
+
+		Convey("Authors can not download backups", func() {
+			So(serve(roleContext(author, nil, "GET", "/admin/backup/")), ShouldEqual, 403)
+		})
+
+		Reset(func() {
+			model.DropDatabase()
+			os.RemoveAll(uploadDir)
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
+}
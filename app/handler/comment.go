@@ -1,57 +1,339 @@
 package handler
 
 import (
+	"log"
+	"net/http"
+	"strconv"
+
 	"github.com/covrom/dingo/app/model"
+	"github.com/covrom/dingo/app/utils"
 	"github.com/dinever/golf"
 	"github.com/globalsign/mgo/bson"
 )
 
-func registerCommentsHandlers(app *golf.Application, routes map[string]map[string]interface{}) {
-	app.Get("/api/comments", APICommentsHandler)
-	routes["GET"]["comments_url"] = "/api/comments"
+var commentIdParam = pathParam("comment_id", "The id of the comment.")
 
-	app.Get("/api/comments/:comment_id", APICommentHandler)
-	routes["GET"]["comment_url"] = "/api/comments/:comment_id"
+func registerCommentsHandlers(api *APIRouter) {
+	api.Handle(&APIRoute{
+		Name:    "comments_url",
+		Method:  "GET",
+		Path:    "/api/comments",
+		Tag:     "comments",
+		Summary: "List the comments by moderation status",
+		Params: []APIParam{
+			queryParam("status", "string", "pending, approved, rejected, spam or trashed. Without it, the comments neither spam nor trashed."),
+			queryParam("page", "integer", "The page, from 1."),
+			queryParam("limit", "integer", "The number of comments of a page, from 1 to 100, 10 by default."),
+		},
+		Response:   APICommentListJSON{},
+		Errors:     []int{http.StatusBadRequest},
+		Permission: model.PermCommentModerate,
+		Handler:    APICommentsHandler(10, 100),
+	})
+	api.Handle(&APIRoute{
+		Name:        "comment_create_url",
+		Method:      "POST",
+		Path:        "/api/comments",
+		Tag:         "comments",
+		Summary:     "Comment on a post",
+		Description: "Comments as the user of the token, in Markdown. The comments of the moderators are approved right away.",
+		Request:     CommentRequestBody{},
+		Response:    model.Comment{},
+		Status:      http.StatusCreated,
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+		Auth:        true,
+		Handler:     APICommentCreateHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:        "comment_url",
+		Method:      "GET",
+		Path:        "/api/comments/:comment_id",
+		Tag:         "comments",
+		Summary:     "Get a comment",
+		Description: "The comments not approved are only given with the token of a moderator.",
+		Params:      []APIParam{commentIdParam},
+		Response:    model.PublicComment{},
+		Errors:      []int{http.StatusNotFound},
+		Handler:     APICommentHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:        "comment_update_url",
+		Method:      "PUT",
+		Path:        "/api/comments/:comment_id",
+		Tag:         "comments",
+		Summary:     "Moderate or edit a comment",
+		Description: "Sets the status and reason, and replaces the HTML of the comment with the content. Fields left out are not changed.",
+		Params:      []APIParam{commentIdParam},
+		Request:     CommentRequestBody{},
+		Response:    model.Comment{},
+		Errors:      []int{http.StatusBadRequest, http.StatusNotFound},
+		Permission:  model.PermCommentModerate,
+		Handler:     APICommentUpdateHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "comment_delete_url",
+		Method:     "DELETE",
+		Path:       "/api/comments/:comment_id",
+		Tag:        "comments",
+		Summary:    "Delete a comment and its replies",
+		Params:     []APIParam{commentIdParam},
+		Errors:     []int{http.StatusNotFound},
+		Permission: model.PermCommentModerate,
+		Handler:    APICommentDeleteHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:        "comment_post_url",
+		Method:      "GET",
+		Path:        "/api/comments/post/:post_id",
+		Tag:         "comments",
+		Summary:     "List the approved comments on a post",
+		Description: "Use /api/posts/{post_id}/comments instead, to page through them.",
+		Params:      []APIParam{postIdParam},
+		Response:    model.PublicComments{},
+		Errors:      []int{http.StatusNotFound},
+		Handler:     APICommentPostHandler,
+	})
+}
 
-	app.Get("/api/comments/post/:post_id", APICommentPostHandler) // This can be removed. Use /api/posts/:post_id/comments instead.
-	routes["GET"]["comment_post_url"] = "/api/comments/post/:post_id"
+// A CommentRequestBody is the json-formatted request body used to create and
+// update comments. New comments are written in Markdown, while the content of
+// an update replaces the HTML of the comment.
+type CommentRequestBody struct {
+	PostId  string `json:"post_id"`
+	Parent  string `json:"parent"`
+	Content string `json:"content"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
 }
 
-// APICommentHandler retrieves a comment with the given comment id.
-func APICommentHandler(ctx *golf.Context) {
+// APICommentListJSON is a page of the comments listed for the moderators.
+type APICommentListJSON struct {
+	Comments model.Comments `json:"comments"`
+	Total    int64          `json:"total"`
+	Pages    int64          `json:"pages"`
+}
+
+// getCommentFromContext loads the comment referenced by the comment_id,
+// answering 404 if there is none.
+func getCommentFromContext(ctx *golf.Context) *model.Comment {
 	id := ctx.Param("comment_id")
-	// if err != nil {
-	// 	handleErr(ctx, 500, err)
-	// 	return
-	// }
-	comment := &model.Comment{Id: bson.ObjectIdHex(id)}
-	err := comment.GetCommentById()
-	if err != nil {
-		handleErr(ctx, 404, err)
+	c := new(model.Comment)
+	if bson.IsObjectIdHex(id) {
+		c.Id = bson.ObjectIdHex(id)
+	}
+	if len(c.Id) == 0 || c.GetCommentById() != nil {
+		sendAPIError(ctx, http.StatusNotFound, "comment not found")
+		return nil
+	}
+	return c
+}
+
+// APICommentHandler retrieves the public view of the comment with the given
+// comment id. Only the moderators get the comments not approved.
+func APICommentHandler(ctx *golf.Context) {
+	c := getCommentFromContext(ctx)
+	if c == nil {
 		return
 	}
-	ctx.JSONIndent(comment, "", "  ")
+	if !c.IsApproved() {
+		if u := requestJWTUser(ctx); u == nil || !u.Can(model.PermCommentModerate) {
+			sendAPIError(ctx, http.StatusNotFound, "comment not found")
+			return
+		}
+	}
+	ctx.JSON(NewAPISuccessResponse(c.Public()))
 }
 
-// APICommentPostHandler retrives the tag with the given post id.
+// APICommentPostHandler retrieves the public view of the approved comments on
+// the post with the given post id, replies nested.
 func APICommentPostHandler(ctx *golf.Context) {
-	id := ctx.Param("post_id")
-	// if err != nil {
-	// 	handleErr(ctx, 500, err)
-	// 	return
-	// }
+	post := getReadablePostFromContext(ctx, "post_id")
+	if post == nil {
+		return
+	}
 	comments := new(model.Comments)
-	err := comments.GetCommentsByPostId(id)
+	if err := comments.GetCommentsByPostId(post.Id.Hex()); err != nil {
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(comments.Public()))
+}
+
+// APICommentsHandler gets a page of the comments in the moderation state of
+// the status query parameter, of length <= limit. Without a status, the
+// comments neither spam nor trashed are listed.
+func APICommentsHandler(limit, maxLimit int) golf.HandlerFunc {
+	// limit is the default value of the limit parameter.
+	return func(ctx *golf.Context) {
+		status, _ := ctx.Query("status")
+		if status != "" && !validCommentStatus(status) {
+			sendAPIError(ctx, http.StatusBadRequest, "Unknown comment status.")
+			return
+		}
+		page, size := 1, limit
+		if q, _ := ctx.Query("page"); q != "" {
+			n, err := strconv.Atoi(q)
+			if err != nil || n < 1 {
+				sendAPIError(ctx, http.StatusBadRequest, "The page must be a positive number.")
+				return
+			}
+			page = n
+		}
+		if q, _ := ctx.Query("limit"); q != "" {
+			n, err := strconv.Atoi(q)
+			if err != nil || n < 1 || n > maxLimit {
+				sendAPIError(ctx, http.StatusBadRequest, "The limit must be between 1 and "+strconv.Itoa(maxLimit)+".")
+				return
+			}
+			size = n
+		}
+		list := APICommentListJSON{Comments: model.Comments{}}
+		pager, err := list.Comments.GetCommentListByStatus(status, int64(page), int64(size))
+		if err != nil {
+			sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		list.Total, list.Pages = pager.Total, pager.Pages
+		ctx.JSON(NewAPISuccessResponse(list))
+	}
+}
+
+// APICommentCreateHandler comments as the user of the token on the post, or
+// replies to the comment, given in the json-formatted request body. The
+// comments of the moderators are approved right away, and the others are
+// checked as the comments of the readers are.
+func APICommentCreateHandler(ctx *golf.Context) {
+	u, err := getJWTUser(ctx)
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		return
+	}
+	req := new(CommentRequestBody)
+	if err := readJSONBody(ctx, req); err != nil {
+		sendAPIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	post := new(model.Post)
+	if !bson.IsObjectIdHex(req.PostId) || post.GetPostById(bson.ObjectIdHex(req.PostId)) != nil {
+		sendAPIError(ctx, http.StatusNotFound, "post not found")
+		return
+	}
+	if !post.CommentsOpen() {
+		sendAPIError(ctx, http.StatusForbidden, "Comments are closed.")
+		return
+	}
+	c := model.NewComment()
+	c.Author = u.Name
+	c.Email = u.Email
+	c.Website = u.Website
+	c.Content = model.CommentContent(req.Content)
+	c.Avatar = utils.Gravatar(c.Email, "50")
+	c.PostId = post.Id.Hex()
+	c.Ip = remoteIP(ctx.Request)
+	c.UserAgent = ctx.Request.UserAgent()
+	c.UserId = u.Id.Hex()
+	if req.Parent != "" {
+		parent := &model.Comment{}
+		if bson.IsObjectIdHex(req.Parent) {
+			parent.Id = bson.ObjectIdHex(req.Parent)
+		}
+		if len(parent.Id) == 0 || parent.GetCommentById() != nil || parent.PostId != c.PostId {
+			sendAPIError(ctx, http.StatusBadRequest, "The parent is not a comment on the post.")
+			return
+		}
+		c.Parent = req.Parent
+	}
+	if c.Content == "" {
+		sendAPIError(ctx, http.StatusBadRequest, "The comment is empty.")
+		return
+	}
+	if u.Can(model.PermCommentModerate) {
+		c.Status = model.CommentApproved
+		c.ModeratedBy = u.Id.Hex()
+		c.ModeratedAt = c.CreatedAt
+	} else {
+		moderateNewComment(c, ctx.Request)
+	}
+	if err := c.Save(); err != nil {
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// Spam is answered as any comment awaiting moderation, so that
+	// spammers do not learn which filter caught them.
+	if c.IsSpam() {
+		answer := *c
+		answer.Status = model.CommentPending
+		answer.Reason = ""
+		ctx.SendStatus(http.StatusCreated)
+		ctx.JSON(NewAPISuccessResponse(&answer))
+		return
+	}
+	if err := post.CountComment(); err != nil {
+		log.Printf("[Error]: Can not increase comment count for post %v: %v", post.Id, err.Error())
+	}
+	ctx.SendStatus(http.StatusCreated)
+	ctx.JSON(NewAPISuccessResponse(c))
+	if err := model.NewMessage("comment", c).Insert(); err != nil {
+		log.Printf("[Error]: Can not add the message of comment %v: %v", c.Id.Hex(), err)
+	}
+	c.NotifyPostAuthor()
+	c.NotifyParent()
+}
+
+// APICommentUpdateHandler moderates the comment referenced by the comment_id,
+// setting the status and reason of the json-formatted request body, and
+// replaces its HTML with the content. Fields left out are not changed.
+func APICommentUpdateHandler(ctx *golf.Context) {
+	u, err := getJWTUser(ctx)
 	if err != nil {
-		handleErr(ctx, 404, err)
+		ctx.SendStatus(http.StatusInternalServerError)
+		return
+	}
+	c := getCommentFromContext(ctx)
+	if c == nil {
+		return
+	}
+	req := new(CommentRequestBody)
+	if err := readJSONBody(ctx, req); err != nil {
+		sendAPIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Status != "" && !validCommentStatus(req.Status) {
+		sendAPIError(ctx, http.StatusBadRequest, "Unknown comment status.")
 		return
 	}
-	ctx.JSONIndent(comments, "", "  ")
+	if req.Content != "" {
+		if err := c.Edit(req.Content); err == model.ErrEmptyComment {
+			sendAPIError(ctx, http.StatusBadRequest, "The comment is empty.")
+			return
+		} else if err != nil {
+			sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if req.Status != "" {
+		wasApproved := c.IsApproved()
+		if err := c.Moderate(req.Status, req.Reason, u); err != nil {
+			sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !wasApproved && c.IsApproved() {
+			defer c.NotifyParent()
+		}
+	}
+	ctx.JSON(NewAPISuccessResponse(c))
 }
 
-// APICommentsHandler retrieves all the comments.
-func APICommentsHandler(ctx *golf.Context) {
-	ctx.JSONIndent(map[string]interface{}{
-		"message": "Not implemented",
-	}, "", "  ")
+// APICommentDeleteHandler deletes the comment referenced by the comment_id,
+// along with its replies.
+func APICommentDeleteHandler(ctx *golf.Context) {
+	c := getCommentFromContext(ctx)
+	if c == nil {
+		return
+	}
+	if err := model.DeleteComment(c.Id.Hex()); err != nil {
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(nil))
 }
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommentModeration(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		p := mockPost()
+		p.IsPublished = true
+		So(p.Save(), ShouldBeNil)
+		admin := mockRoleUser(model.RoleAdministrator)
+
+		var comments []*model.Comment
+		for _, author := range []string{"a", "b", "c"} {
+			c := model.NewComment()
+			c.PostId = p.Id.Hex()
+			c.Author = author
+			c.Email = author + "@example.com"
+			c.Content = "<p>Comment of " + author + "</p>"
+			So(c.Save(), ShouldBeNil)
+			comments = append(comments, c)
+		}
+		stored := func(c *model.Comment) *model.Comment {
+			s := &model.Comment{Id: c.Id}
+			So(s.GetCommentById(), ShouldBeNil)
+			return s
+		}
+
+		Convey("Moderate several comments at once", func() {
+			form := url.Values{}
+			form.Add("id", comments[0].Id.Hex())
+			form.Add("id", comments[1].Id.Hex())
+			form.Add("status", model.CommentRejected)
+			form.Add("reason", "off topic")
+			So(serve(roleContext(admin, form, "PUT", "/admin/comments/")), ShouldEqual, 200)
+			for _, c := range comments[:2] {
+				c = stored(c)
+				So(c.Status, ShouldEqual, model.CommentRejected)
+				So(c.Reason, ShouldEqual, "off topic")
+				So(c.ModeratedBy, ShouldEqual, admin.Id.Hex())
+			}
+			So(stored(comments[2]).Status, ShouldEqual, model.CommentPending)
+
+			// Without a status, the comments are approved.
+			form = url.Values{}
+			form.Add("id", comments[2].Id.Hex())
+			So(serve(roleContext(admin, form, "PUT", "/admin/comments/")), ShouldEqual, 200)
+			So(stored(comments[2]).IsApproved(), ShouldBeTrue)
+
+			ctx := roleContext(admin, nil, "GET", "/admin/comments/?tab=rejected")
+			So(serve(ctx), ShouldEqual, 200)
+			body := ctx.Response.(*httptest.ResponseRecorder).Body.String()
+			So(body, ShouldContainSubstring, `id="comment-`+comments[0].Id.Hex()+`"`)
+			So(body, ShouldNotContainSubstring, `id="comment-`+comments[2].Id.Hex()+`"`)
+		})
+
+		Convey("Refuse the bad moderation requests", func() {
+			form := url.Values{}
+			form.Add("id", comments[0].Id.Hex())
+			form.Add("status", "deleted")
+			So(serve(roleContext(admin, form, "PUT", "/admin/comments/")), ShouldEqual, 400)
+			So(serve(roleContext(admin, url.Values{}, "PUT", "/admin/comments/")), ShouldEqual, 400)
+
+			form = url.Values{}
+			form.Add("id", comments[0].Id.Hex())
+			form.Add("id", "5a0000000000000000000001")
+			So(serve(roleContext(admin, form, "PUT", "/admin/comments/")), ShouldEqual, 404)
+			So(stored(comments[0]).Status, ShouldEqual, model.CommentPending)
+		})
+
+		Convey("Edit the text of a comment", func() {
+			form := url.Values{}
+			form.Add("id", comments[0].Id.Hex())
+			form.Add("content", `<p>Edited <script>alert(1)</script></p>`)
+			ctx := roleContext(admin, form, "PUT", "/admin/comments/")
+			So(serve(ctx), ShouldEqual, 200)
+			So(stored(comments[0]).Content, ShouldEqual, "<p>Edited </p>")
+			So(stored(comments[0]).Status, ShouldEqual, model.CommentPending)
+			var resp struct {
+				Comment struct {
+					Content string
+				}
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Comment.Content, ShouldEqual, "<p>Edited </p>")
+
+			form.Add("id", comments[1].Id.Hex())
+			So(serve(roleContext(admin, form, "PUT", "/admin/comments/")), ShouldEqual, 400)
+		})
+
+		Convey("Delete several comments at once", func() {
+			path := "/admin/comments/?id=" + comments[0].Id.Hex() + "&id=" + comments[1].Id.Hex()
+			So(serve(roleContext(admin, nil, "DELETE", path)), ShouldEqual, 200)
+			for _, c := range comments[:2] {
+				So(c.GetCommentById(), ShouldEqual, model.ErrNotFound)
+			}
+			So(comments[2].GetCommentById(), ShouldBeNil)
+
+			ctx := roleContext(admin, nil, "DELETE", path)
+			So(serve(ctx), ShouldEqual, 404)
+			So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, `"status":"error"`)
+		})
+
+		Convey("Approve the comments of the returning commenters", func() {
+			So(model.NewSetting("comment_auto_approve", "on", "blog").Save(), ShouldBeNil)
+			So(comments[0].Moderate(model.CommentApproved, "", admin), ShouldBeNil)
+			form := url.Values{}
+			form.Add("author", "a")
+			form.Add("email", "a@example.com")
+			form.Add("comment", "Again")
+			ctx := mockContext(form, "POST", "/comment/"+p.Id.Hex()+"/")
+			So(serve(ctx), ShouldEqual, 200)
+			var resp struct {
+				Comment struct {
+					Approved bool
+				}
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Comment.Approved, ShouldBeTrue)
+		})
+
+		Convey("Close the comments of the older posts", func() {
+			published := time.Now().AddDate(0, 0, -10)
+			p.PublishedAt = &published
+			p.CommentDays = 7
+			So(p.Save(), ShouldBeNil)
+			form := url.Values{}
+			form.Add("author", "late")
+			form.Add("email", "late@example.com")
+			form.Add("comment", "Too late")
+			So(serve(mockContext(form, "POST", "/comment/"+p.Id.Hex()+"/")), ShouldEqual, 403)
+
+			ctx := mockContext(nil, "GET", p.Url())
+			So(serve(ctx), ShouldEqual, 200)
+			body := ctx.Response.(*httptest.ResponseRecorder).Body.String()
+			So(body, ShouldContainSubstring, "Comments are closed.")
+			So(body, ShouldNotContainSubstring, `id="comment-form"`)
+		})
+
+		Reset(func() {
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommentUnsubscribe(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+
+		p := mockPost()
+		p.IsPublished = true
+		p.Save()
+		c := model.NewComment()
+		c.PostId = p.Id.Hex()
+		c.Notify = true
+		c.Save()
+
+		Convey("Unsubscribe with the signed link", func() {
+			So(serve(mockContext(nil, "GET", c.UnsubscribeUrl())), ShouldEqual, 200)
+			So(c.GetCommentById(), ShouldBeNil)
+			So(c.Notify, ShouldBeFalse)
+		})
+
+		Convey("Forged links are refused", func() {
+			forged := strings.Replace(c.UnsubscribeUrl(), "sig=", "sig=0", 1)
+			So(serve(mockContext(nil, "GET", forged)), ShouldEqual, 403)
+			So(c.GetCommentById(), ShouldBeNil)
+			So(c.Notify, ShouldBeTrue)
+		})
+
+		Reset(func() {
+			model.DropDatabase()
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommentMarkdown(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		p := mockPost()
+		p.IsPublished = true
+		p.Save()
+
+		comment := func(text, pid string) map[string]interface{} {
+			form := url.Values{}
+			form.Add("author", "Reader")
+			form.Add("email", "reader@example.com")
+			form.Add("comment", text)
+			form.Add("pid", pid)
+			ctx := mockContext(form, "POST", "/comment/"+p.Id.Hex()+"/")
+			So(serve(ctx), ShouldEqual, 200)
+			var resp struct {
+				Comment map[string]interface{}
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			return resp.Comment
+		}
+
+		Convey("Render the Markdown of the comments", func() {
+			c := comment("Use *this*:\n\n```\nx := `<b>`\n```\n\n> quoted\n\n[docs](https://golang.org)", "")
+			So(c["content"], ShouldEqual, "<p>Use <em>this</em>:</p>\n\n<pre><code>x := `&lt;b&gt;`\n</code></pre>\n\n"+
+				"<blockquote>\n<p>quoted</p>\n</blockquote>\n\n"+
+				`<p><a href="https://golang.org" rel="nofollow ugc">docs</a></p>`)
+		})
+
+		Convey("Quote the parent comment in a quote block", func() {
+			parent := comment("First line\nsecond *line*", "")
+			reply := comment("Reply", parent["id"].(string))
+			So(reply["parent_content"], ShouldEqual, "> @Reader\n> \n> First line  \n> second *line*\n")
+		})
+
+		Convey("Preview the comments as they are saved", func() {
+			form := url.Values{}
+			form.Add("comment", "**Bold** <script>alert(1)</script>[x](javascript:void)")
+			ctx := mockContext(form, "POST", "/comment/preview/")
+			So(serve(ctx), ShouldEqual, 200)
+			var resp struct {
+				Status string
+				Html   string
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Status, ShouldEqual, "success")
+			So(resp.Html, ShouldEqual, `<p><strong>Bold</strong> <a rel="nofollow ugc">x</a></p>`)
+
+			comments := new(model.Comments)
+			So(comments.GetCommentsByPostId(p.Id.Hex()), ShouldBeNil)
+			So(comments.Len(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommentSpam(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+
+		p := mockPost()
+		p.IsPublished = true
+		p.Save()
+		form := url.Values{}
+		form.Add("author", "Bot")
+		form.Add("email", "bot@example.com")
+		form.Add("comment", "Hello")
+		form.Add(model.CommentHoneypotField, "http://spam.example")
+
+		Convey("Spam is answered like any other comment", func() {
+			ctx := mockContext(form, "POST", "/comment/"+p.Id.Hex()+"/")
+			So(serve(ctx), ShouldEqual, 200)
+			So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, `"res":true`)
+
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.CommentNum, ShouldEqual, 0)
+			num, _ := model.GetNumberOfSpamComments()
+			So(num, ShouldEqual, 1)
+		})
+
+		Convey("Manage the spam in the admin", func() {
+			spam := model.NewComment()
+			spam.PostId = p.Id.Hex()
+			spam.Status = model.CommentSpam
+			spam.Save()
+			admin := mockRoleUser(model.RoleAdministrator)
+
+			So(serve(roleContext(admin, nil, "GET", "/admin/comments/?tab=spam")), ShouldEqual, 200)
+
+			notSpam := url.Values{}
+			notSpam.Add("id", spam.Id.Hex())
+			So(serve(roleContext(admin, notSpam, "POST", "/admin/comments/spam/")), ShouldEqual, 200)
+			So(spam.GetCommentById(), ShouldBeNil)
+			So(spam.Status, ShouldEqual, model.CommentPending)
+			So(spam.ModeratedBy, ShouldEqual, admin.Id.Hex())
+
+			spam.Status = model.CommentSpam
+			spam.Save()
+			So(serve(roleContext(admin, nil, "DELETE", "/admin/comments/spam/")), ShouldEqual, 200)
+			So(spam.GetCommentById(), ShouldEqual, model.ErrNotFound)
+		})
+
+		Reset(func() {
+			model.DropDatabase()
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommentThread(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		p := mockPost()
+		p.IsPublished = true
+		So(p.Save(), ShouldBeNil)
+
+		// Every comment replies to the one before.
+		var comments []*model.Comment
+		created := time.Now().Add(-time.Hour)
+		for _, author := range []string{"a", "b", "c", "d", "e"} {
+			c := model.NewComment()
+			c.PostId = p.Id.Hex()
+			c.Author = author
+			c.Email = author + "@example.com"
+			c.Content = "<p>Comment of " + author + "</p>"
+			c.Status = model.CommentApproved
+			created = created.Add(time.Minute)
+			at := created
+			c.CreatedAt = &at
+			if len(comments) > 0 {
+				c.Parent = comments[len(comments)-1].Id.Hex()
+			}
+			So(c.Save(), ShouldBeNil)
+			comments = append(comments, c)
+		}
+
+		getPage := func(query string) (int, []string, string) {
+			ctx := mockContext(nil, "GET", "/api/posts/"+p.Id.Hex()+"/comments"+query)
+			code := serve(ctx)
+			var resp struct {
+				Data struct {
+					Comments []struct {
+						Author string
+					}
+					NextCursor string `json:"next_cursor"`
+				}
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			authors := []string{}
+			for _, c := range resp.Data.Comments {
+				authors = append(authors, c.Author)
+			}
+			return code, authors, resp.Data.NextCursor
+		}
+
+		Convey("Page through the comments of a post", func() {
+			code, authors, cursor := getPage("?limit=2")
+			So(code, ShouldEqual, 200)
+			So(authors, ShouldResemble, []string{"a", "b"})
+			So(cursor, ShouldEqual, comments[1].Id.Hex())
+
+			_, authors, cursor = getPage("?limit=2&cursor=" + cursor)
+			So(authors, ShouldResemble, []string{"c", "d"})
+			_, authors, cursor = getPage("?limit=2&cursor=" + cursor)
+			So(authors, ShouldResemble, []string{"e"})
+			So(cursor, ShouldEqual, "")
+
+			_, authors, cursor = getPage("?sort=newest&limit=3")
+			So(authors, ShouldResemble, []string{"e", "d", "c"})
+			_, authors, _ = getPage("?sort=newest&cursor=" + cursor)
+			So(authors, ShouldResemble, []string{"b", "a"})
+		})
+
+		Convey("Reject the bad parameters", func() {
+			for _, query := range []string{"?sort=random", "?limit=0", "?limit=1000", "?cursor=nope", "?cursor=" + p.Id.Hex()} {
+				code, _, _ := getPage(query)
+				So(code, ShouldEqual, 400)
+			}
+			for _, id := range []string{bson.NewObjectId().Hex(), "nothex"} {
+				So(serve(mockContext(nil, "GET", "/api/posts/"+id+"/comments")), ShouldEqual, 404)
+			}
+		})
+
+		Convey("Reply from the comment form to the comments on the post only", func() {
+			other := mockPost()
+			other.IsPublished = true
+			So(other.Save(), ShouldBeNil)
+			elsewhere := model.NewComment()
+			elsewhere.PostId = other.Id.Hex()
+			elsewhere.Status = model.CommentApproved
+			So(elsewhere.Save(), ShouldBeNil)
+
+			reply := func(pid string) int {
+				form := url.Values{}
+				form.Add("author", "f")
+				form.Add("email", "f@example.com")
+				form.Add("comment", "Reply")
+				form.Add("pid", pid)
+				return serve(mockContext(form, "POST", "/comment/"+p.Id.Hex()+"/"))
+			}
+			So(reply(elsewhere.Id.Hex()), ShouldEqual, 400)
+			So(reply(bson.NewObjectId().Hex()), ShouldEqual, 400)
+			So(reply(comments[4].Id.Hex()), ShouldEqual, 200)
+			n, err := model.GetNumberOfComments()
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, int64(len(comments)+2))
+		})
+
+		Convey("Nest the comments as deep as the theme shows them", func() {
+			ctx := mockContext(nil, "GET", p.Url())
+			So(serve(ctx), ShouldEqual, 200)
+			body := ctx.Response.(*httptest.ResponseRecorder).Body.String()
+			for _, c := range comments {
+				So(body, ShouldContainSubstring, `id="comment-`+c.Id.Hex()+`"`)
+			}
+			So(body, ShouldContainSubstring, "in response to d")
+			list := body[strings.Index(body, `id="comment-list"`):]
+			list = list[:strings.Index(list, `id="comment-show"`)]
+			So(strings.Count(list, `<ul class="comment-children">`), ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+)
+
+// FeedHandler serves the feed of the latest posts of the blog.
+func FeedHandler(ctx *golf.Context) {
+	serveFeed(ctx, model.SiteFeed)
+}
+
+// TagFeedHandler serves the feed of the latest posts with the tag.
+func TagFeedHandler(ctx *golf.Context) {
+	tagSlug, _ := url.QueryUnescape(ctx.Param("tag"))
+	tag := &model.Tag{Slug: tagSlug}
+	if err := tag.GetTagBySlug(); err != nil {
+		ctx.Abort(http.StatusNotFound)
+		return
+	}
+	serveFeed(ctx, func() (*model.Feed, error) {
+		return model.TagFeed(*tag)
+	})
+}
+
+// AuthorFeedHandler serves the feed of the latest posts written by the user.
+func AuthorFeedHandler(ctx *golf.Context) {
+	user := &model.User{Slug: ctx.Param("author")}
+	if err := user.GetUserBySlug(); err != nil {
+		ctx.Abort(http.StatusNotFound)
+		return
+	}
+	serveFeed(ctx, func() (*model.Feed, error) {
+		return model.AuthorFeed(user)
+	})
+}
+
+// serveFeed renders the feed in the format given by the "format" parameter,
+// and sends it unless the reader already has it.
+func serveFeed(ctx *golf.Context, newFeed func() (*model.Feed, error)) {
+	format, ok := model.FeedFormats[ctx.Param("format")]
+	if !ok {
+		ctx.Abort(http.StatusNotFound)
+		return
+	}
+	feed, err := newFeed()
+	if err != nil {
+		panic(err)
+	}
+	feed.FeedLink = model.AbsoluteUrl(ctx.Request.URL.Path)
+	body, err := format.Render(feed)
+	if err != nil {
+		panic(err)
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(body))
+	ctx.SetHeader("ETag", etag)
+	if !feed.Updated.IsZero() {
+		ctx.SetHeader("Last-Modified", feed.Updated.UTC().Format(http.TimeFormat))
+	}
+	if notModified(ctx.Request, etag, feed.Updated) {
+		ctx.SendStatus(http.StatusNotModified)
+		return
+	}
+	ctx.SetHeader("Content-Type", format.ContentType)
+	ctx.Send(body)
+}
+
+// notModified reports whether the conditional request already has the
+// content with the given ETag and modification time. As in RFC 7232,
+// If-Modified-Since is ignored when If-None-Match is given.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, m := range strings.Split(match, ",") {
+			m = strings.TrimPrefix(strings.TrimSpace(m), "W/")
+			if m == etag || m == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
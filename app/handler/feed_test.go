@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFeed(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+
+		author := model.NewUser("author@example.com", "Author")
+		author.Create("password")
+		p := mockPost()
+		p.IsPublished = true
+		p.CreatedBy = author.Id.Hex()
+		p.Save(model.NewTag("Go", "go"))
+
+		Convey("Serve the feeds in every format", func() {
+			for path, contentType := range map[string]string{
+				"/feed/":                            "application/rss+xml; charset=utf-8",
+				"/feed/atom/":                       "application/atom+xml; charset=utf-8",
+				"/feed/json/":                       "application/feed+json; charset=utf-8",
+				"/tag/go/feed/atom/":                "application/atom+xml; charset=utf-8",
+				"/author/" + author.Slug + "/feed/": "application/rss+xml; charset=utf-8",
+			} {
+				ctx := mockContext(nil, "GET", path)
+				So(serve(ctx), ShouldEqual, 200)
+				rec := ctx.Response.(*httptest.ResponseRecorder)
+				So(rec.Header().Get("Content-Type"), ShouldEqual, contentType)
+				So(rec.Body.String(), ShouldContainSubstring, p.Title)
+			}
+		})
+
+		Convey("Unknown feeds are not found", func() {
+			So(serve(mockContext(nil, "GET", "/feed/unknown/")), ShouldEqual, 404)
+			So(serve(mockContext(nil, "GET", "/tag/unknown/feed/")), ShouldEqual, 404)
+			So(serve(mockContext(nil, "GET", "/author/unknown/feed/")), ShouldEqual, 404)
+		})
+
+		Convey("Unchanged feeds are not sent again", func() {
+			ctx := mockContext(nil, "GET", "/feed/")
+			So(serve(ctx), ShouldEqual, 200)
+			rec := ctx.Response.(*httptest.ResponseRecorder)
+			etag := rec.Header().Get("ETag")
+			So(etag, ShouldNotBeEmpty)
+			modified := rec.Header().Get("Last-Modified")
+			So(modified, ShouldNotBeEmpty)
+
+			ctx = mockContext(nil, "GET", "/feed/")
+			ctx.Request.Header.Set("If-None-Match", etag)
+			So(serve(ctx), ShouldEqual, 304)
+
+			ctx = mockContext(nil, "GET", "/feed/")
+			ctx.Request.Header.Set("If-Modified-Since", modified)
+			So(serve(ctx), ShouldEqual, 304)
+
+			ctx = mockContext(nil, "GET", "/feed/")
+			ctx.Request.Header.Set("If-None-Match", `"stale"`)
+			ctx.Request.Header.Set("If-Modified-Since", modified)
+			So(serve(ctx), ShouldEqual, 200)
+
+			ctx = mockContext(nil, "GET", "/feed/atom/")
+			ctx.Request.Header.Set("If-None-Match", etag)
+			So(serve(ctx), ShouldEqual, 200)
+		})
+
+		Reset(func() {
+			model.DropDatabase()
+		})
+	})
+}
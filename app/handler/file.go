@@ -1,43 +1,47 @@
 package handler
 
 import (
-	"github.com/dinever/golf"
-	"github.com/covrom/dingo/app/model"
-	"github.com/covrom/dingo/app/utils"
+	"fmt"
 	"io/ioutil"
-	"os"
+	"net/http"
 	"path"
 	"strings"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/covrom/dingo/app/utils"
+	"github.com/dinever/golf"
 )
 
 func FileViewHandler(ctx *golf.Context) {
 	user, _ := ctx.Session.Get("user")
-	uploadDir, _ := ctx.App.Config.GetString("upload_dir", "upload")
-	uploadDir = path.Clean(uploadDir)
 	ctx.Request.ParseForm()
-	dir, err := ctx.Query("dir")
-	dir = path.Clean(dir)
-	var (
-		ParentDir  string
-		IsChildDir bool
-	)
-	if err == nil && dir != uploadDir {
-		IsChildDir = true
-		ParentDir = path.Join(dir, "..")
-	} else {
-		IsChildDir = false
-		dir = uploadDir
+	dir := model.CleanMediaKey(ctx.Request.FormValue("dir"))
+	var ParentDir string
+	IsChildDir := dir != ""
+	if IsChildDir {
+		ParentDir = model.CleanMediaKey(path.Dir(strings.TrimSuffix(dir, "/")) + "/")
 	}
-	var files []*model.File
-	if model.CheckSafe(dir, uploadDir) {
-		files = model.GetFileList(dir)
-	} else {
-		ctx.Abort(403)
-		return
+	list, err := model.Media().List(dir)
+	if err != nil {
+		panic(err)
+	}
+	// The sizes generated for the images are shown through the images.
+	files := make([]*model.File, 0, len(list))
+	items := make(map[string]*model.MediaItem)
+	for _, f := range list {
+		if f.IsDir || !model.IsImageSizeKey(f.Key) {
+			files = append(files, f)
+		}
+		if !f.IsDir {
+			if item, err := model.GetMediaItemByKey(f.Key); err == nil {
+				items[f.Key] = item
+			}
+		}
 	}
 	ctx.Loader("admin").Render("files.html", map[string]interface{}{
 		"Title":      "Files",
 		"Files":      files,
+		"Media":      items,
 		"User":       user,
 		"CurrentDir": dir,
 		"IsChildDir": IsChildDir,
@@ -45,17 +49,40 @@ func FileViewHandler(ctx *golf.Context) {
 	})
 }
 
+// FileRemoveHandler deletes a file, or a directory with everything under it.
+// A file still used by posts is only deleted when the `force` parameter is
+// set, and the posts using it are listed otherwise.
 func FileRemoveHandler(ctx *golf.Context) {
-	p := ctx.Request.FormValue("path")
-	uploadDir, _ := ctx.App.Config.GetString("upload_dir", "upload")
-	if model.CheckSafe(p, uploadDir) {
-		err := model.RemoveFile(p)
+	key := model.CleanMediaKey(ctx.Request.FormValue("path"))
+	if key == "" {
+		ctx.Abort(403)
+		return
+	}
+	if ctx.Request.FormValue("force") == "" {
+		posts, err := model.GetMediaPosts(key)
 		if err != nil {
 			panic(err)
 		}
-	} else {
-		ctx.Abort(403)
-		return
+		if len(posts) > 0 {
+			used := make([]map[string]string, len(posts))
+			for i, p := range posts {
+				used[i] = map[string]string{
+					"title": p.Title,
+					"url":   p.Url(),
+					"edit":  "/admin/editor/" + p.Id.Hex() + "/",
+				}
+			}
+			ctx.SendStatus(http.StatusConflict)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    fmt.Sprintf("This file is used by %d posts.", len(posts)),
+				"posts":  used,
+			})
+			return
+		}
+	}
+	if err := model.DeleteMedia(key); err != nil {
+		panic(err)
 	}
 	ctx.JSON(map[string]interface{}{
 		"status": "success",
@@ -95,33 +122,40 @@ func FileUploadHandler(ctx *golf.Context) {
 		})
 		return
 	}
-	uploadDir, _ := ctx.App.Config.GetString("upload_dir", "upload")
-	Url := model.CreateFilePath(uploadDir, h.Filename)
-	e = ioutil.WriteFile(Url, data, os.ModePerm)
-	if e != nil {
+	user, _ := ctx.Session.Get("user")
+	item, duplicate, e := model.SaveUpload(h.Filename, data, user.(*model.User).Id.Hex())
+	if e == model.ErrInvalidImage {
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
-			"msg":    e.Error(),
+			"msg":    "Invalid image.",
 		})
 		return
 	}
-	fi, err := os.Stat(Url)
-	if err != nil {
+	if e == model.ErrImageTooLarge {
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "The image is too large.",
+		})
+		return
+	}
+	if e != nil {
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
-			"msg": e.Error(),
+			"msg":    e.Error(),
 		})
 		return
 	}
-	
-	fSize := utils.FileSize(fi.Size())
-	fileModTime := fi.ModTime()
-	fModTime := utils.DateFormat(&fileModTime, "%Y-%m-%d %H:%M")
+
+	fSize := utils.FileSize(item.Size)
+	fModTime := utils.DateFormat(item.CreatedAt, "%Y-%m-%d %H:%M")
 	ctx.JSON(map[string]interface{}{
-		"status": "success",
+		"status":    "success",
+		"duplicate": duplicate,
 		"file": map[string]interface{}{
-			"url":  Url,
-			"name": h.Filename,
+			"id":   item.Id.Hex(),
+			"key":  item.Key,
+			"url":  item.Url(),
+			"name": path.Base(item.Key),
 			"size": fSize,
 			"type": "File",
 			"time": fModTime,
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func uploadContext(u *model.User, name, content string) *golf.Context {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	f, _ := w.CreateFormFile("file", name)
+	f.Write([]byte(content))
+	w.Close()
+
+	ctx := roleContext(u, nil, "POST", "/admin/files/upload/")
+	req := makeTestHTTPRequest(&body, "POST", "/admin/files/upload/")
+	req.Header["Cookie"] = ctx.Request.Header["Cookie"]
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return golf.NewContext(req, httptest.NewRecorder(), ctx.App)
+}
+
+func TestFiles(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		uploadDir, _ := ioutil.TempDir("", "dingo-upload")
+		So(model.InitializeMedia("", uploadDir), ShouldBeNil)
+		admin := mockRoleUser(model.RoleAdministrator)
+
+		Convey("Upload a file to the media store", func() {
+			ctx := uploadContext(admin, "notes.txt", "notes")
+			So(serve(ctx), ShouldEqual, 200)
+			var resp struct {
+				Status string
+				File   map[string]string
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Status, ShouldEqual, "success")
+			So(resp.File["key"], ShouldEqual, "notes.txt")
+			So(resp.File["url"], ShouldEqual, "/upload/notes.txt")
+			b, _ := ioutil.ReadFile(filepath.Join(uploadDir, "notes.txt"))
+			So(string(b), ShouldEqual, "notes")
+
+			Convey("List and delete it", func() {
+				ctx := roleContext(admin, nil, "GET", "/admin/files/")
+				So(serve(ctx), ShouldEqual, 200)
+				So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, `rel="notes.txt"`)
+
+				ctx = roleContext(admin, nil, "DELETE", "/admin/files/?path=notes.txt")
+				So(serve(ctx), ShouldEqual, 200)
+				_, err := os.Stat(filepath.Join(uploadDir, "notes.txt"))
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("Upload an image along with its sizes", func() {
+			var img bytes.Buffer
+			png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 800, 400)))
+			ctx := uploadContext(admin, "image.png", img.String())
+			So(serve(ctx), ShouldEqual, 200)
+			for _, key := range []string{"image.png", "image@thumbnail.png", "image@medium.png", "image@large.png"} {
+				_, err := os.Stat(filepath.Join(uploadDir, key))
+				So(err, ShouldBeNil)
+			}
+
+			Convey("List it without its sizes", func() {
+				ctx := roleContext(admin, nil, "GET", "/admin/files/")
+				So(serve(ctx), ShouldEqual, 200)
+				body := ctx.Response.(*httptest.ResponseRecorder).Body.String()
+				So(body, ShouldContainSubstring, `src="/upload/image@thumbnail.png"`)
+				So(body, ShouldNotContainSubstring, `rel="image@thumbnail.png"`)
+			})
+
+			Convey("Delete it along with its sizes", func() {
+				ctx := roleContext(admin, nil, "DELETE", "/admin/files/?path=image.png")
+				So(serve(ctx), ShouldEqual, 200)
+				files, _ := ioutil.ReadDir(uploadDir)
+				So(files, ShouldBeEmpty)
+			})
+
+			Convey("Warn before deleting it while a post uses it", func() {
+				p := mockPost()
+				p.Markdown = "![](/upload/image@large.png)"
+				So(p.Save(), ShouldBeNil)
+
+				ctx := roleContext(admin, nil, "DELETE", "/admin/files/?path=image.png")
+				So(serve(ctx), ShouldEqual, 409)
+				var resp struct {
+					Posts []map[string]string
+				}
+				json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+				So(resp.Posts, ShouldHaveLength, 1)
+				So(resp.Posts[0]["title"], ShouldEqual, p.Title)
+				_, err := os.Stat(filepath.Join(uploadDir, "image.png"))
+				So(err, ShouldBeNil)
+
+				ctx = roleContext(admin, nil, "DELETE", "/admin/files/?path=image.png&force=1")
+				So(serve(ctx), ShouldEqual, 200)
+				_, err = os.Stat(filepath.Join(uploadDir, "image.png"))
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+
+			Convey("Give it back when it is uploaded again", func() {
+				ctx := uploadContext(admin, "copy.png", img.String())
+				So(serve(ctx), ShouldEqual, 200)
+				var resp struct {
+					Duplicate bool
+					File      map[string]string
+				}
+				json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+				So(resp.Duplicate, ShouldBeTrue)
+				So(resp.File["key"], ShouldEqual, "image.png")
+			})
+		})
+
+		Convey("Refuse invalid images", func() {
+			ctx := uploadContext(admin, "image.png", "image")
+			So(serve(ctx), ShouldEqual, 200)
+			So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, "Invalid image.")
+			_, err := os.Stat(filepath.Join(uploadDir, "image.png"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("The root of the media store can not be deleted", func() {
+			ctx := roleContext(admin, nil, "DELETE", "/admin/files/?path=../")
+			So(serve(ctx), ShouldEqual, 403)
+			_, err := os.Stat(uploadDir)
+			So(err, ShouldBeNil)
+		})
+
+		Reset(func() {
+			os.RemoveAll(uploadDir)
+			model.InitializeMedia("", "upload")
+		})
+	})
+}
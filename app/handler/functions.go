@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"time"
+
 	"github.com/covrom/dingo/app/model"
 )
 
@@ -16,6 +18,14 @@ func getRecentPosts() []*model.Post {
 	return *posts
 }
 
+// getPopularPosts returns the n most viewed posts of the last given number of
+// days.
+func getPopularPosts(n int, days int) []*model.Post {
+	posts := new(model.Posts)
+	_ = posts.GetPopularPosts(n, time.Now().AddDate(0, 0, -days))
+	return *posts
+}
+
 func getRecentComments() []*model.Comment {
 	comments := new(model.Comments)
 	comments.GetCommentList(1, 5, true)
@@ -1,8 +1,9 @@
 package handler
 
 import (
-	"html/template"
 	"log"
+	"net"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -18,8 +19,12 @@ func RegisterFunctions(app *golf.Application) {
 	app.View.FuncMap["Tags"] = getAllTags
 	app.View.FuncMap["RecentPosts"] = getRecentPosts
 	app.View.FuncMap["RecentComments"] = getRecentComments
+	app.View.FuncMap["PopularPosts"] = getPopularPosts
 }
 
+// postsPerPage is the number of posts of the pages listing posts.
+const postsPerPage = 5
+
 func HomeHandler(ctx *golf.Context) {
 	p := ctx.Param("page")
 
@@ -29,77 +34,129 @@ func HomeHandler(ctx *golf.Context) {
 	} else {
 		page, _ = strconv.Atoi(p)
 	}
-	posts := new(model.Posts)
-	pager, err := posts.GetPostList(int64(page), 5, false, true, "published_at DESC")
+	data, err := homeData(page)
 	if err != nil {
 		ctx.Abort(404)
 		return
 	}
+	//	updateSidebarData(data)
+	ctx.Loader("theme").Render("index.html", data)
+}
+
+// homeData returns the data of the given page of the home page.
+func homeData(page int) (map[string]interface{}, error) {
+	posts := new(model.Posts)
+	pager, err := posts.GetPostList(int64(page), postsPerPage, false, true, "published_at DESC")
+	if err != nil {
+		return nil, err
+	}
 	// theme := model.GetSetting("site_theme")
-	data := map[string]interface{}{
+	return map[string]interface{}{
 		"Title": "Home",
 		"Posts": posts,
 		"Pager": pager,
-	}
-	//	updateSidebarData(data)
-	ctx.Loader("theme").Render("index.html", data)
+	}, nil
 }
 
 func ContentHandler(ctx *golf.Context) {
 	slug := ctx.Param("slug")
 	post := new(model.Post)
 	err := post.GetPostBySlug(slug)
-	if err != nil || !post.IsPublished {
+	if err != nil || !post.IsPublic() {
 		ctx.Abort(404)
 		return
 	}
-	post.Hits++
-	data := map[string]interface{}{
+	ctx.Loader("theme").Render(contentTemplate(post), contentData(post))
+}
+
+// contentTemplate returns the theme template of the post or page.
+func contentTemplate(post *model.Post) string {
+	if post.IsPage {
+		return "page.html"
+	}
+	return "article.html"
+}
+
+// contentData returns the data of the page of the post.
+func contentData(post *model.Post) map[string]interface{} {
+	return map[string]interface{}{
 		"Title":    post.Title,
 		"Post":     post,
 		"Content":  post,
 		"Comments": post.Comments,
 	}
-	if post.IsPage {
-		ctx.Loader("theme").Render("page.html", data)
-	} else {
-		ctx.Loader("theme").Render("article.html", data)
-	}
 }
 
 func CommentHandler(ctx *golf.Context) {
 	id := ctx.Param("id")
 	// cid, _ := strconv.Atoi(id)
 	post := new(model.Post)
-	post.Id = bson.ObjectIdHex(id)
-	err := post.GetPostById()
-	if len(id) == 0 || err != nil {
+	var err error
+	if bson.IsObjectIdHex(id) {
+		post.Id = bson.ObjectIdHex(id)
+		err = post.GetPostById()
+	}
+	if len(post.Id) == 0 || err != nil {
+		ctx.SendStatus(http.StatusNotFound)
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
+			"msg":    "Post not found.",
 		})
+		return
+	}
+	if !post.CommentsOpen() {
+		ctx.SendStatus(http.StatusForbidden)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Comments are closed.",
+		})
+		return
 	}
 	c := model.NewComment()
 	c.Author = ctx.Request.FormValue("author")
 	c.Email = ctx.Request.FormValue("email")
 	c.Website = ctx.Request.FormValue("website")
-	c.Content = strings.Replace(utils.Html2Str(template.HTMLEscapeString(ctx.Request.FormValue("comment"))), "\n", "<br/>", -1)
+	c.Content = model.CommentContent(ctx.Request.FormValue("comment"))
 	c.Avatar = utils.Gravatar(c.Email, "50")
 	c.PostId = post.Id.Hex()
-	pid := ctx.Request.FormValue("pid")
-	c.Parent = pid
-	c.Ip = ctx.Request.RemoteAddr
+	// Top level comments are sent with a pid of 0 by the theme.
+	if pid := ctx.Request.FormValue("pid"); bson.IsObjectIdHex(pid) {
+		parent := &model.Comment{Id: bson.ObjectIdHex(pid)}
+		if parent.GetCommentById() != nil || parent.PostId != c.PostId {
+			ctx.SendStatus(http.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    "The parent is not a comment on the post.",
+			})
+			return
+		}
+		c.Parent = pid
+	}
+	c.Notify = ctx.Request.FormValue("notify") == "on"
+	c.Ip = remoteIP(ctx.Request)
 	c.UserAgent = ctx.Request.UserAgent()
 	c.UserId = ""
 	msg := c.ValidateComment()
 	if msg == "" {
+		moderateNewComment(c, ctx.Request)
 		if err := c.Save(); err != nil {
+			ctx.SendStatus(http.StatusInternalServerError)
 			ctx.JSON(map[string]interface{}{
 				"status": "error",
 				"msg":    "Can not comment on this post.",
 			})
+			return
 		}
-		post.CommentNum++
-		err = post.Save()
+		// Spam is answered as any comment awaiting moderation, so that
+		// spammers do not learn which filter caught them.
+		if c.IsSpam() {
+			ctx.JSON(map[string]interface{}{
+				"res":     true,
+				"comment": c.ToJson(),
+			})
+			return
+		}
+		err = post.CountComment()
 		if err != nil {
 			log.Printf("[Error]: Can not increase comment count for post %v: %v", post.Id, err.Error())
 		}
@@ -110,6 +167,8 @@ func CommentHandler(ctx *golf.Context) {
 		if err = model.NewMessage("comment", c).Insert(); err != nil {
 			panic(err)
 		}
+		c.NotifyPostAuthor()
+		c.NotifyParent()
 	} else {
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
@@ -118,6 +177,63 @@ func CommentHandler(ctx *golf.Context) {
 	}
 }
 
+// moderateNewComment runs the new comment of a reader through the spam
+// filters, and approves it if it is not spam and comes from a returning
+// commenter.
+func moderateNewComment(c *model.Comment, r *http.Request) {
+	if c.CheckSpam(r) {
+		return
+	}
+	if _, err := c.AutoApprove(); err != nil {
+		log.Printf("[Error]: Can not check the former comments of %v: %v", c.Email, err)
+	}
+}
+
+// CommentPreviewHandler answers the HTML of the comment sent, as it would be
+// saved, for the live preview of the comment form.
+func CommentPreviewHandler(ctx *golf.Context) {
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"html":   model.CommentContent(ctx.Request.FormValue("comment")),
+	})
+}
+
+// remoteIP returns the IP address of the client of the request, without the
+// port.
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// CommentUnsubscribeHandler stops the notifications of replies to a comment,
+// from the signed link sent with them.
+func CommentUnsubscribeHandler(ctx *golf.Context) {
+	id := ctx.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		ctx.Abort(http.StatusNotFound)
+		return
+	}
+	c := &model.Comment{Id: bson.ObjectIdHex(id)}
+	switch err := c.Unsubscribe(ctx.Request.FormValue("sig")); err {
+	case nil:
+	case model.ErrInvalidSignature:
+		ctx.Abort(http.StatusForbidden)
+		return
+	case model.ErrNotFound:
+		ctx.Abort(http.StatusNotFound)
+		return
+	default:
+		panic(err)
+	}
+	ctx.Loader("theme").Render("unsubscribe.html", map[string]interface{}{
+		"Title":   "Unsubscribed",
+		"Comment": c,
+		"Post":    c.Post(),
+	})
+}
+
 func TagHandler(ctx *golf.Context) {
 	p := ctx.Param("page")
 
@@ -136,18 +252,58 @@ func TagHandler(ctx *golf.Context) {
 		NotFoundHandler(ctx)
 		return
 	}
+	data, _ := tagData(tag, page)
+	ctx.Loader("theme").Render("tag.html", data)
+}
+
+// tagData returns the data of the given page of the posts with the tag.
+func tagData(tag *model.Tag, page int) (map[string]interface{}, error) {
 	posts := new(model.Posts)
-	pager, err := posts.GetPostsByTag(tagSlug, int64(page), 5, true)
-	data := map[string]interface{}{
+	pager, err := posts.GetPostsByTag(tag.Slug, int64(page), postsPerPage, true)
+	return map[string]interface{}{
 		"Posts": posts,
 		"Pager": pager,
 		"Tag":   tag,
 		"Title": tag.Name,
+	}, err
+}
+
+// SearchHandler lists the published posts and pages matching the `q` query
+// parameter.
+func SearchHandler(ctx *golf.Context) {
+	q := strings.TrimSpace(ctx.Request.FormValue("q"))
+	page, _ := strconv.Atoi(ctx.Request.FormValue("page"))
+	if page < 1 {
+		page = 1
 	}
-	ctx.Loader("theme").Render("tag.html", data)
+	posts := new(model.Posts)
+	var pager *utils.Pager
+	if q == "" {
+		pager = utils.NewPager(1, postsPerPage, 0)
+	} else {
+		var err error
+		pager, err = posts.SearchPostList(q, int64(page), postsPerPage, nil, true, "published_at DESC")
+		if err != nil {
+			NotFoundHandler(ctx)
+			return
+		}
+	}
+	ctx.Loader("theme").Render("search.html", map[string]interface{}{
+		"Title": "Search",
+		"Posts": posts,
+		"Pager": pager,
+		"Query": q,
+	})
 }
 
 func SiteMapHandler(ctx *golf.Context) {
+	ctx.SetHeader("Content-Type", "application/rss+xml;charset=UTF-8")
+	ctx.Loader("base").Render("sitemap.xml", siteMapData())
+}
+
+// siteMapData returns the data of the sitemap, listing the latest posts and
+// the navigation links.
+func siteMapData() map[string]interface{} {
 	baseUrl := model.GetSettingValue("site_url")
 	posts := new(model.Posts)
 	_, _ = posts.GetPostList(1, 50, false, true, "published_at DESC")
@@ -177,37 +333,11 @@ func SiteMapHandler(ctx *golf.Context) {
 		navMap = append(navMap, m)
 	}
 
-	ctx.SetHeader("Content-Type", "application/rss+xml;charset=UTF-8")
-	ctx.Loader("base").Render("sitemap.xml", map[string]interface{}{
+	return map[string]interface{}{
 		"Title":      model.GetSettingValue("site_title"),
 		"Link":       baseUrl,
 		"Created":    now,
 		"Posts":      articleMap,
 		"Navigators": navMap,
-	})
-}
-
-func RssHandler(ctx *golf.Context) {
-	baseUrl := model.GetSettingValue("site_url")
-	posts := new(model.Posts)
-	_, _ = posts.GetPostList(1, 20, false, true, "published_at DESC")
-	articleMap := make([]map[string]string, posts.Len())
-	for i := 0; i < posts.Len(); i++ {
-		m := make(map[string]string)
-		m["Title"] = posts.Get(i).Title
-		m["Link"] = posts.Get(i).Url()
-		m["Author"] = posts.Get(i).Author().Name
-		m["Desc"] = posts.Get(i).Excerpt()
-		m["Created"] = posts.Get(i).CreatedAt.Format(time.RFC822)
-		articleMap[i] = m
 	}
-	ctx.SetHeader("Content-Type", "text/xml; charset=utf-8")
-
-	ctx.Loader("base").Loader("base").Render("rss.xml", map[string]interface{}{
-		"Title":   model.GetSettingValue("site_title"),
-		"Link":    baseUrl,
-		"Desc":    model.GetSettingValue("site_description"),
-		"Created": utils.Now().Format(time.RFC822),
-		"Posts":   articleMap,
-	})
 }
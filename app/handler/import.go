@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+)
+
+// ImportHandler imports the uploaded export of another blog engine, and
+// replies with the report of what was imported. Nothing is saved if dry_run
+// is set.
+func ImportHandler(ctx *golf.Context) {
+	f, _, err := ctx.Request.FormFile("file")
+	if err != nil {
+		ctx.SendStatus(http.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Please choose an export file.",
+		})
+		return
+	}
+	defer f.Close()
+	i, err := model.ReadImport(f, ctx.Request.FormValue("engine"))
+	if err != nil {
+		ctx.SendStatus(http.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	report, err := i.Run(ctx.Request.FormValue("dry_run") != "")
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"report": report,
+		"text":   report.String(),
+	})
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const sampleGhostExport = `{"data": {
+	"posts": [{"id": 1, "title": "Hello Ghost", "slug": "hello-ghost", "markdown": "Hello", "status": "published", "author_id": 1}],
+	"users": [{"id": 1, "name": "Ghost Writer", "slug": "ghost-writer", "email": "ghost@example.com"}]
+}}`
+
+func importContext(u *model.User, export, dryRun string) *golf.Context {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if dryRun != "" {
+		w.WriteField("dry_run", dryRun)
+	}
+	f, _ := w.CreateFormFile("file", "export.json")
+	f.Write([]byte(export))
+	w.Close()
+
+	ctx := roleContext(u, nil, "POST", "/admin/import/")
+	req := makeTestHTTPRequest(&body, "POST", "/admin/import/")
+	req.Header["Cookie"] = ctx.Request.Header["Cookie"]
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return golf.NewContext(req, httptest.NewRecorder(), ctx.App)
+}
+
+func TestImport(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		admin := mockRoleUser(model.RoleAdministrator)
+		author := mockRoleUser(model.RoleAuthor)
+
+		Convey("Dry run an import", func() {
+			ctx := importContext(admin, sampleGhostExport, "1")
+			So(serve(ctx), ShouldEqual, 200)
+			var resp struct {
+				Report model.ImportReport `json:"report"`
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Report.DryRun, ShouldBeTrue)
+			So(resp.Report.Posts, ShouldHaveLength, 1)
+			So(new(model.Post).GetPostBySlug("hello-ghost"), ShouldNotBeNil)
+		})
+
+		Convey("Import an export", func() {
+			ctx := importContext(admin, sampleGhostExport, "")
+			So(serve(ctx), ShouldEqual, 200)
+			So(new(model.Post).GetPostBySlug("hello-ghost"), ShouldBeNil)
+		})
+
+		Convey("Invalid exports are refused", func() {
+			ctx := importContext(admin, "not an export", "")
+			So(serve(ctx), ShouldEqual, 400)
+		})
+
+		Convey("Authors can not import", func() {
+			ctx := importContext(author, sampleGhostExport, "")
+			So(serve(ctx), ShouldEqual, 403)
+		})
+	})
+}
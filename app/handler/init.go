@@ -12,19 +12,15 @@ import (
 func Initialize(app *golf.Application) *golf.Application {
 	app.Config.Set("app/static_dir", "static")
 	app.Config.Set("app.log_dir", "tmp/log")
-	app.Config.Set("app/upload_dir", "upload")
 	upload_dir, _ := app.Config.GetString("app/upload_dir", "upload")
+	app.Config.Set("app/upload_dir", upload_dir)
 	registerMiddlewares(app)
-	registerFuncMap(app)
-	RegisterFunctions(app)
+	initializeView(app)
 	theme := model.GetSettingValue("theme")
-	app.View.SetTemplateLoader("base", "view")
-	app.View.SetTemplateLoader("admin", filepath.Join("view", "admin"))
-	app.View.SetTemplateLoader("theme", filepath.Join("view", theme))
 	//      static_dir, _ := app.Config.GetString("app/static_dir", "static")
 	app.Static("/upload/", upload_dir)
 	app.Static("/admin/", filepath.Join("view", "admin", "assets", "dist"))
-	app.Static("/", filepath.Join("view", theme, "assets", "dist"))
+	app.Static("/", themeAssetsDir(theme))
 
 	app.SessionManager = golf.NewMemorySessionManager()
 	app.Error(404, NotFoundHandler)
@@ -33,9 +29,27 @@ func Initialize(app *golf.Application) *golf.Application {
 	registerHomeHandler(app)
 	registerAPIHandler(app)
 
+	startHits.Do(func() { go hits.Run(statsFlushInterval) })
+
 	return app
 }
 
+// initializeView registers the template functions and the template loaders
+// of the admin panel and the theme.
+func initializeView(app *golf.Application) {
+	registerFuncMap(app)
+	RegisterFunctions(app)
+	theme := model.GetSettingValue("theme")
+	app.View.SetTemplateLoader("base", "view")
+	app.View.SetTemplateLoader("admin", filepath.Join("view", "admin"))
+	app.View.SetTemplateLoader("theme", filepath.Join("view", theme))
+}
+
+// themeAssetsDir returns the directory of the static files of the theme.
+func themeAssetsDir(theme string) string {
+	return filepath.Join("view", theme, "assets", "dist")
+}
+
 func registerFuncMap(app *golf.Application) {
 	app.View.FuncMap["DateFormat"] = utils.DateFormat
 	app.View.FuncMap["Now"] = utils.Now
@@ -44,6 +58,10 @@ func registerFuncMap(app *golf.Application) {
 	app.View.FuncMap["Setting"] = model.GetSettingValue
 	app.View.FuncMap["Navigator"] = model.GetNavigators
 	app.View.FuncMap["Md2html"] = utils.Markdown2HtmlTemplate
+	app.View.FuncMap["RoleName"] = model.RoleName
+	app.View.FuncMap["IsImage"] = model.IsImageKey
+	app.View.FuncMap["ImageSize"] = model.ImageSizeUrl
+	app.View.FuncMap["Srcset"] = model.ImageSrcset
 }
 
 func registerMiddlewares(app *golf.Application) {
@@ -56,6 +74,17 @@ func registerMiddlewares(app *golf.Application) {
 
 func registerAdminURLHandlers(app *golf.Application) {
 	authChain := golf.NewChain(AuthMiddleware)
+	postChain := golf.NewChain(AuthMiddleware, PermissionMiddleware(model.PermPostEdit))
+	pageChain := golf.NewChain(AuthMiddleware, PermissionMiddleware(model.PermPageEdit))
+	commentChain := golf.NewChain(AuthMiddleware, PermissionMiddleware(model.PermCommentModerate))
+	settingChain := golf.NewChain(AuthMiddleware, PermissionMiddleware(model.PermSettingEdit))
+	fileChain := golf.NewChain(AuthMiddleware, PermissionMiddleware(model.PermFileUpload))
+	fileDeleteChain := golf.NewChain(AuthMiddleware, PermissionMiddleware(model.PermFileDelete))
+	userChain := golf.NewChain(AuthMiddleware, PermissionMiddleware(model.PermUserManage))
+	backupChain := golf.NewChain(AuthMiddleware, PermissionMiddleware(model.PermBackup))
+	restoreChain := golf.NewChain(AuthMiddleware, PermissionMiddleware(model.PermRestore))
+	importChain := golf.NewChain(AuthMiddleware, PermissionMiddleware(model.PermImport))
+	previewChain := golf.NewChain(AuthMiddleware, PermissionMiddleware(model.PermPreviewRevoke))
 	app.Get("/login/", AuthLoginPageHandler)
 	app.Post("/login/", AuthLoginHandler)
 
@@ -69,61 +98,92 @@ func registerAdminURLHandlers(app *golf.Application) {
 	app.Get("/admin/profile/", authChain.Final(ProfileHandler))
 	app.Post("/admin/profile/", authChain.Final(ProfileChangeHandler))
 
-	app.Get("/admin/editor/post/", authChain.Final(PostCreateHandler))
-	app.Post("/admin/editor/post/", authChain.Final(PostSaveHandler))
+	app.Get("/admin/editor/post/", postChain.Final(PostCreateHandler))
+	app.Post("/admin/editor/post/", postChain.Final(PostSaveHandler))
 
-	app.Get("/admin/editor/page/", authChain.Final(PageCreateHandler))
-	app.Post("/admin/editor/page/", authChain.Final(PageSaveHandler))
+	app.Get("/admin/editor/page/", pageChain.Final(PageCreateHandler))
+	app.Post("/admin/editor/page/", pageChain.Final(PageSaveHandler))
 
 	app.Get("/admin/posts/", authChain.Final(AdminPostHandler))
-	app.Get("/admin/pages/", authChain.Final(AdminPageHandler))
-
-	app.Get("/admin/editor/:id/", authChain.Final(ContentEditHandler))
-	app.Post("/admin/editor/:id/", authChain.Final(ContentSaveHandler))
-	app.Delete("/admin/editor/:id/", authChain.Final(ContentRemoveHandler))
-
-	app.Get("/admin/comments/", authChain.Final(CommentViewHandler))
-	app.Post("/admin/comments/", authChain.Final(CommentAddHandler))
-	app.Put("/admin/comments/", authChain.Final(CommentUpdateHandler))
-	app.Delete("/admin/comments/", authChain.Final(CommentRemoveHandler))
-
-	app.Get("/admin/setting/", authChain.Final(SettingViewHandler))
-	app.Post("/admin/setting/", authChain.Final(SettingUpdateHandler))
-	app.Post("/admin/setting/custom/", authChain.Final(SettingCustomHandler))
-	app.Post("/admin/setting/nav/", authChain.Final(SettingNavHandler))
+	app.Get("/admin/pages/", pageChain.Final(AdminPageHandler))
+
+	// The permission to edit a given post is checked by the handlers.
+	app.Get("/admin/editor/:id/", postChain.Final(ContentEditHandler))
+	app.Post("/admin/editor/:id/", postChain.Final(ContentSaveHandler))
+	app.Delete("/admin/editor/:id/", postChain.Final(ContentRemoveHandler))
+	app.Get("/admin/editor/:id/revisions/diff/", postChain.Final(RevisionDiffHandler))
+	app.Post("/admin/editor/:id/revisions/restore/", postChain.Final(RevisionRestoreHandler))
+	app.Post("/admin/editor/:id/autosave/", postChain.Final(AutosaveHandler))
+	app.Delete("/admin/editor/:id/autosave/", postChain.Final(AutosaveDiscardHandler))
+	app.Post("/admin/editor/:id/preview/", postChain.Final(PreviewCreateHandler))
+	app.Delete("/admin/editor/:id/preview/", previewChain.Final(PreviewRevokeHandler))
+	app.Delete("/admin/preview/", previewChain.Final(PreviewRevokeHandler))
+
+	app.Get("/admin/comments/", commentChain.Final(CommentViewHandler))
+	app.Post("/admin/comments/", commentChain.Final(CommentAddHandler))
+	app.Put("/admin/comments/", commentChain.Final(CommentUpdateHandler))
+	app.Delete("/admin/comments/", commentChain.Final(CommentRemoveHandler))
+	app.Post("/admin/comments/spam/", commentChain.Final(CommentNotSpamHandler))
+	app.Delete("/admin/comments/spam/", commentChain.Final(CommentPurgeSpamHandler))
+
+	app.Get("/admin/setting/", settingChain.Final(SettingViewHandler))
+	app.Post("/admin/setting/", settingChain.Final(SettingUpdateHandler))
+	app.Post("/admin/setting/custom/", settingChain.Final(SettingCustomHandler))
+	app.Post("/admin/setting/nav/", settingChain.Final(SettingNavHandler))
+
+	app.Get("/admin/backup/", backupChain.Final(BackupHandler))
+	app.Post("/admin/backup/restore/", restoreChain.Final(RestoreHandler))
+	app.Post("/admin/import/", importChain.Final(ImportHandler))
 	//
-	app.Get("/admin/files/", authChain.Final(FileViewHandler))
-	app.Delete("/admin/files/", authChain.Final(FileRemoveHandler))
-	app.Post("/admin/files/upload/", authChain.Final(FileUploadHandler))
+	app.Get("/admin/files/", fileChain.Final(FileViewHandler))
+	app.Delete("/admin/files/", fileDeleteChain.Final(FileRemoveHandler))
+	app.Post("/admin/files/upload/", fileChain.Final(FileUploadHandler))
+	app.Get("/admin/media/", fileChain.Final(MediaListHandler))
+	app.Get("/admin/media/:id/", fileChain.Final(MediaEditHandler))
+	app.Post("/admin/media/:id/", fileChain.Final(MediaSaveHandler))
+
+	app.Get("/admin/users/", userChain.Final(UserViewHandler))
+	app.Post("/admin/users/", userChain.Final(UserInviteHandler))
+	app.Put("/admin/users/", userChain.Final(UserUpdateHandler))
+	app.Delete("/admin/users/", userChain.Final(UserRemoveHandler))
 
 	app.Get("/admin/password/", authChain.Final(AdminPasswordPage))
 	app.Post("/admin/password/", authChain.Final(AdminPasswordChange))
 
-	app.Get("/admin/monitor/", authChain.Final(AdminMonitorPage))
+	app.Get("/admin/monitor/", settingChain.Final(AdminMonitorPage))
 }
 
 func registerHomeHandler(app *golf.Application) {
-	statsChain := golf.NewChain()
+	statsChain := golf.NewChain(StatsMiddleware)
 	app.Get("/", statsChain.Final(HomeHandler))
-	app.Get("/page/:page/", HomeHandler)
+	app.Get("/page/:page/", statsChain.Final(HomeHandler))
+	app.Post("/comment/preview/", CommentPreviewHandler)
 	app.Post("/comment/:id/", CommentHandler)
+	app.Get("/comment/:id/unsubscribe/", CommentUnsubscribeHandler)
+	app.Get("/preview/:id/", PreviewHandler)
 	app.Get("/tag/:tag/", TagHandler)
 	app.Get("/tag/:tag/page/:page/", TagHandler)
-	app.Get("/feed/", RssHandler)
+	app.Get("/tag/:tag/feed/", TagFeedHandler)
+	app.Get("/tag/:tag/feed/:format/", TagFeedHandler)
+	app.Get("/author/:author/feed/", AuthorFeedHandler)
+	app.Get("/author/:author/feed/:format/", AuthorFeedHandler)
+	app.Get("/feed/", FeedHandler)
+	app.Get("/feed/:format/", FeedHandler)
+	app.Get("/search/", SearchHandler)
 	app.Get("/sitemap.xml", SiteMapHandler)
 	app.Get("/:slug/", statsChain.Final(ContentHandler))
 }
 
-func registerAPIHandler(app *golf.Application) {
-	routes := map[string]map[string]interface{}{}
-	routes["GET"] = map[string]interface{}{}
-	routes["POST"] = map[string]interface{}{}
-	routes["PUT"] = map[string]interface{}{}
-	routes["DELETE"] = map[string]interface{}{}
-	registerJWTHandlers(app, routes)
-	registerPostHandlers(app, routes)
-	registerTagHandlers(app, routes)
-	registerUserHandlers(app, routes)
-	registerCommentsHandlers(app, routes)
-	app.Get("/api", APIDocumentationHandler(routes))
+// registerAPIHandler registers the handlers of the API routes, and returns
+// the router keeping their descriptions.
+func registerAPIHandler(app *golf.Application) *APIRouter {
+	api := NewAPIRouter(app)
+	registerJWTHandlers(api)
+	registerPostHandlers(api)
+	registerTagHandlers(api)
+	registerUserHandlers(api)
+	registerCommentsHandlers(api)
+	registerSettingHandlers(api)
+	registerOpenAPIHandlers(api)
+	return api
 }
@@ -16,14 +16,31 @@ type JWTPostBody struct {
 	Password string `json:"password"`
 }
 
-func registerJWTHandlers(app *golf.Application, routes map[string]map[string]interface{}) {
-	adminChain := golf.NewChain(JWTAuthMiddleware)
-	// Auth
-	app.Post("/auth", JWTAuthLoginHandler)
-	routes["POST"]["auth_new_url"] = "/auth"
-
-	app.Get("/auth", adminChain.Final(JWTDecryptHandler))
-	routes["GET"]["auth_decrypt_url"] = "/auth"
+func registerJWTHandlers(api *APIRouter) {
+	api.Handle(&APIRoute{
+		Name:        "auth_new_url",
+		Method:      "POST",
+		Path:        "/auth",
+		Tag:         "auth",
+		Summary:     "Get a token",
+		Description: "Signs a token for the user of the email and password, given as JSON or as a form.",
+		Request:     JWTPostBody{},
+		Response:    model.JWT{},
+		Raw:         true,
+		Errors:      []int{http.StatusBadRequest, http.StatusUnauthorized},
+		Handler:     JWTAuthLoginHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:     "auth_decrypt_url",
+		Method:   "GET",
+		Path:     "/auth",
+		Tag:      "auth",
+		Summary:  "Decode the token of the request",
+		Response: model.JWT{},
+		Raw:      true,
+		Auth:     true,
+		Handler:  JWTDecryptHandler,
+	})
 }
 
 func JWTAuthLoginHandler(ctx *golf.Context) {
@@ -59,7 +76,7 @@ func JWTAuthLoginHandler(ctx *golf.Context) {
 		ctx.JSON(map[string]interface{}{"status": "error"})
 		return
 	}
-	if !user.CheckPassword(password) {
+	if !user.CheckPassword(password) || user.IsSuspended() {
 		ctx.SendStatus(http.StatusUnauthorized)
 		ctx.JSON(map[string]interface{}{"status": "error"})
 		return
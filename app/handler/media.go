@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/covrom/dingo/app/utils"
+	"github.com/dinever/golf"
+)
+
+// mediaPageSize is the number of files of a page of the media picker.
+const mediaPageSize = 12
+
+// MediaListHandler answers with a page of the media library, filtered by the
+// `q` search query, for the media picker of the editor.
+func MediaListHandler(ctx *golf.Context) {
+	page, _ := strconv.Atoi(ctx.Request.FormValue("page"))
+	if page < 1 {
+		page = 1
+	}
+	items, pager, err := model.GetMediaItems(ctx.Request.FormValue("q"), int64(page), mediaPageSize)
+	if err != nil {
+		panic(err)
+	}
+	media := make([]map[string]interface{}, len(items))
+	for i, m := range items {
+		media[i] = map[string]interface{}{
+			"id":        m.Id.Hex(),
+			"key":       m.Key,
+			"url":       m.Url(),
+			"thumbnail": model.ImageSizeUrl(m.Url(), "thumbnail"),
+			"name":      m.Name,
+			"mime_type": m.MimeType,
+			"size":      utils.FileSize(m.Size),
+			"width":     m.Width,
+			"height":    m.Height,
+			"alt":       m.Alt,
+			"caption":   m.Caption,
+			"image":     m.IsImage(),
+		}
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"media":  media,
+		"page":   pager.Current,
+		"pages":  pager.Pages,
+	})
+}
+
+// MediaEditHandler shows the metadata of a file of the media library, and
+// the posts using it.
+func MediaEditHandler(ctx *golf.Context) {
+	user, _ := ctx.Session.Get("user")
+	m, err := model.GetMediaItemById(ctx.Param("id"))
+	if err != nil {
+		ctx.Abort(http.StatusNotFound)
+		return
+	}
+	posts, err := m.Posts()
+	if err != nil {
+		panic(err)
+	}
+	ctx.Loader("admin").Render("media.html", map[string]interface{}{
+		"Title": "Media",
+		"Media": m,
+		"Posts": posts,
+		"User":  user,
+	})
+}
+
+// MediaSaveHandler saves the alt text and caption of a file of the media
+// library.
+func MediaSaveHandler(ctx *golf.Context) {
+	m, err := model.GetMediaItemById(ctx.Param("id"))
+	if err != nil {
+		ctx.SendStatus(http.StatusNotFound)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "File not found.",
+		})
+		return
+	}
+	m.Alt = ctx.Request.FormValue("alt")
+	m.Caption = ctx.Request.FormValue("caption")
+	if err := m.Save(); err != nil {
+		panic(err)
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMedia(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		uploadDir, _ := ioutil.TempDir("", "dingo-upload")
+		So(model.InitializeMedia("", uploadDir), ShouldBeNil)
+		admin := mockRoleUser(model.RoleAdministrator)
+		notes, _, err := model.SaveUpload("notes.txt", []byte("notes"), admin.Id.Hex())
+		So(err, ShouldBeNil)
+		_, _, err = model.SaveUpload("report.txt", []byte("report"), admin.Id.Hex())
+		So(err, ShouldBeNil)
+
+		Convey("Search the media library", func() {
+			ctx := roleContext(admin, nil, "GET", "/admin/media/?q=note")
+			So(serve(ctx), ShouldEqual, 200)
+			var resp struct {
+				Status string
+				Media  []map[string]interface{}
+				Pages  int
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Status, ShouldEqual, "success")
+			So(resp.Pages, ShouldEqual, 1)
+			So(resp.Media, ShouldHaveLength, 1)
+			So(resp.Media[0]["url"], ShouldEqual, "/upload/notes.txt")
+		})
+
+		Convey("Edit the metadata of a file", func() {
+			ctx := roleContext(admin, nil, "GET", "/admin/media/"+notes.Id.Hex()+"/")
+			So(serve(ctx), ShouldEqual, 200)
+			So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, "No post uses this file.")
+
+			form := url.Values{}
+			form.Add("alt", "Some notes")
+			form.Add("caption", "Notes of the meeting")
+			ctx = roleContext(admin, form, "POST", "/admin/media/"+notes.Id.Hex()+"/")
+			So(serve(ctx), ShouldEqual, 200)
+			m, err := model.GetMediaItemByKey("notes.txt")
+			So(err, ShouldBeNil)
+			So(m.Alt, ShouldEqual, "Some notes")
+			So(m.Caption, ShouldEqual, "Notes of the meeting")
+
+			ctx = roleContext(admin, form, "POST", "/admin/media/unknown/")
+			So(serve(ctx), ShouldEqual, 404)
+		})
+
+		Reset(func() {
+			os.RemoveAll(uploadDir)
+			model.InitializeMedia("", "upload")
+		})
+	})
+}
@@ -2,6 +2,8 @@ package handler
 
 import (
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/globalsign/mgo/bson"
 
@@ -47,17 +49,115 @@ func AuthMiddleware(next golf.HandlerFunc) golf.HandlerFunc {
 
 func JWTAuthMiddleware(next golf.HandlerFunc) golf.HandlerFunc {
 	return func(ctx *golf.Context) {
-		tokenHeader := ctx.Header("X-SESSION-TOKEN")
-		if tokenHeader == "" {
+		if requestJWTUser(ctx) == nil {
 			ctx.SendStatus(http.StatusUnauthorized)
 			return
 		}
-		token, err := model.ValidateJWT(tokenHeader)
-		if err != nil {
-			ctx.SendStatus(http.StatusUnauthorized)
-			return
+		next(ctx)
+	}
+}
+
+// PermissionMiddleware only lets through the users who have the given
+// permission. It reads the user set by AuthMiddleware, so it has to come after
+// it in the chain.
+func PermissionMiddleware(perm model.Permission) golf.MiddlewareHandlerFunc {
+	return func(next golf.HandlerFunc) golf.HandlerFunc {
+		return func(ctx *golf.Context) {
+			userObj, _ := ctx.Session.Get("user")
+			u, ok := userObj.(*model.User)
+			if !ok || !u.Can(perm) {
+				ctx.Abort(http.StatusForbidden)
+				return
+			}
+			next(ctx)
 		}
-		ctx.Session.Set("jwt", model.NewJWTFromToken(token))
+	}
+}
+
+// JWTPermissionMiddleware is the PermissionMiddleware of the API. It reads
+// the token set by JWTAuthMiddleware, so it has to come after it in the chain.
+func JWTPermissionMiddleware(perm model.Permission) golf.MiddlewareHandlerFunc {
+	return func(next golf.HandlerFunc) golf.HandlerFunc {
+		return func(ctx *golf.Context) {
+			u, err := getJWTUser(ctx)
+			if err != nil || !u.Can(perm) {
+				sendForbidden(ctx)
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// getJWTUser loads the user of the token set by JWTAuthMiddleware. The user
+// is loaded from the DB rather than trusting the role in the token, so that
+// a role change applies to the tokens handed out before it.
+func getJWTUser(ctx *golf.Context) (*model.User, error) {
+	token, err := ctx.Session.Get("jwt")
+	if err != nil {
+		return nil, err
+	}
+	userId := token.(model.JWT).UserID
+	if !bson.IsObjectIdHex(userId) {
+		return nil, model.ErrNotFound
+	}
+	u := &model.User{Id: bson.ObjectIdHex(userId)}
+	if err := u.GetUserById(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// requestJWTUser returns the user of the token in the X-SESSION-TOKEN header,
+// setting it for getJWTUser, or nil if there is no valid token or the user is
+// suspended. It lets the routes open to anyone give more to the logged in
+// users.
+func requestJWTUser(ctx *golf.Context) *model.User {
+	tokenHeader := ctx.Header("X-SESSION-TOKEN")
+	if tokenHeader == "" {
+		return nil
+	}
+	token, err := model.ValidateJWT(tokenHeader)
+	if err != nil {
+		return nil
+	}
+	ctx.Session.Set("jwt", model.NewJWTFromToken(token))
+	u, err := getJWTUser(ctx)
+	if err != nil || u.IsSuspended() {
+		return nil
+	}
+	return u
+}
+
+// sendForbidden tells an API client that they are not allowed to do what
+// they asked for.
+func sendForbidden(ctx *golf.Context) {
+	ctx.SendStatus(http.StatusForbidden)
+	ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON("You are not allowed to do that.")})
+}
+
+// hits batches the page views counted by StatsMiddleware.
+var hits = model.NewHitCounter()
+
+// statsFlushInterval is how often the page views are written to the DB.
+const statsFlushInterval = time.Minute
+
+// startHits starts flushing hits once, however many apps are initialized.
+var startHits sync.Once
+
+// FlushStats writes the page views counted since the last flush to the DB.
+// It is called when the blog shuts down, so that they are not lost.
+func FlushStats() error {
+	return hits.Flush()
+}
+
+// StatsMiddleware counts a page view for every successful request, keyed by
+// the post slug when there is one.
+func StatsMiddleware(next golf.HandlerFunc) golf.HandlerFunc {
+	return func(ctx *golf.Context) {
 		next(ctx)
+		if ctx.StatusCode() == http.StatusOK {
+			hits.Hit(ctx.Param("slug"), time.Now())
+		}
 	}
 }
@@ -0,0 +1,608 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+	"github.com/globalsign/mgo/bson"
+)
+
+// apiVersion is the version of the API given in the OpenAPI specification.
+const apiVersion = "1.0.0"
+
+// An APIRoute describes a route of the API: the handler is registered with
+// it, and the OpenAPI specification and the docs page are generated from it.
+type APIRoute struct {
+	// Name is the name of the route in the /api index, like "post_url".
+	Name   string
+	Method string
+	// Path is the pattern of the route, with the path parameters written
+	// as :name.
+	Path string
+	// Tag groups the route with the routes on the same resource.
+	Tag         string
+	Summary     string
+	Description string
+	// Params describes the path parameters, every one of them, and the
+	// query parameters.
+	Params []APIParam
+	// Request is a value of the type of the json-formatted request body,
+	// nil if there is none.
+	Request interface{}
+	// Response is a value of the type of the data of the successful
+	// responses, nil if they have none.
+	Response interface{}
+	// Status is the status code of the successful responses, 200 if zero.
+	Status int
+	// Errors lists the status codes of the errors the handler answers with.
+	// 401 and 403 are added for the routes needing a token.
+	Errors []int
+	// Raw is set when the response is not wrapped in an
+	// APIResponseBodyJSON, and has no body without a Response or a
+	// ContentType. ContentType is its type, application/json if empty.
+	Raw         bool
+	ContentType string
+	// Auth is set when the route needs the token of a user in the
+	// X-SESSION-TOKEN header, and Permission when the user needs it too.
+	Auth       bool
+	Permission model.Permission
+	Handler    golf.HandlerFunc
+}
+
+// An APIParam describes a path or query parameter of a route.
+type APIParam struct {
+	Name string
+	// In is "path" or "query".
+	In string
+	// Type is the type of the value: string, integer or boolean. It is
+	// string if empty.
+	Type        string
+	Description string
+}
+
+// pathParam describes the path parameter of the given name.
+func pathParam(name, description string) APIParam {
+	return APIParam{Name: name, In: "path", Description: description}
+}
+
+// queryParam describes the query parameter of the given name and type.
+func queryParam(name, typ, description string) APIParam {
+	return APIParam{Name: name, In: "query", Type: typ, Description: description}
+}
+
+// pathParams returns the names of the path parameters of the pattern.
+func pathParams(path string) []string {
+	var names []string
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, ":") {
+			names = append(names, part[1:])
+		}
+	}
+	return names
+}
+
+// validate checks that the route is described well enough to be documented.
+func (r *APIRoute) validate() error {
+	switch {
+	case r.Name == "":
+		return errors.New("the route has no name")
+	case r.Summary == "":
+		return errors.New("the route has no summary")
+	case r.Tag == "":
+		return errors.New("the route has no tag")
+	case r.Handler == nil:
+		return errors.New("the route has no handler")
+	}
+	described := map[string]bool{}
+	for _, p := range r.Params {
+		if p.In != "path" && p.In != "query" {
+			return fmt.Errorf("the parameter %v is neither in the path nor the query", p.Name)
+		}
+		if p.Description == "" {
+			return fmt.Errorf("the parameter %v has no description", p.Name)
+		}
+		if p.In == "path" {
+			described[p.Name] = true
+		}
+	}
+	names := pathParams(r.Path)
+	for _, name := range names {
+		if !described[name] {
+			return fmt.Errorf("the path parameter %v is not described", name)
+		}
+	}
+	if len(described) != len(names) {
+		return errors.New("a described path parameter is not in the path")
+	}
+	return nil
+}
+
+// An APIRouter registers the handlers of the API routes, keeping their
+// descriptions.
+type APIRouter struct {
+	app    *golf.Application
+	routes []*APIRoute
+}
+
+// NewAPIRouter returns a router registering the handlers with the app.
+func NewAPIRouter(app *golf.Application) *APIRouter {
+	return &APIRouter{app: app}
+}
+
+// Handle registers the handler of the route, behind the JWT middlewares if
+// the route needs a token.
+func (api *APIRouter) Handle(r *APIRoute) {
+	handler := r.Handler
+	if r.Permission != "" {
+		handler = golf.NewChain(JWTAuthMiddleware, JWTPermissionMiddleware(r.Permission)).Final(handler)
+	} else if r.Auth {
+		handler = golf.NewChain(JWTAuthMiddleware).Final(handler)
+	}
+	switch r.Method {
+	case "GET":
+		api.app.Get(r.Path, handler)
+	case "POST":
+		api.app.Post(r.Path, handler)
+	case "PUT":
+		api.app.Put(r.Path, handler)
+	case "DELETE":
+		api.app.Delete(r.Path, handler)
+	default:
+		panic(fmt.Errorf("unsupported method %v of the API route %v", r.Method, r.Path))
+	}
+	api.routes = append(api.routes, r)
+}
+
+// index maps the methods to the names and paths of the routes.
+func (api *APIRouter) index() map[string]map[string]interface{} {
+	routes := map[string]map[string]interface{}{}
+	for _, r := range api.routes {
+		if routes[r.Method] == nil {
+			routes[r.Method] = map[string]interface{}{}
+		}
+		routes[r.Method][r.Name] = r.Path
+	}
+	return routes
+}
+
+// apiTags describes the groups of the routes, in the order of the docs page.
+var apiTags = []OpenAPITag{
+	{Name: "auth", Description: "Tokens to authenticate the requests."},
+	{Name: "posts", Description: "Posts and pages."},
+	{Name: "comments", Description: "Comments on the posts, and their moderation."},
+	{Name: "tags", Description: "Tags of the posts, named by their slug."},
+	{Name: "users", Description: "Users and their invites."},
+	{Name: "settings", Description: "Blog settings and the navigation menu."},
+	{Name: "api", Description: "Descriptions of the API."},
+}
+
+// OpenAPISpec is an OpenAPI 3 specification of the API.
+type OpenAPISpec struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Tags       []OpenAPITag                            `json:"tags"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type OpenAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// An OpenAPIOperation describes a route. The permission needed by the user
+// of the token is given in the x-permission extension.
+type OpenAPIOperation struct {
+	Tags        []string                    `json:"tags"`
+	Summary     string                      `json:"summary"`
+	Description string                      `json:"description,omitempty"`
+	OperationId string                      `json:"operationId"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+	Permission  string                      `json:"x-permission,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*OpenAPISchema         `json:"schemas"`
+	SecuritySchemes map[string]*OpenAPISecurityScheme `json:"securitySchemes"`
+}
+
+type OpenAPISecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// An OpenAPISchema describes the json value of a request or response body.
+// A schema without a type allows any value.
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+const schemaRefPrefix = "#/components/schemas/"
+
+// RefName returns the name of the component schema referenced by the
+// schema, or by the items of an array or map.
+func (s *OpenAPISchema) RefName() string {
+	switch {
+	case s == nil:
+		return ""
+	case s.Ref != "":
+		return strings.TrimPrefix(s.Ref, schemaRefPrefix)
+	case s.Items != nil:
+		return s.Items.RefName()
+	}
+	return s.AdditionalProperties.RefName()
+}
+
+// TypeName describes the type of the values of the schema to the readers of
+// the docs page.
+func (s *OpenAPISchema) TypeName() string {
+	switch {
+	case s.Ref != "":
+		return s.RefName()
+	case s.Type == "array":
+		return "array of " + s.Items.TypeName()
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		return "map of " + s.AdditionalProperties.TypeName()
+	case s.Type == "":
+		return "any"
+	case s.Format != "":
+		return s.Type + " (" + s.Format + ")"
+	}
+	return s.Type
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIdType = reflect.TypeOf(bson.ObjectId(""))
+)
+
+// A schemaGenerator converts the Go types of the bodies to schemas. The
+// named structs go to the component schemas, and are referenced.
+type schemaGenerator struct {
+	schemas map[string]*OpenAPISchema
+}
+
+// valueSchema returns the schema of the type of v, nil if v is nil.
+func (g *schemaGenerator) valueSchema(v interface{}) *OpenAPISchema {
+	if v == nil {
+		return nil
+	}
+	return g.schema(reflect.TypeOf(v))
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) *OpenAPISchema {
+	switch t {
+	case timeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case objectIdType:
+		return &OpenAPISchema{Type: "string", Pattern: "^[0-9a-f]{24}$"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16:
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Int32, reflect.Uint32:
+		return &OpenAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &OpenAPISchema{Type: "number"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+		return &OpenAPISchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// The schema is added before its fields are, for the types
+			// referencing themselves.
+			s := new(OpenAPISchema)
+			g.schemas[t.Name()] = s
+			*s = *g.structSchema(t)
+		}
+		return &OpenAPISchema{Ref: schemaRefPrefix + t.Name()}
+	}
+	return &OpenAPISchema{}
+}
+
+// structSchema lists the fields of the struct as encoding/json marshals
+// them.
+func (g *schemaGenerator) structSchema(t reflect.Type) *OpenAPISchema {
+	s := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for n, p := range g.structSchema(ft).Properties {
+				s.Properties[n] = p
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = g.schema(f.Type)
+	}
+	return s
+}
+
+// openAPIPath writes the path parameters of the pattern in braces.
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// apiDocsRoute is a route on the docs page, with the schemas of its request
+// body and of the data of its responses.
+type apiDocsRoute struct {
+	*APIRoute
+	Operation *OpenAPIOperation
+	Request   *OpenAPISchema
+	Response  *OpenAPISchema
+}
+
+// apiDocsGroup lists the routes of a tag on the docs page.
+type apiDocsGroup struct {
+	OpenAPITag
+	Routes []*apiDocsRoute
+}
+
+// spec generates the OpenAPI specification of the routes, along with the
+// groups of routes of the docs page.
+func (api *APIRouter) spec() (*OpenAPISpec, []*apiDocsGroup) {
+	g := &schemaGenerator{schemas: map[string]*OpenAPISchema{}}
+	errorSchema := g.valueSchema(APIResponseBodyJSON{})
+	statusSchema := g.valueSchema(APIStatusJSON{})
+	spec := &OpenAPISpec{
+		OpenAPI: "3.0.2",
+		Info: OpenAPIInfo{
+			Title:       "Dingo API",
+			Description: "The JSON API of the Dingo blog engine.",
+			Version:     apiVersion,
+		},
+		Tags:  apiTags,
+		Paths: map[string]map[string]*OpenAPIOperation{},
+		Components: OpenAPIComponents{
+			Schemas: g.schemas,
+			SecuritySchemes: map[string]*OpenAPISecurityScheme{
+				"token": {
+					Type:        "apiKey",
+					In:          "header",
+					Name:        "X-SESSION-TOKEN",
+					Description: "The token given by POST /auth.",
+				},
+			},
+		},
+	}
+	groups := []*apiDocsGroup{}
+	groupOf := map[string]*apiDocsGroup{}
+	for _, tag := range apiTags {
+		group := &apiDocsGroup{OpenAPITag: tag}
+		groups = append(groups, group)
+		groupOf[tag.Name] = group
+	}
+
+	for _, r := range api.routes {
+		op := &OpenAPIOperation{
+			Tags:        []string{r.Tag},
+			Summary:     r.Summary,
+			Description: r.Description,
+			OperationId: strings.TrimSuffix(r.Name, "_url"),
+			Responses:   map[string]*OpenAPIResponse{},
+			Permission:  string(r.Permission),
+		}
+		for _, p := range r.Params {
+			typ := p.Type
+			if typ == "" {
+				typ = "string"
+			}
+			op.Parameters = append(op.Parameters, &OpenAPIParameter{
+				Name:        p.Name,
+				In:          p.In,
+				Description: p.Description,
+				Required:    p.In == "path",
+				Schema:      &OpenAPISchema{Type: typ},
+			})
+		}
+		doc := &apiDocsRoute{
+			APIRoute:  r,
+			Operation: op,
+			Request:   g.valueSchema(r.Request),
+			Response:  g.valueSchema(r.Response),
+		}
+		if doc.Request != nil {
+			op.RequestBody = &OpenAPIRequestBody{
+				Required: true,
+				Content:  map[string]OpenAPIMediaType{"application/json": {Schema: doc.Request}},
+			}
+		}
+
+		status := r.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := &OpenAPIResponse{Description: http.StatusText(status)}
+		contentType := r.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		switch {
+		case r.Raw && doc.Response != nil:
+			success.Content = map[string]OpenAPIMediaType{contentType: {Schema: doc.Response}}
+		case r.Raw && r.ContentType != "":
+			success.Content = map[string]OpenAPIMediaType{contentType: {Schema: &OpenAPISchema{Type: "string"}}}
+		case r.Raw:
+			// The response has no body.
+		default:
+			data := doc.Response
+			if data == nil {
+				data = &OpenAPISchema{Nullable: true}
+			}
+			success.Content = map[string]OpenAPIMediaType{contentType: {Schema: &OpenAPISchema{
+				Type: "object",
+				Properties: map[string]*OpenAPISchema{
+					"data":   data,
+					"status": statusSchema,
+				},
+			}}}
+		}
+		op.Responses[strconv.Itoa(status)] = success
+
+		errs := r.Errors
+		if r.Auth || r.Permission != "" {
+			op.Security = []map[string][]string{{"token": {}}}
+			errs = append([]int{http.StatusUnauthorized}, errs...)
+			if r.Permission != "" {
+				errs = append(errs, http.StatusForbidden)
+			}
+		}
+		for _, code := range errs {
+			resp := &OpenAPIResponse{Description: http.StatusText(code)}
+			if !r.Raw {
+				resp.Content = map[string]OpenAPIMediaType{"application/json": {Schema: errorSchema}}
+			}
+			op.Responses[strconv.Itoa(code)] = resp
+		}
+
+		path := openAPIPath(r.Path)
+		if spec.Paths[path] == nil {
+			spec.Paths[path] = map[string]*OpenAPIOperation{}
+		}
+		spec.Paths[path][strings.ToLower(r.Method)] = op
+		if group := groupOf[r.Tag]; group != nil {
+			group.Routes = append(group.Routes, doc)
+		}
+	}
+	return spec, groups
+}
+
+func registerOpenAPIHandlers(api *APIRouter) {
+	api.Handle(&APIRoute{
+		Name:     "api_documentation_url",
+		Method:   "GET",
+		Path:     "/api",
+		Tag:      "api",
+		Summary:  "List the routes by method and name",
+		Response: map[string]map[string]map[string]string{},
+		Raw:      true,
+		Handler:  APIDocumentationHandler(api),
+	})
+	api.Handle(&APIRoute{
+		Name:     "openapi_url",
+		Method:   "GET",
+		Path:     "/api/openapi.json",
+		Tag:      "api",
+		Summary:  "Get the OpenAPI 3 specification of the API",
+		Response: OpenAPISpec{},
+		Raw:      true,
+		Handler:  APIOpenAPIHandler(api),
+	})
+	api.Handle(&APIRoute{
+		Name:        "api_docs_url",
+		Method:      "GET",
+		Path:        "/api/docs",
+		Tag:         "api",
+		Summary:     "Browse the documentation of the API",
+		Raw:         true,
+		ContentType: "text/html",
+		Handler:     APIDocsHandler(api),
+	})
+}
+
+// APIOpenAPIHandler serves the OpenAPI specification of the routes.
+func APIOpenAPIHandler(api *APIRouter) golf.HandlerFunc {
+	return func(ctx *golf.Context) {
+		spec, _ := api.spec()
+		ctx.JSONIndent(spec, "", "  ")
+	}
+}
+
+// APIDocsHandler shows the documentation of the routes, generated as their
+// OpenAPI specification.
+func APIDocsHandler(api *APIRouter) golf.HandlerFunc {
+	return func(ctx *golf.Context) {
+		spec, groups := api.spec()
+		ctx.Loader("admin").Render("api.html", map[string]interface{}{
+			"Title":  spec.Info.Title,
+			"Spec":   spec,
+			"Groups": groups,
+		})
+	}
+}
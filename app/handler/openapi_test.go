@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// directAPIRoutes lists the API routes registered with the app rather than
+// through an APIRouter, which would be left out of the specification.
+func directAPIRoutes() ([]string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	methods := map[string]bool{"Get": true, "Post": true, "Put": true, "Delete": true, "Patch": true, "Head": true, "Options": true}
+	var found []string
+	for _, pkg := range pkgs {
+		ast.Inspect(pkg, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || !methods[sel.Sel.Name] {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			path, _ := strconv.Unquote(lit.Value)
+			if path == "/api" || strings.HasPrefix(path, "/api/") || path == "/auth" {
+				found = append(found, fset.Position(lit.Pos()).String()+": "+path)
+			}
+			return true
+		})
+	}
+	return found, nil
+}
+
+// schemaRefs lists the $ref values found in the json value.
+func schemaRefs(v interface{}) []string {
+	var refs []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if ref, ok := e.(string); ok && k == "$ref" {
+				refs = append(refs, ref)
+			}
+			refs = append(refs, schemaRefs(e)...)
+		}
+	case []interface{}:
+		for _, e := range v {
+			refs = append(refs, schemaRefs(e)...)
+		}
+	}
+	return refs
+}
+
+func TestOpenAPI(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+
+		Convey("Register every API route with a descriptor", func() {
+			direct, err := directAPIRoutes()
+			So(err, ShouldBeNil)
+			So(direct, ShouldBeEmpty)
+
+			api := registerAPIHandler(golf.New())
+			So(len(api.routes), ShouldBeGreaterThan, 40)
+			names := map[string]bool{}
+			routes := map[string]bool{}
+			for _, r := range api.routes {
+				So(r.Path+": "+errString(r.validate()), ShouldEqual, r.Path+": ")
+				So(names[r.Name], ShouldBeFalse)
+				So(routes[r.Method+" "+r.Path], ShouldBeFalse)
+				names[r.Name] = true
+				routes[r.Method+" "+r.Path] = true
+			}
+
+			So((&APIRoute{Name: "x_url", Path: "/api/x/:id", Tag: "x", Summary: "X", Handler: APITagsHandler}).validate(), ShouldNotBeNil)
+		})
+
+		Convey("Answer 404 for the posts which do not exist", func() {
+			owner := mockRoleUser(model.RoleOwner)
+			api := registerAPIHandler(golf.New())
+			checked := 0
+			for _, r := range api.routes {
+				if !strings.Contains(r.Path, ":post_id") || !hasStatus(r.Errors, http.StatusNotFound) {
+					continue
+				}
+				for _, id := range []string{bson.NewObjectId().Hex(), "nothex"} {
+					path := strings.Replace(strings.Replace(r.Path, ":post_id", id, 1), ":rev", "1", 1)
+					ctx := mockContext(nil, r.Method, path)
+					if r.Auth || r.Permission != "" {
+						ctx = jwtContext(owner, "", r.Method, path)
+					}
+					So(r.Method+" "+path+": "+strconv.Itoa(serve(ctx)), ShouldEqual, r.Method+" "+path+": 404")
+				}
+				checked++
+			}
+			So(checked, ShouldBeGreaterThan, 10)
+		})
+
+		Convey("Serve the OpenAPI specification", func() {
+			ctx := mockContext(nil, "GET", "/api/openapi.json")
+			So(serve(ctx), ShouldEqual, 200)
+			body := ctx.Response.(*httptest.ResponseRecorder).Body.Bytes()
+			spec := new(OpenAPISpec)
+			So(json.Unmarshal(body, spec), ShouldBeNil)
+			So(spec.OpenAPI, ShouldStartWith, "3.")
+
+			api := registerAPIHandler(golf.New())
+			ids := map[string]bool{}
+			for _, r := range api.routes {
+				op := spec.Paths[openAPIPath(r.Path)][strings.ToLower(r.Method)]
+				So(op, ShouldNotBeNil)
+				So(ids[op.OperationId], ShouldBeFalse)
+				ids[op.OperationId] = true
+			}
+
+			op := spec.Paths["/api/posts/{post_id}/revisions/{rev}/restore"]["post"]
+			So(op.Parameters, ShouldHaveLength, 2)
+			So(op.Parameters[1].Name, ShouldEqual, "rev")
+			So(op.Parameters[1].Required, ShouldBeTrue)
+
+			op = spec.Paths["/api/tags/{slug}"]["put"]
+			So(op.Security, ShouldResemble, []map[string][]string{{"token": {}}})
+			So(op.Permission, ShouldEqual, string(model.PermTagManage))
+			for _, code := range []string{"200", "400", "401", "403", "404", "409"} {
+				So(op.Responses, ShouldContainKey, code)
+			}
+			So(op.RequestBody.Content["application/json"].Schema.Ref, ShouldEqual, "#/components/schemas/TagRequestBody")
+			data := op.Responses["200"].Content["application/json"].Schema.Properties["data"]
+			So(data.Ref, ShouldEqual, "#/components/schemas/Tag")
+
+			op = spec.Paths["/api/comments"]["post"]
+			So(op.Responses, ShouldContainKey, "201")
+			So(op.Responses, ShouldNotContainKey, "200")
+			So(spec.Paths["/api/tags"]["get"].Security, ShouldBeEmpty)
+
+			post := spec.Components.Schemas["Post"]
+			So(post, ShouldNotBeNil)
+			So(post.Properties["tags"].TypeName(), ShouldEqual, "array of Tag")
+			So(post.Properties["created_at"].TypeName(), ShouldEqual, "string (date-time)")
+			So(post.Properties, ShouldNotContainKey, "Hits")
+			So(spec.Components.Schemas["User"].Properties, ShouldNotContainKey, "HashedPassword")
+			So(spec.Components.Schemas["Comment"].Properties, ShouldNotContainKey, "Children")
+
+			var raw interface{}
+			So(json.Unmarshal(body, &raw), ShouldBeNil)
+			for _, ref := range schemaRefs(raw) {
+				So(spec.Components.Schemas, ShouldContainKey, strings.TrimPrefix(ref, schemaRefPrefix))
+			}
+		})
+
+		Convey("Browse the documentation", func() {
+			ctx := mockContext(nil, "GET", "/api/docs")
+			So(serve(ctx), ShouldEqual, 200)
+			body := ctx.Response.(*httptest.ResponseRecorder).Body.String()
+			So(body, ShouldContainSubstring, "<code>/api/posts/:post_id/revisions/:rev/restore</code>")
+			So(body, ShouldContainSubstring, `id="schema-Post"`)
+			So(body, ShouldContainSubstring, `<a href="#schema-Tag"><code>array of Tag</code></a>`)
+
+			ctx = mockContext(nil, "GET", "/api")
+			So(serve(ctx), ShouldEqual, 200)
+			var index struct {
+				Routes map[string]map[string]string `json:"request_method"`
+			}
+			So(json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &index), ShouldBeNil)
+			So(index.Routes["GET"]["post_url"], ShouldEqual, "/api/posts/:post_id")
+			So(index.Routes["GET"]["api_documentation_url"], ShouldEqual, "/api")
+		})
+
+		Reset(func() {
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// hasStatus reports whether the status code is in codes.
+func hasStatus(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
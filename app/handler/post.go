@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -12,70 +13,232 @@ import (
 	"github.com/dinever/golf"
 )
 
-func registerPostHandlers(app *golf.Application, routes map[string]map[string]interface{}) {
-	adminChain := golf.NewChain(JWTAuthMiddleware)
-	app.Get("/api/posts", APIPostsHandler(0, 10))
-	routes["GET"]["posts_url"] = "/api/posts"
+var errEmptySearch = errors.New("The search query can not be empty.")
 
-	app.Get("/api/posts/:post_id", APIPostHandler)
-	routes["GET"]["post_url"] = "/api/posts/:post_id"
-
-	app.Get("/api/posts/slug/:slug", APIPostSlugHandler)
-	routes["GET"]["post_slug_url"] = "/api/posts/slug/:slug"
-
-	app.Get("/api/posts/:post_id/comments", APIPostCommentsHandler)
-	routes["GET"]["post_comments_url"] = "/api/posts/:post_id/comments"
-
-	app.Get("/api/posts/:post_id/author", APIPostAuthorHandler)
-	routes["GET"]["post_author_url"] = "/api/posts/:post_id/author"
-
-	app.Get("/api/posts/:post_id/excerpt", APIPostExcerptHandler)
-	routes["GET"]["post_excerpt_url"] = "/api/posts/:post_id/excerpt"
-
-	app.Get("/api/posts/:post_id/summary", APIPostSummaryHandler)
-	routes["GET"]["post_summary_url"] = "/api/posts/:post_id/summary"
-
-	app.Get("/api/posts/:post_id/tag_string", APIPostTagStringHandler)
-	routes["GET"]["post_tag_string_url"] = "/api/posts/:post_id/tag_string"
-
-	app.Get("/api/posts/:post_id/tags", APIPostTagsHandler)
-	routes["GET"]["post_tags_url"] = "/api/posts/:post_id/tags"
-
-	app.Put("/api/posts", adminChain.Final(APIPostSaveHandler))
-	routes["PUT"]["post_save_url"] = "/api/posts"
-
-	app.Post("/api/posts/:post_id/publish", adminChain.Final(APIPostPublishHandler))
-	routes["POST"]["post_publish_url"] = "/api/posts/:post_id/publish"
+var (
+	postIdParam = pathParam("post_id", "The id of the post.")
+	offsetParam = queryParam("offset", "integer", "The number of items to skip, 0 by default.")
+	limitParam  = queryParam("limit", "integer", "The maximum number of items, 10 by default.")
+)
 
-	app.Delete("/api/posts/:post_id", adminChain.Final(APIPostDeleteHandler))
-	routes["DELETE"]["post_delete_url"] = "/api/posts/:post_id"
+func registerPostHandlers(api *APIRouter) {
+	api.Handle(&APIRoute{
+		Name:        "posts_url",
+		Method:      "GET",
+		Path:        "/api/posts",
+		Tag:         "posts",
+		Summary:     "List the posts and pages",
+		Description: "The drafts are only listed with the token of a user who may edit posts.",
+		Params:      []APIParam{offsetParam, limitParam, queryParam("published", "boolean", "Only the published posts if true, only the drafts if false.")},
+		Response:    []*model.Post{},
+		Errors:      []int{http.StatusBadRequest},
+		Handler:     APIPostsHandler(0, 10),
+	})
+	api.Handle(&APIRoute{
+		Name:     "post_search_url",
+		Method:   "GET",
+		Path:     "/api/posts/search",
+		Tag:      "posts",
+		Summary:  "Search the published posts and pages",
+		Params:   []APIParam{queryParam("q", "string", "The words to search for."), offsetParam, limitParam},
+		Response: model.Posts{},
+		Errors:   []int{http.StatusBadRequest},
+		Handler:  APIPostSearchHandler(0, 10),
+	})
+	api.Handle(&APIRoute{
+		Name:        "post_url",
+		Method:      "GET",
+		Path:        "/api/posts/:post_id",
+		Tag:         "posts",
+		Summary:     "Get a post",
+		Description: "The drafts are only given with the token of a user who may edit posts.",
+		Params:      []APIParam{postIdParam},
+		Response:    model.Post{},
+		Errors:      []int{http.StatusNotFound},
+		Handler:     APIPostHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:        "post_slug_url",
+		Method:      "GET",
+		Path:        "/api/posts/slug/:slug",
+		Tag:         "posts",
+		Summary:     "Get a post by its slug",
+		Description: "The drafts are only given with the token of a user who may edit posts.",
+		Params:      []APIParam{pathParam("slug", "The slug of the post.")},
+		Response:    model.Post{},
+		Errors:      []int{http.StatusNotFound},
+		Handler:     APIPostSlugHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:        "post_comments_url",
+		Method:      "GET",
+		Path:        "/api/posts/:post_id/comments",
+		Tag:         "posts",
+		Summary:     "Page through the approved comments on a post",
+		Description: "Pass the next_cursor of a page as the cursor of the next one, until it is empty.",
+		Params: []APIParam{
+			postIdParam,
+			queryParam("sort", "string", "oldest, the default, or newest."),
+			queryParam("limit", "integer", "The maximum number of comments, from 1 to 100, 20 by default."),
+			queryParam("cursor", "string", "The next_cursor of the previous page."),
+		},
+		Response: APICommentPageJSON{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+		Handler:  APIPostCommentsHandler(20, 100),
+	})
+	api.Handle(&APIRoute{
+		Name:     "post_author_url",
+		Method:   "GET",
+		Path:     "/api/posts/:post_id/author",
+		Tag:      "posts",
+		Summary:  "Get the author of a post",
+		Params:   []APIParam{postIdParam},
+		Response: model.PublicUser{},
+		Errors:   []int{http.StatusNotFound},
+		Handler:  APIPostAuthorHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:     "post_excerpt_url",
+		Method:   "GET",
+		Path:     "/api/posts/:post_id/excerpt",
+		Tag:      "posts",
+		Summary:  "Get the excerpt of a post",
+		Params:   []APIParam{postIdParam},
+		Response: "",
+		Errors:   []int{http.StatusNotFound},
+		Handler:  APIPostExcerptHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:     "post_summary_url",
+		Method:   "GET",
+		Path:     "/api/posts/:post_id/summary",
+		Tag:      "posts",
+		Summary:  "Get the summary of a post",
+		Params:   []APIParam{postIdParam},
+		Response: "",
+		Errors:   []int{http.StatusNotFound},
+		Handler:  APIPostSummaryHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:     "post_tag_string_url",
+		Method:   "GET",
+		Path:     "/api/posts/:post_id/tag_string",
+		Tag:      "posts",
+		Summary:  "Get the names of the tags of a post, separated by commas",
+		Params:   []APIParam{postIdParam},
+		Response: "",
+		Errors:   []int{http.StatusNotFound},
+		Handler:  APIPostTagStringHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:     "post_tags_url",
+		Method:   "GET",
+		Path:     "/api/posts/:post_id/tags",
+		Tag:      "posts",
+		Summary:  "Get the tags of a post",
+		Params:   []APIParam{postIdParam},
+		Response: model.Tags{},
+		Errors:   []int{http.StatusNotFound},
+		Handler:  APIPostTagsHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:        "post_save_url",
+		Method:      "PUT",
+		Path:        "/api/posts",
+		Tag:         "posts",
+		Summary:     "Create or update a post",
+		Description: "Send back the version of the post read: a post saved by someone else since is refused with 409, holding both versions.",
+		Request:     model.Post{},
+		Response:    model.Post{},
+		Errors:      []int{http.StatusConflict},
+		Permission:  model.PermPostEdit,
+		Handler:     APIPostSaveHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "post_publish_url",
+		Method:     "POST",
+		Path:       "/api/posts/:post_id/publish",
+		Tag:        "posts",
+		Summary:    "Publish a post",
+		Params:     []APIParam{postIdParam},
+		Response:   model.Post{},
+		Errors:     []int{http.StatusNotFound, http.StatusConflict},
+		Permission: model.PermPostEdit,
+		Handler:    APIPostPublishHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "post_delete_url",
+		Method:     "DELETE",
+		Path:       "/api/posts/:post_id",
+		Tag:        "posts",
+		Summary:    "Delete a post",
+		Params:     []APIParam{postIdParam},
+		Raw:        true,
+		Errors:     []int{http.StatusNotFound},
+		Permission: model.PermPostEdit,
+		Handler:    APIPostDeleteHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "post_revisions_url",
+		Method:     "GET",
+		Path:       "/api/posts/:post_id/revisions",
+		Tag:        "posts",
+		Summary:    "List the revisions of a post, newest first",
+		Params:     []APIParam{postIdParam},
+		Response:   model.Revisions{},
+		Errors:     []int{http.StatusNotFound},
+		Permission: model.PermPostEdit,
+		Handler:    APIPostRevisionsHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "post_revision_restore_url",
+		Method:     "POST",
+		Path:       "/api/posts/:post_id/revisions/:rev/restore",
+		Tag:        "posts",
+		Summary:    "Bring a post back to a revision",
+		Params:     []APIParam{postIdParam, pathParam("rev", "The id of the revision.")},
+		Response:   model.Post{},
+		Errors:     []int{http.StatusNotFound},
+		Permission: model.PermPostEdit,
+		Handler:    APIPostRevisionRestoreHandler,
+	})
 }
 
-func getPostFromContext(ctx *golf.Context, param ...string) (post *model.Post) {
-	post = new(model.Post)
-	if len(param) == 0 {
-		for _, p := range []string{"post_id", "slug"} {
-			post = getPostFromContext(ctx, p)
-			if post != nil {
-				return post
-			}
-		}
-	}
-	var err error
-	switch param[0] {
+// getPostFromContext loads the post referenced by the given path parameter,
+// either "post_id" or "slug", answering 404 if there is none.
+func getPostFromContext(ctx *golf.Context, param string) *model.Post {
+	post := new(model.Post)
+	err := model.ErrNotFound
+	switch param {
 	case "post_id":
-		id := ctx.Param("post_id")
-		// if convErr != nil {
-		// 	handleErr(ctx, 500, convErr)
-		// 	return nil
-		// }
-		err = post.GetPostById(bson.ObjectIdHex(id))
+		if id := ctx.Param("post_id"); bson.IsObjectIdHex(id) {
+			err = post.GetPostById(bson.ObjectIdHex(id))
+		}
 	case "slug":
-		slug := ctx.Param("slug")
-		err = post.GetPostBySlug(slug)
+		err = post.GetPostBySlug(ctx.Param("slug"))
 	}
 	if err != nil {
-		handleErr(ctx, 404, err)
+		sendAPIError(ctx, http.StatusNotFound, "post not found")
+		return nil
+	}
+	return post
+}
+
+// canReadDrafts reports whether the user of the token, if any, may read the
+// posts not shown to the readers yet.
+func canReadDrafts(ctx *golf.Context) bool {
+	u := requestJWTUser(ctx)
+	return u != nil && u.Can(model.PermPostEdit)
+}
+
+// getReadablePostFromContext loads the post referenced by the given path
+// parameter as getPostFromContext does, answering 404 as well if the post is
+// not shown to the readers, being a draft or scheduled for later, unless the
+// user of the token may edit posts.
+func getReadablePostFromContext(ctx *golf.Context, param string) *model.Post {
+	post := getPostFromContext(ctx, param)
+	if post != nil && !post.IsPublic() && !canReadDrafts(ctx) {
+		sendAPIError(ctx, http.StatusNotFound, "post not found")
 		return nil
 	}
 	return post
@@ -83,17 +246,24 @@ func getPostFromContext(ctx *golf.Context, param ...string) (post *model.Post) {
 
 // APIPostHandler retrieves the post with the given ID.
 func APIPostHandler(ctx *golf.Context) {
-	post := getPostFromContext(ctx, "post_id")
+	post := getReadablePostFromContext(ctx, "post_id")
+	if post == nil {
+		return
+	}
 	ctx.JSON(NewAPISuccessResponse(post))
 }
 
 // APIPostSlugHandler retrieves the post with the given slug.
 func APIPostSlugHandler(ctx *golf.Context) {
-	post := getPostFromContext(ctx, "slug")
+	post := getReadablePostFromContext(ctx, "slug")
+	if post == nil {
+		return
+	}
 	ctx.JSON(NewAPISuccessResponse(post))
 }
 
-// APIPostsHandler gets an array of posts of length <= limit, starting at offset.
+// APIPostsHandler gets an array of posts of length <= limit, starting at
+// offset. Only the users who may edit posts get the drafts.
 // To paginate through posts, increment offset by limit until the length of the
 // post array in the response is less than limit.
 func APIPostsHandler(offset, limit int) golf.HandlerFunc {
@@ -124,13 +294,18 @@ func APIPostsHandler(offset, limit int) golf.HandlerFunc {
 			return
 		}
 		published, _ := ctx.Query("published")
-		switch published {
-		case "true":
-			posts, err = model.GetPublishedPosts(offset, limit)
-		case "false":
+		// The drafts and the posts scheduled for later are only listed for
+		// the users who may edit posts.
+		drafts := published != "true" && canReadDrafts(ctx)
+		switch {
+		case published == "false" && drafts:
 			posts, err = model.GetUnpublishedPosts(offset, limit)
-		default:
+		case published == "false":
+			posts = []*model.Post{}
+		case drafts:
 			posts, err = model.GetAllPosts(offset, limit)
+		default:
+			posts, err = model.GetPublishedPosts(offset, limit)
 		}
 		if err != nil {
 			ctx.SendStatus(http.StatusInternalServerError)
@@ -141,29 +316,94 @@ func APIPostsHandler(offset, limit int) golf.HandlerFunc {
 	}
 }
 
-// APIPostCommentsHandler gets the comments on the given post.
-func APIPostCommentsHandler(ctx *golf.Context) {
-	post := getPostFromContext(ctx)
-	if post == nil {
-		return
+// APICommentPageJSON is a page of the comments on a post. NextCursor is the
+// cursor of the following page, empty on the last one.
+type APICommentPageJSON struct {
+	Comments   model.PublicComments `json:"comments"`
+	NextCursor string               `json:"next_cursor"`
+}
+
+// commentSorts maps the sort parameter of the comments to their order.
+var commentSorts = map[string]string{
+	"oldest": "created_at",
+	"newest": "created_at DESC",
+}
+
+// APIPostCommentsHandler gets the public view of the approved comments on the
+// given post, a page of at most limit comments at a time. They are sorted by
+// the sort parameter, "oldest" first by default or "newest" first. To page
+// through them, pass the next_cursor of the response as the cursor parameter,
+// until it is empty.
+func APIPostCommentsHandler(limit, maxLimit int) golf.HandlerFunc {
+	// limit is the default value of the limit parameter.
+	return func(ctx *golf.Context) {
+		post := getReadablePostFromContext(ctx, "post_id")
+		if post == nil {
+			return
+		}
+		sort, _ := ctx.Query("sort")
+		if sort == "" {
+			sort = "oldest"
+		}
+		orderBy := commentSorts[sort]
+		if orderBy == "" {
+			ctx.SendStatus(http.StatusBadRequest)
+			ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON("The sort must be oldest or newest.")})
+			return
+		}
+		if l, _ := ctx.Query("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 1 || n > maxLimit {
+				ctx.SendStatus(http.StatusBadRequest)
+				ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON("The limit must be between 1 and " + strconv.Itoa(maxLimit) + ".")})
+				return
+			}
+			limit = n
+		}
+		var after *model.Comment
+		if cursor, _ := ctx.Query("cursor"); cursor != "" {
+			after = new(model.Comment)
+			if bson.IsObjectIdHex(cursor) {
+				after.Id = bson.ObjectIdHex(cursor)
+			}
+			if after.Id == "" || after.GetCommentById() != nil || after.PostId != post.Id.Hex() {
+				ctx.SendStatus(http.StatusBadRequest)
+				ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON("The cursor is not valid.")})
+				return
+			}
+		}
+
+		// One more comment tells whether there is a next page.
+		comments := model.Comments{}
+		if err := comments.GetCommentPage(post.Id.Hex(), orderBy, after, limit+1); err != nil {
+			ctx.SendStatus(http.StatusInternalServerError)
+			ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(err.Error())})
+			return
+		}
+		page := APICommentPageJSON{}
+		if len(comments) > limit {
+			comments = comments[:limit]
+			page.NextCursor = comments[len(comments)-1].Id.Hex()
+		}
+		page.Comments = comments.Public()
+		ctx.JSON(NewAPISuccessResponse(page))
 	}
-	comments := post.Comments()
-	ctx.JSON(NewAPISuccessResponse(comments))
 }
 
-// APIPostAuthorHandler gets the author of the given post.
+// APIPostAuthorHandler gets the public profile of the author of the given
+// post.
 func APIPostAuthorHandler(ctx *golf.Context) {
-	post := getPostFromContext(ctx)
+	post := getReadablePostFromContext(ctx, "post_id")
 	if post == nil {
 		return
 	}
 	author := post.Author()
-	ctx.JSON(NewAPISuccessResponse(author))
+	ctx.JSON(NewAPISuccessResponse(author.Public()))
 }
 
 // APIPostExcerptHandler gets the excerpt of the given post.
 func APIPostExcerptHandler(ctx *golf.Context) {
-	post := getPostFromContext(ctx)
+	post := getReadablePostFromContext(ctx, "post_id")
 	if post == nil {
 		return
 	}
@@ -173,7 +413,7 @@ func APIPostExcerptHandler(ctx *golf.Context) {
 
 // APIPostSummaryHandler gets the summary of the given post.
 func APIPostSummaryHandler(ctx *golf.Context) {
-	post := getPostFromContext(ctx)
+	post := getReadablePostFromContext(ctx, "post_id")
 	if post == nil {
 		return
 	}
@@ -183,7 +423,7 @@ func APIPostSummaryHandler(ctx *golf.Context) {
 
 // APIPostTagStringHandler gets the tag string of the given post.
 func APIPostTagStringHandler(ctx *golf.Context) {
-	post := getPostFromContext(ctx)
+	post := getReadablePostFromContext(ctx, "post_id")
 	if post == nil {
 		return
 	}
@@ -193,7 +433,7 @@ func APIPostTagStringHandler(ctx *golf.Context) {
 
 // APIPostTagsHandler gets the tags of the given post.
 func APIPostTagsHandler(ctx *golf.Context) {
-	post := getPostFromContext(ctx)
+	post := getReadablePostFromContext(ctx, "post_id")
 	if post == nil {
 		return
 	}
@@ -201,15 +441,47 @@ func APIPostTagsHandler(ctx *golf.Context) {
 	ctx.JSON(NewAPISuccessResponse(tags))
 }
 
+// APIPostSearchHandler gets an array of the published posts and pages
+// matching the q query parameter, of length <= limit, starting at offset.
+func APIPostSearchHandler(offset, limit int) golf.HandlerFunc {
+	// offset, limit args are default values
+	return func(ctx *golf.Context) {
+		var err error
+		if q, _ := ctx.Query("offset"); q != "" {
+			offset, err = strconv.Atoi(q)
+		}
+		if err == nil {
+			if q, _ := ctx.Query("limit"); q != "" {
+				limit, err = strconv.Atoi(q)
+			}
+		}
+		q, _ := ctx.Query("q")
+		if err == nil && q == "" {
+			err = errEmptySearch
+		}
+		if err != nil {
+			ctx.SendStatus(http.StatusBadRequest)
+			ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(err.Error())})
+			return
+		}
+		posts, err := model.SearchPosts(q, offset, limit)
+		if err != nil {
+			ctx.SendStatus(http.StatusInternalServerError)
+			ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(err.Error())})
+			return
+		}
+		ctx.JSON(NewAPISuccessResponse(posts))
+	}
+}
+
 // APIPostSaveHandler saves the post given in the json-formatted request body.
 func APIPostSaveHandler(ctx *golf.Context) {
-	token, err := ctx.Session.Get("jwt")
+	u, err := getJWTUser(ctx)
 	if err != nil {
 		ctx.SendStatus(http.StatusInternalServerError)
 		return
 	}
 	post := model.NewPost()
-	post.CreatedBy = token.(model.JWT).UserID
 	defer ctx.Request.Body.Close()
 	body, err := ioutil.ReadAll(ctx.Request.Body)
 	if err != nil {
@@ -222,7 +494,34 @@ func APIPostSaveHandler(ctx *golf.Context) {
 		ctx.JSON(APIResponseBodyJSON{Data: nil, Status: NewErrorStatusJSON(err.Error())})
 		return
 	}
+	post.UpdatedBy = u.Id.Hex()
+	// The author of a post can not be changed through the request body.
+	current := &model.Post{Id: post.Id}
+	if err := current.GetPostById(); err == nil {
+		if !u.CanEditPost(current) {
+			sendForbidden(ctx)
+			return
+		}
+		post.CreatedBy = current.CreatedBy
+	} else {
+		post.CreatedBy = u.Id.Hex()
+	}
+	if !u.CanEditPost(post) {
+		sendForbidden(ctx)
+		return
+	}
 	err = post.Save(post.Tags...)
+	if conflict, ok := err.(*model.ConflictError); ok {
+		ctx.SendStatus(http.StatusConflict)
+		ctx.JSON(APIResponseBodyJSON{
+			Data: map[string]interface{}{
+				"post":    post,
+				"current": conflict.Current,
+			},
+			Status: NewErrorStatusJSON("the post was changed since version", strconv.FormatInt(post.Version, 10)),
+		})
+		return
+	}
 	if err != nil {
 		ctx.SendStatus(http.StatusInternalServerError)
 		ctx.JSON(APIResponseBodyJSON{Data: nil, Status: NewErrorStatusJSON(err.Error())})
@@ -233,17 +532,28 @@ func APIPostSaveHandler(ctx *golf.Context) {
 
 // APIPostPublishHandler publishes the post referenced by the post_id.
 func APIPostPublishHandler(ctx *golf.Context) {
-	token, err := ctx.Session.Get("jwt")
+	u, err := getJWTUser(ctx)
 	if err != nil {
 		ctx.SendStatus(http.StatusInternalServerError)
 		return
 	}
-	post := getPostFromContext(ctx)
+	post := getPostFromContext(ctx, "post_id")
 	if post == nil {
-		ctx.SendStatus(http.StatusNotFound)
 		return
 	}
-	err = post.Publish(token.(model.JWT).UserID)
+	if !u.CanEditPost(post) {
+		sendForbidden(ctx)
+		return
+	}
+	err = post.Publish(u.Id.Hex())
+	if conflict, ok := err.(*model.ConflictError); ok {
+		ctx.SendStatus(http.StatusConflict)
+		ctx.JSON(APIResponseBodyJSON{
+			Data:   conflict.Current,
+			Status: NewErrorStatusJSON("the post was changed since version", strconv.FormatInt(post.Version, 10)),
+		})
+		return
+	}
 	if err != nil {
 		ctx.SendStatus(http.StatusInternalServerError)
 		ctx.JSON(APIResponseBodyJSON{Data: nil, Status: NewErrorStatusJSON(err.Error())})
@@ -254,15 +564,79 @@ func APIPostPublishHandler(ctx *golf.Context) {
 
 // APIPostDeleteHandler deletes the post referenced by the post_id.
 func APIPostDeleteHandler(ctx *golf.Context) {
-	post := getPostFromContext(ctx)
+	u, err := getJWTUser(ctx)
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		return
+	}
+	post := getPostFromContext(ctx, "post_id")
 	if post == nil {
-		ctx.SendStatus(http.StatusNotFound)
 		return
 	}
-	err := model.DeletePostById(post.Id.Hex())
+	if !u.CanEditPost(post) {
+		sendForbidden(ctx)
+		return
+	}
+	err = model.DeletePostById(post.Id.Hex())
 	if err != nil {
 		ctx.SendStatus(http.StatusInternalServerError)
 		ctx.JSON(APIResponseBodyJSON{Data: nil, Status: NewErrorStatusJSON(err.Error())})
 		return
 	}
 }
+
+// getEditablePost gets the post referenced by the post_id, and answers with an
+// error if it does not exist or the user is not allowed to edit it.
+func getEditablePost(ctx *golf.Context) (*model.User, *model.Post) {
+	u, err := getJWTUser(ctx)
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		return nil, nil
+	}
+	post := getPostFromContext(ctx, "post_id")
+	if post == nil {
+		return nil, nil
+	}
+	if !u.CanEditPost(post) {
+		sendForbidden(ctx)
+		return nil, nil
+	}
+	return u, post
+}
+
+// APIPostRevisionsHandler retrieves the revisions of the post referenced by
+// the post_id, newest first.
+func APIPostRevisionsHandler(ctx *golf.Context) {
+	_, post := getEditablePost(ctx)
+	if post == nil {
+		return
+	}
+	revisions, err := post.GetRevisions()
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(APIResponseBodyJSON{Data: nil, Status: NewErrorStatusJSON(err.Error())})
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(revisions))
+}
+
+// APIPostRevisionRestoreHandler brings the post referenced by the post_id back
+// to the revision referenced by rev.
+func APIPostRevisionRestoreHandler(ctx *golf.Context) {
+	u, post := getEditablePost(ctx)
+	if post == nil {
+		return
+	}
+	r, err := post.GetRevision(ctx.Param("rev"))
+	if err != nil {
+		ctx.SendStatus(http.StatusNotFound)
+		ctx.JSON(APIResponseBodyJSON{Data: nil, Status: NewErrorStatusJSON("revision not found")})
+		return
+	}
+	if err := post.RestoreRevision(r, u.Id.Hex()); err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(APIResponseBodyJSON{Data: nil, Status: NewErrorStatusJSON(err.Error())})
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(post))
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+)
+
+// PreviewHandler renders a post, published or not, to anyone holding a valid
+// preview link of it. Search engines are asked not to index the preview.
+func PreviewHandler(ctx *golf.Context) {
+	post, err := model.GetPreviewPost(ctx.Param("id"), ctx.Request.FormValue("token"))
+	if err != nil {
+		ctx.Abort(http.StatusNotFound)
+		return
+	}
+	ctx.SetHeader("X-Robots-Tag", "noindex, nofollow")
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	data := contentData(post)
+	data["Preview"] = true
+	ctx.Loader("theme").Render(contentTemplate(post), data)
+}
+
+// PreviewCreateHandler answers with a new preview link of the edited post.
+func PreviewCreateHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	p := getEditedPost(ctx, u)
+	if p == nil {
+		return
+	}
+	preview, err := model.NewPreview(p, u.Id.Hex())
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status":  "success",
+		"url":     model.AbsoluteUrl(preview.Url()),
+		"expires": preview.ExpiresAt,
+	})
+}
+
+// PreviewRevokeHandler revokes the preview links made so far of the post
+// with the id in the URL, or of every post if there is none.
+func PreviewRevokeHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	id := ""
+	if ctx.Param("id") != "" {
+		p := getEditedPost(ctx, u)
+		if p == nil {
+			return
+		}
+		id = p.Id.Hex()
+	}
+	if err := model.RevokePreviews(id, u.Id.Hex()); err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPreview(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		admin := mockRoleUser(model.RoleAdministrator)
+		author := mockRoleUser(model.RoleAuthor)
+		p := mockPost()
+		p.CreatedBy = author.Id.Hex()
+		So(p.Save(), ShouldBeNil)
+
+		ctx := roleContext(author, nil, "POST", "/admin/editor/"+p.Id.Hex()+"/preview/")
+		So(serve(ctx), ShouldEqual, 200)
+		var resp struct {
+			Status string
+			Url    string
+		}
+		json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+		So(resp.Status, ShouldEqual, "success")
+		link, err := url.Parse(resp.Url)
+		So(err, ShouldBeNil)
+		So(link.Path, ShouldEqual, "/preview/"+p.Id.Hex()+"/")
+
+		Convey("The draft is not public", func() {
+			So(serve(mockContext(nil, "GET", "/"+p.Slug+"/")), ShouldEqual, 404)
+		})
+
+		Convey("The preview link shows the draft without indexing", func() {
+			ctx := mockContext(nil, "GET", link.RequestURI())
+			So(serve(ctx), ShouldEqual, 200)
+			rec := ctx.Response.(*httptest.ResponseRecorder)
+			So(rec.Header().Get("X-Robots-Tag"), ShouldEqual, "noindex, nofollow")
+			So(rec.Body.String(), ShouldContainSubstring, p.Title)
+			So(rec.Body.String(), ShouldContainSubstring, "preview-banner")
+			So(rec.Body.String(), ShouldContainSubstring, `<meta name="robots" content="noindex, nofollow" />`)
+			So(rec.Body.String(), ShouldNotContainSubstring, "comment-form")
+		})
+
+		Convey("A wrong token is refused", func() {
+			path := strings.Replace(link.RequestURI(), "token=", "token=x", 1)
+			So(serve(mockContext(nil, "GET", path)), ShouldEqual, 404)
+			So(serve(mockContext(nil, "GET", "/preview/"+p.Id.Hex()+"/")), ShouldEqual, 404)
+		})
+
+		Convey("Only admins revoke the links", func() {
+			ctx := roleContext(author, nil, "DELETE", "/admin/editor/"+p.Id.Hex()+"/preview/")
+			So(serve(ctx), ShouldEqual, 403)
+			So(serve(mockContext(nil, "GET", link.RequestURI())), ShouldEqual, 200)
+
+			ctx = roleContext(admin, nil, "DELETE", "/admin/editor/"+p.Id.Hex()+"/preview/")
+			So(serve(ctx), ShouldEqual, 200)
+			So(serve(mockContext(nil, "GET", link.RequestURI())), ShouldEqual, 404)
+		})
+
+		Convey("Revoke every link", func() {
+			ctx := roleContext(admin, nil, "DELETE", "/admin/preview/")
+			So(serve(ctx), ShouldEqual, 200)
+			So(serve(mockContext(nil, "GET", link.RequestURI())), ShouldEqual, 404)
+		})
+	})
+}
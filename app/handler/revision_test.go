@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRevisions(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+
+		editor := mockRoleUser(model.RoleEditor)
+		author := mockRoleUser(model.RoleAuthor)
+
+		p := model.NewPost()
+		p.Slug = "revised"
+		p.CreatedBy = editor.Id.Hex()
+		p.Markdown = "first"
+		p.Save()
+		p.Markdown = "second"
+		p.Save()
+		revisions, _ := p.GetRevisions()
+		path := "/api/posts/" + p.Id.Hex() + "/revisions"
+
+		Convey("List the revisions through the API", func() {
+			ctx := jwtContext(editor, "", "GET", path)
+			So(serve(ctx), ShouldEqual, 200)
+			var resp struct {
+				Data []map[string]interface{} `json:"data"`
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Data, ShouldHaveLength, 2)
+			So(resp.Data[0]["markdown"], ShouldEqual, "second")
+
+			So(serve(jwtContext(author, "", "GET", path)), ShouldEqual, 403)
+		})
+
+		Convey("Restore a revision through the API", func() {
+			restore := path + "/" + revisions.Get(1).Id.Hex() + "/restore"
+			So(serve(jwtContext(author, "", "POST", restore)), ShouldEqual, 403)
+			So(serve(jwtContext(editor, "", "POST", restore)), ShouldEqual, 200)
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.Markdown, ShouldEqual, "first")
+
+			So(serve(jwtContext(editor, "", "POST", path+"/nothing/restore")), ShouldEqual, 404)
+		})
+
+		Convey("Diff two revisions in the editor", func() {
+			q := url.Values{}
+			q.Add("from", revisions.Get(1).Id.Hex())
+			q.Add("to", revisions.Get(0).Id.Hex())
+			ctx := roleContext(editor, nil, "GET", "/admin/editor/"+p.Id.Hex()+"/revisions/diff/?"+q.Encode())
+			So(serve(ctx), ShouldEqual, 200)
+			var resp struct {
+				Diff []map[string]string `json:"diff"`
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Diff, ShouldHaveLength, 2)
+			So(resp.Diff[0]["kind"], ShouldEqual, "delete")
+		})
+
+		Convey("Restore a revision in the editor", func() {
+			form := url.Values{}
+			form.Add("rev", revisions.Get(1).Id.Hex())
+			So(serve(roleContext(author, form, "POST", "/admin/editor/"+p.Id.Hex()+"/revisions/restore/")), ShouldEqual, 403)
+			So(serve(roleContext(editor, form, "POST", "/admin/editor/"+p.Id.Hex()+"/revisions/restore/")), ShouldEqual, 200)
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.Markdown, ShouldEqual, "first")
+		})
+
+		Reset(func() {
+			model.DropDatabase()
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
+}
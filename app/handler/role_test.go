@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/dinever/golf"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockRoleUser creates a user with the given role.
+func mockRoleUser(role int) *model.User {
+	u := model.NewUser(fmt.Sprintf("role%d@example.com", role), fmt.Sprintf("Role %d", role))
+	u.Role = role
+	u.Create(password)
+	return u
+}
+
+// roleContext returns a context for a request made by the given user, logged
+// in through the admin login form.
+func roleContext(u *model.User, form url.Values, method, path string) *golf.Context {
+	login := url.Values{}
+	login.Add("email", u.Email)
+	login.Add("password", password)
+	ctx := mockContext(login, "POST", "/login/")
+	ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+	rec := ctx.Response.(*httptest.ResponseRecorder)
+
+	req := makeTestHTTPRequest(strings.NewReader(form.Encode()), method, path)
+	req.Header = http.Header{"Cookie": rec.HeaderMap["Set-Cookie"]}
+	req.PostForm = form
+	return golf.NewContext(req, httptest.NewRecorder(), ctx.App)
+}
+
+// jwtContext returns a context for an API request made by the given user.
+func jwtContext(u *model.User, body, method, path string) *golf.Context {
+	token, _ := model.NewJWT(u)
+	app := InitTestApp(golf.New())
+	req := makeTestHTTPRequest(strings.NewReader(body), method, path)
+	req.Header.Set("X-SESSION-TOKEN", token.Token)
+	return golf.NewContext(req, httptest.NewRecorder(), app)
+}
+
+func serve(ctx *golf.Context) int {
+	ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+	return ctx.Response.(*httptest.ResponseRecorder).Code
+}
+
+func TestRoles(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+
+		owner := mockRoleUser(model.RoleOwner)
+		admin := mockRoleUser(model.RoleAdministrator)
+		editor := mockRoleUser(model.RoleEditor)
+		author := mockRoleUser(model.RoleAuthor)
+
+		ownPost := model.NewPost()
+		ownPost.Title = "Written by the author"
+		ownPost.Slug = "author-post"
+		ownPost.CreatedBy = author.Id.Hex()
+		ownPost.Save()
+
+		otherPost := model.NewPost()
+		otherPost.Title = "Written by the editor"
+		otherPost.Slug = "editor-post"
+		otherPost.CreatedBy = editor.Id.Hex()
+		otherPost.Save()
+
+		Convey("Roles should be loaded with the user", func() {
+			u := &model.User{Email: author.Email}
+			So(u.GetUserByEmail(), ShouldBeNil)
+			So(u.Role, ShouldEqual, model.RoleAuthor)
+		})
+
+		Convey("The owner can not be demoted", func() {
+			So(owner.ChangeRole(model.RoleEditor), ShouldEqual, model.ErrOwnerDemotion)
+			u := &model.User{Id: owner.Id}
+			So(u.GetUserById(), ShouldBeNil)
+			So(u.Role, ShouldEqual, model.RoleOwner)
+		})
+
+		Convey("Settings are only for administrators and the owner", func() {
+			So(serve(roleContext(owner, nil, "GET", "/admin/setting/")), ShouldEqual, 200)
+			So(serve(roleContext(admin, nil, "GET", "/admin/setting/")), ShouldEqual, 200)
+			So(serve(roleContext(editor, nil, "GET", "/admin/setting/")), ShouldEqual, 403)
+			So(serve(roleContext(author, nil, "GET", "/admin/setting/")), ShouldEqual, 403)
+
+			form := url.Values{}
+			form.Add("site_title", "Hacked")
+			So(serve(roleContext(author, form, "POST", "/admin/setting/")), ShouldEqual, 403)
+			So(model.GetSettingValue("site_title"), ShouldNotEqual, "Hacked")
+		})
+
+		Convey("Files can only be deleted by administrators and the owner", func() {
+			form := url.Values{}
+			form.Add("path", "upload/nothing")
+			So(serve(roleContext(editor, form, "DELETE", "/admin/files/")), ShouldEqual, 403)
+			So(serve(roleContext(author, form, "DELETE", "/admin/files/")), ShouldEqual, 403)
+		})
+
+		Convey("Authors can only edit their own posts", func() {
+			So(serve(roleContext(author, nil, "GET", "/admin/editor/"+ownPost.Id.Hex()+"/")), ShouldEqual, 200)
+			So(serve(roleContext(author, nil, "GET", "/admin/editor/"+otherPost.Id.Hex()+"/")), ShouldEqual, 403)
+			So(serve(roleContext(editor, nil, "GET", "/admin/editor/"+ownPost.Id.Hex()+"/")), ShouldEqual, 200)
+
+			form := url.Values{}
+			form.Add("title", "Overwritten")
+			form.Add("slug", "editor-post")
+			So(serve(roleContext(author, form, "POST", "/admin/editor/"+otherPost.Id.Hex()+"/")), ShouldEqual, 403)
+
+			form.Add("id", otherPost.Id.Hex())
+			So(serve(roleContext(author, form, "POST", "/admin/editor/post/")), ShouldEqual, 403)
+
+			So(serve(roleContext(author, nil, "DELETE", "/admin/editor/"+otherPost.Id.Hex()+"/")), ShouldEqual, 403)
+			p := &model.Post{Id: otherPost.Id}
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.Title, ShouldEqual, "Written by the editor")
+		})
+
+		Convey("Authors can not moderate comments or edit pages", func() {
+			So(serve(roleContext(author, nil, "GET", "/admin/comments/")), ShouldEqual, 403)
+			So(serve(roleContext(author, nil, "GET", "/admin/pages/")), ShouldEqual, 403)
+			So(serve(roleContext(editor, nil, "GET", "/admin/comments/")), ShouldEqual, 200)
+		})
+
+		Convey("The API checks the post author", func() {
+			path := "/api/posts/" + otherPost.Id.Hex()
+			So(serve(jwtContext(author, "", "DELETE", path)), ShouldEqual, 403)
+			So(serve(jwtContext(author, "", "POST", path+"/publish")), ShouldEqual, 403)
+
+			body := fmt.Sprintf(`{"id": "%s", "title": "Overwritten", "created_by": "%s"}`, otherPost.Id.Hex(), author.Id.Hex())
+			So(serve(jwtContext(author, body, "PUT", "/api/posts")), ShouldEqual, 403)
+
+			So(serve(jwtContext(author, "", "POST", "/api/posts/"+ownPost.Id.Hex()+"/publish")), ShouldEqual, 200)
+			So(serve(jwtContext(editor, "", "DELETE", path)), ShouldEqual, 200)
+		})
+
+		Reset(func() {
+			model.DropDatabase()
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSanitizeContent(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		markdown := "Hello <script>alert(1)</script><iframe src=\"https://video.example\"></iframe>"
+
+		savePost := func(u *model.User) *model.Post {
+			form := url.Values{}
+			form.Add("title", "Raw HTML")
+			form.Add("slug", "raw-html-"+u.Id.Hex())
+			form.Add("content", markdown)
+			So(serve(roleContext(u, form, "POST", "/admin/editor/post/")), ShouldEqual, 200)
+			p := new(model.Post)
+			So(p.GetPostBySlug("raw-html-"+u.Id.Hex()), ShouldBeNil)
+			return p
+		}
+
+		Convey("Sanitize the posts of the authors", func() {
+			p := savePost(mockRoleUser(model.RoleAuthor))
+			So(p.Markdown, ShouldEqual, markdown)
+			So(p.Html, ShouldNotContainSubstring, "<script")
+			So(p.Html, ShouldNotContainSubstring, "<iframe")
+			So(p.Html, ShouldContainSubstring, "Hello")
+		})
+
+		Convey("Keep the HTML of the administrators", func() {
+			p := savePost(mockRoleUser(model.RoleAdministrator))
+			So(p.Html, ShouldContainSubstring, `<iframe src="https://video.example">`)
+		})
+
+		Convey("Sanitize the comments", func() {
+			p := mockPost()
+			p.IsPublished = true
+			p.Save()
+			form := url.Values{}
+			form.Add("author", "Visitor <b>")
+			form.Add("email", "visitor@example.com")
+			form.Add("comment", "<b>Nice</b> <a href=\"https://example.com\" onclick=\"x()\">site</a>\n<img src=x onerror=alert(1)><script>alert(1)</script>")
+			ctx := mockContext(form, "POST", "/comment/"+p.Id.Hex()+"/")
+			So(serve(ctx), ShouldEqual, 200)
+			var resp struct {
+				Comment struct {
+					Content string
+				}
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Comment.Content, ShouldEqual, `<p><b>Nice</b> <a href="https://example.com" rel="nofollow ugc">site</a><br />`+"\n</p>")
+
+			messages := new(model.Messages)
+			messages.GetUnreadMessages()
+			So(len(*messages), ShouldBeGreaterThan, 0)
+			So(messages.Get(0).Data, ShouldContainSubstring, "Visitor &lt;b&gt;")
+		})
+	})
+}
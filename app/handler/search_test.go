@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSearch(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+
+		editor := mockRoleUser(model.RoleEditor)
+
+		p := model.NewPost()
+		p.Title = "About gophers"
+		p.Slug = "gophers"
+		p.Markdown = "Gophers dig holes."
+		p.Html = "<p>Gophers dig holes.</p>"
+		p.IsPublished = true
+		p.Save()
+
+		draft := model.NewPost()
+		draft.Title = "Draft about gophers"
+		draft.Slug = "draft"
+		draft.Save()
+
+		Convey("Search the blog", func() {
+			ctx := mockContext(nil, "GET", "/search/?q=holes")
+			So(serve(ctx), ShouldEqual, 200)
+			body := ctx.Response.(*httptest.ResponseRecorder).Body.String()
+			So(body, ShouldContainSubstring, "<mark>holes</mark>")
+			So(body, ShouldNotContainSubstring, "Draft about gophers")
+		})
+
+		Convey("Search through the API", func() {
+			ctx := mockContext(nil, "GET", "/api/posts/search?q=gophers")
+			So(serve(ctx), ShouldEqual, 200)
+			var resp struct {
+				Data []map[string]interface{} `json:"data"`
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Data, ShouldHaveLength, 1)
+			So(resp.Data[0]["slug"], ShouldEqual, "gophers")
+
+			So(serve(mockContext(nil, "GET", "/api/posts/search")), ShouldEqual, 400)
+		})
+
+		Convey("Filter the posts in the admin panel", func() {
+			ctx := roleContext(editor, nil, "GET", "/admin/posts/?q=draft")
+			So(serve(ctx), ShouldEqual, 200)
+			body := ctx.Response.(*httptest.ResponseRecorder).Body.String()
+			So(body, ShouldContainSubstring, "Draft about gophers")
+			So(body, ShouldNotContainSubstring, ">About gophers<")
+		})
+
+		Reset(func() {
+			model.DropDatabase()
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
+}
@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/covrom/dingo/app/utils"
+	"github.com/dinever/golf"
+)
+
+var settingKeyParam = pathParam("key", "The key of the setting.")
+
+func registerSettingHandlers(api *APIRouter) {
+	api.Handle(&APIRoute{
+		Name:       "settings_url",
+		Method:     "GET",
+		Path:       "/api/settings",
+		Tag:        "settings",
+		Summary:    "List the settings",
+		Params:     []APIParam{queryParam("type", "string", "Only the settings of the type: general, content, navigation or custom.")},
+		Response:   model.Settings{},
+		Permission: model.PermSettingEdit,
+		Handler:    APISettingsHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:        "setting_create_url",
+		Method:      "POST",
+		Path:        "/api/settings",
+		Tag:         "settings",
+		Summary:     "Create a setting",
+		Description: "The type is custom if left out.",
+		Request:     SettingRequestBody{},
+		Response:    model.Setting{},
+		Status:      http.StatusCreated,
+		Errors:      []int{http.StatusBadRequest, http.StatusConflict},
+		Permission:  model.PermSettingEdit,
+		Handler:     APISettingCreateHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "setting_url",
+		Method:     "GET",
+		Path:       "/api/settings/:key",
+		Tag:        "settings",
+		Summary:    "Get a setting",
+		Params:     []APIParam{settingKeyParam},
+		Response:   model.Setting{},
+		Errors:     []int{http.StatusNotFound},
+		Permission: model.PermSettingEdit,
+		Handler:    APISettingHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "setting_update_url",
+		Method:     "PUT",
+		Path:       "/api/settings/:key",
+		Tag:        "settings",
+		Summary:    "Change the value of a setting",
+		Params:     []APIParam{settingKeyParam},
+		Request:    SettingRequestBody{},
+		Response:   model.Setting{},
+		Errors:     []int{http.StatusBadRequest, http.StatusNotFound},
+		Permission: model.PermSettingEdit,
+		Handler:    APISettingUpdateHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "setting_delete_url",
+		Method:     "DELETE",
+		Path:       "/api/settings/:key",
+		Tag:        "settings",
+		Summary:    "Delete a custom setting",
+		Params:     []APIParam{settingKeyParam},
+		Errors:     []int{http.StatusBadRequest, http.StatusNotFound},
+		Permission: model.PermSettingEdit,
+		Handler:    APISettingDeleteHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:     "navigation_url",
+		Method:   "GET",
+		Path:     "/api/navigation",
+		Tag:      "settings",
+		Summary:  "Get the links of the navigation menu",
+		Response: []*model.Navigator{},
+		Handler:  APINavigationHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "navigation_update_url",
+		Method:     "PUT",
+		Path:       "/api/navigation",
+		Tag:        "settings",
+		Summary:    "Replace the links of the navigation menu",
+		Request:    []*model.Navigator{},
+		Response:   []*model.Navigator{},
+		Errors:     []int{http.StatusBadRequest},
+		Permission: model.PermSettingEdit,
+		Handler:    APINavigationUpdateHandler,
+	})
+}
+
+// A SettingRequestBody is the json-formatted request body used to create and
+// update settings.
+type SettingRequestBody struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+// getSettingFromContext loads the setting referenced by the key, answering
+// 404 if there is none.
+func getSettingFromContext(ctx *golf.Context) *model.Setting {
+	s := &model.Setting{Key: ctx.Param("key")}
+	if err := s.GetSetting(); err != nil {
+		sendAPIError(ctx, http.StatusNotFound, "setting not found")
+		return nil
+	}
+	return s
+}
+
+// APISettingsHandler retrieves all the settings, or the ones of the type
+// query parameter.
+func APISettingsHandler(ctx *golf.Context) {
+	if t, _ := ctx.Query("type"); t != "" {
+		ctx.JSON(NewAPISuccessResponse(model.GetSettingsByType(t)))
+		return
+	}
+	settings, err := model.GetAllSettings()
+	if err != nil {
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(settings))
+}
+
+// APISettingHandler retrieves the setting with the given key.
+func APISettingHandler(ctx *golf.Context) {
+	s := getSettingFromContext(ctx)
+	if s == nil {
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(s))
+}
+
+// APISettingCreateHandler creates the setting given in the json-formatted
+// request body, a custom one unless it has another type.
+func APISettingCreateHandler(ctx *golf.Context) {
+	u, err := getJWTUser(ctx)
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		return
+	}
+	req := new(SettingRequestBody)
+	if err := readJSONBody(ctx, req); err != nil {
+		sendAPIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Key == "" {
+		sendAPIError(ctx, http.StatusBadRequest, "The key of the setting can not be empty.")
+		return
+	}
+	if (&model.Setting{Key: req.Key}).GetSetting() == nil {
+		sendAPIError(ctx, http.StatusConflict, "A setting with that key already exists.")
+		return
+	}
+	if req.Type == "" {
+		req.Type = "custom"
+	}
+	s := model.NewSetting(req.Key, req.Value, req.Type)
+	s.CreatedBy = u.Id.Hex()
+	if err := s.Save(); err != nil {
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.SendStatus(http.StatusCreated)
+	ctx.JSON(NewAPISuccessResponse(s))
+}
+
+// APISettingUpdateHandler sets the value of the setting with the given key to
+// the one of the json-formatted request body.
+func APISettingUpdateHandler(ctx *golf.Context) {
+	u, err := getJWTUser(ctx)
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		return
+	}
+	s := getSettingFromContext(ctx)
+	if s == nil {
+		return
+	}
+	req := new(SettingRequestBody)
+	if err := readJSONBody(ctx, req); err != nil {
+		sendAPIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.Value = req.Value
+	s.UpdatedAt = utils.Now()
+	s.UpdatedBy = u.Id.Hex()
+	if err := s.Save(); err != nil {
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(s))
+}
+
+// APISettingDeleteHandler deletes the custom setting with the given key. The
+// other settings are used by the blog, and can not be deleted.
+func APISettingDeleteHandler(ctx *golf.Context) {
+	s := getSettingFromContext(ctx)
+	if s == nil {
+		return
+	}
+	if s.Type != "custom" {
+		sendAPIError(ctx, http.StatusBadRequest, "Only the custom settings can be deleted.")
+		return
+	}
+	if err := s.Delete(); err != nil {
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(nil))
+}
+
+// APINavigationHandler retrieves the links of the site navigation menu.
+func APINavigationHandler(ctx *golf.Context) {
+	navs := model.GetNavigators()
+	if navs == nil {
+		navs = []*model.Navigator{}
+	}
+	ctx.JSON(NewAPISuccessResponse(navs))
+}
+
+// APINavigationUpdateHandler replaces the site navigation menu with the links
+// of the json-formatted request body, in order.
+func APINavigationUpdateHandler(ctx *golf.Context) {
+	var navs []*model.Navigator
+	if err := readJSONBody(ctx, &navs); err != nil {
+		sendAPIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, n := range navs {
+		if n == nil || n.Label == "" || n.Url == "" {
+			sendAPIError(ctx, http.StatusBadRequest, "Every link needs a label and a URL.")
+			return
+		}
+	}
+	if err := model.SaveNavigators(navs); err != nil {
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	APINavigationHandler(ctx)
+}
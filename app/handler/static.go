@@ -0,0 +1,311 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/covrom/dingo/app/model"
+	"github.com/covrom/dingo/app/utils"
+	"github.com/dinever/golf"
+)
+
+// staticManifest is the file of a static export listing the version of the
+// source of each exported file, so that exporting again only rewrites the
+// files whose source changed.
+const staticManifest = ".dingo-static.json"
+
+// staticFeeds maps the feed formats to the URLs they are exported to. Static
+// hosts serve the index.xml and index.json files of a directory for its URL
+// when configured to.
+var staticFeeds = map[string]string{
+	"rss":  "/feed/index.xml",
+	"atom": "/feed/atom/index.xml",
+	"json": "/feed/json/index.json",
+}
+
+// A StaticReport tells what a static export did.
+type StaticReport struct {
+	Written   int
+	Unchanged int
+	Removed   int
+	// Copied is the number of theme assets and uploaded files copied.
+	Copied int
+}
+
+// String returns the report as a line of text.
+func (r *StaticReport) String() string {
+	return fmt.Sprintf("%d files written, %d unchanged, %d removed, %d assets copied",
+		r.Written, r.Unchanged, r.Removed, r.Copied)
+}
+
+// staticExport is a static export in progress.
+type staticExport struct {
+	app *golf.Application
+	dir string
+	// versions are the versions of the files of the previous export, and
+	// exported those of this one.
+	versions map[string]string
+	exported map[string]string
+	// siteVersion changes with any published post or page.
+	siteVersion string
+	report      StaticReport
+}
+
+// ExportStatic renders the published posts and pages, the pages of the home
+// page and of the tags, the feed and the sitemap of the blog with its theme
+// into dir, along with the assets of the theme and the uploaded files. The
+// files of a previous export are only rewritten when the posts they show
+// were updated.
+func ExportStatic(dir, uploadDir string) (*StaticReport, error) {
+	app := golf.New()
+	initializeView(app)
+	e := &staticExport{
+		app:      app,
+		dir:      dir,
+		versions: make(map[string]string),
+		exported: make(map[string]string),
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, staticManifest)); err == nil {
+		json.Unmarshal(b, &e.versions)
+	}
+
+	steps := []func() error{e.exportContent, e.exportHome, e.exportTags, e.exportFeeds, e.exportSiteMap}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.copyDir(themeAssetsDir(model.GetSettingValue("theme")), dir); err != nil {
+		return nil, err
+	}
+	if err := e.copyDir(uploadDir, filepath.Join(dir, "upload")); err != nil {
+		return nil, err
+	}
+
+	// The posts unpublished or deleted since the previous export are removed.
+	for p := range e.versions {
+		if _, ok := e.exported[p]; ok {
+			continue
+		}
+		if err := os.Remove(e.file(p)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		e.report.Removed++
+	}
+	b, err := json.MarshalIndent(e.exported, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, staticManifest), b, 0644); err != nil {
+		return nil, err
+	}
+	return &e.report, nil
+}
+
+// postsVersion returns the version of a file showing the posts, which
+// changes when they are updated or listed differently.
+func postsVersion(posts []*model.Post, extra ...interface{}) string {
+	h := sha1.New()
+	fmt.Fprintln(h, extra...)
+	for _, p := range posts {
+		var updated int64
+		if p.UpdatedAt != nil {
+			updated = p.UpdatedAt.UnixNano()
+		}
+		fmt.Fprintln(h, p.Id.Hex(), updated)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// file returns the path of the file exported for the URL path. The URLs
+// ending with a slash are exported to index.html files.
+func (e *staticExport) file(urlPath string) string {
+	p := path.Clean("/" + urlPath)
+	if urlPath == "" || urlPath[len(urlPath)-1] == '/' {
+		p = path.Join(p, "index.html")
+	}
+	return filepath.Join(e.dir, filepath.FromSlash(p))
+}
+
+// write writes the file of the URL path, unless the previous export already
+// wrote the same version of it.
+func (e *staticExport) write(urlPath, version string, render func() ([]byte, error)) error {
+	e.exported[urlPath] = version
+	file := e.file(urlPath)
+	if e.versions[urlPath] == version {
+		if _, err := os.Stat(file); err == nil {
+			e.report.Unchanged++
+			return nil
+		}
+	}
+	b, err := render()
+	if err != nil {
+		return fmt.Errorf("failed to render %s: %v", urlPath, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	e.report.Written++
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// template returns the renderer of the template with the data.
+func (e *staticExport) template(loader, name string, data map[string]interface{}) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		// There is no form to protect on a static site.
+		data["xsrf_token"] = ""
+		s, err := e.app.View.Render(loader, name, data)
+		return []byte(s), err
+	}
+}
+
+func (e *staticExport) exportContent() error {
+	var all []*model.Post
+	for _, isPage := range []bool{false, true} {
+		posts := new(model.Posts)
+		if err := posts.GetAllPostList(isPage, true, "published_at DESC"); err != nil {
+			return err
+		}
+		for _, p := range *posts {
+			if !p.IsPublic() {
+				continue
+			}
+			all = append(all, p)
+			err := e.write(p.Url()+"/", postsVersion([]*model.Post{p}), e.template("theme", contentTemplate(p), contentData(p)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	e.siteVersion = postsVersion(all)
+	return nil
+}
+
+func (e *staticExport) exportHome() error {
+	for page := 1; ; page++ {
+		data, err := homeData(page)
+		if err != nil {
+			return err
+		}
+		urlPath := "/"
+		if page > 1 {
+			urlPath = "/page/" + strconv.Itoa(page) + "/"
+		}
+		pager := data["Pager"].(*utils.Pager)
+		version := postsVersion(*data["Posts"].(*model.Posts), pager.Total)
+		if err := e.write(urlPath, version, e.template("theme", "index.html", data)); err != nil {
+			return err
+		}
+		if !pager.IsNext {
+			return nil
+		}
+	}
+}
+
+func (e *staticExport) exportTags() error {
+	tags := new(model.Tags)
+	if err := tags.GetAllTags(); err != nil {
+		return err
+	}
+	for i := range *tags {
+		tag := &(*tags)[i]
+		for page := 1; ; page++ {
+			data, err := tagData(tag, page)
+			if err != nil {
+				return err
+			}
+			pager := data["Pager"].(*utils.Pager)
+			// The tags of drafts only have no page.
+			if pager.Total == 0 {
+				break
+			}
+			urlPath := tag.Url() + "/"
+			if page > 1 {
+				urlPath += "page/" + strconv.Itoa(page) + "/"
+			}
+			version := postsVersion(*data["Posts"].(*model.Posts), pager.Total, tag.Name)
+			if err := e.write(urlPath, version, e.template("theme", "tag.html", data)); err != nil {
+				return err
+			}
+			if !pager.IsNext {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (e *staticExport) exportFeeds() error {
+	for name, urlPath := range staticFeeds {
+		format := model.FeedFormats[name]
+		err := e.write(urlPath, e.siteVersion, func() ([]byte, error) {
+			feed, err := model.SiteFeed()
+			if err != nil {
+				return nil, err
+			}
+			feed.FeedLink = model.AbsoluteUrl(path.Dir(urlPath) + "/")
+			return format.Render(feed)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *staticExport) exportSiteMap() error {
+	return e.write("/sitemap.xml", e.siteVersion, e.template("base", "sitemap.xml", siteMapData()))
+}
+
+// copyDir copies the files of the src directory to dst, but those already
+// copied and unchanged since. A missing src directory has nothing to copy.
+func (e *staticExport) copyDir(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && p == src {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if t, err := os.Stat(target); err == nil && t.Size() == info.Size() && t.ModTime().Equal(info.ModTime()) {
+			return nil
+		}
+		if err := copyFile(p, target); err != nil {
+			return err
+		}
+		e.report.Copied++
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExportStatic(t *testing.T) {
+	// The templates of the export are found from the root of the repository.
+	wd, _ := os.Getwd()
+	os.Chdir(filepath.Join("..", ".."))
+	defer os.Chdir(wd)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+		dir, _ := ioutil.TempDir("", "dingo-static")
+		uploadDir := filepath.Join(dir, "uploaded")
+		os.MkdirAll(uploadDir, os.ModePerm)
+		ioutil.WriteFile(filepath.Join(uploadDir, "image.png"), []byte("image"), 0644)
+		out := filepath.Join(dir, "public")
+
+		p := mockPost()
+		p.IsPublished = true
+		So(p.Save(model.NewTag("Go", "go")), ShouldBeNil)
+		page := mockPost()
+		page.Title = "About"
+		page.Slug = "about"
+		page.IsPage = true
+		page.IsPublished = true
+		So(page.Save(), ShouldBeNil)
+		draft := mockPost()
+		draft.Slug = "draft"
+		So(draft.Save(model.NewTag("Secret", "secret")), ShouldBeNil)
+
+		exists := func(name string) bool {
+			_, err := os.Stat(filepath.Join(out, filepath.FromSlash(name)))
+			return err == nil
+		}
+
+		report, err := ExportStatic(out, uploadDir)
+		So(err, ShouldBeNil)
+
+		Convey("Export the published content", func() {
+			So(exists("index.html"), ShouldBeTrue)
+			So(exists("welcome-to-dingo/index.html"), ShouldBeTrue)
+			So(exists("about/index.html"), ShouldBeTrue)
+			So(exists("tag/go/index.html"), ShouldBeTrue)
+			So(exists("feed/index.xml"), ShouldBeTrue)
+			So(exists("feed/atom/index.xml"), ShouldBeTrue)
+			So(exists("feed/json/index.json"), ShouldBeTrue)
+			So(exists("sitemap.xml"), ShouldBeTrue)
+			So(exists("upload/image.png"), ShouldBeTrue)
+			So(exists("css"), ShouldBeTrue)
+			So(exists("draft/index.html"), ShouldBeFalse)
+			So(exists("tag/secret/index.html"), ShouldBeFalse)
+			So(report.Written, ShouldEqual, 8)
+			So(report.Copied, ShouldBeGreaterThan, 1)
+
+			b, _ := ioutil.ReadFile(filepath.Join(out, "welcome-to-dingo", "index.html"))
+			So(string(b), ShouldContainSubstring, "Welcome to Dingo!")
+		})
+
+		Convey("Export again without changes", func() {
+			report, err := ExportStatic(out, uploadDir)
+			So(err, ShouldBeNil)
+			So(report.Written, ShouldEqual, 0)
+			So(report.Unchanged, ShouldEqual, 8)
+			So(report.Copied, ShouldEqual, 0)
+		})
+
+		Convey("Export again after an update", func() {
+			time.Sleep(time.Millisecond)
+			p.Title = "Updated"
+			So(p.Save(), ShouldBeNil)
+			report, err := ExportStatic(out, uploadDir)
+			So(err, ShouldBeNil)
+			// Only the page is unchanged, and the post lost its tag.
+			So(report.Unchanged, ShouldEqual, 1)
+			So(report.Written, ShouldEqual, 6)
+			So(report.Removed, ShouldEqual, 1)
+			So(exists("tag/go/index.html"), ShouldBeFalse)
+
+			b, _ := ioutil.ReadFile(filepath.Join(out, "welcome-to-dingo", "index.html"))
+			So(string(b), ShouldContainSubstring, "Updated")
+		})
+
+		Convey("Remove the unpublished content", func() {
+			page.IsPublished = false
+			So(page.Save(), ShouldBeNil)
+			report, err := ExportStatic(out, uploadDir)
+			So(err, ShouldBeNil)
+			So(report.Removed, ShouldEqual, 1)
+			So(exists("about/index.html"), ShouldBeFalse)
+		})
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+	})
+}
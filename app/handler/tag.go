@@ -1,62 +1,183 @@
 package handler
 
 import (
-	"fmt"
+	"net/http"
 
 	"github.com/covrom/dingo/app/model"
 	"github.com/dinever/golf"
 )
 
-func registerTagHandlers(app *golf.Application, routes map[string]map[string]interface{}) {
-	app.Get("/api/tags", APITagsHandler)
-	routes["GET"]["tags_url"] = "/api/tags"
+// Tags are kept on the posts, which give them no id: the slug of a tag is
+// its id.
+var tagSlugParam = pathParam("slug", "The slug of the tag.")
 
-	app.Get("/api/tags/:tag_id", APITagHandler)
-	routes["GET"]["tag_url"] = "/api/tags/:tag_id"
+func registerTagHandlers(api *APIRouter) {
+	api.Handle(&APIRoute{
+		Name:     "tags_url",
+		Method:   "GET",
+		Path:     "/api/tags",
+		Tag:      "tags",
+		Summary:  "List the tags",
+		Response: model.Tags{},
+		Handler:  APITagsHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:     "tag_url",
+		Method:   "GET",
+		Path:     "/api/tags/:slug",
+		Tag:      "tags",
+		Summary:  "Get a tag",
+		Params:   []APIParam{tagSlugParam},
+		Response: model.Tag{},
+		Errors:   []int{http.StatusNotFound},
+		Handler:  APITagHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:        "tag_rename_url",
+		Method:      "PUT",
+		Path:        "/api/tags/:slug",
+		Tag:         "tags",
+		Summary:     "Rename a tag on every post",
+		Description: "The slug is made from the name if left out.",
+		Params:      []APIParam{tagSlugParam},
+		Request:     TagRequestBody{},
+		Response:    model.Tag{},
+		Errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict},
+		Permission:  model.PermTagManage,
+		Handler:     APITagRenameHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "tag_merge_url",
+		Method:     "POST",
+		Path:       "/api/tags/:slug/merge",
+		Tag:        "tags",
+		Summary:    "Merge a tag into the tag of the slug into, on every post",
+		Params:     []APIParam{tagSlugParam},
+		Request:    TagRequestBody{},
+		Response:   model.Tag{},
+		Errors:     []int{http.StatusBadRequest, http.StatusNotFound},
+		Permission: model.PermTagManage,
+		Handler:    APITagMergeHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "tag_delete_url",
+		Method:     "DELETE",
+		Path:       "/api/tags/:slug",
+		Tag:        "tags",
+		Summary:    "Remove a tag from every post",
+		Params:     []APIParam{tagSlugParam},
+		Errors:     []int{http.StatusNotFound},
+		Permission: model.PermTagManage,
+		Handler:    APITagDeleteHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:     "tag_slug_url",
+		Method:   "GET",
+		Path:     "/api/tags/slug/:slug",
+		Tag:      "tags",
+		Summary:  "Get a tag by its slug",
+		Params:   []APIParam{tagSlugParam},
+		Response: model.Tag{},
+		Errors:   []int{http.StatusNotFound},
+		Handler:  APITagHandler,
+	})
+}
 
-	app.Get("/api/tags/slug/:slug", APITagSlugHandler)
-	routes["GET"]["tag_slug_url"] = "/api/tags/:slug"
+// A TagRequestBody is the json-formatted request body used to rename and
+// merge tags.
+type TagRequestBody struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+	// Into is the slug of the tag to merge a tag into.
+	Into string `json:"into"`
 }
 
-// APITagHandler retrieves the tag with the given id.
+// APITagHandler retrieves the tag with the given slug.
 func APITagHandler(ctx *golf.Context) {
-	// FIXME: нет такого id теперь
-
-	// id := ctx.Param("tag_id")
-	// if err != nil {
-	// 	handleErr(ctx, 500, err)
-	// 	return
-	// }
-
-	// tag := &model.Tag{Id: bson.ObjectIdHex(id)}
-	// err = tag.GetTag()
-	// if err != nil {
-	// 		handleErr(ctx, 404, err)
-	handleErr(ctx, 404, fmt.Errorf("tag_id not supported with mongodb"))
-	return
-	// }
-	// ctx.JSONIndent(tag, "", "  ")
+	tag := &model.Tag{Slug: ctx.Param("slug")}
+	if err := tag.GetTagBySlug(); err == model.ErrNotFound {
+		sendAPIError(ctx, http.StatusNotFound, "tag not found")
+		return
+	} else if err != nil {
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(tag))
 }
 
 // APITagsHandler retrieves all the tags.
 func APITagsHandler(ctx *golf.Context) {
-	tags := new(model.Tags)
-	err := tags.GetAllTags()
-	if err != nil {
-		handleErr(ctx, 404, err)
+	tags := model.Tags{}
+	if err := tags.GetAllTags(); err != nil {
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
-	ctx.JSONIndent(tags, "", "  ")
+	ctx.JSON(NewAPISuccessResponse(tags))
+}
+
+// APITagRenameHandler gives the tag with the given slug the name and slug of
+// the json-formatted request body, on every post. The slug is generated from
+// the name if left out.
+func APITagRenameHandler(ctx *golf.Context) {
+	req := new(TagRequestBody)
+	if err := readJSONBody(ctx, req); err != nil {
+		sendAPIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Name == "" {
+		sendAPIError(ctx, http.StatusBadRequest, "The name of the tag can not be empty.")
+		return
+	}
+	tag, err := model.RenameTag(ctx.Param("slug"), model.NewTag(req.Name, req.Slug))
+	switch err {
+	case nil:
+		ctx.JSON(NewAPISuccessResponse(tag))
+	case model.ErrNotFound:
+		sendAPIError(ctx, http.StatusNotFound, "tag not found")
+	case model.ErrTagExists:
+		sendAPIError(ctx, http.StatusConflict, err.Error())
+	default:
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+	}
 }
 
-// APITagSlugHandler retrieves the tag(s) with the given slug.
-func APITagSlugHandler(ctx *golf.Context) {
+// APITagMergeHandler replaces the tag with the given slug by the tag into of
+// the json-formatted request body, on every post.
+func APITagMergeHandler(ctx *golf.Context) {
+	req := new(TagRequestBody)
+	if err := readJSONBody(ctx, req); err != nil {
+		sendAPIError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
 	slug := ctx.Param("slug")
-	tags := &model.Tag{Slug: slug}
-	err := tags.GetTagBySlug()
-	if err != nil {
-		handleErr(ctx, 500, err)
+	if req.Into == "" || req.Into == slug {
+		sendAPIError(ctx, http.StatusBadRequest, "The tag to merge into must be another tag.")
+		return
+	}
+	if err := (&model.Tag{Slug: slug}).GetTagBySlug(); err != nil {
+		sendAPIError(ctx, http.StatusNotFound, "tag not found")
+		return
+	}
+	into := &model.Tag{Slug: req.Into}
+	if err := into.GetTagBySlug(); err != nil {
+		sendAPIError(ctx, http.StatusBadRequest, "The tag to merge into does not exist.")
 		return
 	}
-	ctx.JSONIndent(tags, "", "  ")
+	if err := model.MergeTags(slug, req.Into); err != nil {
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(into))
+}
+
+// APITagDeleteHandler removes the tag with the given slug from every post.
+func APITagDeleteHandler(ctx *golf.Context) {
+	switch err := model.DeleteTag(ctx.Param("slug")); err {
+	case nil:
+		ctx.JSON(NewAPISuccessResponse(nil))
+	case model.ErrNotFound:
+		sendAPIError(ctx, http.StatusNotFound, "tag not found")
+	default:
+		sendAPIError(ctx, http.StatusInternalServerError, err.Error())
+	}
 }
@@ -1,52 +1,138 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
 	"github.com/globalsign/mgo/bson"
 
 	"github.com/covrom/dingo/app/model"
 	"github.com/dinever/golf"
 )
 
-func registerUserHandlers(app *golf.Application, routes map[string]map[string]interface{}) {
-	app.Get("/api/users", APIUsersHandler)
-	routes["GET"]["users_url"] = "/api/users"
-
-	app.Get("/api/users/:user_id", APIUserHandler)
-	routes["GET"]["user_url"] = "/api/users/:user_id"
+var (
+	errSelfChange     = errors.New("You can not change your own role or status.")
+	errSelfDelete     = errors.New("You can not delete yourself.")
+	errOwnerRole      = errors.New("The owner role can not be given away.")
+	errUnknownStatus  = errors.New("Unknown user status.")
+	errInvalidEmail   = errors.New("Invalid email address.")
+	errEmailExists    = errors.New("A user with that email address already exists.")
+	errUnknownNewUser = errors.New("The user to give the posts to does not exist.")
+)
 
-	app.Get("/api/users/slug/:slug", APIUserSlugHandler)
-	routes["GET"]["user_slug_url"] = "/api/users/slug/:slug"
+var userIdParam = pathParam("user_id", "The id of the user.")
 
-	app.Get("/api/users/email/:email", APIUserEmailHandler)
-	routes["GET"]["user_email_url"] = "/api/users/email/:email"
+func registerUserHandlers(api *APIRouter) {
+	api.Handle(&APIRoute{
+		Name:       "users_url",
+		Method:     "GET",
+		Path:       "/api/users",
+		Tag:        "users",
+		Summary:    "List the users",
+		Params:     []APIParam{offsetParam, limitParam},
+		Response:   model.Users{},
+		Errors:     []int{http.StatusBadRequest},
+		Permission: model.PermUserManage,
+		Handler:    APIUsersHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "user_invite_url",
+		Method:     "POST",
+		Path:       "/api/users",
+		Tag:        "users",
+		Summary:    "Invite a user with a role",
+		Request:    UserRequestBody{},
+		Response:   model.Invite{},
+		Status:     http.StatusCreated,
+		Errors:     []int{http.StatusBadRequest},
+		Permission: model.PermUserManage,
+		Handler:    APIUserInviteHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:        "user_update_url",
+		Method:      "PUT",
+		Path:        "/api/users/:user_id",
+		Tag:         "users",
+		Summary:     "Change the role and status of a user",
+		Description: "Fields left out are not changed.",
+		Params:      []APIParam{userIdParam},
+		Request:     UserRequestBody{},
+		Response:    model.User{},
+		Errors:      []int{http.StatusBadRequest, http.StatusNotFound},
+		Permission:  model.PermUserManage,
+		Handler:     APIUserUpdateHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:        "user_delete_url",
+		Method:      "DELETE",
+		Path:        "/api/users/:user_id",
+		Tag:         "users",
+		Summary:     "Delete a user",
+		Description: "The posts of the user are given to the user of the reassign parameter, or of the token.",
+		Params:      []APIParam{userIdParam, queryParam("reassign", "string", "The id of the user to give the posts to.")},
+		Errors:      []int{http.StatusBadRequest, http.StatusNotFound},
+		Permission:  model.PermUserManage,
+		Handler:     APIUserDeleteHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:     "user_url",
+		Method:   "GET",
+		Path:     "/api/users/:user_id",
+		Tag:      "users",
+		Summary:  "Get the public profile of a user",
+		Params:   []APIParam{userIdParam},
+		Response: model.PublicUser{},
+		Errors:   []int{http.StatusNotFound},
+		Handler:  APIUserHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:     "user_slug_url",
+		Method:   "GET",
+		Path:     "/api/users/slug/:slug",
+		Tag:      "users",
+		Summary:  "Get the public profile of a user by their slug",
+		Params:   []APIParam{pathParam("slug", "The slug of the user.")},
+		Response: model.PublicUser{},
+		Errors:   []int{http.StatusNotFound},
+		Handler:  APIUserSlugHandler,
+	})
+	api.Handle(&APIRoute{
+		Name:       "user_email_url",
+		Method:     "GET",
+		Path:       "/api/users/email/:email",
+		Tag:        "users",
+		Summary:    "Get a user by their email",
+		Params:     []APIParam{pathParam("email", "The email of the user.")},
+		Response:   model.User{},
+		Errors:     []int{http.StatusNotFound},
+		Permission: model.PermUserManage,
+		Handler:    APIUserEmailHandler,
+	})
 }
 
-// APIUserHandler retrieves the user with the given id.
+// APIUserHandler retrieves the public profile of the user with the given id.
 func APIUserHandler(ctx *golf.Context) {
-	id := ctx.Param("user_id")
-	// if err != nil {
-	// 	handleErr(ctx, 500, err)
-	// 	return
-	// }
-	user := &model.User{Id: bson.ObjectIdHex(id)}
-	err := user.GetUserById()
-	if err != nil {
-		handleErr(ctx, 404, err)
+	user := getUserFromContext(ctx)
+	if user == nil {
 		return
 	}
-	ctx.JSONIndent(user, "", "  ")
+	ctx.JSON(NewAPISuccessResponse(user.Public()))
 }
 
-// APIUserSlugHandler retrives the user with the given slug.
+// APIUserSlugHandler retrives the public profile of the user with the given
+// slug.
 func APIUserSlugHandler(ctx *golf.Context) {
 	slug := ctx.Param("slug")
 	user := &model.User{Slug: slug}
 	err := user.GetUserBySlug()
 	if err != nil {
-		handleErr(ctx, 404, err)
+		sendAPIError(ctx, http.StatusNotFound, err.Error())
 		return
 	}
-	ctx.JSONIndent(user, "", "  ")
+	ctx.JSON(NewAPISuccessResponse(user.Public()))
 }
 
 // APIUserEmailHandler retrieves the user with the given email.
@@ -55,15 +141,207 @@ func APIUserEmailHandler(ctx *golf.Context) {
 	user := &model.User{Email: email}
 	err := user.GetUserByEmail()
 	if err != nil {
-		handleErr(ctx, 404, err)
+		sendAPIError(ctx, http.StatusNotFound, err.Error())
 		return
 	}
-	ctx.JSONIndent(user, "", "  ")
+	ctx.JSON(NewAPISuccessResponse(user))
 }
 
-// APIUsersHandler retrieves all users.
+// APIUsersHandler gets an array of users of length <= limit, starting at
+// offset.
 func APIUsersHandler(ctx *golf.Context) {
-	ctx.JSONIndent(map[string]interface{}{
-		"message": "Not implemented",
-	}, "", "  ")
+	offset, limit := 0, 10
+	var err error
+	if q, _ := ctx.Query("offset"); q != "" {
+		offset, err = strconv.Atoi(q)
+	}
+	if err == nil {
+		if q, _ := ctx.Query("limit"); q != "" {
+			limit, err = strconv.Atoi(q)
+		}
+	}
+	if err != nil {
+		ctx.SendStatus(http.StatusBadRequest)
+		ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(err.Error())})
+		return
+	}
+	users, err := model.GetAllUsers(offset, limit)
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(err.Error())})
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(users))
+}
+
+// A UserRequestBody is the json-formatted request body used to invite and
+// update users.
+type UserRequestBody struct {
+	Email  string `json:"email"`
+	Role   int    `json:"role"`
+	Status string `json:"status"`
+}
+
+func readUserRequestBody(ctx *golf.Context) (*UserRequestBody, error) {
+	defer ctx.Request.Body.Close()
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	req := new(UserRequestBody)
+	return req, json.Unmarshal(body, req)
+}
+
+// getUserFromContext loads the user referenced by the user_id, answering 404
+// if there is none.
+func getUserFromContext(ctx *golf.Context) *model.User {
+	id := ctx.Param("user_id")
+	if !bson.IsObjectIdHex(id) {
+		ctx.SendStatus(http.StatusNotFound)
+		ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(model.ErrNotFound.Error())})
+		return nil
+	}
+	u := &model.User{Id: bson.ObjectIdHex(id)}
+	if err := u.GetUserById(); err != nil {
+		ctx.SendStatus(http.StatusNotFound)
+		ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(err.Error())})
+		return nil
+	}
+	return u
+}
+
+// APIUserInviteHandler invites the user given in the json-formatted request
+// body, and returns the invite.
+func APIUserInviteHandler(ctx *golf.Context) {
+	by, err := getJWTUser(ctx)
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		return
+	}
+	req, err := readUserRequestBody(ctx)
+	if err != nil {
+		ctx.SendStatus(http.StatusBadRequest)
+		ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(err.Error())})
+		return
+	}
+	invite, err := inviteUser(by, req.Email, req.Role)
+	if err != nil {
+		ctx.SendStatus(http.StatusBadRequest)
+		ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(err.Error())})
+		return
+	}
+	ctx.SendStatus(http.StatusCreated)
+	ctx.JSON(NewAPISuccessResponse(invite))
+}
+
+// APIUserUpdateHandler changes the role and status of the user referenced by
+// the user_id. Fields left out of the json-formatted request body are not
+// changed.
+func APIUserUpdateHandler(ctx *golf.Context) {
+	by, err := getJWTUser(ctx)
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		return
+	}
+	u := getUserFromContext(ctx)
+	if u == nil {
+		return
+	}
+	req, err := readUserRequestBody(ctx)
+	if err != nil {
+		ctx.SendStatus(http.StatusBadRequest)
+		ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(err.Error())})
+		return
+	}
+	if err := updateUser(by, u, req.Role, req.Status); err != nil {
+		ctx.SendStatus(http.StatusBadRequest)
+		ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(err.Error())})
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(u))
+}
+
+// APIUserDeleteHandler deletes the user referenced by the user_id. Their
+// posts are given to the user in the reassign query parameter, or to the
+// user making the request.
+func APIUserDeleteHandler(ctx *golf.Context) {
+	by, err := getJWTUser(ctx)
+	if err != nil {
+		ctx.SendStatus(http.StatusInternalServerError)
+		return
+	}
+	u := getUserFromContext(ctx)
+	if u == nil {
+		return
+	}
+	reassign, _ := ctx.Query("reassign")
+	if err := deleteUser(by, u, reassign); err != nil {
+		ctx.SendStatus(http.StatusBadRequest)
+		ctx.JSON(APIResponseBodyJSON{Status: NewErrorStatusJSON(err.Error())})
+		return
+	}
+	ctx.JSON(NewAPISuccessResponse(nil))
+}
+
+// inviteUser creates and saves an invite for the given email and role.
+func inviteUser(by *model.User, email string, role int) (*model.Invite, error) {
+	if !rxEmail.MatchString(email) {
+		return nil, errInvalidEmail
+	}
+	if (&model.User{Email: email}).UserEmailExist() {
+		return nil, errEmailExists
+	}
+	if role == model.RoleOwner {
+		return nil, errOwnerRole
+	}
+	if model.RoleName(role) == "" {
+		return nil, model.ErrUnknownRole
+	}
+	invite, err := model.NewInvite(email, role, by)
+	if err != nil {
+		return nil, err
+	}
+	return invite, invite.Save()
+}
+
+// updateUser changes the role and status of the user. A zero role or an
+// empty status are left unchanged.
+func updateUser(by, u *model.User, role int, status string) error {
+	if by.Id == u.Id {
+		return errSelfChange
+	}
+	if role != 0 && role != u.Role {
+		if role == model.RoleOwner {
+			return errOwnerRole
+		}
+		if err := u.ChangeRole(role); err != nil {
+			return err
+		}
+	}
+	switch status {
+	case "":
+	case model.UserStatusActive, model.UserStatusSuspended:
+		return u.SetStatus(status)
+	default:
+		return errUnknownStatus
+	}
+	return nil
+}
+
+// deleteUser deletes the user, giving their posts to the user with the given
+// ID, or to the user doing the deletion.
+func deleteUser(by, u *model.User, reassignTo string) error {
+	if by.Id == u.Id {
+		return errSelfDelete
+	}
+	if reassignTo == "" {
+		reassignTo = by.Id.Hex()
+	}
+	if !bson.IsObjectIdHex(reassignTo) || reassignTo == u.Id.Hex() {
+		return errUnknownNewUser
+	}
+	if err := (&model.User{Id: bson.ObjectIdHex(reassignTo)}).GetUserById(); err != nil {
+		return errUnknownNewUser
+	}
+	return u.Delete(reassignTo)
 }
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/covrom/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUserManagement(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	model.InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		model.Initialize("sqlite://:memory:", true)
+
+		owner := mockRoleUser(model.RoleOwner)
+		admin := mockRoleUser(model.RoleAdministrator)
+		author := mockRoleUser(model.RoleAuthor)
+
+		Convey("Invite a user", func() {
+			form := url.Values{}
+			form.Add("email", "invited@example.com")
+			form.Add("role", "2")
+			ctx := roleContext(admin, form, "POST", "/admin/users/")
+			So(serve(ctx), ShouldEqual, 200)
+
+			var resp map[string]string
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp["url"], ShouldContainSubstring, "/signup/?token=")
+			token := resp["url"][strings.Index(resp["url"], "=")+1:]
+
+			Convey("Sign up with the invite", func() {
+				form := url.Values{}
+				form.Add("token", token)
+				form.Add("email", "someone-else@example.com")
+				form.Add("name", "Invited")
+				form.Add("password", password)
+				form.Add("re-password", password)
+				So(serve(mockContext(form, "POST", "/signup/")), ShouldEqual, 200)
+
+				u := &model.User{Email: "invited@example.com"}
+				So(u.GetUserByEmail(), ShouldBeNil)
+				So(u.Role, ShouldEqual, model.RoleEditor)
+
+				Convey("The invite can only be used once", func() {
+					form.Set("name", "Invited again")
+					So(serve(mockContext(form, "POST", "/signup/")), ShouldEqual, 403)
+				})
+			})
+
+			Convey("Authors can not invite users", func() {
+				So(serve(roleContext(author, form, "POST", "/admin/users/")), ShouldEqual, 403)
+			})
+		})
+
+		Convey("The owner role can not be given", func() {
+			form := url.Values{}
+			form.Add("email", "owner2@example.com")
+			form.Add("role", "4")
+			So(serve(roleContext(admin, form, "POST", "/admin/users/")), ShouldEqual, 400)
+		})
+
+		Convey("Change the role of a user", func() {
+			form := url.Values{}
+			form.Add("id", author.Id.Hex())
+			form.Add("role", "2")
+			So(serve(roleContext(admin, form, "PUT", "/admin/users/")), ShouldEqual, 200)
+			u := &model.User{Id: author.Id}
+			So(u.GetUserById(), ShouldBeNil)
+			So(u.Role, ShouldEqual, model.RoleEditor)
+
+			form.Set("id", owner.Id.Hex())
+			So(serve(roleContext(admin, form, "PUT", "/admin/users/")), ShouldEqual, 400)
+		})
+
+		Convey("Suspend a user", func() {
+			form := url.Values{}
+			form.Add("id", author.Id.Hex())
+			form.Add("status", model.UserStatusSuspended)
+			So(serve(roleContext(admin, form, "PUT", "/admin/users/")), ShouldEqual, 200)
+
+			Convey("A suspended user can not get to the admin panel", func() {
+				So(serve(roleContext(author, nil, "GET", "/admin/")), ShouldEqual, 302)
+			})
+
+			Convey("A suspended user can not use the API", func() {
+				So(serve(jwtContext(author, "", "GET", "/auth")), ShouldEqual, 401)
+			})
+		})
+
+		Convey("Delete a user", func() {
+			p := model.NewPost()
+			p.Slug = "author-post"
+			p.CreatedBy = author.Id.Hex()
+			p.Save()
+
+			form := url.Values{}
+			form.Add("id", author.Id.Hex())
+			form.Add("reassign", owner.Id.Hex())
+			So(serve(roleContext(admin, form, "DELETE", "/admin/users/")), ShouldEqual, 200)
+
+			So((&model.User{Id: author.Id}).GetUserById(), ShouldEqual, model.ErrNotFound)
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.CreatedBy, ShouldEqual, owner.Id.Hex())
+
+			Convey("The owner can not be deleted", func() {
+				form.Set("id", owner.Id.Hex())
+				form.Set("reassign", admin.Id.Hex())
+				So(serve(roleContext(admin, form, "DELETE", "/admin/users/")), ShouldEqual, 400)
+			})
+		})
+
+		Convey("List the users through the API", func() {
+			ctx := jwtContext(admin, "", "GET", "/api/users?limit=2")
+			So(serve(ctx), ShouldEqual, 200)
+			var resp struct {
+				Data []map[string]interface{} `json:"data"`
+			}
+			json.Unmarshal(ctx.Response.(*httptest.ResponseRecorder).Body.Bytes(), &resp)
+			So(resp.Data, ShouldHaveLength, 2)
+			So(resp.Data[0], ShouldNotContainKey, "HashedPassword")
+		})
+
+		Convey("Suspend a user through the API", func() {
+			body := `{"status": "suspended"}`
+			So(serve(jwtContext(author, body, "PUT", "/api/users/"+admin.Id.Hex())), ShouldEqual, 403)
+			So(serve(jwtContext(admin, body, "PUT", "/api/users/"+author.Id.Hex())), ShouldEqual, 200)
+			So(serve(jwtContext(admin, body, "PUT", "/api/users/"+owner.Id.Hex())), ShouldEqual, 400)
+		})
+
+		Reset(func() {
+			model.DropDatabase()
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/covrom/dingo/app/utils"
+)
+
+// An Autosave is a draft of a post saved by the editor while a user writes
+// it. It is kept apart from the post, which it never changes, until the user
+// saves the post or discards the draft.
+type Autosave struct {
+	PostId   string `json:"post_id"`
+	UserId   string `json:"user_id"`
+	Title    string `json:"title"`
+	Slug     string `json:"slug"`
+	Image    string `json:"image"`
+	Markdown string `json:"markdown"`
+	// Tags are separated by commas, as typed in the editor.
+	Tags string `json:"tags"`
+	// Version is the version of the post the draft was written from.
+	Version int64      `json:"version"`
+	SavedAt *time.Time `json:"saved_at"`
+}
+
+// NewAutosaveFromRequest returns the draft of the post by the user sent by the
+// editor.
+func NewAutosaveFromRequest(postId, userId string, r *http.Request) *Autosave {
+	a := &Autosave{
+		PostId:   postId,
+		UserId:   userId,
+		Title:    r.FormValue("title"),
+		Slug:     r.FormValue("slug"),
+		Image:    r.FormValue("image"),
+		Markdown: r.FormValue("content"),
+		Tags:     r.FormValue("tag"),
+	}
+	a.Version, _ = strconv.ParseInt(r.FormValue("version"), 10, 64)
+	return a
+}
+
+// Save replaces the draft of the user for the post.
+func (a *Autosave) Save() error {
+	a.SavedAt = utils.Now()
+	return store.UpsertAutosave(a)
+}
+
+// GetAutosave returns the draft of the post by the user, or ErrNotFound if
+// there is none.
+func GetAutosave(postId, userId string) (*Autosave, error) {
+	a := new(Autosave)
+	return a, store.GetAutosave(postId, userId, a)
+}
+
+// DeleteAutosave discards the draft of the post by the user.
+func DeleteAutosave(postId, userId string) error {
+	return store.DeleteAutosaves(postId, userId)
+}
+
+// IsNewer reports whether the draft was saved after the last update of the
+// post, so that it holds changes the post does not have.
+func (a *Autosave) IsNewer(p *Post) bool {
+	return a.SavedAt != nil && (p.UpdatedAt == nil || a.SavedAt.After(*p.UpdatedAt))
+}
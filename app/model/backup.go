@@ -0,0 +1,388 @@
+package model
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/covrom/dingo/app/utils"
+)
+
+const (
+	// backupVersion is the version of the archives written by WriteArchive. Newer
+	// archives can not be restored. Archives of version 2 add the media
+	// library, and version 3 the moderation status of the comments.
+	backupVersion = 3
+	// backupManifest is the name of the archive entry holding the version of
+	// the archive.
+	backupManifest = "backup.json"
+	// backupUploadDir is the directory of the uploaded files in the archive.
+	backupUploadDir = "upload/"
+)
+
+// A Backup is the whole content of the blog: the posts along with their
+// revisions, the comments, users, settings and dashboard messages, and the
+// uploaded files along with the media library. It is saved as a zip archive holding a JSON file for each
+// collection, and the upload directory.
+type Backup struct {
+	Version   int        `json:"version"`
+	CreatedAt *time.Time `json:"created_at"`
+
+	Posts     Posts         `json:"-"`
+	Revisions Revisions     `json:"-"`
+	Comments  Comments      `json:"-"`
+	Users     []*BackupUser `json:"-"`
+	Settings  Settings      `json:"-"`
+	Messages  Messages      `json:"-"`
+	Media     MediaItems    `json:"-"`
+
+	// files are the uploaded files of a backup read from an archive.
+	files []*zip.File
+}
+
+// A BackupUser is a user as saved in a backup, with their password and role.
+type BackupUser struct {
+	*User
+	HashedPassword string
+}
+
+// collections returns the JSON files of the archive, mapped to the
+// collections they hold.
+func (b *Backup) collections() map[string]interface{} {
+	collections := map[string]interface{}{
+		"posts.json":     &b.Posts,
+		"revisions.json": &b.Revisions,
+		"comments.json":  &b.Comments,
+		"users.json":     &b.Users,
+		"settings.json":  &b.Settings,
+		"messages.json":  &b.Messages,
+	}
+	if b.Version >= 2 {
+		collections["media.json"] = &b.Media
+	}
+	return collections
+}
+
+// NewBackup reads the content of the blog from the DB.
+func NewBackup() (*Backup, error) {
+	b := &Backup{Version: backupVersion, CreatedAt: utils.Now()}
+	if err := store.FindPosts(PostQuery{OrderBy: "created_at"}, &b.Posts); err != nil {
+		return nil, err
+	}
+	for _, p := range b.Posts {
+		var revisions Revisions
+		if err := store.FindRevisions(p.Id.Hex(), &revisions); err != nil {
+			return nil, err
+		}
+		b.Revisions = append(b.Revisions, revisions...)
+	}
+	if err := store.FindComments(CommentQuery{OrderBy: "created_at"}, &b.Comments); err != nil {
+		return nil, err
+	}
+	var users Users
+	if err := store.FindUsers(0, 0, &users); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if err := u.loadRole(); err != nil {
+			return nil, err
+		}
+		b.Users = append(b.Users, &BackupUser{User: u, HashedPassword: u.HashedPassword})
+	}
+	if err := store.FindSettings(&b.Settings); err != nil {
+		return nil, err
+	}
+	if err := store.FindMessages(&b.Messages); err != nil {
+		return nil, err
+	}
+	if err := store.FindMediaItems(MediaQuery{}, &b.Media); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// WriteArchive writes the backup as a zip archive, along with the files of the
+// given upload directory.
+func (b *Backup) WriteArchive(w io.Writer, uploadDir string) error {
+	z := zip.NewWriter(w)
+	if err := writeBackupJSON(z, backupManifest, b); err != nil {
+		return err
+	}
+	for name, collection := range b.collections() {
+		if err := writeBackupJSON(z, name, collection); err != nil {
+			return err
+		}
+	}
+	err := filepath.Walk(uploadDir, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && p == uploadDir {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(uploadDir, p)
+		if err != nil {
+			return err
+		}
+		return writeBackupFile(z, backupUploadDir+filepath.ToSlash(rel), p)
+	})
+	if err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+func writeBackupJSON(z *zip.Writer, name string, v interface{}) error {
+	w, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(v)
+}
+
+func writeBackupFile(z *zip.Writer, name, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// ReadBackup reads and checks a backup archive. The uploaded files are only
+// read when the backup is restored.
+func ReadBackup(r io.ReaderAt, size int64) (*Backup, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup archive: %v", err)
+	}
+	entries := make(map[string]*zip.File)
+	b := new(Backup)
+	for _, f := range z.File {
+		if !strings.HasPrefix(f.Name, backupUploadDir) {
+			entries[f.Name] = f
+			continue
+		}
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		if !safeBackupPath(strings.TrimPrefix(f.Name, backupUploadDir)) {
+			return nil, fmt.Errorf("invalid backup archive: unsafe file name %s", f.Name)
+		}
+		b.files = append(b.files, f)
+	}
+
+	if err := readBackupJSON(entries, backupManifest, b); err != nil {
+		return nil, err
+	}
+	if b.Version < 1 || b.Version > backupVersion {
+		return nil, fmt.Errorf("invalid backup archive: unsupported version %d", b.Version)
+	}
+	for name, collection := range b.collections() {
+		if err := readBackupJSON(entries, name, collection); err != nil {
+			return nil, err
+		}
+	}
+	if b.Version < 3 {
+		if err := b.readLegacyComments(entries); err != nil {
+			return nil, err
+		}
+	}
+	return b, b.validate()
+}
+
+// readLegacyComments sets the status of the comments of an archive made
+// before the comments had one, from their approved and spam flags.
+func (b *Backup) readLegacyComments(entries map[string]*zip.File) error {
+	var legacy []struct {
+		Approved   bool
+		Spam       bool
+		SpamReason string
+	}
+	if err := readBackupJSON(entries, "comments.json", &legacy); err != nil {
+		return err
+	}
+	for i, c := range b.Comments {
+		if i >= len(legacy) {
+			break
+		}
+		switch {
+		case legacy[i].Spam:
+			c.Status = CommentSpam
+			c.Reason = legacy[i].SpamReason
+		case legacy[i].Approved:
+			c.Status = CommentApproved
+		default:
+			c.Status = CommentPending
+		}
+	}
+	return nil
+}
+
+func readBackupJSON(entries map[string]*zip.File, name string, v interface{}) error {
+	f, ok := entries[name]
+	if !ok {
+		return fmt.Errorf("invalid backup archive: %s is missing", name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("invalid backup archive: %s: %v", name, err)
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("invalid backup archive: %s: %v", name, err)
+	}
+	return nil
+}
+
+// safeBackupPath reports whether the path of an uploaded file stays inside
+// the upload directory.
+func safeBackupPath(p string) bool {
+	return p != "" && !path.IsAbs(p) && path.Clean(p) == p && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// validate checks that every record of the backup can be saved.
+func (b *Backup) validate() error {
+	for _, p := range b.Posts {
+		if !p.Id.Valid() || p.Slug == "" {
+			return fmt.Errorf("invalid backup archive: post %q has no id or slug", p.Title)
+		}
+	}
+	for _, r := range b.Revisions {
+		if !r.Id.Valid() {
+			return fmt.Errorf("invalid backup archive: revision of post %s has no id", r.PostId)
+		}
+	}
+	for _, c := range b.Comments {
+		if !c.Id.Valid() {
+			return fmt.Errorf("invalid backup archive: comment by %s has no id", c.Author)
+		}
+	}
+	for _, u := range b.Users {
+		if u.User == nil || !u.Id.Valid() || u.Email == "" {
+			return fmt.Errorf("invalid backup archive: user has no id or email")
+		}
+	}
+	for _, s := range b.Settings {
+		if s.Key == "" {
+			return fmt.Errorf("invalid backup archive: setting has no key")
+		}
+	}
+	for _, m := range b.Messages {
+		if !m.Id.Valid() {
+			return fmt.Errorf("invalid backup archive: message has no id")
+		}
+	}
+	for _, m := range b.Media {
+		if !m.Id.Valid() || m.Key == "" {
+			return fmt.Errorf("invalid backup archive: media file has no id or key")
+		}
+	}
+	return nil
+}
+
+// Restore saves the content of the backup to the DB, and its files to the
+// given upload directory. Unless merge is set, the content of the blog is
+// deleted first, so that it is replaced by the backup. When merging, the
+// records of the backup replace the ones of the blog with the same id.
+func (b *Backup) Restore(uploadDir string, merge bool) error {
+	if !merge {
+		if err := store.DropDatabase(); err != nil {
+			return err
+		}
+		if _, err := store.Setup(); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(uploadDir); err != nil {
+			return err
+		}
+	}
+	for _, p := range b.Posts {
+		if err := store.UpsertPost(p); err != nil {
+			return err
+		}
+		if err := p.saveMediaUsage(); err != nil {
+			return err
+		}
+	}
+	for _, r := range b.Revisions {
+		// Revisions are never changed, so existing ones are kept.
+		if store.GetRevision(r.Id, new(Revision)) == nil {
+			continue
+		}
+		if err := store.InsertRevision(r); err != nil {
+			return err
+		}
+	}
+	for _, c := range b.Comments {
+		if err := store.UpsertComment(c); err != nil {
+			return err
+		}
+	}
+	// Backups made before the comments had a path have none.
+	if err := migrateCommentPaths(); err != nil {
+		return err
+	}
+	for _, u := range b.Users {
+		u.User.HashedPassword = u.HashedPassword
+		if err := store.UpsertUser(u.User); err != nil {
+			return err
+		}
+		if err := u.saveRole(); err != nil {
+			return err
+		}
+	}
+	for _, s := range b.Settings {
+		if err := store.UpsertSetting(s); err != nil {
+			return err
+		}
+	}
+	for _, m := range b.Messages {
+		if err := store.UpsertMessage(m); err != nil {
+			return err
+		}
+	}
+	for _, m := range b.Media {
+		if err := store.UpsertMediaItem(m); err != nil {
+			return err
+		}
+	}
+	for _, f := range b.files {
+		if err := restoreBackupFile(f, uploadDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restoreBackupFile(f *zip.File, uploadDir string) error {
+	p := filepath.Join(uploadDir, filepath.FromSlash(strings.TrimPrefix(f.Name, backupUploadDir)))
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
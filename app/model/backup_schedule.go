@@ -0,0 +1,143 @@
+package model
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupFilePrefix = "dingo-backup-"
+	backupFileExt    = ".zip"
+	// backupTimeFormat dates the backup archives in UTC, so that their names
+	// sort in time order.
+	backupTimeFormat = "20060102-150405"
+	// defaultBackupKeep is the number of scheduled backups kept when the
+	// "backup_keep" setting is not set.
+	defaultBackupKeep = 7
+)
+
+// FileName returns the name of the backup archive, dated with the time of
+// the backup.
+func (b *Backup) FileName() string {
+	return backupFilePrefix + b.CreatedAt.UTC().Format(backupTimeFormat) + backupFileExt
+}
+
+// BackupToDir writes a backup archive of the blog and its upload directory in
+// a new file of the given directory, and returns the path of the file.
+func BackupToDir(dir, uploadDir string) (string, error) {
+	b, err := NewBackup()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	p := filepath.Join(dir, b.FileName())
+	f, err := os.Create(p)
+	if err != nil {
+		return "", err
+	}
+	if err := b.WriteArchive(f, uploadDir); err != nil {
+		f.Close()
+		os.Remove(p)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(p)
+		return "", err
+	}
+	return p, nil
+}
+
+// listBackups returns the names of the backup archives of the directory,
+// oldest first.
+func listBackups(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasPrefix(info.Name(), backupFilePrefix) && strings.HasSuffix(info.Name(), backupFileExt) {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RotateBackups deletes the oldest backup archives of the directory, keeping
+// the newest keep ones. Zero keeps every archive.
+func RotateBackups(dir string, keep int) error {
+	names, err := listBackups(dir)
+	if err != nil || keep == 0 || len(names) <= keep {
+		return err
+	}
+	for _, name := range names[:len(names)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lastBackupTime returns the time of the newest backup archive of the
+// directory, or the zero time if there is none.
+func lastBackupTime(dir string) (time.Time, error) {
+	names, err := listBackups(dir)
+	if err != nil || len(names) == 0 {
+		return time.Time{}, err
+	}
+	last := strings.TrimSuffix(strings.TrimPrefix(names[len(names)-1], backupFilePrefix), backupFileExt)
+	t, err := time.Parse(backupTimeFormat, last)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return t, nil
+}
+
+// ScheduledBackup backs up the blog in the given directory when the newest
+// backup is older than the "backup_interval" setting, in hours, and deletes
+// the backups past the "backup_keep" setting. The outcome is left as a
+// message on the dashboard. An interval of zero disables the backups.
+func ScheduledBackup(dir, uploadDir string) {
+	hours := GetSettingInt("backup_interval", 0)
+	if hours == 0 {
+		return
+	}
+	last, err := lastBackupTime(dir)
+	if err == nil && time.Since(last) < time.Duration(hours)*time.Hour {
+		return
+	}
+	var p string
+	if err == nil {
+		p, err = BackupToDir(dir, uploadDir)
+	}
+	if err == nil {
+		err = RotateBackups(dir, GetSettingInt("backup_keep", defaultBackupKeep))
+	}
+	msg := "[1]" + p
+	if err != nil {
+		log.Printf("[Error]: Can not back up the site: %v", err)
+		msg = "[0]" + err.Error()
+	}
+	if m := NewMessage("backup", msg); m != nil {
+		m.Insert()
+	}
+}
+
+// RunBackups checks every interval whether the blog should be backed up in
+// the given directory, and never returns.
+func RunBackups(interval time.Duration, dir, uploadDir string) {
+	for range time.Tick(interval) {
+		ScheduledBackup(dir, uploadDir)
+	}
+}
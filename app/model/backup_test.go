@@ -0,0 +1,197 @@
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeZip returns a zip archive holding the given files.
+func writeZip(files map[string]string) *bytes.Reader {
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, _ := z.Create(name)
+		w.Write([]byte(content))
+	}
+	z.Close()
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestBackup(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("sqlite://:memory:", true)
+		dir, _ := ioutil.TempDir("", "dingo-backup")
+		uploadDir := filepath.Join(dir, "upload")
+		os.MkdirAll(filepath.Join(uploadDir, "2018"), os.ModePerm)
+		ioutil.WriteFile(filepath.Join(uploadDir, "2018", "image.png"), []byte("image"), 0644)
+
+		user := mockUser()
+		So(user.Create(password), ShouldBeNil)
+		p := mockPost()
+		p.CreatedBy = user.Id.Hex()
+		So(p.Save(NewTag("Go", "go")), ShouldBeNil)
+		c := mockComment(Tmp_id_1, p.Id)
+		So(c.Save(), ShouldBeNil)
+		So(NewSetting("site_url", "http://blog.example.com", "blog").Save(), ShouldBeNil)
+		So(NewMessage("comment", c).Insert(), ShouldBeNil)
+		media := &MediaItem{Id: bson.NewObjectId(), Key: "2018/image.png", Alt: "An image"}
+		So(media.Save(), ShouldBeNil)
+
+		b, err := NewBackup()
+		So(err, ShouldBeNil)
+		var buf bytes.Buffer
+		So(b.WriteArchive(&buf, uploadDir), ShouldBeNil)
+
+		Convey("Read a backup archive", func() {
+			read, err := ReadBackup(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			So(err, ShouldBeNil)
+			So(read.Version, ShouldEqual, backupVersion)
+			So(read.Posts, ShouldHaveLength, 1)
+			So(read.Revisions, ShouldHaveLength, 1)
+			So(read.Comments, ShouldHaveLength, 1)
+			So(read.Users, ShouldHaveLength, 1)
+			So(read.Users[0].HashedPassword, ShouldNotBeEmpty)
+			So(read.Messages, ShouldHaveLength, 1)
+			So(read.Media, ShouldHaveLength, 1)
+			So(read.files, ShouldHaveLength, 1)
+
+			other := mockPost()
+			other.Slug = "other"
+			So(other.Save(), ShouldBeNil)
+			p.Title = "Changed"
+			So(p.Update(), ShouldBeNil)
+			os.RemoveAll(uploadDir)
+
+			Convey("Replace the content of the blog", func() {
+				So(read.Restore(uploadDir, false), ShouldBeNil)
+				So(other.GetPostById(), ShouldEqual, ErrNotFound)
+				restored := &Post{Id: p.Id}
+				So(restored.GetPostById(), ShouldBeNil)
+				So(restored.Title, ShouldEqual, "Welcome to Dingo!")
+				So(restored.Tags, ShouldHaveLength, 1)
+				revisions, err := restored.GetRevisions()
+				So(err, ShouldBeNil)
+				So(revisions.Len(), ShouldEqual, 1)
+
+				u := &User{Email: email}
+				So(u.GetUserByEmail(), ShouldBeNil)
+				So(u.CheckPassword(password), ShouldBeTrue)
+				So(GetSettingValue("site_url"), ShouldEqual, "http://blog.example.com")
+				So((&Comment{Id: c.Id}).GetCommentById(), ShouldBeNil)
+				restoredMedia, err := GetMediaItemByKey("2018/image.png")
+				So(err, ShouldBeNil)
+				So(restoredMedia.Alt, ShouldEqual, "An image")
+
+				image, err := ioutil.ReadFile(filepath.Join(uploadDir, "2018", "image.png"))
+				So(err, ShouldBeNil)
+				So(string(image), ShouldEqual, "image")
+			})
+
+			Convey("Merge into the blog", func() {
+				So(read.Restore(uploadDir, true), ShouldBeNil)
+				So(other.GetPostById(), ShouldBeNil)
+				restored := &Post{Id: p.Id}
+				So(restored.GetPostById(), ShouldBeNil)
+				So(restored.Title, ShouldEqual, "Welcome to Dingo!")
+				_, err := os.Stat(filepath.Join(uploadDir, "2018", "image.png"))
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("Invalid archives are refused", func() {
+			_, err := ReadBackup(bytes.NewReader([]byte("not a zip")), 9)
+			So(err, ShouldNotBeNil)
+
+			r := writeZip(map[string]string{backupManifest: `{"version":1}`})
+			_, err = ReadBackup(r, r.Size())
+			So(err.Error(), ShouldContainSubstring, "is missing")
+
+			// Archives of version 1 have no media library.
+			files := map[string]string{backupManifest: `{"version":1}`}
+			for name := range (&Backup{Version: 1}).collections() {
+				files[name] = "[]"
+			}
+			r = writeZip(files)
+			old, err := ReadBackup(r, r.Size())
+			So(err, ShouldBeNil)
+			So(old.Media, ShouldBeEmpty)
+
+			// Archives of version 2 flag the comments instead of giving them a
+			// status.
+			files = map[string]string{backupManifest: `{"version":2}`}
+			for name := range (&Backup{Version: 2}).collections() {
+				files[name] = "[]"
+			}
+			files["comments.json"] = `[{"Id":"5a0000000000000000000001","Approved":true},` +
+				`{"Id":"5a0000000000000000000002","Spam":true,"SpamReason":"2 links"},{"Id":"5a0000000000000000000003"}]`
+			r = writeZip(files)
+			old, err = ReadBackup(r, r.Size())
+			So(err, ShouldBeNil)
+			So(old.Comments.Get(0).Status, ShouldEqual, CommentApproved)
+			So(old.Comments.Get(1).Status, ShouldEqual, CommentSpam)
+			So(old.Comments.Get(1).Reason, ShouldEqual, "2 links")
+			So(old.Comments.Get(2).Status, ShouldEqual, CommentPending)
+
+			r = writeZip(map[string]string{backupManifest: `{"version":99}`})
+			_, err = ReadBackup(r, r.Size())
+			So(err.Error(), ShouldContainSubstring, "unsupported version")
+
+			r = writeZip(map[string]string{backupUploadDir + "../../evil": "evil"})
+			_, err = ReadBackup(r, r.Size())
+			So(err.Error(), ShouldContainSubstring, "unsafe file name")
+		})
+
+		Convey("Scheduled backups", func() {
+			backupDir := filepath.Join(dir, "backup")
+
+			Convey("Are disabled by default", func() {
+				ScheduledBackup(backupDir, uploadDir)
+				names, err := listBackups(backupDir)
+				So(err, ShouldBeNil)
+				So(names, ShouldBeEmpty)
+			})
+
+			Convey("Write a backup once per interval", func() {
+				So(NewSetting("backup_interval", "24", "blog").Save(), ShouldBeNil)
+				ScheduledBackup(backupDir, uploadDir)
+				ScheduledBackup(backupDir, uploadDir)
+				names, err := listBackups(backupDir)
+				So(err, ShouldBeNil)
+				So(names, ShouldHaveLength, 1)
+
+				var messages Messages
+				messages.GetUnreadMessages()
+				types := make([]string, len(messages))
+				for i, m := range messages {
+					types[i] = m.Type
+				}
+				So(types, ShouldContain, "backup")
+			})
+
+			Convey("Rotate the backups", func() {
+				os.MkdirAll(backupDir, os.ModePerm)
+				for _, name := range []string{"dingo-backup-20180101-000000.zip", "dingo-backup-20180102-000000.zip", "dingo-backup-20180103-000000.zip", "other.zip"} {
+					ioutil.WriteFile(filepath.Join(backupDir, name), nil, 0644)
+				}
+				So(RotateBackups(backupDir, 2), ShouldBeNil)
+				names, err := listBackups(backupDir)
+				So(err, ShouldBeNil)
+				So(names, ShouldResemble, []string{"dingo-backup-20180102-000000.zip", "dingo-backup-20180103-000000.zip"})
+				_, err = os.Stat(filepath.Join(backupDir, "other.zip"))
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Reset(func() {
+			DropDatabase()
+			os.RemoveAll(dir)
+		})
+	})
+}
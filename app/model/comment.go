@@ -5,19 +5,39 @@ import (
 
 	"time"
 
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/covrom/dingo/app/utils"
-	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 )
 
+// The moderation states of the comments.
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentRejected = "rejected"
+	CommentSpam     = "spam"
+	CommentTrashed  = "trashed"
+)
+
+// CommentStatuses lists the moderation states of the comments.
+var CommentStatuses = []string{CommentPending, CommentApproved, CommentRejected, CommentSpam, CommentTrashed}
+
+// listedCommentStatuses are the states of the comments listed by default, the
+// spam and the trash being kept apart.
+var listedCommentStatuses = []string{CommentPending, CommentApproved, CommentRejected}
+
+// ErrEmptyComment is returned when editing a comment leaves it without text.
+var ErrEmptyComment = errors.New("the comment is empty")
+
 // Comments are a slice of "Comment"s
 type Comments []*Comment
 
 // A Comment defines comment item data.
 type Comment struct {
-	Id        bson.ObjectId
+	Id        bson.ObjectId `meddler:"Id,objectid"`
 	PostId    string
 	Author    string
 	Email     string
@@ -26,12 +46,76 @@ type Comment struct {
 	Ip        string
 	CreatedAt *time.Time
 	Content   string
-	Approved  bool
 	UserAgent string
 	Type      string
 	Parent    string
+	// Path lists the ids of the ancestors of a reply, from the top-level
+	// comment, each followed by a slash, so that a thread is selected by its
+	// prefix. It is empty for the top-level comments.
+	Path   string
+	UserId string
+	// Status is the moderation state of the comment, one of CommentStatuses.
+	// Only the approved comments are shown.
+	Status string
+	// Reason tells why the comment got its status: the filter flagging it as
+	// spam, or the note of the moderator.
+	Reason string
+	// ModeratedBy is the id of the user who last set the status, empty when
+	// the blog did, and ModeratedAt is when.
+	ModeratedBy string
+	ModeratedAt *time.Time
+	// Notify is set when the commenter wants to be emailed about replies.
+	Notify   bool
+	Children *Comments `json:"-" bson:"-" meddler:"-"`
+	// parent is the comment replied to, when loaded with its thread.
+	parent *Comment
+}
+
+// A PublicComment is what anyone may know of a comment, without the email,
+// IP address and user agent of the commenter or the notes of the moderators.
+type PublicComment struct {
+	Id        bson.ObjectId
+	PostId    string
+	Author    string
+	Avatar    string
+	Website   string
+	CreatedAt *time.Time
+	Content   string
+	Type      string
+	Parent    string
+	Path      string
 	UserId    string
-	Children  *Comments `json:"-" bson:"-"`
+	Status    string
+}
+
+// PublicComments are a slice of "PublicComment"s.
+type PublicComments []*PublicComment
+
+// Public returns the PublicComment of the comment.
+func (c *Comment) Public() *PublicComment {
+	return &PublicComment{
+		Id:        c.Id,
+		PostId:    c.PostId,
+		Author:    c.Author,
+		Avatar:    c.Avatar,
+		Website:   c.Website,
+		CreatedAt: c.CreatedAt,
+		Content:   c.Content,
+		Type:      c.Type,
+		Parent:    c.Parent,
+		Path:      c.Path,
+		UserId:    c.UserId,
+		Status:    c.Status,
+	}
+}
+
+// Public returns the PublicComments of the comments.
+func (c Comments) Public() PublicComments {
+	public := PublicComments{}
+	for _, comment := range c {
+		public = append(public, comment.Public())
+	}
+	return public
 }
 
 // Len returns the number of "Comment"s in a "Comments".
@@ -49,15 +133,99 @@ func (c Comments) GetAll() []*Comment {
 	return c
 }
 
-// NewComment returns a new comment, with the CreatedAt field set to the
-// current time.
+// NewComment returns a new comment awaiting moderation, with the CreatedAt
+// field set to the current time.
 func NewComment() *Comment {
 	return &Comment{
 		Id:        bson.NewObjectId(),
 		CreatedAt: utils.Now(),
+		Status:    CommentPending,
 	}
 }
 
+// IsApproved reports whether the comment is approved, and shown on the blog.
+func (c *Comment) IsApproved() bool {
+	return c.Status == CommentApproved
+}
+
+// IsSpam reports whether the comment was flagged as spam.
+func (c *Comment) IsSpam() bool {
+	return c.Status == CommentSpam
+}
+
+// Moderate sets the status of the comment along with its reason, records the
+// user moderating it, nil for the blog itself, and saves it.
+func (c *Comment) Moderate(status, reason string, by *User) error {
+	if !validCommentStatus(status) {
+		return fmt.Errorf("unknown comment status %q", status)
+	}
+	c.Status = status
+	c.Reason = reason
+	c.ModeratedBy = ""
+	if by != nil {
+		c.ModeratedBy = by.Id.Hex()
+	}
+	c.ModeratedAt = utils.Now()
+	return c.Save()
+}
+
+// AutoApprove approves the comment awaiting moderation if the
+// "comment_auto_approve" setting is on, and a comment with the same email was
+// approved before. It reports whether it did, the comment still having to be
+// saved.
+func (c *Comment) AutoApprove() (bool, error) {
+	if c.Status != CommentPending || c.Email == "" || GetSettingValue("comment_auto_approve") != "on" {
+		return false, nil
+	}
+	n, err := store.CountComments(CommentQuery{Email: c.Email, Status: []string{CommentApproved}})
+	if err != nil || n == 0 {
+		return false, err
+	}
+	c.Status = CommentApproved
+	c.Reason = "returning commenter"
+	return true, nil
+}
+
+// Moderator returns the user who last set the status of the comment, or nil
+// if the blog did.
+func (c *Comment) Moderator() *User {
+	if !bson.IsObjectIdHex(c.ModeratedBy) {
+		return nil
+	}
+	u := &User{Id: bson.ObjectIdHex(c.ModeratedBy)}
+	if u.GetUserById() != nil {
+		return nil
+	}
+	return u
+}
+
+func validCommentStatus(status string) bool {
+	for _, s := range CommentStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// CommentContent returns the HTML of the Markdown text of a comment, with only
+// the markup of the comment policy kept.
+func CommentContent(text string) string {
+	text = strings.TrimSpace(strings.Replace(text, "\r\n", "\n", -1))
+	return strings.TrimSpace(utils.CommentPolicy.Sanitize(utils.CommentMarkdown(text)))
+}
+
+// Edit replaces the text of the comment with the given HTML, sanitized as
+// the HTML of the comments, and saves it.
+func (c *Comment) Edit(html string) error {
+	html = strings.TrimSpace(utils.CommentPolicy.Sanitize(html))
+	if html == "" {
+		return ErrEmptyComment
+	}
+	c.Content = html
+	return c.Save()
+}
+
 // Save saves the comment in the DB.
 func (c *Comment) Save() error {
 	c.Avatar = utils.Gravatar(c.Email, "50")
@@ -65,9 +233,22 @@ func (c *Comment) Save() error {
 	if len(c.Id) == 0 {
 		c.Id = bson.NewObjectId()
 	}
-	_, err := comSession.Clone().DB(DBName).C("comments").UpsertId(c.Id, c)
-	return err
+	if c.Status == "" {
+		c.Status = CommentPending
+	}
+	if bson.IsObjectIdHex(c.Parent) && c.Path == "" {
+		parent, err := c.ParentComment()
+		if err != nil {
+			return err
+		}
+		c.Path = parent.ThreadPath()
+	}
+	return store.UpsertComment(c)
+}
 
+// ThreadPath returns the Path of the replies to the comment.
+func (c *Comment) ThreadPath() string {
+	return c.Path + c.Id.Hex() + "/"
 }
 
 // ToJson returns a comment as a map, in order to be encoded as JSON.
@@ -81,144 +262,291 @@ func (c *Comment) ToJson() map[string]interface{} {
 	m["content"] = c.Content
 	m["create_time"] = c.CreatedAt.Unix()
 	m["pid"] = c.Parent
-	m["approved"] = c.Approved
+	m["approved"] = c.IsApproved()
 	m["ip"] = c.Ip
 	m["user_agent"] = c.UserAgent
 	m["parent_content"] = c.ParentContent()
 	return m
 }
 
-// ParentContent returns the parent of a given comment, if it exists. Used for
-// threaded comments.
+// ParentContent returns the parent of a given comment, if it exists, as a
+// Markdown quote block. Used for threaded comments.
 func (c *Comment) ParentContent() string {
-	if len(c.Parent) == 0 {
+	if !bson.IsObjectIdHex(c.Parent) {
 		return ""
 	}
 
 	comment := &Comment{Id: bson.ObjectIdHex(c.Parent)}
 	err := comment.GetCommentById()
 	if err != nil {
-		return "> Comment not found."
+		return "> Comment not found.\n"
 	}
-	str := "> @" + comment.Author + "\n\n"
-	str += "> " + comment.Content + "\n"
-	return str
+	quote := "@" + comment.Author + "\n\n" + utils.Html2Markdown(comment.Content)
+	return "> " + strings.Replace(quote, "\n", "\n> ", -1) + "\n"
 }
 
-// GetNumberOfComments returns the total number of comments in the DB.
+// GetNumberOfComments returns the total number of comments in the DB, spam
+// and trash excepted.
 func GetNumberOfComments() (int64, error) {
+	return store.CountComments(CommentQuery{Status: listedCommentStatuses})
+}
 
-	count, err := comSession.Clone().DB(DBName).C("comments").Count()
+// GetNumberOfSpamComments returns the number of comments flagged as spam.
+func GetNumberOfSpamComments() (int64, error) {
+	return store.CountComments(CommentQuery{Status: []string{CommentSpam}})
+}
 
-	if err != nil {
-		return 0, err
+// CountCommentsByStatus returns the number of comments in each moderation
+// state.
+func CountCommentsByStatus() (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, status := range CommentStatuses {
+		n, err := store.CountComments(CommentQuery{Status: []string{status}})
+		if err != nil {
+			return nil, err
+		}
+		counts[status] = n
 	}
-	return int64(count), nil
+	return counts, nil
 }
 
-// GetCommentList returns a new pager based on the total number of comments.
+// GetCommentList returns a new pager based on the total number of comments,
+// spam and trash excepted.
 func (c *Comments) GetCommentList(page, size int64, onlyApproved bool) (*utils.Pager, error) {
+	if onlyApproved {
+		return c.GetCommentListByStatus(CommentApproved, page, size)
+	}
+	return c.GetCommentListByStatus("", page, size)
+}
+
+// GetSpamCommentList returns a new pager based on the number of comments
+// flagged as spam.
+func (c *Comments) GetSpamCommentList(page, size int64) (*utils.Pager, error) {
+	return c.GetCommentListByStatus(CommentSpam, page, size)
+}
+
+// GetCommentListByStatus returns a new pager based on the number of comments
+// with the given status. An empty status lists the comments neither spam nor
+// trashed.
+func (c *Comments) GetCommentListByStatus(status string, page, size int64) (*utils.Pager, error) {
+	statuses := listedCommentStatuses
+	if status != "" {
+		statuses = []string{status}
+	}
+	return c.getCommentList(CommentQuery{Status: statuses}, page, size)
+}
+
+func (c *Comments) getCommentList(q CommentQuery, page, size int64) (*utils.Pager, error) {
 	var pager *utils.Pager
 
-	count, err := GetNumberOfComments()
+	count, err := store.CountComments(q)
+	if err != nil {
+		return nil, err
+	}
 	pager = utils.NewPager(page, size, count)
 
 	if !pager.IsValid {
 		return pager, fmt.Errorf("Page not found")
 	}
 
-	if onlyApproved {
-		err = comSession.Clone().DB(DBName).C("comments").Find(bson.M{"approved": true}).Sort("-createdat").Skip(int(pager.Begin)).Limit(int(size)).All(c)
-	} else {
-		err = comSession.Clone().DB(DBName).C("comments").Find(bson.M{}).Sort("-createdat").Skip(int(pager.Begin)).Limit(int(size)).All(c)
-	}
-
-	return pager, err
+	q.OrderBy = "created_at DESC"
+	q.Offset = int(pager.Begin)
+	q.Limit = int(size)
+	return pager, store.FindComments(q, c)
 }
 
 // GetCommentById gets a comment by its ID, and populates that comment struct
 // with the contents for that comment from the DB.
 func (c *Comment) GetCommentById() error {
-
-	err := comSession.Clone().DB(DBName).C("comments").FindId(c.Id).One(c)
-
-	return err
+	return store.GetComment(c.Id, c)
 }
 
-func (c *Comment) getChildComments() (*Comments, error) {
-
+// Replies returns the approved replies to the comment, at any depth, loaded
+// in a single query. They are nested as in GetCommentThread, the comment
+// being at the first level.
+func (c *Comment) Replies(maxDepth int) (Comments, error) {
 	comments := new(Comments)
-	err := comSession.Clone().DB(DBName).C("comments").Find(bson.M{"parent": c.Id.Hex(), "approved": true}).All(comments)
-
-	return comments, err
+	err := store.FindComments(CommentQuery{PathPrefix: c.ThreadPath(), Status: []string{CommentApproved}, OrderBy: "created_at"}, comments)
+	if err != nil {
+		return nil, err
+	}
+	return threadReplies(comments.repliesByParent(), c, 2, maxDepth), nil
 }
 
-// ParentComment returns the associated parent Comment, if one exists.
+// ParentComment returns the associated parent Comment, if one exists, or
+// ErrNotFound.
 func (c *Comment) ParentComment() (*Comment, error) {
+	if c.parent != nil {
+		return c.parent, nil
+	}
+	if !bson.IsObjectIdHex(c.Parent) {
+		return nil, ErrNotFound
+	}
 	parent := NewComment()
 	parent.Id = bson.ObjectIdHex(c.Parent)
 	return parent, parent.GetCommentById()
 }
 
-// Post returns the post associated with the commment.
+// Post returns the post associated with the commment, or nil if there is
+// none.
 func (c *Comment) Post() *Post {
-	post := NewPost()
-	post.Id = bson.ObjectIdHex(c.PostId)
-	post.GetPostById()
+	if !bson.IsObjectIdHex(c.PostId) {
+		return nil
+	}
+	post := &Post{Id: bson.ObjectIdHex(c.PostId)}
+	if post.GetPostById() != nil {
+		return nil
+	}
 	return post
 }
 
-// GetCommentsByPostId gets all the comments for the given post ID.
+// GetCommentsByPostId gets the approved comments for the given post ID, the
+// top-level ones with their replies as Children.
 func (comments *Comments) GetCommentsByPostId(id string) error {
+	return comments.GetCommentThread(id, 0)
+}
 
-	err := comSession.Clone().DB(DBName).C("comments").Find(bson.M{"postid": id, "parent": "", "approved": true}).All(comments)
-
-	for _, c := range *comments {
-		buildCommentTree(c, c, 1)
+// GetCommentThread gets the approved comments for the given post ID in a
+// single query, and nests the replies as the Children of the comments they
+// answer. The replies nested deeper than maxDepth levels are shown at the
+// last level, after the comment they answer. A maxDepth of zero or less
+// nests every reply.
+func (comments *Comments) GetCommentThread(id string, maxDepth int) error {
+	all := new(Comments)
+	if err := store.FindComments(CommentQuery{PostId: id, Status: []string{CommentApproved}, OrderBy: "created_at"}, all); err != nil {
+		return err
 	}
-	return err
+	*comments = threadReplies(all.repliesByParent(), nil, 1, maxDepth)
+	return nil
+}
 
+// GetCommentPage gets at most limit approved comments for the given post ID,
+// in the order of orderBy, either "created_at" or "created_at DESC". With a
+// comment after, they are the ones following it.
+func (comments *Comments) GetCommentPage(postId, orderBy string, after *Comment, limit int) error {
+	return store.FindComments(CommentQuery{PostId: postId, Status: []string{CommentApproved}, OrderBy: orderBy, After: after, Limit: limit}, comments)
 }
 
-func buildCommentTree(p *Comment, c *Comment, level int) {
-	childComments, _ := c.getChildComments()
-	if p.Children == nil {
-		p.Children = childComments
-	} else {
-		newChildComments := append(*p.Children, *childComments...)
-		p.Children = &newChildComments
+// repliesByParent maps the ids of the comments to their replies, in order.
+// The top-level comments are mapped to an empty id.
+func (comments Comments) repliesByParent() map[string]Comments {
+	replies := make(map[string]Comments)
+	for _, c := range comments {
+		replies[c.Parent] = append(replies[c.Parent], c)
 	}
-	for _, c := range *childComments {
-		if level >= 2 {
-			buildCommentTree(p, c, level+1)
-		} else {
-			buildCommentTree(c, c, level+1)
+	return replies
+}
+
+// threadReplies returns the replies to the parent, or the top-level comments
+// for a nil parent, at the given depth of the thread. Their own replies are
+// their Children, unless the depth reached maxDepth, where they follow them
+// instead. The replies whose parent is not approved are left out.
+func threadReplies(replies map[string]Comments, parent *Comment, depth, maxDepth int) Comments {
+	id := ""
+	if parent != nil {
+		id = parent.Id.Hex()
+	}
+	list := Comments{}
+	for _, c := range replies[id] {
+		c.parent = parent
+		list = append(list, c)
+		if maxDepth > 0 && depth >= maxDepth {
+			list = append(list, threadReplies(replies, c, depth, maxDepth)...)
+			continue
+		}
+		if children := threadReplies(replies, c, depth+1, maxDepth); len(children) > 0 {
+			c.Children = &children
 		}
 	}
+	return list
 }
 
-// DeleteComment deletes the comment with the given ID from the DB.
+// DeleteComment deletes the comment with the given ID from the DB, along with
+// all the replies to it.
 func DeleteComment(id string) error {
-	session := comSession.Clone()
-
-	childs := new(Comments)
-	err := session.DB(DBName).C("comments").Find(bson.M{"parent": id}).All(childs)
-	if err == nil {
-		for _, child := range *childs {
-			if len(child.Id) > 0 {
-				DeleteComment(child.Id.Hex())
-			}
+	c := &Comment{Id: bson.ObjectIdHex(id)}
+	err := c.GetCommentById()
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	replies := new(Comments)
+	if err := store.FindComments(CommentQuery{PathPrefix: c.ThreadPath()}, replies); err != nil {
+		return err
+	}
+	for _, reply := range *replies {
+		if err := store.DeleteComment(reply.Id); err != nil && err != ErrNotFound {
+			return err
 		}
 	}
 
-	err = session.DB(DBName).C("comments").RemoveId(bson.ObjectIdHex(id))
-	if err == mgo.ErrNotFound {
+	err = store.DeleteComment(c.Id)
+	if err == ErrNotFound {
 		err = nil
 	}
-
 	return err
 }
 
+// migrateCommentPaths sets the Path of the replies saved before the comments
+// had one. The replies to deleted comments, never shown, are left as they are.
+func migrateCommentPaths() error {
+	missing := new(Comments)
+	if err := store.FindComments(CommentQuery{MissingPath: true}, missing); err != nil {
+		return err
+	}
+	for _, c := range *missing {
+		path, seen := "", map[string]bool{c.Id.Hex(): true}
+		for ancestor := c; ancestor.Parent != "" && ancestor.Path == ""; {
+			if seen[ancestor.Parent] {
+				return fmt.Errorf("comment %s is its own ancestor", ancestor.Parent)
+			}
+			seen[ancestor.Parent] = true
+			path = ancestor.Parent + "/" + path
+			parent, err := ancestor.ParentComment()
+			if err == ErrNotFound {
+				path = ""
+				break
+			}
+			if err != nil {
+				return err
+			}
+			ancestor = parent
+			path = ancestor.Path + path
+		}
+		if path == "" {
+			continue
+		}
+		c.Path = path
+		if err := store.UpsertComment(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NotSpam clears the spam flag of the comment, set back to await moderation,
+// and saves it. The user clearing it is recorded as its moderator.
+func (c *Comment) NotSpam(by *User) error {
+	return c.Moderate(CommentPending, "", by)
+}
+
+// PurgeSpamComments deletes all the comments flagged as spam.
+func PurgeSpamComments() error {
+	spam := new(Comments)
+	if err := store.FindComments(CommentQuery{Status: []string{CommentSpam}}, spam); err != nil {
+		return err
+	}
+	for _, c := range *spam {
+		if err := DeleteComment(c.Id.Hex()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ValidateComment validates a comment to ensure that all required data exists
 // and is valid. Returns an empty string on success.
 func (c *Comment) ValidateComment() string {
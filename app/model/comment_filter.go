@@ -0,0 +1,261 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommentHoneypotField is the name of a comment form field hidden from the
+// readers. Bots filling in every field of the form give themselves away.
+const CommentHoneypotField = "url"
+
+const (
+	// defaultCommentRateLimit is the number of comments an IP can post in
+	// commentRateWindow when the "comment_rate_limit" setting is not set.
+	defaultCommentRateLimit = 10
+	commentRateWindow       = time.Hour
+	// defaultCommentMaxLinks is the number of links a comment can hold when
+	// the "comment_max_links" setting is not set.
+	defaultCommentMaxLinks = 3
+	// defaultAkismetUrl is the Akismet endpoint used when the "akismet_url"
+	// setting is not set.
+	defaultAkismetUrl = "https://rest.akismet.com"
+)
+
+// A CommentFilter checks a new comment before it is saved, and returns the
+// reason why the comment is spam, or an empty string if it is not.
+type CommentFilter interface {
+	FilterComment(c *Comment, r *http.Request) (reason string, err error)
+}
+
+// CommentFilterFunc turns a function into a CommentFilter.
+type CommentFilterFunc func(c *Comment, r *http.Request) (string, error)
+
+// FilterComment calls f(c, r).
+func (f CommentFilterFunc) FilterComment(c *Comment, r *http.Request) (string, error) {
+	return f(c, r)
+}
+
+// commentFilters is the chain of filters run on each new comment, cheapest
+// first.
+var commentFilters = []CommentFilter{
+	CommentFilterFunc(filterHoneypot),
+	CommentFilterFunc(filterBlocklist),
+	CommentFilterFunc(commentRate.filter),
+	CommentFilterFunc(filterLinks),
+	CommentFilterFunc(filterAkismet),
+}
+
+// RegisterCommentFilter adds a filter at the end of the comment filter chain.
+func RegisterCommentFilter(f CommentFilter) {
+	commentFilters = append(commentFilters, f)
+}
+
+// CheckSpam runs the comment through the filter chain, and marks it as spam
+// if one of the filters flags it. Filters failing to check the comment are
+// skipped.
+func (c *Comment) CheckSpam(r *http.Request) bool {
+	for _, f := range commentFilters {
+		reason, err := f.FilterComment(c, r)
+		if err != nil {
+			log.Printf("[Error]: Can not check comment for spam: %v", err)
+			continue
+		}
+		if reason != "" {
+			c.Status = CommentSpam
+			c.Reason = reason
+			return true
+		}
+	}
+	return false
+}
+
+func filterHoneypot(c *Comment, r *http.Request) (string, error) {
+	if r.FormValue(CommentHoneypotField) != "" {
+		return "honeypot field filled in", nil
+	}
+	return "", nil
+}
+
+// filterBlocklist flags the comments matching a line of the
+// "comment_blocklist" setting. Lines holding an IP or a CIDR range are
+// matched against the IP of the commenter, and the other ones against the
+// author, email, website and content of the comment.
+func filterBlocklist(c *Comment, r *http.Request) (string, error) {
+	ip := net.ParseIP(c.Ip)
+	text := strings.ToLower(strings.Join([]string{c.Author, c.Email, c.Website, c.Content}, "\n"))
+	for _, line := range strings.Split(GetSettingValue("comment_blocklist"), "\n") {
+		entry := strings.TrimSpace(line)
+		if entry == "" {
+			continue
+		}
+		if blocked := net.ParseIP(entry); blocked != nil {
+			if blocked.Equal(ip) {
+				return "blocked IP " + entry, nil
+			}
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return "blocked IP range " + entry, nil
+			}
+			continue
+		}
+		if strings.Contains(text, strings.ToLower(entry)) {
+			return "blocked keyword " + entry, nil
+		}
+	}
+	return "", nil
+}
+
+var linkRegexp = regexp.MustCompile(`(?i)(https?://|\bwww\.)\S+`)
+
+// filterLinks flags the comments holding more links than the
+// "comment_max_links" setting allows.
+func filterLinks(c *Comment, r *http.Request) (string, error) {
+	max := GetSettingInt("comment_max_links", defaultCommentMaxLinks)
+	if n := len(linkRegexp.FindAllString(c.Content, -1)); n > max {
+		return fmt.Sprintf("%d links", n), nil
+	}
+	return "", nil
+}
+
+// commentRate limits the number of comments posted from each IP.
+var commentRate = &rateLimiter{times: make(map[string][]time.Time)}
+
+// A rateLimiter remembers when each IP commented during the last
+// commentRateWindow.
+type rateLimiter struct {
+	sync.Mutex
+	times map[string][]time.Time
+}
+
+// filter flags the comments of the IPs which commented more than the
+// "comment_rate_limit" setting allows. A limit of zero disables the filter.
+func (l *rateLimiter) filter(c *Comment, r *http.Request) (string, error) {
+	limit := GetSettingInt("comment_rate_limit", defaultCommentRateLimit)
+	if limit == 0 {
+		return "", nil
+	}
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	for ip := range l.times {
+		l.times[ip] = recentTimes(l.times[ip], now.Add(-commentRateWindow))
+		if len(l.times[ip]) == 0 {
+			delete(l.times, ip)
+		}
+	}
+	l.times[c.Ip] = append(l.times[c.Ip], now)
+	if len(l.times[c.Ip]) > limit {
+		return "too many comments from " + c.Ip, nil
+	}
+	return "", nil
+}
+
+// recentTimes returns the times, in increasing order, that are after since.
+func recentTimes(times []time.Time, since time.Time) []time.Time {
+	for i, t := range times {
+		if t.After(since) {
+			return times[i:]
+		}
+	}
+	return nil
+}
+
+// An AkismetFilter checks the comments with an Akismet compatible service.
+type AkismetFilter struct {
+	// Url is the root of the service, such as "https://rest.akismet.com".
+	Url string
+	Key string
+	// Blog is the URL of the blog.
+	Blog   string
+	Client *http.Client
+}
+
+// NewAkismetFilter returns an AkismetFilter set up from the "akismet_url",
+// "akismet_key" and "site_url" settings, or nil if no key is set.
+func NewAkismetFilter() *AkismetFilter {
+	key := GetSettingValue("akismet_key")
+	if key == "" {
+		return nil
+	}
+	u := GetSettingValue("akismet_url")
+	if u == "" {
+		u = defaultAkismetUrl
+	}
+	return &AkismetFilter{
+		Url:    strings.TrimRight(u, "/"),
+		Key:    key,
+		Blog:   GetSettingValue("site_url"),
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func filterAkismet(c *Comment, r *http.Request) (string, error) {
+	if a := NewAkismetFilter(); a != nil {
+		return a.FilterComment(c, r)
+	}
+	return "", nil
+}
+
+// FilterComment asks the service whether the comment is spam.
+func (a *AkismetFilter) FilterComment(c *Comment, r *http.Request) (string, error) {
+	form := a.form(c)
+	form.Set("referrer", r.Referer())
+	body, err := a.call("comment-check", form)
+	if err != nil {
+		return "", err
+	}
+	switch body {
+	case "true":
+		return "flagged by Akismet", nil
+	case "false":
+		return "", nil
+	}
+	return "", fmt.Errorf("unexpected Akismet answer: %q", body)
+}
+
+// SubmitHam tells the service that the comment was wrongly flagged as spam.
+func (a *AkismetFilter) SubmitHam(c *Comment) error {
+	_, err := a.call("submit-ham", a.form(c))
+	return err
+}
+
+func (a *AkismetFilter) form(c *Comment) url.Values {
+	return url.Values{
+		"api_key":              {a.Key},
+		"blog":                 {a.Blog},
+		"user_ip":              {c.Ip},
+		"user_agent":           {c.UserAgent},
+		"comment_type":         {"comment"},
+		"comment_author":       {c.Author},
+		"comment_author_email": {c.Email},
+		"comment_author_url":   {c.Website},
+		"comment_content":      {c.Content},
+	}
+}
+
+func (a *AkismetFilter) call(method string, form url.Values) (string, error) {
+	resp, err := a.Client.PostForm(a.Url+"/1.1/"+method, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Akismet answered %s", resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func commentRequest(form url.Values) *http.Request {
+	r := httptest.NewRequest("POST", "/comment/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// akismetStub answers like Akismet, flagging the comments about pills, and
+// records the comments reported as ham.
+func akismetStub(ham *url.Values) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("api_key") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/1.1/comment-check":
+			if strings.Contains(r.PostForm.Get("comment_content"), "pills") {
+				w.Write([]byte("true"))
+			} else {
+				w.Write([]byte("false"))
+			}
+		case "/1.1/submit-ham":
+			*ham = r.PostForm
+			w.Write([]byte("Thanks for making the web a better place."))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCommentFilters(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("sqlite://:memory:", true)
+		commentRate.times = make(map[string][]time.Time)
+
+		c := mockComment(Tmp_id_1, bson.NewObjectId())
+		c.Ip = "192.0.2.1"
+		c.Status = CommentPending
+		r := commentRequest(url.Values{"comment": {c.Content}})
+
+		Convey("A regular comment is not spam", func() {
+			So(c.CheckSpam(r), ShouldBeFalse)
+			So(c.IsSpam(), ShouldBeFalse)
+		})
+
+		Convey("The honeypot field catches bots", func() {
+			r := commentRequest(url.Values{CommentHoneypotField: {"http://spam.example"}})
+			So(c.CheckSpam(r), ShouldBeTrue)
+			So(c.Reason, ShouldEqual, "honeypot field filled in")
+		})
+
+		Convey("The blocklist matches words, IPs and IP ranges", func() {
+			So(NewSetting("comment_blocklist", "casino\n198.51.100.0/24\n203.0.113.9", "blog").Save(), ShouldBeNil)
+			c.Content = "Best CASINO in town"
+			So(c.CheckSpam(r), ShouldBeTrue)
+			So(c.Reason, ShouldEqual, "blocked keyword casino")
+
+			c.Content = "comment test"
+			c.Ip = "198.51.100.7"
+			So(c.CheckSpam(r), ShouldBeTrue)
+			So(c.Reason, ShouldEqual, "blocked IP range 198.51.100.0/24")
+
+			c.Ip = "203.0.113.9"
+			So(c.CheckSpam(r), ShouldBeTrue)
+			So(c.Reason, ShouldEqual, "blocked IP 203.0.113.9")
+		})
+
+		Convey("Comments with too many links are spam", func() {
+			So(NewSetting("comment_max_links", "1", "blog").Save(), ShouldBeNil)
+			c.Content = "see http://a.example and www.b.example"
+			So(c.CheckSpam(r), ShouldBeTrue)
+			So(c.Reason, ShouldEqual, "2 links")
+		})
+
+		Convey("Comments are rate limited per IP", func() {
+			So(NewSetting("comment_rate_limit", "2", "blog").Save(), ShouldBeNil)
+			So(c.CheckSpam(r), ShouldBeFalse)
+			So(c.CheckSpam(r), ShouldBeFalse)
+			So(c.CheckSpam(r), ShouldBeTrue)
+
+			other := mockComment(Tmp_id_1, bson.NewObjectId())
+			other.Ip = "192.0.2.2"
+			So(other.CheckSpam(r), ShouldBeFalse)
+		})
+
+		Convey("Comments are checked with Akismet", func() {
+			var ham url.Values
+			server := akismetStub(&ham)
+			defer server.Close()
+			So(NewSetting("akismet_url", server.URL, "blog").Save(), ShouldBeNil)
+			So(NewSetting("akismet_key", "secret", "blog").Save(), ShouldBeNil)
+
+			So(c.CheckSpam(r), ShouldBeFalse)
+			c.Content = "cheap pills"
+			So(c.CheckSpam(r), ShouldBeTrue)
+			So(c.Reason, ShouldEqual, "flagged by Akismet")
+
+			So(NewAkismetFilter().SubmitHam(c), ShouldBeNil)
+			So(ham.Get("comment_content"), ShouldEqual, "cheap pills")
+
+			Convey("Akismet errors do not block comments", func() {
+				So(NewSetting("akismet_key", "wrong", "blog").Save(), ShouldBeNil)
+				c.Status = CommentPending
+				So(c.CheckSpam(r), ShouldBeFalse)
+			})
+		})
+
+		Convey("Spam is kept apart from the other comments", func() {
+			So(c.Save(), ShouldBeNil)
+			spam := mockComment(Tmp_id_1, bson.NewObjectId())
+			spam.Status = CommentSpam
+			spam.Reason = "test"
+			So(spam.Save(), ShouldBeNil)
+
+			num, err := GetNumberOfComments()
+			So(err, ShouldBeNil)
+			So(num, ShouldEqual, 1)
+			num, err = GetNumberOfSpamComments()
+			So(err, ShouldBeNil)
+			So(num, ShouldEqual, 1)
+
+			comments := new(Comments)
+			_, err = comments.GetSpamCommentList(1, 10)
+			So(err, ShouldBeNil)
+			So(comments.Len(), ShouldEqual, 1)
+			So(comments.Get(0).Reason, ShouldEqual, "test")
+
+			Convey("Mark a comment as not spam", func() {
+				So(spam.NotSpam(nil), ShouldBeNil)
+				num, _ := GetNumberOfComments()
+				So(num, ShouldEqual, 2)
+			})
+
+			Convey("Purge the spam", func() {
+				So(PurgeSpamComments(), ShouldBeNil)
+				num, _ := GetNumberOfSpamComments()
+				So(num, ShouldEqual, 0)
+				num, _ = GetNumberOfComments()
+				So(num, ShouldEqual, 1)
+			})
+		})
+
+		Reset(func() {
+			DropDatabase()
+		})
+	})
+}
@@ -0,0 +1,189 @@
+package model
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/covrom/dingo/app/utils"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommentModeration(t *testing.T) {
+	Convey("Initialize an in-memory SQLite database", t, func() {
+		Initialize("sqlite://:memory:", true)
+		p := mockPost()
+		So(p.Save(), ShouldBeNil)
+		moderator := mockUser()
+		So(moderator.Save(), ShouldBeNil)
+
+		c := NewComment()
+		c.PostId = p.Id.Hex()
+		c.Author = "Reader"
+		c.Email = "reader@example.com"
+		c.Content = "First comment"
+		So(c.Save(), ShouldBeNil)
+
+		Convey("New comments await moderation", func() {
+			So(c.Status, ShouldEqual, CommentPending)
+			So(c.IsApproved(), ShouldBeFalse)
+			So(c.Moderator(), ShouldBeNil)
+		})
+
+		Convey("Record the moderator and the reason", func() {
+			So(c.Moderate(CommentRejected, "off topic", moderator), ShouldBeNil)
+			stored := &Comment{Id: c.Id}
+			So(stored.GetCommentById(), ShouldBeNil)
+			So(stored.Status, ShouldEqual, CommentRejected)
+			So(stored.Reason, ShouldEqual, "off topic")
+			So(stored.Moderator().Email, ShouldEqual, moderator.Email)
+			So(stored.ModeratedAt, ShouldNotBeNil)
+
+			So(c.Moderate("deleted", "", moderator), ShouldNotBeNil)
+		})
+
+		Convey("List the comments by status", func() {
+			So(c.Moderate(CommentTrashed, "", moderator), ShouldBeNil)
+			approved := mockComment(Tmp_id_1, p.Id)
+			So(approved.Save(), ShouldBeNil)
+
+			counts, err := CountCommentsByStatus()
+			So(err, ShouldBeNil)
+			So(counts[CommentTrashed], ShouldEqual, 1)
+			So(counts[CommentApproved], ShouldEqual, 1)
+			num, err := GetNumberOfComments()
+			So(err, ShouldBeNil)
+			So(num, ShouldEqual, 1)
+
+			trash := new(Comments)
+			_, err = trash.GetCommentListByStatus(CommentTrashed, 1, 10)
+			So(err, ShouldBeNil)
+			So(trash.Len(), ShouldEqual, 1)
+			So(trash.Get(0).Id, ShouldEqual, c.Id)
+		})
+
+		Convey("Approve the returning commenters", func() {
+			next := NewComment()
+			next.PostId = p.Id.Hex()
+			next.Email = c.Email
+			ok, err := next.AutoApprove()
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			So(NewSetting("comment_auto_approve", "on", "blog").Save(), ShouldBeNil)
+			ok, _ = next.AutoApprove()
+			So(ok, ShouldBeFalse)
+
+			So(c.Moderate(CommentApproved, "", moderator), ShouldBeNil)
+			ok, err = next.AutoApprove()
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(next.IsApproved(), ShouldBeTrue)
+			So(next.Reason, ShouldEqual, "returning commenter")
+
+			stranger := NewComment()
+			stranger.Email = "stranger@example.com"
+			ok, _ = stranger.AutoApprove()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Close the comments some days after publishing", func() {
+			So(p.CommentsOpen(), ShouldBeTrue)
+			p.PublishedAt = utils.Now()
+			p.CommentDays = 7
+			So(p.CommentsOpen(), ShouldBeTrue)
+			old := time.Now().AddDate(0, 0, -8)
+			p.PublishedAt = &old
+			So(p.CommentsOpen(), ShouldBeFalse)
+			p.CommentDays = 0
+			So(p.CommentsOpen(), ShouldBeTrue)
+			p.AllowComment = false
+			So(p.CommentsOpen(), ShouldBeFalse)
+		})
+	})
+
+	Convey("Set the status of the comments of an older SQLite database", t, func() {
+		dir, _ := ioutil.TempDir("", "dingo-sqlite")
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "dingo.db")
+		db, err := sql.Open("sqlite3", file)
+		So(err, ShouldBeNil)
+		schema := strings.Replace(sqliteSchema, `	Status      TEXT NOT NULL DEFAULT 'pending',
+	Reason      TEXT NOT NULL DEFAULT '',
+	ModeratedBy TEXT NOT NULL DEFAULT '',
+	ModeratedAt DATETIME,`, `	Approved    BOOLEAN NOT NULL DEFAULT 0,
+	Spam        BOOLEAN NOT NULL DEFAULT 0,
+	SpamReason  TEXT NOT NULL DEFAULT '',`, 1)
+		for _, index := range []string{"comments_status", "comments_post_status", "comments_email"} {
+			schema = strings.Replace(schema, "CREATE INDEX IF NOT EXISTS "+index, "-- ", 1)
+		}
+		_, err = db.Exec(schema)
+		So(err, ShouldBeNil)
+		_, err = db.Exec(`INSERT INTO comments (Id, Approved, Spam, SpamReason) VALUES
+			('5a0000000000000000000001', 1, 0, ''),
+			('5a0000000000000000000002', 0, 1, '2 links'),
+			('5a0000000000000000000003', 0, 0, '')`)
+		So(err, ShouldBeNil)
+		db.Close()
+
+		s, err := newSQLiteStore(file)
+		So(err, ShouldBeNil)
+		defer s.Close()
+		_, err = s.Setup()
+		So(err, ShouldBeNil)
+		expected := map[string][]string{
+			"5a0000000000000000000001": {CommentApproved, ""},
+			"5a0000000000000000000002": {CommentSpam, "2 links"},
+			"5a0000000000000000000003": {CommentPending, ""},
+		}
+		for id, status := range expected {
+			c := new(Comment)
+			So(s.GetComment(bson.ObjectIdHex(id), c), ShouldBeNil)
+			So([]string{c.Status, c.Reason}, ShouldResemble, status)
+		}
+	})
+
+	Convey("Set the status of the comments of a SQLite database older than the spam filters", t, func() {
+		dir, _ := ioutil.TempDir("", "dingo-sqlite")
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "dingo.db")
+		db, err := sql.Open("sqlite3", file)
+		So(err, ShouldBeNil)
+		schema := strings.Replace(sqliteSchema, `	Status      TEXT NOT NULL DEFAULT 'pending',
+	Reason      TEXT NOT NULL DEFAULT '',
+	ModeratedBy TEXT NOT NULL DEFAULT '',
+	ModeratedAt DATETIME,
+	Notify      BOOLEAN NOT NULL DEFAULT 0`, `	Approved    BOOLEAN NOT NULL DEFAULT 0`, 1)
+		for _, index := range []string{"comments_status", "comments_post_status", "comments_email"} {
+			schema = strings.Replace(schema, "CREATE INDEX IF NOT EXISTS "+index, "-- ", 1)
+		}
+		_, err = db.Exec(schema)
+		So(err, ShouldBeNil)
+		_, err = db.Exec(`INSERT INTO comments (Id, Approved) VALUES
+			('5a0000000000000000000001', 1),
+			('5a0000000000000000000002', 0)`)
+		So(err, ShouldBeNil)
+		db.Close()
+
+		s, err := newSQLiteStore(file)
+		So(err, ShouldBeNil)
+		defer s.Close()
+		_, err = s.Setup()
+		So(err, ShouldBeNil)
+		expected := map[string]string{
+			"5a0000000000000000000001": CommentApproved,
+			"5a0000000000000000000002": CommentPending,
+		}
+		for id, status := range expected {
+			c := new(Comment)
+			So(s.GetComment(bson.ObjectIdHex(id), c), ShouldBeNil)
+			So(c.Status, ShouldEqual, status)
+			So(c.Notify, ShouldBeFalse)
+		}
+	})
+}
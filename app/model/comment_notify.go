@@ -0,0 +1,103 @@
+package model
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/covrom/dingo/app/utils"
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrInvalidSignature is returned for unsubscribe links which were not signed
+// by the blog.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// NotifyPostAuthor emails the author of the post about the comment, if it
+// awaits moderation.
+func (c *Comment) NotifyPostAuthor() {
+	if c.Status != CommentPending {
+		return
+	}
+	post := c.Post()
+	if post == nil {
+		return
+	}
+	author := post.Author()
+	if author.Email == "" {
+		return
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s <%s> commented on \"%s\":\n\n", c.Author, c.Email, post.Title)
+	fmt.Fprintf(&b, "%s\n\n", c.text())
+	fmt.Fprintf(&b, "The comment awaits your moderation at %s/admin/comments/\n", GetSettingValue("site_url"))
+	SendMail(&Mail{
+		To:      author.Email,
+		Subject: "New comment on " + post.Title,
+		Body:    b.String(),
+	})
+}
+
+// NotifyParent emails the author of the comment replied to, if the reply is
+// approved and they asked to be notified of replies.
+func (c *Comment) NotifyParent() {
+	if !c.IsApproved() || !bson.IsObjectIdHex(c.Parent) {
+		return
+	}
+	parent, err := c.ParentComment()
+	if err != nil || !parent.Notify || parent.Email == c.Email {
+		return
+	}
+	post := c.Post()
+	if post == nil {
+		return
+	}
+	siteUrl := GetSettingValue("site_url")
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s replied to your comment on \"%s\":\n\n", c.Author, post.Title)
+	fmt.Fprintf(&b, "%s\n\n", c.text())
+	fmt.Fprintf(&b, "Read the discussion at %s%s\n\n", siteUrl, post.Url())
+	fmt.Fprintf(&b, "To stop being notified of replies to your comment, open %s%s\n", siteUrl, parent.UnsubscribeUrl())
+	SendMail(&Mail{
+		To:      parent.Email,
+		Subject: "New reply to your comment on " + post.Title,
+		Body:    b.String(),
+	})
+}
+
+// text returns the content of the comment as plain text.
+func (c *Comment) text() string {
+	return html.UnescapeString(utils.Html2Str(strings.Replace(c.Content, "<br/>", "\n", -1)))
+}
+
+// UnsubscribeUrl returns the URL, relative to the blog URL, which stops the
+// notifications of replies to the comment.
+func (c *Comment) UnsubscribeUrl() string {
+	return "/comment/" + c.Id.Hex() + "/unsubscribe/?sig=" + unsubscribeSignature(c.Id.Hex())
+}
+
+// Unsubscribe stops the notifications of replies to the comment, if the
+// signature comes from its unsubscribe URL.
+func (c *Comment) Unsubscribe(sig string) error {
+	if !hmac.Equal([]byte(sig), []byte(unsubscribeSignature(c.Id.Hex()))) {
+		return ErrInvalidSignature
+	}
+	if err := c.GetCommentById(); err != nil {
+		return err
+	}
+	c.Notify = false
+	return store.UpsertComment(c)
+}
+
+// unsubscribeSignature signs the comment ID with the private key of the blog.
+func unsubscribeSignature(id string) string {
+	mac := hmac.New(sha256.New, x509.MarshalPKCS1PrivateKey(signKey))
+	mac.Write([]byte("unsubscribe:" + id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -22,7 +22,7 @@ func mockComment(Tmp_id_1, id2 bson.ObjectId) *Comment {
 	//	c.Ip = "127.0.0.1"
 	c.UserAgent = "Mozilla"
 	c.UserId = Tmp_id_1.Hex()
-	c.Approved = true
+	c.Status = CommentApproved
 	return c
 }
 
@@ -37,7 +37,7 @@ func commentEqualCheck(c *Comment, expected *Comment) {
 	So(c.Ip, ShouldEqual, expected.Ip)
 	So(c.UserAgent, ShouldEqual, expected.UserAgent)
 	So(c.UserId, ShouldEqual, expected.UserId)
-	So(c.Approved, ShouldEqual, expected.Approved)
+	So(c.Status, ShouldEqual, expected.Status)
 }
 
 func TestComment(t *testing.T) {
@@ -0,0 +1,155 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommentThread(t *testing.T) {
+	Convey("Initialize an in-memory SQLite database", t, func() {
+		Initialize("sqlite://:memory:", true)
+		p := mockPost()
+		So(p.Save(), ShouldBeNil)
+
+		created := time.Now().Add(-time.Hour)
+		reply := func(parent *Comment, author string) *Comment {
+			c := mockComment(Tmp_id_1, p.Id)
+			c.Author = author
+			created = created.Add(time.Minute)
+			at := created
+			c.CreatedAt = &at
+			if parent != nil {
+				c.Parent = parent.Id.Hex()
+			}
+			So(c.Save(), ShouldBeNil)
+			return c
+		}
+		// a <- b <- c <- d <- e, and a <- f, g
+		a := reply(nil, "a")
+		b := reply(a, "b")
+		c := reply(b, "c")
+		d := reply(c, "d")
+		e := reply(d, "e")
+		f := reply(a, "f")
+		g := reply(nil, "g")
+
+		authors := func(comments Comments) []string {
+			names := []string{}
+			for _, c := range comments {
+				names = append(names, c.Author)
+			}
+			return names
+		}
+
+		Convey("Save the path of the ancestors", func() {
+			So(a.Path, ShouldEqual, "")
+			So(b.Path, ShouldEqual, a.Id.Hex()+"/")
+			So(e.Path, ShouldEqual, a.Id.Hex()+"/"+b.Id.Hex()+"/"+c.Id.Hex()+"/"+d.Id.Hex()+"/")
+			So(e.Path, ShouldStartWith, b.ThreadPath())
+		})
+
+		Convey("Nest every reply", func() {
+			comments := new(Comments)
+			So(comments.GetCommentsByPostId(p.Id.Hex()), ShouldBeNil)
+			So(authors(*comments), ShouldResemble, []string{"a", "g"})
+			first := comments.Get(0)
+			So(authors(*first.Children), ShouldResemble, []string{"b", "f"})
+			d := (*(*(*first.Children)[0].Children)[0].Children)[0]
+			So(authors(*d.Children), ShouldResemble, []string{"e"})
+			parent, err := d.ParentComment()
+			So(err, ShouldBeNil)
+			So(parent.Author, ShouldEqual, "c")
+			So(comments.Get(1).Children, ShouldBeNil)
+		})
+
+		Convey("Show the deepest replies at the last level", func() {
+			comments := new(Comments)
+			So(comments.GetCommentThread(p.Id.Hex(), 3), ShouldBeNil)
+			b := (*comments.Get(0).Children)[0]
+			So(authors(*b.Children), ShouldResemble, []string{"c", "d", "e"})
+			for _, c := range *b.Children {
+				So(c.Children, ShouldBeNil)
+			}
+
+			So(comments.GetCommentThread(p.Id.Hex(), 1), ShouldBeNil)
+			So(authors(*comments), ShouldResemble, []string{"a", "b", "c", "d", "e", "f", "g"})
+		})
+
+		Convey("Leave out the replies to the comments not approved", func() {
+			c.Status = CommentPending
+			So(c.Save(), ShouldBeNil)
+			comments := new(Comments)
+			So(comments.GetCommentThread(p.Id.Hex(), 2), ShouldBeNil)
+			So(authors(*comments.Get(0).Children), ShouldResemble, []string{"b", "f"})
+		})
+
+		Convey("Load the replies to a comment", func() {
+			replies, err := b.Replies(0)
+			So(err, ShouldBeNil)
+			So(authors(replies), ShouldResemble, []string{"c"})
+			So(authors(*replies[0].Children), ShouldResemble, []string{"d"})
+
+			replies, err = b.Replies(2)
+			So(err, ShouldBeNil)
+			So(authors(replies), ShouldResemble, []string{"c", "d", "e"})
+		})
+
+		Convey("Page through the comments", func() {
+			page := new(Comments)
+			So(page.GetCommentPage(p.Id.Hex(), "created_at", nil, 3), ShouldBeNil)
+			So(authors(*page), ShouldResemble, []string{"a", "b", "c"})
+			next := new(Comments)
+			So(next.GetCommentPage(p.Id.Hex(), "created_at", page.Get(2), 3), ShouldBeNil)
+			So(authors(*next), ShouldResemble, []string{"d", "e", "f"})
+
+			newest := new(Comments)
+			So(newest.GetCommentPage(p.Id.Hex(), "created_at DESC", f, 10), ShouldBeNil)
+			So(authors(*newest), ShouldResemble, []string{"e", "d", "c", "b", "a"})
+		})
+
+		Convey("Delete a comment with its replies", func() {
+			So(DeleteComment(c.Id.Hex()), ShouldBeNil)
+			for _, id := range []bson.ObjectId{c.Id, d.Id, e.Id} {
+				So(store.GetComment(id, new(Comment)), ShouldEqual, ErrNotFound)
+			}
+			for _, id := range []bson.ObjectId{a.Id, b.Id, f.Id, g.Id} {
+				So(store.GetComment(id, new(Comment)), ShouldBeNil)
+			}
+		})
+
+		Convey("Skip the parent and post ids which are not valid", func() {
+			legacy := mockComment(Tmp_id_1, p.Id)
+			legacy.Parent = "0"
+			legacy.PostId = ""
+			So(legacy.Save(), ShouldBeNil)
+			So(legacy.Path, ShouldEqual, "")
+			parent, err := legacy.ParentComment()
+			So(parent, ShouldBeNil)
+			So(err, ShouldEqual, ErrNotFound)
+			So(legacy.ParentContent(), ShouldEqual, "")
+			So(legacy.Post(), ShouldBeNil)
+			So(migrateCommentPaths(), ShouldBeNil)
+		})
+
+		Convey("Set the path of the replies saved without one", func() {
+			paths := make(map[bson.ObjectId]string)
+			for _, c := range []*Comment{b, c, d, e} {
+				paths[c.Id] = c.Path
+			}
+			for _, c := range []*Comment{b, c, d} {
+				old := *c
+				old.Path = ""
+				So(store.UpsertComment(&old), ShouldBeNil)
+			}
+			So(migrateCommentPaths(), ShouldBeNil)
+			for id, path := range paths {
+				stored := &Comment{Id: id}
+				So(stored.GetCommentById(), ShouldBeNil)
+				So(stored.Path, ShouldEqual, path)
+			}
+		})
+	})
+}
@@ -0,0 +1,343 @@
+package model
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+)
+
+const (
+	// defaultFeedSize is the number of posts of the feeds when the
+	// "feed_size" setting is not set.
+	defaultFeedSize = 20
+	// FeedFullContent is the value of the "feed_content" setting putting the
+	// whole content of the posts in the feeds, instead of their excerpt.
+	FeedFullContent = "full"
+	// FeedExcerpt is the value of the "feed_content" setting putting the
+	// excerpt of the posts in the feeds.
+	FeedExcerpt = "excerpt"
+)
+
+// A Feed is a list of the latest published posts, which can be rendered in
+// any of the FeedFormats.
+type Feed struct {
+	Title       string
+	Description string
+	// Link is the absolute URL of the page of the blog the feed is about.
+	Link string
+	// FeedLink is the absolute URL of the feed itself.
+	FeedLink string
+	// Updated is the last time a post of the feed was updated.
+	Updated time.Time
+	Items   []*FeedItem
+}
+
+// A FeedItem is a post of a feed. Content is only set when the feeds hold
+// the whole content of the posts.
+type FeedItem struct {
+	Id        string
+	Title     string
+	Link      string
+	Author    string
+	Summary   string
+	Content   string
+	Tags      []string
+	Published time.Time
+	Updated   time.Time
+}
+
+// NewFeed returns the feed of the latest published posts matching the query.
+// The number of posts and whether their whole content is shown are taken
+// from the "feed_size" and "feed_content" settings.
+func NewFeed(q PostQuery, title, description, link string) (*Feed, error) {
+	q.onlyPublic()
+	q.IsPage = boolPtr(false)
+	q.OrderBy = "published_at DESC"
+	q.Offset = 0
+	q.Limit = GetSettingInt("feed_size", defaultFeedSize)
+	var posts Posts
+	if err := store.FindPosts(q, &posts); err != nil {
+		return nil, err
+	}
+
+	f := &Feed{Title: title, Description: description, Link: link}
+	full := GetSettingValue("feed_content") == FeedFullContent
+	authors := make(map[string]string)
+	for _, p := range posts {
+		author, ok := authors[p.CreatedBy]
+		if !ok {
+			author = p.Author().Name
+			authors[p.CreatedBy] = author
+		}
+		item := &FeedItem{
+			Id:      AbsoluteUrl(p.Url()),
+			Title:   p.Title,
+			Link:    AbsoluteUrl(p.Url()),
+			Author:  author,
+			Summary: p.Excerpt(),
+		}
+		if full {
+			item.Content = p.Html
+		}
+		for _, t := range p.Tags {
+			item.Tags = append(item.Tags, t.Name)
+		}
+		if p.PublishedAt != nil {
+			item.Published = *p.PublishedAt
+		}
+		item.Updated = item.Published
+		if p.UpdatedAt != nil && p.UpdatedAt.After(item.Updated) {
+			item.Updated = *p.UpdatedAt
+		}
+		if item.Updated.After(f.Updated) {
+			f.Updated = item.Updated
+		}
+		f.Items = append(f.Items, item)
+	}
+	return f, nil
+}
+
+// SiteFeed returns the feed of the latest posts of the blog.
+func SiteFeed() (*Feed, error) {
+	return NewFeed(PostQuery{}, GetSettingValue("title"), GetSettingValue("subtitle"), AbsoluteUrl("/"))
+}
+
+// TagFeed returns the feed of the latest posts with the tag.
+func TagFeed(t Tag) (*Feed, error) {
+	return NewFeed(PostQuery{TagSlug: t.Slug}, GetSettingValue("title")+" - "+t.Name, GetSettingValue("subtitle"), AbsoluteUrl(t.Url()))
+}
+
+// AuthorFeed returns the feed of the latest posts written by the user.
+func AuthorFeed(u *User) (*Feed, error) {
+	return NewFeed(PostQuery{CreatedBy: u.Id.Hex()}, GetSettingValue("title")+" - "+u.Name, u.Bio, AbsoluteUrl("/"))
+}
+
+// A FeedFormat renders feeds in a format understood by feed readers.
+type FeedFormat struct {
+	ContentType string
+	Render      func(f *Feed) ([]byte, error)
+}
+
+// FeedFormats maps the names of the formats of the feeds, given at the end
+// of their URL, to the formats. The empty name is the default format.
+var FeedFormats = map[string]*FeedFormat{
+	"":     rssFormat,
+	"rss":  rssFormat,
+	"atom": {ContentType: "application/atom+xml; charset=utf-8", Render: renderAtom},
+	"json": {ContentType: "application/feed+json; charset=utf-8", Render: renderJSONFeed},
+}
+
+var rssFormat = &FeedFormat{ContentType: "application/rss+xml; charset=utf-8", Render: renderRSS}
+
+// feedTime formats the time of a feed, or returns an empty string for the
+// zero time so that the element is left out.
+func feedTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(layout)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        string   `xml:"guid"`
+	Description string   `xml:"description"`
+	Creator     string   `xml:"dc:creator,omitempty"`
+	Categories  []string `xml:"category"`
+	PubDate     string   `xml:"pubDate,omitempty"`
+}
+
+// renderRSS renders the feed as RSS 2.0.
+func renderRSS(f *Feed) ([]byte, error) {
+	rss := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Description,
+			Self:          atomLink{Href: f.FeedLink, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: feedTime(f.Updated, time.RFC1123Z),
+		},
+	}
+	for _, item := range f.Items {
+		description := item.Summary
+		if item.Content != "" {
+			description = item.Content
+		}
+		rss.Channel.Items = append(rss.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			Guid:        item.Id,
+			Description: description,
+			Creator:     item.Author,
+			Categories:  item.Tags,
+			PubDate:     feedTime(item.Published, time.RFC1123Z),
+		})
+	}
+	return marshalFeedXML(rss)
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Id       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	Id         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published,omitempty"`
+	Updated    string         `xml:"updated"`
+	Author     atomAuthor     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Summary    atomText       `xml:"summary"`
+	Content    *atomText      `xml:"content,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// renderAtom renders the feed as Atom 1.0.
+func renderAtom(f *Feed) ([]byte, error) {
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	atom := atomFeed{
+		Title:    f.Title,
+		Subtitle: f.Description,
+		Id:       f.FeedLink,
+		Updated:  feedTime(updated, time.RFC3339),
+		Links: []atomLink{
+			{Href: f.Link, Rel: "alternate", Type: "text/html"},
+			{Href: f.FeedLink, Rel: "self", Type: "application/atom+xml"},
+		},
+	}
+	for _, item := range f.Items {
+		entry := atomEntry{
+			Title:     item.Title,
+			Id:        item.Id,
+			Link:      atomLink{Href: item.Link, Rel: "alternate", Type: "text/html"},
+			Published: feedTime(item.Published, time.RFC3339),
+			Updated:   feedTime(item.Updated, time.RFC3339),
+			Author:    atomAuthor{Name: item.Author},
+			Summary:   atomText{Type: "text", Body: item.Summary},
+		}
+		for _, t := range item.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: t})
+		}
+		if item.Content != "" {
+			entry.Content = &atomText{Type: "html", Body: item.Content}
+		}
+		atom.Entries = append(atom.Entries, entry)
+	}
+	return marshalFeedXML(atom)
+}
+
+func marshalFeedXML(v interface{}) ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+type jsonFeed struct {
+	Version     string          `json:"version"`
+	Title       string          `json:"title"`
+	HomePageUrl string          `json:"home_page_url"`
+	FeedUrl     string          `json:"feed_url"`
+	Description string          `json:"description,omitempty"`
+	Items       []*jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	Id            string            `json:"id"`
+	Url           string            `json:"url"`
+	Title         string            `json:"title"`
+	ContentHtml   string            `json:"content_html,omitempty"`
+	ContentText   string            `json:"content_text,omitempty"`
+	Summary       string            `json:"summary,omitempty"`
+	DatePublished string            `json:"date_published,omitempty"`
+	DateModified  string            `json:"date_modified,omitempty"`
+	Authors       []*jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// renderJSONFeed renders the feed as JSON Feed 1.1.
+func renderJSONFeed(f *Feed) ([]byte, error) {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageUrl: f.Link,
+		FeedUrl:     f.FeedLink,
+		Description: f.Description,
+		Items:       []*jsonFeedItem{},
+	}
+	for _, item := range f.Items {
+		i := &jsonFeedItem{
+			Id:            item.Id,
+			Url:           item.Link,
+			Title:         item.Title,
+			Summary:       item.Summary,
+			DatePublished: feedTime(item.Published, time.RFC3339),
+			DateModified:  feedTime(item.Updated, time.RFC3339),
+			Tags:          item.Tags,
+		}
+		if item.Content != "" {
+			i.ContentHtml = item.Content
+		} else {
+			i.ContentText = item.Summary
+		}
+		if item.Author != "" {
+			i.Authors = []*jsonFeedAuthor{{Name: item.Author}}
+		}
+		feed.Items = append(feed.Items, i)
+	}
+	return json.MarshalIndent(feed, "", "  ")
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFeed(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("sqlite://:memory:", true)
+		So(NewSetting("site_url", "http://blog.example.com/", "blog").Save(), ShouldBeNil)
+		So(NewSetting("title", "My Blog", "blog").Save(), ShouldBeNil)
+
+		author := NewUser("author@example.com", "Author")
+		So(author.Create("password"), ShouldBeNil)
+		p := mockPost()
+		p.CreatedBy = author.Id.Hex()
+		So(p.Save(NewTag("Go", "go")), ShouldBeNil)
+		draft := mockPost()
+		draft.Slug = "draft"
+		draft.IsPublished = false
+		So(draft.Save(), ShouldBeNil)
+
+		Convey("The feed holds the published posts with absolute links", func() {
+			f, err := SiteFeed()
+			So(err, ShouldBeNil)
+			So(f.Title, ShouldEqual, "My Blog")
+			So(f.Link, ShouldEqual, "http://blog.example.com/")
+			So(f.Items, ShouldHaveLength, 1)
+			So(f.Items[0].Link, ShouldEqual, "http://blog.example.com/welcome-to-dingo")
+			So(f.Items[0].Author, ShouldEqual, "Author")
+			So(f.Items[0].Tags, ShouldResemble, []string{"Go"})
+			So(f.Items[0].Published.Equal(*p.PublishedAt), ShouldBeTrue)
+			So(f.Items[0].Content, ShouldBeEmpty)
+			So(f.Updated.IsZero(), ShouldBeFalse)
+		})
+
+		Convey("The whole content is shown in full mode", func() {
+			So(NewSetting("feed_content", FeedFullContent, "blog").Save(), ShouldBeNil)
+			f, err := SiteFeed()
+			So(err, ShouldBeNil)
+			So(f.Items[0].Content, ShouldEqual, p.Html)
+		})
+
+		Convey("Feeds of a tag and an author", func() {
+			f, err := TagFeed(NewTag("Go", "go"))
+			So(err, ShouldBeNil)
+			So(f.Items, ShouldHaveLength, 1)
+			So(f.Link, ShouldEqual, "http://blog.example.com/tag/go")
+			f, err = TagFeed(NewTag("Rust", "rust"))
+			So(err, ShouldBeNil)
+			So(f.Items, ShouldBeEmpty)
+
+			f, err = AuthorFeed(author)
+			So(err, ShouldBeNil)
+			So(f.Items, ShouldHaveLength, 1)
+			f, err = AuthorFeed(&User{Id: Tmp_id_2})
+			So(err, ShouldBeNil)
+			So(f.Items, ShouldBeEmpty)
+		})
+
+		Convey("Render the feed", func() {
+			f, err := SiteFeed()
+			So(err, ShouldBeNil)
+			f.FeedLink = "http://blog.example.com/feed/"
+
+			Convey("As RSS 2.0", func() {
+				b, err := FeedFormats["rss"].Render(f)
+				So(err, ShouldBeNil)
+				var rss struct {
+					Version string `xml:"version,attr"`
+					Items   []struct {
+						Link    string `xml:"link"`
+						PubDate string `xml:"pubDate"`
+					} `xml:"channel>item"`
+				}
+				So(xml.Unmarshal(b, &rss), ShouldBeNil)
+				So(rss.Version, ShouldEqual, "2.0")
+				So(rss.Items, ShouldHaveLength, 1)
+				So(rss.Items[0].Link, ShouldEqual, "http://blog.example.com/welcome-to-dingo")
+				So(rss.Items[0].PubDate, ShouldNotBeEmpty)
+				So(FeedFormats[""], ShouldEqual, FeedFormats["rss"])
+			})
+
+			Convey("As Atom 1.0", func() {
+				b, err := FeedFormats["atom"].Render(f)
+				So(err, ShouldBeNil)
+				var atom struct {
+					XMLName xml.Name
+					Id      string `xml:"id"`
+					Entries []struct {
+						Title string `xml:"title"`
+					} `xml:"entry"`
+				}
+				So(xml.Unmarshal(b, &atom), ShouldBeNil)
+				So(atom.XMLName.Space, ShouldEqual, "http://www.w3.org/2005/Atom")
+				So(atom.Id, ShouldEqual, "http://blog.example.com/feed/")
+				So(atom.Entries, ShouldHaveLength, 1)
+				So(atom.Entries[0].Title, ShouldEqual, p.Title)
+			})
+
+			Convey("As JSON Feed 1.1", func() {
+				b, err := FeedFormats["json"].Render(f)
+				So(err, ShouldBeNil)
+				var feed struct {
+					Version string `json:"version"`
+					FeedUrl string `json:"feed_url"`
+					Items   []struct {
+						Url         string `json:"url"`
+						ContentText string `json:"content_text"`
+					} `json:"items"`
+				}
+				So(json.Unmarshal(b, &feed), ShouldBeNil)
+				So(feed.Version, ShouldEqual, "https://jsonfeed.org/version/1.1")
+				So(feed.FeedUrl, ShouldEqual, "http://blog.example.com/feed/")
+				So(feed.Items, ShouldHaveLength, 1)
+				So(feed.Items[0].ContentText, ShouldNotBeEmpty)
+			})
+		})
+
+		Reset(func() {
+			DropDatabase()
+		})
+	})
+}
@@ -1,54 +1,188 @@
 package model
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
-// A File is a file, along with a URL and last modified time.
+// A File is a file or directory of a MediaStore, along with its public URL
+// and last modified time. The keys of the directories end with a slash.
 type File struct {
-	os.FileInfo
+	Name    string
+	Key     string
 	Url     string
+	Size    int64
+	IsDir   bool
 	ModTime *time.Time
 }
 
-// CheckSafe checks if the directory is a child directory of base, to make sure
-// that GetFileList won't read any folder other than the upload folder.
-func CheckSafe(directory string, base string) bool {
-	directory = path.Clean(directory)
-	dirs := strings.Split(directory, "/")
-	return dirs[0] == base
+// A MediaStore stores the uploaded files under keys, which are slash
+// separated paths relative to the root of the store.
+type MediaStore interface {
+	// Put saves the content under the key, replacing any previous file.
+	Put(key string, r io.Reader, contentType string) error
+	// Delete removes the file with the key, or everything under it if the
+	// key is a directory.
+	Delete(key string) error
+	// List returns the files and directories directly under the directory.
+	List(dir string) ([]*File, error)
+	// Exists reports whether there is a file with the key.
+	Exists(key string) (bool, error)
+	// Url returns the public URL of the file with the key.
+	Url(key string) string
 }
 
-// GetFileList returns a slice of all Files in the given directory.
-func GetFileList(directory string) []*File {
+// mediaStore is the MediaStore of the uploaded files.
+var mediaStore MediaStore = &LocalMediaStore{Dir: "upload", BaseUrl: "/upload/"}
+
+// Media returns the MediaStore of the uploaded files.
+func Media() MediaStore {
+	return mediaStore
+}
+
+// InitializeMedia sets the MediaStore of the uploaded files from its URL,
+// either empty for the local uploadDir directory, or s3://bucket/prefix for
+// an S3 compatible object store.
+func InitializeMedia(rawurl, uploadDir string) error {
+	s, err := NewMediaStore(rawurl, uploadDir)
+	if err != nil {
+		return err
+	}
+	mediaStore = s
+	return nil
+}
+
+// NewMediaStore returns the MediaStore of the URL, either empty or "local"
+// for the local uploadDir directory, or s3://bucket/prefix for an S3
+// compatible object store, configured by the query parameters of the URL
+// and the credentials of the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// environment variables.
+func NewMediaStore(rawurl, uploadDir string) (MediaStore, error) {
+	if rawurl == "" || rawurl == "local" {
+		return &LocalMediaStore{Dir: uploadDir, BaseUrl: "/upload/"}, nil
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" {
+		return nil, fmt.Errorf("unknown media store %s", rawurl)
+	}
+	return NewS3MediaStore(u)
+}
+
+// CleanMediaKey returns the key relative to the root of a MediaStore, so
+// that it can not refer to anything outside of the store. The empty key is
+// the root.
+func CleanMediaKey(key string) string {
+	clean := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if clean != "" && strings.HasSuffix(key, "/") {
+		clean += "/"
+	}
+	return clean
+}
+
+// A LocalMediaStore stores the files in a directory, served by the blog
+// under BaseUrl.
+type LocalMediaStore struct {
+	Dir     string
+	BaseUrl string
+}
+
+func (s *LocalMediaStore) file(key string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(CleanMediaKey(key)))
+}
+
+func (s *LocalMediaStore) Put(key string, r io.Reader, contentType string) error {
+	p := s.file(key)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *LocalMediaStore) Delete(key string) error {
+	if CleanMediaKey(key) == "" {
+		return fmt.Errorf("can not delete the root of the media store")
+	}
+	return os.RemoveAll(s.file(key))
+}
+
+func (s *LocalMediaStore) List(dir string) ([]*File, error) {
+	dir = CleanMediaKey(dir)
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	fileInfoList, err := ioutil.ReadDir(s.file(dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 	files := make([]*File, 0)
-	fileInfoList, _ := ioutil.ReadDir(directory)
 	for i := len(fileInfoList) - 1; i >= 0; i-- {
-		if fileInfoList[i].Name() == ".DS_Store" {
+		info := fileInfoList[i]
+		if info.Name() == ".DS_Store" {
 			continue
 		}
-		file := new(File)
-		file.FileInfo = fileInfoList[i]
-		file.Url = path.Join(directory, fileInfoList[i].Name())
-		t := fileInfoList[i].ModTime()
-		file.ModTime = &t
+		t := info.ModTime()
+		file := &File{
+			Name:    info.Name(),
+			Key:     dir + info.Name(),
+			Size:    info.Size(),
+			IsDir:   info.IsDir(),
+			ModTime: &t,
+		}
+		if file.IsDir {
+			file.Key += "/"
+		}
+		file.Url = s.Url(file.Key)
 		files = append(files, file)
 	}
-	return files
+	return files, nil
 }
 
-// RemoveFile removes a file with the given path.
-func RemoveFile(path string) error {
-	return os.RemoveAll(path)
+func (s *LocalMediaStore) Exists(key string) (bool, error) {
+	_, err := os.Stat(s.file(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
 }
 
-// CreateFilePath creates a filepath from the given directory and name,
-// returning the name of the newly created filepath.
-func CreateFilePath(dir string, name string) string {
-	os.MkdirAll(dir, os.ModePerm)
-	return path.Join(dir, name)
+func (s *LocalMediaStore) Url(key string) string {
+	return s.BaseUrl + CleanMediaKey(key)
+}
+
+// Walk calls fn with the key of every file of the store.
+func (s *LocalMediaStore) Walk(fn func(key, file string) error) error {
+	return filepath.Walk(s.Dir, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && p == s.Dir {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(s.Dir, p)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), p)
+	})
 }
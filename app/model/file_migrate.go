@@ -0,0 +1,96 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/covrom/dingo/app/utils"
+)
+
+// A MediaMigrationReport tells what a media migration moved.
+type MediaMigrationReport struct {
+	Files []string
+	// Posts are the slugs of the posts and pages whose references to the
+	// files were rewritten.
+	Posts []string
+}
+
+// String returns the report as text.
+func (r *MediaMigrationReport) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Files moved: %d\n", len(r.Files))
+	fmt.Fprintf(&b, "Posts rewritten: %d\n", len(r.Posts))
+	for _, slug := range r.Posts {
+		fmt.Fprintf(&b, "  /%s\n", slug)
+	}
+	return b.String()
+}
+
+// MigrateMedia copies the files of the local store to the other store, and
+// rewrites the references to them in the markdown and images of the posts
+// and pages. The local files are removed once the posts are rewritten,
+// unless keep is set.
+func MigrateMedia(from *LocalMediaStore, to MediaStore, keep bool) (*MediaMigrationReport, error) {
+	report := new(MediaMigrationReport)
+	var files []string
+	err := from.Walk(func(key, file string) error {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := to.Put(key, f, mime.TypeByExtension(path.Ext(key))); err != nil {
+			return err
+		}
+		report.Files = append(report.Files, key)
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// The absolute URLs are replaced along with the site URL in front of
+	// them. The keys are walked in lexical order, so going backwards replaces
+	// the keys before those they start with.
+	var pairs []string
+	for i := len(report.Files) - 1; i >= 0; i-- {
+		key := report.Files[i]
+		pairs = append(pairs, AbsoluteUrl(from.Url(key)), to.Url(key), from.Url(key), to.Url(key))
+	}
+	replacer := strings.NewReplacer(pairs...)
+	for _, isPage := range []bool{false, true} {
+		posts := new(Posts)
+		if err := posts.GetAllPostList(isPage, false, "created_at"); err != nil {
+			return report, err
+		}
+		for _, p := range *posts {
+			markdown, image := replacer.Replace(p.Markdown), replacer.Replace(p.Image)
+			if markdown == p.Markdown && image == p.Image {
+				continue
+			}
+			p.Markdown = markdown
+			p.Html = RenderMarkdown(markdown)
+			p.sanitizeHtml()
+			p.Image = image
+			p.UpdatedAt = utils.Now()
+			if err := p.Update(); err != nil {
+				return report, err
+			}
+			report.Posts = append(report.Posts, p.Slug)
+		}
+	}
+
+	if !keep {
+		for _, file := range files {
+			if err := os.Remove(file); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
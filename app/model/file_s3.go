@@ -0,0 +1,374 @@
+package model
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Timeout bounds each request to the S3 API, uploads included.
+const s3Timeout = 5 * time.Minute
+
+// An S3MediaStore stores the files in a bucket of Amazon S3, or of any object
+// store with an S3 compatible API like MinIO.
+type S3MediaStore struct {
+	// Endpoint is the URL of the API, like https://s3.amazonaws.com.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the keys of the files in the bucket.
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// PathStyle puts the bucket in the path of the URLs rather than in their
+	// host name, as most S3 compatible stores need.
+	PathStyle bool
+	// PublicUrl is the base URL the files are served from, like the URL of a
+	// CDN. The files are served by the endpoint if it is empty.
+	PublicUrl string
+	Client    *http.Client
+}
+
+// NewS3MediaStore returns the S3MediaStore of a s3://bucket/prefix URL. Its
+// endpoint, region, path_style and public_url query parameters set the
+// fields of the store, and its credentials are read from the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.
+func NewS3MediaStore(u *url.URL) (*S3MediaStore, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("no bucket in media store %s", u)
+	}
+	q := u.Query()
+	s := &S3MediaStore{
+		Endpoint:  strings.TrimRight(q.Get("endpoint"), "/"),
+		Region:    q.Get("region"),
+		Bucket:    u.Host,
+		Prefix:    strings.Trim(u.Path, "/"),
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		PathStyle: q.Get("path_style") == "true" || q.Get("path_style") == "1",
+		PublicUrl: q.Get("public_url"),
+		Client:    &http.Client{Timeout: s3Timeout},
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	}
+	if s.Prefix != "" {
+		s.Prefix += "/"
+	}
+	if e, err := url.Parse(s.Endpoint); err != nil || e.Scheme == "" || e.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %s of media store %s", s.Endpoint, u)
+	}
+	return s, nil
+}
+
+// bucketUrl returns the URL of the object of the bucket with the key, or of
+// the bucket itself if the key is empty.
+func (s *S3MediaStore) bucketUrl(key string) string {
+	if s.PathStyle {
+		return s.Endpoint + "/" + s.Bucket + "/" + awsEscapePath(key)
+	}
+	u, _ := url.Parse(s.Endpoint)
+	return u.Scheme + "://" + s.Bucket + "." + u.Host + "/" + awsEscapePath(key)
+}
+
+func (s *S3MediaStore) Put(key string, r io.Reader, contentType string) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do("PUT", s.Prefix+CleanMediaKey(key), nil, b, header)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3MediaStore) Delete(key string) error {
+	key = CleanMediaKey(key)
+	if key == "" {
+		return fmt.Errorf("can not delete the root of the media store")
+	}
+	keys := []string{s.Prefix + key}
+	if strings.HasSuffix(key, "/") {
+		objects, _, err := s.list(s.Prefix+key, "")
+		if err != nil {
+			return err
+		}
+		keys = keys[:0]
+		for _, o := range objects {
+			keys = append(keys, o.Key)
+		}
+	}
+	for _, k := range keys {
+		resp, err := s.do("DELETE", k, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+func (s *S3MediaStore) List(dir string) ([]*File, error) {
+	dir = CleanMediaKey(dir)
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	objects, prefixes, err := s.list(s.Prefix+dir, "/")
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*File, 0)
+	for _, p := range prefixes {
+		key := strings.TrimPrefix(p, s.Prefix)
+		files = append(files, &File{
+			Name:  path.Base(key),
+			Key:   key,
+			Url:   s.Url(key),
+			IsDir: true,
+		})
+	}
+	for _, o := range objects {
+		key := strings.TrimPrefix(o.Key, s.Prefix)
+		// Some tools create empty objects standing for the directories.
+		if key == dir {
+			continue
+		}
+		modTime := o.LastModified
+		files = append(files, &File{
+			Name:    path.Base(key),
+			Key:     key,
+			Url:     s.Url(key),
+			Size:    o.Size,
+			ModTime: &modTime,
+		})
+	}
+	return files, nil
+}
+
+func (s *S3MediaStore) Exists(key string) (bool, error) {
+	key = s.Prefix + CleanMediaKey(key)
+	objects, _, err := s.list(key, "/")
+	if err != nil {
+		return false, err
+	}
+	for _, o := range objects {
+		if o.Key == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *S3MediaStore) Url(key string) string {
+	key = s.Prefix + CleanMediaKey(key)
+	if s.PublicUrl != "" {
+		return strings.TrimRight(s.PublicUrl, "/") + "/" + awsEscapePath(key)
+	}
+	return s.bucketUrl(key)
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type s3ListResult struct {
+	Contents       []s3Object `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// list returns the objects of the bucket whose key starts with the prefix,
+// and the common prefixes of the others up to the delimiter, if any.
+func (s *S3MediaStore) list(prefix, delimiter string) ([]s3Object, []string, error) {
+	var (
+		objects  []s3Object
+		prefixes []string
+		token    string
+	)
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			q.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := s.do("GET", "", q, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid S3 listing: %v", err)
+		}
+		objects = append(objects, result.Contents...)
+		for _, p := range result.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, prefixes, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do sends a signed request for the object with the key, or for the bucket
+// if the key is empty. The errors of the API are returned as errors.
+func (s *S3MediaStore) do(method, key string, q url.Values, body []byte, header http.Header) (*http.Response, error) {
+	u := s.bucketUrl(key)
+	if len(q) > 0 {
+		u += "?" + awsEscapeQuery(q)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", time.Now().UTC().Format(awsDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	signV4(req, hex.EncodeToString(payloadHash[:]), s.AccessKey, s.SecretKey, s.Region, "s3")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		xml.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Code == "" {
+			apiErr.Code = resp.Status
+		}
+		return nil, fmt.Errorf("S3 %s %s failed: %s %s", method, key, apiErr.Code, apiErr.Message)
+	}
+	return resp, nil
+}
+
+// awsDateFormat is the format of the dates of the AWS signatures.
+const awsDateFormat = "20060102T150405Z"
+
+// signV4 signs the request with the AWS Signature Version 4, from its host,
+// its X-Amz-* headers, including its X-Amz-Date, and the SHA-256 hash of its
+// payload.
+func signV4(req *http.Request, payloadHash, accessKey, secretKey, region, service string) {
+	amzDate := req.Header.Get("X-Amz-Date")
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalUri := awsEscapePath(req.URL.Path)
+	if canonicalUri == "" {
+		canonicalUri = "/"
+	}
+	req.URL.RawPath = canonicalUri
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalUri,
+		awsEscapeQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSha256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSha256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// awsEscape escapes everything but the unreserved characters, as the AWS
+// signatures need.
+func awsEscape(s string, keepSlash bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && keepSlash {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func awsEscapePath(p string) string {
+	return awsEscape(p, true)
+}
+
+// awsEscapeQuery returns the query sorted by key, as the AWS signatures need.
+func awsEscapeQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,227 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeS3 is an in memory stand-in of the S3 API of a bucket, served with
+// path style URLs.
+type fakeS3 struct {
+	bucket  string
+	objects map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") ||
+		r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>"))
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+s.bucket+"/")
+	switch {
+	case r.Method == "PUT":
+		s.objects[key] = body
+	case r.Method == "DELETE":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "GET" && key == "":
+		prefix, delimiter := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+		var result s3ListResult
+		prefixes := make(map[string]bool)
+		var keys []string
+		for k := range s.objects {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if !strings.HasPrefix(k, prefix) {
+				continue
+			}
+			if i := strings.Index(k[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+				p := k[:len(prefix)+i+1]
+				if !prefixes[p] {
+					prefixes[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, struct {
+						Prefix string `xml:"Prefix"`
+					}{p})
+				}
+				continue
+			}
+			result.Contents = append(result.Contents, s3Object{Key: k, Size: int64(len(s.objects[k])), LastModified: time.Now()})
+		}
+		xml.NewEncoder(w).Encode(struct {
+			XMLName xml.Name `xml:"ListBucketResult"`
+			s3ListResult
+		}{s3ListResult: result})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+	}
+}
+
+func newFakeS3MediaStore() (*S3MediaStore, *fakeS3, func()) {
+	fake := &fakeS3{bucket: "media", objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	os.Setenv("AWS_ACCESS_KEY_ID", "key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	u, _ := url.Parse("s3://media/blog?path_style=true&endpoint=" + url.QueryEscape(server.URL))
+	s, _ := NewS3MediaStore(u)
+	return s, fake, server.Close
+}
+
+func TestMediaStore(t *testing.T) {
+	Convey("Clean media keys", t, func() {
+		So(CleanMediaKey(""), ShouldEqual, "")
+		So(CleanMediaKey("/"), ShouldEqual, "")
+		So(CleanMediaKey("../../etc/passwd"), ShouldEqual, "etc/passwd")
+		So(CleanMediaKey("2018/a.png"), ShouldEqual, "2018/a.png")
+		So(CleanMediaKey("2018/../2019/"), ShouldEqual, "2019/")
+	})
+
+	Convey("Sign requests with the AWS Signature Version 4", t, func() {
+		// The get-vanilla case of the AWS test suite.
+		req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+		req.Header.Set("X-Amz-Date", "20150830T123600Z")
+		sum := sha256.Sum256(nil)
+		signV4(req, hex.EncodeToString(sum[:]), "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service")
+		So(req.Header.Get("Authorization"), ShouldEqual, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+			"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31")
+	})
+
+	Convey("Parse media store URLs", t, func() {
+		s, err := NewMediaStore("", "upload")
+		So(err, ShouldBeNil)
+		So(s.Url("a.png"), ShouldEqual, "/upload/a.png")
+		s, err = NewMediaStore("s3://media/blog?region=eu-west-1", "upload")
+		So(err, ShouldBeNil)
+		So(s.Url("a b.png"), ShouldEqual, "https://media.s3.eu-west-1.amazonaws.com/blog/a%20b.png")
+		So(s.(*S3MediaStore).Client.Timeout, ShouldEqual, s3Timeout)
+		s, err = NewMediaStore("s3://media?public_url=https://cdn.example.com/", "upload")
+		So(err, ShouldBeNil)
+		So(s.Url("a.png"), ShouldEqual, "https://cdn.example.com/a.png")
+		_, err = NewMediaStore("ftp://media", "upload")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Store files on the local disk", t, func() {
+		dir, _ := ioutil.TempDir("", "dingo-media")
+		s := &LocalMediaStore{Dir: dir, BaseUrl: "/upload/"}
+		So(s.Put("2018/a.png", strings.NewReader("image"), "image/png"), ShouldBeNil)
+		So(s.Put("b.txt", strings.NewReader("text"), "text/plain"), ShouldBeNil)
+
+		files, err := s.List("")
+		So(err, ShouldBeNil)
+		So(files, ShouldHaveLength, 2)
+		So(files[0].Key, ShouldEqual, "b.txt")
+		So(files[0].Url, ShouldEqual, "/upload/b.txt")
+		So(files[1].Key, ShouldEqual, "2018/")
+		So(files[1].IsDir, ShouldBeTrue)
+
+		files, err = s.List("2018/")
+		So(err, ShouldBeNil)
+		So(files, ShouldHaveLength, 1)
+		So(files[0].Size, ShouldEqual, 5)
+
+		exists, err := s.Exists("2018/a.png")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+		exists, err = s.Exists("2018/b.png")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+
+		So(s.Delete(""), ShouldNotBeNil)
+		So(s.Delete("2018/"), ShouldBeNil)
+		_, err = os.Stat(filepath.Join(dir, "2018"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+	})
+
+	Convey("Store files in an S3 bucket", t, func() {
+		s, fake, closeServer := newFakeS3MediaStore()
+		So(s.Put("2018/a.png", strings.NewReader("image"), "image/png"), ShouldBeNil)
+		So(s.Put("b.txt", strings.NewReader("text"), "text/plain"), ShouldBeNil)
+		So(string(fake.objects["blog/2018/a.png"]), ShouldEqual, "image")
+
+		files, err := s.List("")
+		So(err, ShouldBeNil)
+		So(files, ShouldHaveLength, 2)
+		So(files[0].Key, ShouldEqual, "2018/")
+		So(files[0].IsDir, ShouldBeTrue)
+		So(files[1].Key, ShouldEqual, "b.txt")
+		So(files[1].Size, ShouldEqual, 4)
+		So(files[1].Url, ShouldEqual, s.Endpoint+"/media/blog/b.txt")
+
+		exists, err := s.Exists("2018/a.png")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+		exists, err = s.Exists("2018/a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+
+		So(s.Delete("2018/"), ShouldBeNil)
+		So(fake.objects, ShouldHaveLength, 1)
+
+		s.SecretKey = ""
+		s.AccessKey = "wrong"
+		So(s.Put("c.txt", strings.NewReader("text"), ""), ShouldNotBeNil)
+
+		Reset(closeServer)
+	})
+
+	Convey("Migrate the local files to an S3 bucket", t, func() {
+		Initialize("sqlite://:memory:", true)
+		So(NewSetting("site_url", "http://blog.example.com", "blog").Save(), ShouldBeNil)
+		dir, _ := ioutil.TempDir("", "dingo-media")
+		local := &LocalMediaStore{Dir: dir, BaseUrl: "/upload/"}
+		local.Put("a.png", strings.NewReader("image"), "")
+		local.Put("a.png.bak", strings.NewReader("backup"), "")
+		s, fake, closeServer := newFakeS3MediaStore()
+
+		p := mockPost()
+		p.Markdown = "![](/upload/a.png) ![](http://blog.example.com/upload/a.png.bak)"
+		p.Image = "/upload/a.png"
+		So(p.Save(), ShouldBeNil)
+		other := mockPost()
+		other.Slug = "other"
+		So(other.Save(), ShouldBeNil)
+
+		report, err := MigrateMedia(local, s, false)
+		So(err, ShouldBeNil)
+		So(report.Files, ShouldResemble, []string{"a.png", "a.png.bak"})
+		So(report.Posts, ShouldResemble, []string{p.Slug})
+		So(fake.objects, ShouldHaveLength, 2)
+
+		migrated := new(Post)
+		So(migrated.GetPostBySlug(p.Slug), ShouldBeNil)
+		So(migrated.Markdown, ShouldEqual, "![]("+s.Url("a.png")+") ![]("+s.Url("a.png.bak")+")")
+		So(migrated.Html, ShouldContainSubstring, s.Url("a.png"))
+		So(migrated.Image, ShouldEqual, s.Url("a.png"))
+		files, _ := local.List("")
+		So(files, ShouldBeEmpty)
+
+		Reset(func() {
+			closeServer()
+			os.RemoveAll(dir)
+		})
+	})
+}
@@ -0,0 +1,248 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/covrom/dingo/app/utils"
+)
+
+const (
+	defaultImageMaxSize = 2400
+	// imageJpegQuality is the quality of the JPEG images encoded again.
+	imageJpegQuality = 90
+	// imageMaxPixels bounds the pixels of the images decoded, so that a small
+	// file claiming a huge size can not exhaust the memory.
+	imageMaxPixels = 50 * 1000 * 1000
+)
+
+// ErrImageTooLarge is returned when an uploaded image has more pixels than
+// are ever decoded.
+var ErrImageTooLarge = errors.New("image too large")
+
+// ImageSizes are the names of the sizes generated for the uploaded images,
+// from the smallest. Their widths are set by the image_<size>_width
+// settings.
+var ImageSizes = []string{"thumbnail", "medium", "large"}
+
+var defaultImageSizeWidths = map[string]int{
+	"thumbnail": 150,
+	"medium":    600,
+	"large":     1200,
+}
+
+// imageExts are the extensions of the images processed on upload.
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// ImageSizeWidth returns the width of the named size of the images.
+func ImageSizeWidth(size string) int {
+	return GetSettingInt("image_"+size+"_width", defaultImageSizeWidths[size])
+}
+
+// ImageSizeKey returns the key of the named size of the image with the key:
+// photo.jpg has a photo@thumbnail.jpg thumbnail.
+func ImageSizeKey(key, size string) string {
+	ext := path.Ext(key)
+	return strings.TrimSuffix(key, ext) + "@" + size + ext
+}
+
+// IsImageSizeKey reports whether the key is the one of a size generated for
+// an image.
+func IsImageSizeKey(key string) bool {
+	return imageOriginalKey(key) != key
+}
+
+// imageOriginalKey returns the key of the image a generated size is of, or
+// the key itself if it is not the one of a size.
+func imageOriginalKey(key string) string {
+	ext := path.Ext(key)
+	base := strings.TrimSuffix(key, ext)
+	for _, size := range ImageSizes {
+		if strings.HasSuffix(base, "@"+size) {
+			return strings.TrimSuffix(base, "@"+size) + ext
+		}
+	}
+	return key
+}
+
+// IsImageKey reports whether the key, or URL, is the one of an uploaded
+// image, rather than of another file or of a size generated for an image.
+func IsImageKey(key string) bool {
+	return imageExts[strings.ToLower(path.Ext(key))] && !IsImageSizeKey(key)
+}
+
+// mediaImageUrl reports whether the URL is the one of an image of the media
+// store.
+func mediaImageUrl(url string) bool {
+	base := Media().Url("")
+	if !strings.HasPrefix(url, base) && !(strings.HasPrefix(base, "/") && strings.HasPrefix(url, AbsoluteUrl(base))) {
+		return false
+	}
+	return IsImageKey(url)
+}
+
+// ImageSizeUrl returns the URL of the named size of the uploaded image with
+// the URL. Any other URL is returned unchanged.
+func ImageSizeUrl(url, size string) string {
+	if !mediaImageUrl(url) {
+		return url
+	}
+	return ImageSizeKey(url, size)
+}
+
+// ImageSrcset returns the srcset attribute listing the sizes of the uploaded
+// image with the URL, or an empty string for any other URL.
+func ImageSrcset(url string) string {
+	if !mediaImageUrl(url) {
+		return ""
+	}
+	srcset := make([]string, len(ImageSizes))
+	for i, size := range ImageSizes {
+		srcset[i] = ImageSizeKey(url, size) + " " + strconv.Itoa(ImageSizeWidth(size)) + "w"
+	}
+	return strings.Join(srcset, ", ")
+}
+
+// A ProcessedImage is an uploaded image ready to be stored, along with the
+// sizes generated for it.
+type ProcessedImage struct {
+	Data  []byte
+	Sizes map[string][]byte
+}
+
+// ProcessImage strips the metadata of an uploaded JPEG or PNG image, like the
+// EXIF and GPS data of the photos, rotates it as its EXIF orientation says,
+// scales it down to the image_max_size setting, and generates its sizes.
+// The sizes are never larger than the image. GIF images, which have no such
+// metadata, are kept as they are so that the animated ones still are, and
+// their sizes are generated from their first frame. Images of more than
+// imageMaxPixels pixels are refused with ErrImageTooLarge.
+func ProcessImage(key string, data []byte) (*ProcessedImage, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %s: %v", key, err)
+	}
+	if int64(config.Width)*int64(config.Height) > imageMaxPixels {
+		return nil, ErrImageTooLarge
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %s: %v", key, err)
+	}
+	p := &ProcessedImage{Data: data, Sizes: make(map[string][]byte)}
+	if format != "gif" {
+		if format == "jpeg" {
+			img = utils.OrientImage(img, utils.JpegOrientation(data))
+		}
+		max := GetSettingInt("image_max_size", defaultImageMaxSize)
+		b := img.Bounds()
+		if w, h := utils.FitSize(b.Dx(), b.Dy(), max, max); w != b.Dx() || h != b.Dy() {
+			img = utils.ResizeImage(img, w, h)
+		}
+		// Encoding the image again leaves its metadata out.
+		if p.Data, err = encodeImage(img, format); err != nil {
+			return nil, err
+		}
+	}
+	b := img.Bounds()
+	for _, size := range ImageSizes {
+		resized := img
+		if w, h := utils.FitSize(b.Dx(), b.Dy(), ImageSizeWidth(size), 0); w != b.Dx() {
+			resized = utils.ResizeImage(img, w, h)
+		}
+		if p.Sizes[size], err = encodeImage(resized, format); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func encodeImage(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: imageJpegQuality})
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = fmt.Errorf("unsupported image format %s", format)
+	}
+	return buf.Bytes(), err
+}
+
+// Put saves the image and its sizes to the store under the key.
+func (p *ProcessedImage) Put(store MediaStore, key, contentType string) error {
+	for _, size := range ImageSizes {
+		if err := store.Put(ImageSizeKey(key, size), bytes.NewReader(p.Sizes[size]), contentType); err != nil {
+			return err
+		}
+	}
+	return store.Put(key, bytes.NewReader(p.Data), contentType)
+}
+
+// DeleteImageSizes removes the sizes generated for the image with the key
+// from the store.
+func DeleteImageSizes(store MediaStore, key string) error {
+	if !IsImageKey(key) {
+		return nil
+	}
+	for _, size := range ImageSizes {
+		if err := store.Delete(ImageSizeKey(key, size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResizeImages generates the missing sizes of the images of the local store,
+// like those uploaded before the sizes were, and returns their keys. The
+// images themselves are left as they are.
+func ResizeImages(s *LocalMediaStore) ([]string, error) {
+	var keys []string
+	err := s.Walk(func(key, file string) error {
+		if !IsImageKey(key) {
+			return nil
+		}
+		for _, size := range ImageSizes {
+			if _, err := os.Stat(s.file(ImageSizeKey(key, size))); os.IsNotExist(err) {
+				keys = append(keys, key)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		data, err := ioutil.ReadFile(s.file(key))
+		if err != nil {
+			return nil, err
+		}
+		img, err := ProcessImage(key, data)
+		if err != nil {
+			return nil, err
+		}
+		contentType := mime.TypeByExtension(path.Ext(key))
+		for _, size := range ImageSizes {
+			if err := s.Put(ImageSizeKey(key, size), bytes.NewReader(img.Sizes[size]), contentType); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,189 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// gpsJpeg returns a JPEG image of the size, rotated by its EXIF orientation,
+// whose EXIF data also holds a GPS marker.
+func gpsJpeg(width, height int, orientation byte) []byte {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil)
+	data := buf.Bytes()
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1,
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0,
+		0, 0, 0, 0}
+	segment := append(append([]byte("Exif\x00\x00"), tiff...), "GPS 48.8584N 2.2945E"...)
+	app1 := append([]byte{0xFF, 0xE1, 0, byte(len(segment) + 2)}, segment...)
+	return append(append(append([]byte(nil), data[:2]...), app1...), data[2:]...)
+}
+
+// hugePng returns a PNG image claiming the size in its header, but holding
+// no pixels.
+func hugePng(width, height uint32) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func imageSize(data []byte) (int, int, string) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, ""
+	}
+	return config.Width, config.Height, format
+}
+
+func TestImage(t *testing.T) {
+	Convey("Name the sizes of the images", t, func() {
+		So(ImageSizeKey("2018/photo.jpg", "thumbnail"), ShouldEqual, "2018/photo@thumbnail.jpg")
+		So(IsImageKey("2018/photo.JPG"), ShouldBeTrue)
+		So(IsImageKey("2018/photo@large.jpg"), ShouldBeFalse)
+		So(IsImageSizeKey("2018/photo@large.jpg"), ShouldBeTrue)
+		So(IsImageKey("notes.txt"), ShouldBeFalse)
+	})
+
+	Convey("Process the uploaded images", t, func() {
+		Initialize("sqlite://:memory:", true)
+
+		Convey("Strip the EXIF data of photos, rotated and scaled down", func() {
+			data := gpsJpeg(3200, 1600, 6)
+			So(bytes.Contains(data, []byte("GPS")), ShouldBeTrue)
+			img, err := ProcessImage("photo.jpg", data)
+			So(err, ShouldBeNil)
+			So(bytes.Contains(img.Data, []byte("Exif")), ShouldBeFalse)
+			So(bytes.Contains(img.Data, []byte("GPS")), ShouldBeFalse)
+			w, h, format := imageSize(img.Data)
+			So(format, ShouldEqual, "jpeg")
+			So(w, ShouldEqual, 1200)
+			So(h, ShouldEqual, 2400)
+
+			w, h, _ = imageSize(img.Sizes["thumbnail"])
+			So(w, ShouldEqual, 150)
+			So(h, ShouldEqual, 300)
+			w, _, _ = imageSize(img.Sizes["medium"])
+			So(w, ShouldEqual, 600)
+			// The sizes are never larger than the image.
+			w, _, _ = imageSize(img.Sizes["large"])
+			So(w, ShouldEqual, 1200)
+		})
+
+		Convey("Follow the image settings", func() {
+			NewSetting("image_thumbnail_width", "100", "blog").Save()
+			NewSetting("image_max_size", "0", "blog").Save()
+			var buf bytes.Buffer
+			png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 3000, 300)))
+			img, err := ProcessImage("wide.png", buf.Bytes())
+			So(err, ShouldBeNil)
+			w, _, format := imageSize(img.Data)
+			So(format, ShouldEqual, "png")
+			So(w, ShouldEqual, 3000)
+			w, h, _ := imageSize(img.Sizes["thumbnail"])
+			So(w, ShouldEqual, 100)
+			So(h, ShouldEqual, 10)
+		})
+
+		Convey("Keep the GIF images as they are", func() {
+			var buf bytes.Buffer
+			palette := color.Palette{color.Black, color.White}
+			gif.EncodeAll(&buf, &gif.GIF{
+				Image: []*image.Paletted{
+					image.NewPaletted(image.Rect(0, 0, 800, 400), palette),
+					image.NewPaletted(image.Rect(0, 0, 800, 400), palette),
+				},
+				Delay: []int{10, 10},
+			})
+			img, err := ProcessImage("anim.gif", buf.Bytes())
+			So(err, ShouldBeNil)
+			So(img.Data, ShouldResemble, buf.Bytes())
+			w, _, format := imageSize(img.Sizes["medium"])
+			So(format, ShouldEqual, "gif")
+			So(w, ShouldEqual, 600)
+		})
+
+		Convey("Refuse invalid images", func() {
+			_, err := ProcessImage("fake.png", []byte("not an image"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Refuse images of too many pixels", func() {
+			w, h, format := imageSize(hugePng(100000, 100000))
+			So([]interface{}{w, h, format}, ShouldResemble, []interface{}{100000, 100000, "png"})
+			_, err := ProcessImage("huge.png", hugePng(100000, 100000))
+			So(err, ShouldEqual, ErrImageTooLarge)
+		})
+
+		Convey("Store the images with their sizes", func() {
+			dir, _ := ioutil.TempDir("", "dingo-media")
+			s := &LocalMediaStore{Dir: dir, BaseUrl: "/upload/"}
+			img, err := ProcessImage("photo.jpg", gpsJpeg(800, 600, 1))
+			So(err, ShouldBeNil)
+			So(img.Put(s, "photo.jpg", "image/jpeg"), ShouldBeNil)
+			files, _ := s.List("")
+			So(files, ShouldHaveLength, 4)
+
+			So(DeleteImageSizes(s, "photo.jpg"), ShouldBeNil)
+			files, _ = s.List("")
+			So(files, ShouldHaveLength, 1)
+			_, err = os.Stat(filepath.Join(dir, "photo.jpg"))
+			So(err, ShouldBeNil)
+
+			Reset(func() {
+				os.RemoveAll(dir)
+			})
+		})
+
+		Convey("Generate the missing sizes of the local images", func() {
+			dir, _ := ioutil.TempDir("", "dingo-media")
+			s := &LocalMediaStore{Dir: dir, BaseUrl: "/upload/"}
+			data := gpsJpeg(800, 600, 1)
+			s.Put("2018/photo.jpg", bytes.NewReader(data), "image/jpeg")
+			s.Put("notes.txt", bytes.NewReader([]byte("notes")), "text/plain")
+			keys, err := ResizeImages(s)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"2018/photo.jpg"})
+			files, _ := s.List("2018/")
+			So(files, ShouldHaveLength, 4)
+			b, _ := ioutil.ReadFile(filepath.Join(dir, "2018", "photo.jpg"))
+			So(b, ShouldResemble, data)
+
+			keys, err = ResizeImages(s)
+			So(err, ShouldBeNil)
+			So(keys, ShouldBeEmpty)
+
+			Reset(func() {
+				os.RemoveAll(dir)
+			})
+		})
+	})
+
+	Convey("List the sizes of the uploaded images in srcsets", t, func() {
+		Initialize("sqlite://:memory:", true)
+		NewSetting("site_url", "http://example.com", "blog").Save()
+
+		So(ImageSrcset("/upload/photo.jpg"), ShouldEqual,
+			"/upload/photo@thumbnail.jpg 150w, /upload/photo@medium.jpg 600w, /upload/photo@large.jpg 1200w")
+		So(ImageSizeUrl("http://example.com/upload/photo.jpg", "large"), ShouldEqual,
+			"http://example.com/upload/photo@large.jpg")
+		So(ImageSrcset("http://elsewhere.com/photo.jpg"), ShouldEqual, "")
+		So(ImageSizeUrl("http://elsewhere.com/photo.jpg", "large"), ShouldEqual, "http://elsewhere.com/photo.jpg")
+		So(ImageSrcset("/upload/notes.txt"), ShouldEqual, "")
+	})
+}
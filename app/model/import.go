@@ -0,0 +1,239 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// An Import is the content read from the export of another blog engine,
+// ready to be saved to the blog.
+type Import struct {
+	Users []*ImportUser
+	Posts []*ImportPost
+}
+
+// An ImportUser is a user of an export, known by the key the posts of the
+// export use to refer to their author.
+type ImportUser struct {
+	*User
+	Key string
+}
+
+// An ImportPost is a post or page of an export, along with its comments.
+// Author is the key of its ImportUser.
+type ImportPost struct {
+	*Post
+	Author   string
+	Comments []*ImportComment
+}
+
+// An ImportComment is a comment of an export. Key is its id in the export,
+// and ParentKey the id of the comment it replies to.
+type ImportComment struct {
+	*Comment
+	Key       string
+	ParentKey string
+}
+
+// An ImportReport tells what was, or would be with a dry run, imported.
+type ImportReport struct {
+	DryRun   bool     `json:"dry_run"`
+	Posts    []string `json:"posts"`
+	Pages    []string `json:"pages"`
+	Users    []string `json:"users"`
+	Tags     []string `json:"tags"`
+	Comments int      `json:"comments"`
+	// Conflicts are the posts which were not imported, since their slug is
+	// already used.
+	Conflicts []string `json:"conflicts"`
+}
+
+// Importers maps the names of the supported blog engines to the parsers of
+// their exports.
+var Importers = map[string]func(r io.Reader) (*Import, error){
+	"wordpress": ParseWXR,
+	"ghost":     ParseGhost,
+}
+
+// ReadImport parses the export of the named blog engine. If the name is
+// empty, the engine is guessed from the content: Ghost exports are JSON, and
+// WordPress ones XML.
+func ReadImport(r io.Reader, engine string) (*Import, error) {
+	if engine == "" {
+		br := bufio.NewReader(r)
+		r = br
+		engine = "wordpress"
+		for {
+			b, err := br.Peek(1)
+			if err != nil {
+				return nil, fmt.Errorf("invalid export: %v", err)
+			}
+			// Skip the spaces and byte order mark before the content.
+			if strings.IndexByte(" \t\r\n\xef\xbb\xbf", b[0]) < 0 {
+				if b[0] == '{' {
+					engine = "ghost"
+				}
+				break
+			}
+			br.ReadByte()
+		}
+	}
+	parse, ok := Importers[engine]
+	if !ok {
+		return nil, fmt.Errorf("unknown blog engine %s", engine)
+	}
+	return parse(r)
+}
+
+// Run saves the content of the import to the blog, unless dryRun is set, and
+// reports what was imported. Users are matched to the existing ones by
+// email, and the new ones get the Author role and a random password. The
+// posts whose slug is already used are not imported.
+func (i *Import) Run(dryRun bool) (*ImportReport, error) {
+	report := &ImportReport{DryRun: dryRun}
+
+	authors := make(map[string]string)
+	for _, u := range i.Users {
+		existing := &User{Email: u.Email}
+		if u.Email != "" && existing.GetUserByEmail() == nil {
+			authors[u.Key] = existing.Id.Hex()
+			continue
+		}
+		if err := u.importUser(dryRun); err != nil {
+			return nil, err
+		}
+		authors[u.Key] = u.Id.Hex()
+		report.Users = append(report.Users, fmt.Sprintf("%s <%s>", u.Name, u.Email))
+	}
+
+	tags := make(map[string]string)
+	slugs := make(map[string]bool)
+	for _, p := range i.Posts {
+		if p.Slug == "" {
+			p.Slug = GenerateSlug(p.Title, "posts")
+		}
+		if slugs[p.Slug] || !PostChangeSlug(p.Slug) {
+			report.Conflicts = append(report.Conflicts, fmt.Sprintf("%s: the slug %q is already used", p.Title, p.Slug))
+			continue
+		}
+		slugs[p.Slug] = true
+		p.CreatedBy = authors[p.Author]
+		p.UpdatedBy = p.CreatedBy
+		for _, t := range p.Tags {
+			tags[t.Slug] = t.Name
+		}
+		report.Comments += len(p.Comments)
+		line := fmt.Sprintf("%s (/%s)", p.Title, p.Slug)
+		if p.IsPage {
+			report.Pages = append(report.Pages, line)
+		} else {
+			report.Posts = append(report.Posts, line)
+		}
+		if dryRun {
+			continue
+		}
+		if err := p.importPost(); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range tags {
+		report.Tags = append(report.Tags, name)
+	}
+	sort.Strings(report.Tags)
+	return report, nil
+}
+
+// importUser saves a new user, keeping their slug if it is free.
+func (u *ImportUser) importUser(dryRun bool) error {
+	if len(u.Id) == 0 {
+		u.Id = bson.NewObjectId()
+	}
+	if u.Slug != "" && store.GetUserBySlug(u.Slug, new(User)) == nil {
+		u.Slug = ""
+	}
+	if u.Role == 0 {
+		u.Role = RoleAuthor
+	}
+	if dryRun {
+		return nil
+	}
+	password := make([]byte, 16)
+	if _, err := rand.Read(password); err != nil {
+		return err
+	}
+	return u.Create(hex.EncodeToString(password))
+}
+
+// importPost saves the post along with its comments, whose parents are
+// replaced by their new ids.
+func (p *ImportPost) importPost() error {
+	byKey := make(map[string]*ImportComment)
+	for _, c := range p.Comments {
+		c.Id = bson.NewObjectId()
+		byKey[c.Key] = c
+	}
+	p.CommentNum = 0
+	for _, c := range p.Comments {
+		c.PostId = p.Id.Hex()
+		c.Parent, c.Path = "", ""
+		// The parents may come after their replies, so the paths are made
+		// from the imported comments rather than the saved ones.
+		parent := byKey[c.ParentKey]
+		if parent != nil {
+			c.Parent = parent.Id.Hex()
+		}
+		for depth := 0; parent != nil && depth < len(p.Comments); depth++ {
+			c.Path = parent.Id.Hex() + "/" + c.Path
+			parent = byKey[parent.ParentKey]
+		}
+		if c.IsApproved() {
+			p.CommentNum++
+		}
+		if err := c.Save(); err != nil {
+			return err
+		}
+	}
+	return p.Save(p.Tags...)
+}
+
+// htmlTagRe matches the tags of the HTML of the imported comments.
+var htmlTagRe = regexp.MustCompile(`<[^>]*>`)
+
+// importCommentContent turns the HTML of an imported comment into the
+// escaped text the comments are saved as.
+func importCommentContent(s string) string {
+	text := html.UnescapeString(htmlTagRe.ReplaceAllString(strings.TrimSpace(s), ""))
+	return strings.Replace(template.HTMLEscapeString(text), "\n", "<br/>", -1)
+}
+
+// String returns the report as text, listing everything imported.
+func (r *ImportReport) String() string {
+	var b bytes.Buffer
+	if r.DryRun {
+		b.WriteString("Dry run, nothing was imported.\n")
+	}
+	writeList := func(title string, items []string) {
+		fmt.Fprintf(&b, "%s: %d\n", title, len(items))
+		for _, item := range items {
+			fmt.Fprintf(&b, "  %s\n", item)
+		}
+	}
+	writeList("Posts", r.Posts)
+	writeList("Pages", r.Pages)
+	writeList("New users", r.Users)
+	writeList("Tags", r.Tags)
+	fmt.Fprintf(&b, "Comments: %d\n", r.Comments)
+	writeList("Conflicts", r.Conflicts)
+	return b.String()
+}
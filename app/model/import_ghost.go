@@ -0,0 +1,218 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/covrom/dingo/app/utils"
+)
+
+// ghostExport is a Ghost JSON export. Older versions of Ghost keep the data
+// at the top level, newer ones in a "db" list.
+type ghostExport struct {
+	Db []struct {
+		Data ghostData `json:"data"`
+	} `json:"db"`
+	Data *ghostData `json:"data"`
+}
+
+type ghostData struct {
+	Posts        []ghostPost   `json:"posts"`
+	Tags         []ghostTag    `json:"tags"`
+	Users        []ghostAuthor `json:"users"`
+	PostsTags    []ghostLink   `json:"posts_tags"`
+	PostsAuthors []ghostLink   `json:"posts_authors"`
+}
+
+// ghostValue is a value of a Ghost export, which may be a string, a number
+// or a boolean depending on the version of Ghost and its database.
+type ghostValue string
+
+func (v *ghostValue) UnmarshalJSON(b []byte) error {
+	if s, err := strconv.Unquote(string(b)); err == nil {
+		*v = ghostValue(s)
+		return nil
+	}
+	if string(b) == "null" {
+		*v = ""
+		return nil
+	}
+	*v = ghostValue(b)
+	return nil
+}
+
+// Bool reads booleans saved as true or 1.
+func (v ghostValue) Bool() bool {
+	return v == "true" || v == "1"
+}
+
+// Time reads dates saved as ISO 8601, SQL dates, or milliseconds since the
+// epoch.
+func (v ghostValue) Time() *time.Time {
+	s := string(v)
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		t := time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).UTC()
+		return &t
+	}
+	return nil
+}
+
+type ghostPost struct {
+	Id              ghostValue `json:"id"`
+	Title           string     `json:"title"`
+	Slug            string     `json:"slug"`
+	Markdown        string     `json:"markdown"`
+	Mobiledoc       string     `json:"mobiledoc"`
+	Html            string     `json:"html"`
+	Image           string     `json:"image"`
+	FeatureImage    string     `json:"feature_image"`
+	Featured        ghostValue `json:"featured"`
+	Page            ghostValue `json:"page"`
+	Type            string     `json:"type"`
+	Status          string     `json:"status"`
+	Language        string     `json:"language"`
+	MetaTitle       string     `json:"meta_title"`
+	MetaDescription string     `json:"meta_description"`
+	AuthorId        ghostValue `json:"author_id"`
+	CreatedAt       ghostValue `json:"created_at"`
+	PublishedAt     ghostValue `json:"published_at"`
+}
+
+type ghostTag struct {
+	Id   ghostValue `json:"id"`
+	Name string     `json:"name"`
+	Slug string     `json:"slug"`
+}
+
+type ghostAuthor struct {
+	Id       ghostValue `json:"id"`
+	Name     string     `json:"name"`
+	Slug     string     `json:"slug"`
+	Email    string     `json:"email"`
+	Bio      string     `json:"bio"`
+	Website  string     `json:"website"`
+	Location string     `json:"location"`
+}
+
+// ghostLink links a post to one of its tags or authors.
+type ghostLink struct {
+	PostId   ghostValue `json:"post_id"`
+	TagId    ghostValue `json:"tag_id"`
+	AuthorId ghostValue `json:"author_id"`
+}
+
+// ghostMobiledoc is the part of the Mobiledoc format of the posts written
+// with the editor of Ghost 1 which holds the markdown cards.
+type ghostMobiledoc struct {
+	Cards [][]json.RawMessage `json:"cards"`
+}
+
+// markdown returns the markdown of a post made of a single markdown card.
+func (p *ghostPost) markdown() string {
+	if p.Markdown != "" {
+		return p.Markdown
+	}
+	var doc ghostMobiledoc
+	if json.Unmarshal([]byte(p.Mobiledoc), &doc) == nil && len(doc.Cards) == 1 && len(doc.Cards[0]) == 2 {
+		var name string
+		var card struct {
+			Markdown string `json:"markdown"`
+		}
+		json.Unmarshal(doc.Cards[0][0], &name)
+		json.Unmarshal(doc.Cards[0][1], &card)
+		if strings.Contains(name, "markdown") && card.Markdown != "" {
+			return card.Markdown
+		}
+	}
+	return utils.Html2Markdown(p.Html)
+}
+
+// ParseGhost parses a Ghost JSON export. Ghost keeps no comments, so there
+// are none to import.
+func ParseGhost(r io.Reader) (*Import, error) {
+	var export ghostExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("invalid Ghost export: %v", err)
+	}
+	data := export.Data
+	if len(export.Db) > 0 {
+		data = &export.Db[0].Data
+	}
+	if data == nil {
+		return nil, fmt.Errorf("invalid Ghost export: no data found")
+	}
+
+	imp := new(Import)
+	for _, gu := range data.Users {
+		u := NewUser(gu.Email, gu.Name)
+		u.Slug = gu.Slug
+		u.Bio = gu.Bio
+		u.Website = gu.Website
+		u.Location = gu.Location
+		imp.Users = append(imp.Users, &ImportUser{User: u, Key: string(gu.Id)})
+	}
+
+	tags := make(map[ghostValue]Tag)
+	for _, t := range data.Tags {
+		tags[t.Id] = NewTag(t.Name, t.Slug)
+	}
+	postTags := make(map[ghostValue]Tags)
+	for _, l := range data.PostsTags {
+		if t, ok := tags[l.TagId]; ok {
+			postTags[l.PostId] = append(postTags[l.PostId], t)
+		}
+	}
+	// The first author of the posts of Ghost 1.22 and later is their author.
+	postAuthors := make(map[ghostValue]ghostValue)
+	for _, l := range data.PostsAuthors {
+		if _, ok := postAuthors[l.PostId]; !ok {
+			postAuthors[l.PostId] = l.AuthorId
+		}
+	}
+
+	for _, gp := range data.Posts {
+		p := NewPost()
+		p.Title = gp.Title
+		p.Slug = gp.Slug
+		p.Markdown = gp.markdown()
+		p.Html = RenderMarkdown(p.Markdown)
+		p.Image = gp.FeatureImage
+		if p.Image == "" {
+			p.Image = gp.Image
+		}
+		p.IsFeatured = gp.Featured.Bool()
+		p.IsPage = gp.Page.Bool() || gp.Type == "page"
+		p.AllowComment = true
+		p.Language = gp.Language
+		p.MetaTitle = gp.MetaTitle
+		p.MetaDescription = gp.MetaDescription
+		if t := gp.CreatedAt.Time(); t != nil {
+			p.CreatedAt = t
+		}
+		switch gp.Status {
+		case "published", "scheduled":
+			p.IsPublished = true
+			p.PublishedAt = gp.PublishedAt.Time()
+		case "draft":
+		default:
+			continue
+		}
+		p.Tags = postTags[gp.Id].GetDistinctBySlug()
+
+		author, ok := postAuthors[gp.Id]
+		if !ok {
+			author = gp.AuthorId
+		}
+		imp.Posts = append(imp.Posts, &ImportPost{Post: p, Author: string(author)})
+	}
+	return imp, nil
+}
@@ -0,0 +1,202 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const sampleWXR = `<?xml version="1.0" encoding="UTF-8" ?>
+<rss version="2.0"
+	xmlns:content="http://purl.org/rss/1.0/modules/content/"
+	xmlns:dc="http://purl.org/dc/elements/1.1/"
+	xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+	<wp:author>
+		<wp:author_login><![CDATA[jane]]></wp:author_login>
+		<wp:author_email><![CDATA[jane@example.com]]></wp:author_email>
+		<wp:author_display_name><![CDATA[Jane Doe]]></wp:author_display_name>
+	</wp:author>
+	<item>
+		<title>Hello WordPress</title>
+		<dc:creator><![CDATA[jane]]></dc:creator>
+		<content:encoded><![CDATA[<p>Some <strong>bold</strong> text.</p>]]></content:encoded>
+		<wp:post_date_gmt><![CDATA[2015-03-04 05:06:07]]></wp:post_date_gmt>
+		<wp:post_name><![CDATA[hello-wordpress]]></wp:post_name>
+		<wp:status><![CDATA[publish]]></wp:status>
+		<wp:post_type><![CDATA[post]]></wp:post_type>
+		<wp:is_sticky>0</wp:is_sticky>
+		<category domain="category" nicename="uncategorized"><![CDATA[Uncategorized]]></category>
+		<category domain="post_tag" nicename="golang"><![CDATA[Go]]></category>
+		<wp:comment>
+			<wp:comment_id>1</wp:comment_id>
+			<wp:comment_author><![CDATA[Bob]]></wp:comment_author>
+			<wp:comment_author_email><![CDATA[bob@example.com]]></wp:comment_author_email>
+			<wp:comment_date_gmt><![CDATA[2015-03-05 00:00:00]]></wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[First <b>comment</b> & more]]></wp:comment_content>
+			<wp:comment_approved><![CDATA[1]]></wp:comment_approved>
+			<wp:comment_type><![CDATA[]]></wp:comment_type>
+			<wp:comment_parent>0</wp:comment_parent>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>2</wp:comment_id>
+			<wp:comment_author><![CDATA[Jane Doe]]></wp:comment_author>
+			<wp:comment_date_gmt><![CDATA[2015-03-06 00:00:00]]></wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[A reply]]></wp:comment_content>
+			<wp:comment_approved><![CDATA[1]]></wp:comment_approved>
+			<wp:comment_parent>1</wp:comment_parent>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>3</wp:comment_id>
+			<wp:comment_content><![CDATA[A pingback]]></wp:comment_content>
+			<wp:comment_approved><![CDATA[1]]></wp:comment_approved>
+			<wp:comment_type><![CDATA[pingback]]></wp:comment_type>
+			<wp:comment_parent>0</wp:comment_parent>
+		</wp:comment>
+	</item>
+	<item>
+		<title>About</title>
+		<dc:creator><![CDATA[jane]]></dc:creator>
+		<content:encoded><![CDATA[About me]]></content:encoded>
+		<wp:post_name><![CDATA[about]]></wp:post_name>
+		<wp:status><![CDATA[draft]]></wp:status>
+		<wp:post_type><![CDATA[page]]></wp:post_type>
+	</item>
+	<item>
+		<title>Existing</title>
+		<wp:post_name><![CDATA[welcome-to-dingo]]></wp:post_name>
+		<wp:status><![CDATA[publish]]></wp:status>
+		<wp:post_type><![CDATA[post]]></wp:post_type>
+	</item>
+	<item>
+		<title>Logo</title>
+		<wp:post_name><![CDATA[logo]]></wp:post_name>
+		<wp:status><![CDATA[inherit]]></wp:status>
+		<wp:post_type><![CDATA[attachment]]></wp:post_type>
+	</item>
+</channel>
+</rss>`
+
+const sampleGhost = `{"db": [{"data": {
+	"posts": [
+		{"id": 1, "title": "Hello Ghost", "slug": "hello-ghost", "markdown": "Some *markdown*",
+		 "status": "published", "page": 0, "featured": 1, "author_id": 1,
+		 "created_at": "2016-01-02 03:04:05", "published_at": 1451703845000},
+		{"id": "2", "title": "Mobiledoc", "slug": "mobiledoc", "status": "draft", "page": false,
+		 "mobiledoc": "{\"version\":\"0.3.1\",\"cards\":[[\"card-markdown\",{\"markdown\":\"From a card\"}]]}",
+		 "created_at": "2017-01-02T03:04:05.000Z"}
+	],
+	"tags": [{"id": 1, "name": "Ghost", "slug": "ghost"}],
+	"posts_tags": [{"post_id": 1, "tag_id": 1}],
+	"posts_authors": [{"post_id": "2", "author_id": 1}],
+	"users": [{"id": 1, "name": "Ghost Writer", "slug": "ghost-writer", "email": "ghost@example.com"}]
+}}]}`
+
+func TestImport(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("sqlite://:memory:", true)
+		existing := mockPost()
+		So(existing.Save(), ShouldBeNil)
+
+		Convey("Detect the blog engine", func() {
+			_, err := ReadImport(strings.NewReader("\n  "+sampleGhost), "")
+			So(err, ShouldBeNil)
+			_, err = ReadImport(strings.NewReader(sampleWXR), "")
+			So(err, ShouldBeNil)
+			_, err = ReadImport(strings.NewReader(sampleWXR), "blogger")
+			So(err, ShouldNotBeNil)
+			_, err = ReadImport(strings.NewReader("<rss>"), "wordpress")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Parse a WordPress export", func() {
+			imp, err := ParseWXR(strings.NewReader(sampleWXR))
+			So(err, ShouldBeNil)
+			So(imp.Users, ShouldHaveLength, 1)
+			So(imp.Users[0].Email, ShouldEqual, "jane@example.com")
+			So(imp.Posts, ShouldHaveLength, 3)
+
+			p := imp.Posts[0]
+			So(p.Markdown, ShouldEqual, "Some **bold** text.")
+			So(p.IsPublished, ShouldBeTrue)
+			So(p.PublishedAt.UTC().Format(wxrDateFormat), ShouldEqual, "2015-03-04 05:06:07")
+			So(p.Tags, ShouldHaveLength, 1)
+			So(p.Tags[0].Slug, ShouldEqual, "golang")
+			So(p.Comments, ShouldHaveLength, 2)
+			So(p.Comments[0].Content, ShouldEqual, "First comment &amp; more")
+			So(p.Comments[1].ParentKey, ShouldEqual, "1")
+
+			So(imp.Posts[1].IsPage, ShouldBeTrue)
+			So(imp.Posts[1].IsPublished, ShouldBeFalse)
+		})
+
+		Convey("Parse a Ghost export", func() {
+			imp, err := ParseGhost(strings.NewReader(sampleGhost))
+			So(err, ShouldBeNil)
+			So(imp.Users, ShouldHaveLength, 1)
+			So(imp.Users[0].Slug, ShouldEqual, "ghost-writer")
+			So(imp.Posts, ShouldHaveLength, 2)
+			So(imp.Posts[0].Markdown, ShouldEqual, "Some *markdown*")
+			So(imp.Posts[0].IsFeatured, ShouldBeTrue)
+			So(imp.Posts[0].PublishedAt.Unix(), ShouldEqual, 1451703845)
+			So(imp.Posts[0].Tags, ShouldHaveLength, 1)
+			So(imp.Posts[0].Author, ShouldEqual, "1")
+			So(imp.Posts[1].Markdown, ShouldEqual, "From a card")
+			So(imp.Posts[1].Author, ShouldEqual, "1")
+
+			_, err = ParseGhost(strings.NewReader(`{"meta": {}}`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Dry run an import", func() {
+			imp, _ := ParseWXR(strings.NewReader(sampleWXR))
+			report, err := imp.Run(true)
+			So(err, ShouldBeNil)
+			So(report.DryRun, ShouldBeTrue)
+			So(report.Posts, ShouldHaveLength, 1)
+			So(report.Pages, ShouldHaveLength, 1)
+			So(report.Users, ShouldHaveLength, 1)
+			So(report.Tags, ShouldResemble, []string{"Go"})
+			So(report.Comments, ShouldEqual, 2)
+			So(report.Conflicts, ShouldHaveLength, 1)
+			So(report.String(), ShouldContainSubstring, "welcome-to-dingo")
+
+			p := new(Post)
+			So(p.GetPostBySlug("hello-wordpress"), ShouldNotBeNil)
+			u := &User{Email: "jane@example.com"}
+			So(u.GetUserByEmail(), ShouldNotBeNil)
+		})
+
+		Convey("Import a WordPress export", func() {
+			imp, _ := ParseWXR(strings.NewReader(sampleWXR))
+			report, err := imp.Run(false)
+			So(err, ShouldBeNil)
+			So(report.Conflicts, ShouldHaveLength, 1)
+
+			u := &User{Email: "jane@example.com"}
+			So(u.GetUserByEmail(), ShouldBeNil)
+			So(u.Role, ShouldEqual, RoleAuthor)
+
+			p := new(Post)
+			So(p.GetPostBySlug("hello-wordpress"), ShouldBeNil)
+			So(p.CreatedBy, ShouldEqual, u.Id.Hex())
+			So(p.CommentNum, ShouldEqual, 2)
+			So(p.PublishedAt.UTC().Format(wxrDateFormat), ShouldEqual, "2015-03-04 05:06:07")
+
+			comments := new(Comments)
+			So(comments.GetCommentsByPostId(p.Id.Hex()), ShouldBeNil)
+			So(*comments, ShouldHaveLength, 1)
+			So(*(*comments)[0].Children, ShouldHaveLength, 1)
+
+			Convey("Importing again only reports conflicts", func() {
+				imp, _ := ParseWXR(strings.NewReader(sampleWXR))
+				report, err := imp.Run(false)
+				So(err, ShouldBeNil)
+				So(report.Users, ShouldBeEmpty)
+				So(report.Posts, ShouldBeEmpty)
+				So(report.Conflicts, ShouldHaveLength, 3)
+			})
+		})
+	})
+}
@@ -0,0 +1,169 @@
+package model
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/covrom/dingo/app/utils"
+	"github.com/globalsign/mgo/bson"
+)
+
+// wxrDateFormat is the format of the dates of WordPress exports.
+const wxrDateFormat = "2006-01-02 15:04:05"
+
+// wxrExport is a WordPress eXtended RSS export. The elements of the "wp"
+// namespace are matched by their local name, since its URL changes with the
+// version of the format.
+type wxrExport struct {
+	XMLName xml.Name    `xml:"rss"`
+	Authors []wxrAuthor `xml:"channel>author"`
+	Items   []wxrItem   `xml:"channel>item"`
+}
+
+type wxrAuthor struct {
+	Login       string `xml:"author_login"`
+	Email       string `xml:"author_email"`
+	DisplayName string `xml:"author_display_name"`
+}
+
+type wxrItem struct {
+	Title       string        `xml:"title"`
+	PubDate     string        `xml:"pubDate"`
+	Creator     string        `xml:"creator"`
+	Content     string        `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PostDate    string        `xml:"post_date"`
+	PostDateGmt string        `xml:"post_date_gmt"`
+	Name        string        `xml:"post_name"`
+	Status      string        `xml:"status"`
+	Type        string        `xml:"post_type"`
+	IsSticky    int           `xml:"is_sticky"`
+	Categories  []wxrCategory `xml:"category"`
+	Comments    []wxrComment  `xml:"comment"`
+}
+
+type wxrCategory struct {
+	Domain   string `xml:"domain,attr"`
+	Nicename string `xml:"nicename,attr"`
+	Name     string `xml:",chardata"`
+}
+
+type wxrComment struct {
+	Id          string `xml:"comment_id"`
+	Author      string `xml:"comment_author"`
+	AuthorEmail string `xml:"comment_author_email"`
+	AuthorUrl   string `xml:"comment_author_url"`
+	AuthorIp    string `xml:"comment_author_IP"`
+	Date        string `xml:"comment_date"`
+	DateGmt     string `xml:"comment_date_gmt"`
+	Content     string `xml:"comment_content"`
+	Approved    string `xml:"comment_approved"`
+	Type        string `xml:"comment_type"`
+	Parent      string `xml:"comment_parent"`
+}
+
+// wxrCommentStatus maps the comment_approved values of WordPress to the
+// moderation states of the comments. Others await moderation.
+var wxrCommentStatus = map[string]string{
+	"1":     CommentApproved,
+	"spam":  CommentSpam,
+	"trash": CommentTrashed,
+}
+
+// wxrTime parses the first valid date of a WordPress export, written in UTC.
+// Drafts have a zero date.
+func wxrTime(dates ...string) *time.Time {
+	for _, d := range dates {
+		t, err := time.Parse(wxrDateFormat, d)
+		if err == nil && t.Year() > 1 {
+			return &t
+		}
+	}
+	return nil
+}
+
+// ParseWXR parses a WordPress eXtended RSS export. The attachments, menus and
+// trashed posts are left out, as are the pingbacks and trashed comments.
+func ParseWXR(r io.Reader) (*Import, error) {
+	var export wxrExport
+	if err := xml.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("invalid WordPress export: %v", err)
+	}
+
+	imp := new(Import)
+	for _, a := range export.Authors {
+		u := NewUser(a.Email, a.DisplayName)
+		u.Slug = GenerateSlug(a.Login, "users")
+		if u.Name == "" {
+			u.Name = a.Login
+		}
+		imp.Users = append(imp.Users, &ImportUser{User: u, Key: a.Login})
+	}
+
+	for _, item := range export.Items {
+		if item.Type != "post" && item.Type != "page" {
+			continue
+		}
+		p := NewPost()
+		p.Title = item.Title
+		p.Slug, _ = url.QueryUnescape(item.Name)
+		p.IsPage = item.Type == "page"
+		p.IsFeatured = item.IsSticky == 1
+		p.AllowComment = true
+		p.Markdown = utils.Html2Markdown(item.Content)
+		p.Html = RenderMarkdown(p.Markdown)
+		if t := wxrTime(item.PostDateGmt, item.PostDate); t != nil {
+			p.CreatedAt = t
+		} else if t, err := time.Parse(time.RFC1123Z, item.PubDate); err == nil {
+			p.CreatedAt = &t
+		}
+		switch item.Status {
+		case "publish", "future":
+			p.IsPublished = true
+			p.PublishedAt = p.CreatedAt
+		case "draft", "pending", "private":
+		default:
+			continue
+		}
+		for _, c := range item.Categories {
+			if c.Domain != "post_tag" && c.Domain != "category" || c.Nicename == "uncategorized" {
+				continue
+			}
+			slug, _ := url.QueryUnescape(c.Nicename)
+			p.Tags = append(p.Tags, NewTag(c.Name, slug))
+		}
+		p.Tags = p.Tags.GetDistinctBySlug()
+
+		ip := &ImportPost{Post: p, Author: item.Creator}
+		for _, wc := range item.Comments {
+			if wc.Type != "" && wc.Type != "comment" {
+				continue
+			}
+			c := &Comment{
+				Id:        bson.NewObjectId(),
+				Author:    wc.Author,
+				Email:     wc.AuthorEmail,
+				Website:   wc.AuthorUrl,
+				Ip:        wc.AuthorIp,
+				CreatedAt: wxrTime(wc.DateGmt, wc.Date),
+				Content:   importCommentContent(wc.Content),
+				Status:    wxrCommentStatus[wc.Approved],
+			}
+			if c.Status == "" {
+				c.Status = CommentPending
+			}
+			if c.IsSpam() {
+				c.Reason = "wordpress"
+			}
+			parent := wc.Parent
+			if parent == "0" {
+				parent = ""
+			}
+			ip.Comments = append(ip.Comments, &ImportComment{Comment: c, Key: wc.Id, ParentKey: parent})
+		}
+		imp.Posts = append(imp.Posts, ip)
+	}
+	return imp, nil
+}
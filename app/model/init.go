@@ -1,14 +1,13 @@
 package model
 
 import (
-	"github.com/globalsign/mgo"
+	"strconv"
+
 	"github.com/globalsign/mgo/bson"
 
 	"github.com/covrom/dingo/app/utils"
 )
 
-var mdb, comSession, mesSession, postSession, setSession, userSession *mgo.Session
-
 const samplePostContent = `
 Welcome to Dingo! This is your first post. You can find it in the [admin panel](/admin/).
 
@@ -81,62 +80,37 @@ Table:
 // }
 
 // Initialize sets up the DB by creaing a new connection, creating tables if
-// they don't exist yet, and creates the welcome data.
-func Initialize(dbPath string, skipWelcomeData bool) error {
-	if err := initConnection(dbPath); err != nil {
-		return err
-	}
-
-	dbnames, err := mdb.DatabaseNames()
+// they don't exist yet, and creates the welcome data. The backend is picked
+// from the scheme of dbUrl, either "mongodb://" or "sqlite://". A plain host
+// name is treated as a MongoDB server.
+func Initialize(dbUrl string, skipWelcomeData bool) error {
+	s, err := openStore(dbUrl)
 	if err != nil {
 		return err
 	}
-
-	dbExists := false
-	for _, n := range dbnames {
-		if n == DBName {
-			dbExists = true
-		}
+	if store != nil {
+		store.Close()
 	}
+	store = s
 
-	if err := ensureIndexes(); err != nil {
+	dbExists, err := store.Setup()
+	if err != nil {
 		return err
 	}
 
 	checkBlogSettings()
 
-	if !(dbExists || skipWelcomeData) {
-		if err := createWelcomeData(); err != nil {
+	if dbExists {
+		if err := migrateUserRoles(); err != nil {
+			return err
+		}
+		if err := migrateCommentPaths(); err != nil {
 			return err
 		}
 	}
 
-	return nil
-}
-
-func initConnection(dbPath string) error {
-	var err error
-	mdb, err = mgo.Dial(dbPath)
-	if err != nil {
-		return err
-	}
-	mdb.SetMode(mgo.Monotonic, true)
-
-	comSession = mdb.Copy()
-	mesSession = mdb.Copy()
-	postSession = mdb.Copy()
-	setSession = mdb.Copy()
-	// tagSession = mdb.Copy()
-	userSession = mdb.Copy()
-
-	return nil
-}
-
-func ensureIndexes() error {
-
-	for _, idx := range shema_indexes {
-		err := mdb.DB(DBName).C(idx.name).EnsureIndex(idx.idx)
-		if err != nil {
+	if !(dbExists || skipWelcomeData) {
+		if err := createWelcomeData(); err != nil {
 			return err
 		}
 	}
@@ -148,6 +122,23 @@ func checkBlogSettings() {
 	SetSettingIfNotExists("theme", "default", "blog")
 	SetSettingIfNotExists("title", "My Blog", "blog")
 	SetSettingIfNotExists("description", "Awesome blog created by covrom/dingo.", "blog")
+	SetSettingIfNotExists("revisions_num", strconv.Itoa(defaultRevisionRetention), "blog")
+	SetSettingIfNotExists("comment_rate_limit", strconv.Itoa(defaultCommentRateLimit), "blog")
+	SetSettingIfNotExists("comment_max_links", strconv.Itoa(defaultCommentMaxLinks), "blog")
+	SetSettingIfNotExists("comment_auto_approve", "off", "blog")
+	SetSettingIfNotExists("akismet_url", defaultAkismetUrl, "blog")
+	SetSettingIfNotExists("backup_interval", "0", "blog")
+	SetSettingIfNotExists("backup_keep", strconv.Itoa(defaultBackupKeep), "blog")
+	SetSettingIfNotExists("feed_size", strconv.Itoa(defaultFeedSize), "blog")
+	SetSettingIfNotExists("feed_content", FeedExcerpt, "blog")
+	for _, size := range ImageSizes {
+		SetSettingIfNotExists("image_"+size+"_width", strconv.Itoa(defaultImageSizeWidths[size]), "blog")
+	}
+	SetSettingIfNotExists("image_max_size", strconv.Itoa(defaultImageMaxSize), "blog")
+	SetSettingIfNotExists("preview_ttl", strconv.Itoa(defaultPreviewTTL), "blog")
+	for _, k := range markdownExtensions {
+		SetSettingIfNotExists(k, "on", "blog")
+	}
 }
 
 var Tmp_id_1 = bson.NewObjectId()
@@ -160,7 +151,7 @@ func createWelcomeData() error {
 	p.Title = "Welcome to Dingo!"
 	p.Slug = "welcome-to-dingo"
 	p.Markdown = samplePostContent
-	p.Html = utils.Markdown2Html(p.Markdown)
+	p.Html = RenderMarkdown(p.Markdown)
 	p.AllowComment = true
 	p.Category = ""
 	p.CreatedBy = ""
@@ -184,13 +175,14 @@ func createWelcomeData() error {
 	c.Ip = "127.0.0.1"
 	c.UserAgent = "Mozilla"
 	c.UserId = ""
-	c.Approved = true
+	c.Status = CommentApproved
 	c.Save()
 
 	SetNavigators([]string{"Home"}, []string{"/"})
 	return nil
 }
 
+// DropDatabase removes all the data from the DB.
 func DropDatabase() {
-	mdb.DB(DBName).DropDatabase()
+	store.DropDatabase()
 }
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/covrom/dingo/app/utils"
+	"github.com/globalsign/mgo/bson"
+)
+
+// inviteLifetime is how long an invite can be used to sign up.
+const inviteLifetime = 7 * 24 * time.Hour
+
+// An Invite lets the person with the given email sign up once, with the given
+// role. Like a Token, it is found by its value.
+type Invite struct {
+	Id        bson.ObjectId `bson:"_id" json:"id" meddler:"Id,objectid"`
+	Value     string        `json:"value"`
+	Email     string        `json:"email"`
+	Role      int           `json:"role"`
+	CreatedAt *time.Time    `json:"created_at"`
+	CreatedBy string        `json:"created_by"`
+	ExpiredAt *time.Time    `json:"expired_at"`
+}
+
+// NewInvite creates a new invite for the given email and role, sent by the
+// given user.
+func NewInvite(email string, role int, by *User) (*Invite, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	i := &Invite{
+		Id:        bson.NewObjectId(),
+		Value:     hex.EncodeToString(b),
+		Email:     email,
+		Role:      role,
+		CreatedAt: utils.Now(),
+		CreatedBy: by.Id.Hex(),
+	}
+	expiredAt := i.CreatedAt.Add(inviteLifetime)
+	i.ExpiredAt = &expiredAt
+	return i, nil
+}
+
+// Url returns the signup URL of the invite, relative to the blog URL.
+func (i *Invite) Url() string {
+	return "/signup/?token=" + i.Value
+}
+
+// Save saves the invite in the DB.
+func (i *Invite) Save() error {
+	if len(i.Id) == 0 {
+		i.Id = bson.NewObjectId()
+	}
+	return store.UpsertInvite(i)
+}
+
+// GetInviteByValue gets an invite from the DB based on its value.
+func (i *Invite) GetInviteByValue() error {
+	return store.GetInviteByValue(i.Value, i)
+}
+
+// IsValid checks whether the invite can still be used.
+func (i *Invite) IsValid() bool {
+	return i.ExpiredAt.After(*utils.Now())
+}
+
+// Accept creates the invited user with the given name and password, and
+// deletes the invite so it can not be used again.
+func (i *Invite) Accept(name, password string) (*User, error) {
+	u := NewUser(i.Email, name)
+	u.Role = i.Role
+	if err := u.Create(password); err != nil {
+		return nil, err
+	}
+	return u, store.DeleteInvite(i.Id)
+}
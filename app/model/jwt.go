@@ -108,6 +108,10 @@ func ValidateJWT(t string) (*jwt.Token, error) {
 		if !token.Valid {
 			return token, fmt.Errorf("Invalid token: %s\n", token.Raw)
 		}
+		// Tokens made for a purpose, like the preview links, do not log in.
+		if _, ok := token.Claims.(jwt.MapClaims)["Purpose"]; ok {
+			return token, fmt.Errorf("Invalid token: %s\n", token.Raw)
+		}
 		return token, nil
 	case *jwt.ValidationError:
 		validationErr := err.(*jwt.ValidationError)
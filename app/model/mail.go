@@ -0,0 +1,233 @@
+package model
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSMTPPort is the port of the SMTP server when the "smtp_port"
+	// setting is not set.
+	defaultSMTPPort = "25"
+	// smtpTimeout bounds the whole conversation with the SMTP server.
+	smtpTimeout = 30 * time.Second
+
+	mailQueueSize    = 100
+	mailRetries      = 3
+	mailRetryDelay   = time.Minute
+	mailDateFormat   = "Mon, 02 Jan 2006 15:04:05 -0700"
+	mailContentType  = "text/plain; charset=utf-8"
+	mailTransferType = "8bit"
+)
+
+// A Mail is a plain text email sent by the blog.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// A Mailer sends mails.
+type Mailer interface {
+	SendMail(m *Mail) error
+}
+
+// An SMTPMailer sends mails through an SMTP server.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	From     string
+	// TLS connects to the server over TLS, as is done on port 465.
+	// Otherwise the connection is upgraded with STARTTLS when the server
+	// supports it.
+	TLS bool
+}
+
+// NewSMTPMailer returns an SMTPMailer set up from the "smtp_host",
+// "smtp_port", "smtp_user", "smtp_password", "smtp_from" and "smtp_tls"
+// settings, or nil if no host is set.
+func NewSMTPMailer() *SMTPMailer {
+	host := GetSettingValue("smtp_host")
+	if host == "" {
+		return nil
+	}
+	port := GetSettingValue("smtp_port")
+	if port == "" {
+		port = defaultSMTPPort
+	}
+	from := GetSettingValue("smtp_from")
+	if from == "" {
+		from = GetSettingValue("smtp_user")
+	}
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		User:     GetSettingValue("smtp_user"),
+		Password: GetSettingValue("smtp_password"),
+		From:     from,
+		TLS:      GetSettingValue("smtp_tls") == "on",
+	}
+}
+
+// SendMail sends the mail through the SMTP server.
+func (s *SMTPMailer) SendMail(m *Mail) error {
+	addr := net.JoinHostPort(s.Host, s.Port)
+	tlsConfig := &tls.Config{ServerName: s.Host}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.TLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, smtpTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && !s.TLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.User != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.User, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(m)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message returns the mail with its headers, as sent to the server.
+func (s *SMTPMailer) message(m *Mail) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(mailDateFormat))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: %s\r\n", mailContentType)
+	fmt.Fprintf(&b, "Content-Transfer-Encoding: %s\r\n\r\n", mailTransferType)
+	body := strings.Replace(m.Body, "\r\n", "\n", -1)
+	b.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return b.Bytes()
+}
+
+// settingsMailer returns the mailer set up in the settings, or nil if the
+// blog can not send mails.
+func settingsMailer() Mailer {
+	if m := NewSMTPMailer(); m != nil {
+		return m
+	}
+	return nil
+}
+
+// A MailQueue sends mails in the background, so that a slow mail server does
+// not hold up the requests. Failed mails are tried again later, waiting
+// twice as long each time.
+type MailQueue struct {
+	// Mailer returns the mailer sending the mails, or nil if mails can not
+	// be sent.
+	Mailer  func() Mailer
+	Retries int
+	Delay   time.Duration
+
+	mails chan *queuedMail
+	once  sync.Once
+}
+
+type queuedMail struct {
+	*Mail
+	tries int
+}
+
+// mailQueue is the queue of the mails sent by the blog.
+var mailQueue = NewMailQueue(settingsMailer)
+
+// NewMailQueue returns a new queue sending mails with the given mailer.
+func NewMailQueue(mailer func() Mailer) *MailQueue {
+	return &MailQueue{
+		Mailer:  mailer,
+		Retries: mailRetries,
+		Delay:   mailRetryDelay,
+		mails:   make(chan *queuedMail, mailQueueSize),
+	}
+}
+
+// SendMail queues the mail to be sent by the blog.
+func SendMail(m *Mail) {
+	mailQueue.Push(m)
+}
+
+// Push queues the mail. The mail is dropped if the queue is full.
+func (q *MailQueue) Push(m *Mail) {
+	q.once.Do(func() {
+		go q.run()
+	})
+	q.push(&queuedMail{Mail: m})
+}
+
+func (q *MailQueue) push(m *queuedMail) {
+	select {
+	case q.mails <- m:
+	default:
+		log.Printf("[Error]: The mail queue is full, dropping the mail to %s", m.To)
+	}
+}
+
+func (q *MailQueue) run() {
+	for m := range q.mails {
+		mailer := q.Mailer()
+		if mailer == nil {
+			continue
+		}
+		err := mailer.SendMail(m.Mail)
+		if err == nil {
+			continue
+		}
+		if m.tries >= q.Retries {
+			log.Printf("[Error]: Can not send the mail to %s: %v", m.To, err)
+			continue
+		}
+		m.tries++
+		log.Printf("[Warning]: Can not send the mail to %s, trying again: %v", m.To, err)
+		retry := m
+		time.AfterFunc(q.Delay<<uint(m.tries-1), func() {
+			q.push(retry)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package model
+
+import (
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeSMTPServer is an SMTP server accepting every mail, except the first
+// failures ones which are refused with a temporary error.
+type fakeSMTPServer struct {
+	net.Listener
+	failures int
+	mails    chan string
+}
+
+func newFakeSMTPServer(failures int) *fakeSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s := &fakeSMTPServer{Listener: l, failures: failures, mails: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.Fields(line + " ")[0]); cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			if s.failures > 0 {
+				s.failures--
+				tp.PrintfLine("451 Try again later")
+				continue
+			}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			data, _ := tp.ReadDotBytes()
+			s.mails <- string(data)
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) setup() {
+	host, port, _ := net.SplitHostPort(s.Addr().String())
+	NewSetting("smtp_host", host, "blog").Save()
+	NewSetting("smtp_port", port, "blog").Save()
+	NewSetting("smtp_from", "blog@example.com", "blog").Save()
+	NewSetting("site_url", "http://blog.example.com", "blog").Save()
+}
+
+func (s *fakeSMTPServer) nextMail() string {
+	select {
+	case m := <-s.mails:
+		return m
+	case <-time.After(5 * time.Second):
+		return ""
+	}
+}
+
+func TestMail(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		Initialize("sqlite://:memory:", true)
+		mailQueue.Delay = 10 * time.Millisecond
+
+		Convey("Send a mail through SMTP", func() {
+			server := newFakeSMTPServer(0)
+			defer server.Close()
+			server.setup()
+
+			So(NewSMTPMailer().SendMail(&Mail{To: "reader@example.com", Subject: "Hello", Body: "line\nline"}), ShouldBeNil)
+			mail := server.nextMail()
+			So(mail, ShouldContainSubstring, "To: reader@example.com\n")
+			So(mail, ShouldContainSubstring, "Subject: Hello\n")
+			So(mail, ShouldEndWith, "line\nline\n")
+		})
+
+		Convey("Failed mails are tried again", func() {
+			server := newFakeSMTPServer(2)
+			defer server.Close()
+			server.setup()
+
+			SendMail(&Mail{To: "reader@example.com", Subject: "Retried"})
+			So(server.nextMail(), ShouldContainSubstring, "Subject: Retried")
+		})
+
+		Convey("Notify about comments", func() {
+			server := newFakeSMTPServer(0)
+			defer server.Close()
+			server.setup()
+
+			author := NewUser("author@example.com", "Author")
+			So(author.Create("password"), ShouldBeNil)
+			p := mockPost()
+			p.CreatedBy = author.Id.Hex()
+			So(p.Save(), ShouldBeNil)
+
+			parent := mockComment(Tmp_id_1, p.Id)
+			parent.Email = "reader@example.com"
+			parent.Notify = true
+			So(parent.Save(), ShouldBeNil)
+			reply := mockComment(Tmp_id_1, p.Id)
+			reply.Email = "other@example.com"
+			reply.Parent = parent.Id.Hex()
+
+			Convey("The post author is told about comments awaiting moderation", func() {
+				reply.Status = CommentPending
+				reply.NotifyPostAuthor()
+				mail := server.nextMail()
+				So(mail, ShouldContainSubstring, "To: author@example.com")
+				So(mail, ShouldContainSubstring, "/admin/comments/")
+			})
+
+			Convey("The commenter is told about replies", func() {
+				reply.NotifyParent()
+				mail := server.nextMail()
+				So(mail, ShouldContainSubstring, "To: reader@example.com")
+				So(mail, ShouldContainSubstring, "http://blog.example.com"+parent.UnsubscribeUrl())
+			})
+
+			Convey("Unsubscribe from replies", func() {
+				c := &Comment{Id: parent.Id}
+				So(c.Unsubscribe("forged"), ShouldEqual, ErrInvalidSignature)
+				sig := strings.SplitAfter(parent.UnsubscribeUrl(), "sig=")[1]
+				So(c.Unsubscribe(sig), ShouldBeNil)
+				So(c.Notify, ShouldBeFalse)
+
+				c = &Comment{Id: bson.NewObjectId()}
+				So(c.Unsubscribe(strings.SplitAfter(c.UnsubscribeUrl(), "sig=")[1]), ShouldEqual, ErrNotFound)
+			})
+		})
+
+		Reset(func() {
+			DropDatabase()
+			os.Remove(testPubKey)
+			os.Remove(testPrivKey)
+		})
+	})
+}
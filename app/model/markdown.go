@@ -0,0 +1,72 @@
+package model
+
+import (
+	"github.com/covrom/dingo/app/utils"
+)
+
+// markdownExtensions are the settings turning on the extensions of the
+// Markdown pipeline, all on unless set to "off".
+var markdownExtensions = []string{"markdown_highlight", "markdown_heading_ids", "markdown_toc", "markdown_footnotes"}
+
+// MarkdownOptions returns the extensions of the Markdown pipeline turned on
+// in the settings. Links and images point to the files of the media library
+// by their key.
+func MarkdownOptions() utils.MarkdownOptions {
+	return utils.MarkdownOptions{
+		Highlight:  GetSettingValue("markdown_highlight") != "off",
+		HeadingIDs: GetSettingValue("markdown_heading_ids") != "off",
+		TOC:        GetSettingValue("markdown_toc") != "off",
+		Footnotes:  GetSettingValue("markdown_footnotes") != "off",
+		MediaUrl:   mediaLibraryUrl,
+	}
+}
+
+// mediaLibraryUrl returns the URL of the file of the media library with the
+// key, or an empty string if the library has no such file.
+func mediaLibraryUrl(key string) string {
+	key = CleanMediaKey(key)
+	if key == "" {
+		return ""
+	}
+	if err := store.GetMediaItemByKey(key, new(MediaItem)); err != nil {
+		return ""
+	}
+	return Media().Url(key)
+}
+
+// RenderMarkdown returns the HTML of the Markdown text, rendered by the
+// pipeline set up in the settings.
+func RenderMarkdown(text string) string {
+	return utils.Markdown(text, MarkdownOptions())
+}
+
+// RenderPosts renders the HTML of all the posts and pages again, after the
+// settings of the Markdown pipeline changed. The posts keep their version and
+// revisions, as their content is the same. It returns the slugs of the posts
+// whose HTML changed.
+func RenderPosts() ([]string, error) {
+	opts := MarkdownOptions()
+	var slugs []string
+	for _, isPage := range []bool{false, true} {
+		posts := new(Posts)
+		if err := posts.GetAllPostList(isPage, false, "created_at"); err != nil {
+			return slugs, err
+		}
+		for _, p := range *posts {
+			html := p.Html
+			p.Html = utils.Markdown(p.Markdown, opts)
+			p.sanitizeHtml()
+			if p.Html == html {
+				continue
+			}
+			if err := store.UpsertPost(p); err != nil {
+				return slugs, err
+			}
+			if err := p.saveMediaUsage(); err != nil {
+				return slugs, err
+			}
+			slugs = append(slugs, p.Slug)
+		}
+	}
+	return slugs, nil
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMarkdownPipeline(t *testing.T) {
+	Convey("Initialize the media library", t, func() {
+		Initialize("sqlite://:memory:", true)
+		dir, _ := ioutil.TempDir("", "dingo-media")
+		defer os.RemoveAll(dir)
+		So(InitializeMedia("", dir), ShouldBeNil)
+
+		var img bytes.Buffer
+		png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 32, 20)))
+		_, _, err := SaveUpload("photo.png", img.Bytes(), "uploader")
+		So(err, ShouldBeNil)
+
+		Convey("Turn the extensions on by default", func() {
+			html := RenderMarkdown("# Title\n\n```go\nreturn\n```\n")
+			So(html, ShouldContainSubstring, `<h1 id="title">`)
+			So(html, ShouldContainSubstring, `<span class="hl-keyword">return</span>`)
+		})
+
+		Convey("Turn the extensions off in the settings", func() {
+			So(NewSetting("markdown_highlight", "off", "blog").Save(), ShouldBeNil)
+			So(NewSetting("markdown_heading_ids", "off", "blog").Save(), ShouldBeNil)
+			html := RenderMarkdown("# Title\n")
+			So(html, ShouldEqual, `<h1 id="title">Title</h1>`+"\n")
+
+			So(NewSetting("markdown_toc", "off", "blog").Save(), ShouldBeNil)
+			html = RenderMarkdown("# Title\n\n```go\nreturn\n```\n")
+			So(html, ShouldContainSubstring, "<h1>Title</h1>")
+			So(html, ShouldContainSubstring, `<code class="language-go">return`)
+		})
+
+		Convey("Point the links to the files of the media library", func() {
+			html := RenderMarkdown("![Photo](media:photo.png) [file][photo.png] [missing](media:missing.png)\n")
+			So(html, ShouldContainSubstring, `<img src="/upload/photo.png" alt="Photo" />`)
+			So(html, ShouldContainSubstring, `<a href="/upload/photo.png">file</a>`)
+			So(html, ShouldContainSubstring, `<a href="media:missing.png">missing</a>`)
+
+			p := mockPost()
+			p.Markdown = "![Photo](media:photo.png)"
+			p.Html = RenderMarkdown(p.Markdown)
+			So(p.Save(), ShouldBeNil)
+			posts, err := GetMediaPosts("photo.png")
+			So(err, ShouldBeNil)
+			So(posts, ShouldHaveLength, 1)
+		})
+
+		Convey("Render the posts again when the pipeline changes", func() {
+			p := mockPost()
+			p.Markdown = "# Title"
+			p.Html = RenderMarkdown(p.Markdown)
+			So(p.Save(), ShouldBeNil)
+
+			slugs, err := RenderPosts()
+			So(err, ShouldBeNil)
+			So(slugs, ShouldBeEmpty)
+
+			So(NewSetting("markdown_heading_ids", "off", "blog").Save(), ShouldBeNil)
+			So(NewSetting("markdown_toc", "off", "blog").Save(), ShouldBeNil)
+			slugs, err = RenderPosts()
+			So(err, ShouldBeNil)
+			So(slugs, ShouldResemble, []string{p.Slug})
+
+			stored := &Post{Id: p.Id}
+			So(stored.GetPostById(), ShouldBeNil)
+			So(stored.Html, ShouldEqual, "<h1>Title</h1>\n")
+			So(stored.Version, ShouldEqual, p.Version)
+		})
+	})
+}
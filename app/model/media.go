@@ -0,0 +1,317 @@
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/covrom/dingo/app/utils"
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrInvalidImage is returned when an uploaded image can not be decoded.
+var ErrInvalidImage = errors.New("invalid image")
+
+// A MediaItem is a file of the media library, recorded when it is uploaded.
+type MediaItem struct {
+	Id  bson.ObjectId `bson:"_id" json:"id" meddler:"Id,objectid"`
+	Key string        `json:"key"`
+	// Name is the name of the file as uploaded.
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	// Width and Height are the size in pixels of the images, and zero for
+	// the other files.
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Alt     string `json:"alt"`
+	Caption string `json:"caption"`
+	// Sha256 is the hash of the uploaded content, used to find the files
+	// uploaded twice.
+	Sha256    string     `json:"sha256"`
+	CreatedAt *time.Time `json:"created_at"`
+	CreatedBy string     `json:"created_by"`
+}
+
+// MediaItems is a slice of "MediaItem"s.
+type MediaItems []*MediaItem
+
+// Len returns the amount of "MediaItem"s.
+func (m MediaItems) Len() int {
+	return len(m)
+}
+
+// Get returns the MediaItem at the given index.
+func (m MediaItems) Get(i int) *MediaItem {
+	return m[i]
+}
+
+// Url returns the public URL of the file.
+func (m *MediaItem) Url() string {
+	return Media().Url(m.Key)
+}
+
+// IsImage reports whether the file is an image with generated sizes.
+func (m *MediaItem) IsImage() bool {
+	return IsImageKey(m.Key)
+}
+
+// Uploader returns the User who uploaded the file.
+func (m *MediaItem) Uploader() *User {
+	return (&Post{CreatedBy: m.CreatedBy}).Author()
+}
+
+// Posts returns the posts and pages using the file.
+func (m *MediaItem) Posts() (Posts, error) {
+	return GetMediaPosts(m.Key)
+}
+
+// GetMediaItemById gets the file of the media library with the id.
+func GetMediaItemById(id string) (*MediaItem, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrNotFound
+	}
+	m := new(MediaItem)
+	return m, store.GetMediaItem(bson.ObjectIdHex(id), m)
+}
+
+// GetMediaItemByKey gets the file of the media library with the key.
+func GetMediaItemByKey(key string) (*MediaItem, error) {
+	m := new(MediaItem)
+	return m, store.GetMediaItemByKey(key, m)
+}
+
+// Save saves the metadata of the file.
+func (m *MediaItem) Save() error {
+	return store.UpsertMediaItem(m)
+}
+
+// GetMediaItems returns a page of the files of the media library, newest
+// first, whose name, key, alt text or caption contain the search query.
+func GetMediaItems(search string, page, size int64) (MediaItems, *utils.Pager, error) {
+	q := MediaQuery{Search: search}
+	total, err := store.CountMediaItems(q)
+	if err != nil {
+		return nil, nil, err
+	}
+	pager := utils.NewPager(page, size, total)
+	q.Offset, q.Limit = int(pager.Begin), int(size)
+	var items MediaItems
+	return items, pager, store.FindMediaItems(q, &items)
+}
+
+// SaveUpload stores an uploaded file and records it in the media library.
+// Images are processed and their sizes generated by ProcessImage. When the
+// same content was already uploaded, its MediaItem is returned instead and
+// duplicate is set. The key of the file is made unique, so that an upload
+// never replaces a file of the library.
+func SaveUpload(name string, data []byte, by string) (item *MediaItem, duplicate bool, err error) {
+	sum := sha256.Sum256(data)
+	item = &MediaItem{Sha256: hex.EncodeToString(sum[:])}
+	if err := store.GetMediaItemBySha256(item.Sha256, item); err == nil {
+		return item, true, nil
+	} else if err != ErrNotFound {
+		return nil, false, err
+	}
+
+	key, err := uniqueMediaKey(CleanMediaKey(path.Base(name)))
+	if err != nil {
+		return nil, false, err
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	if IsImageKey(key) {
+		img, err := ProcessImage(key, data)
+		if err == ErrImageTooLarge {
+			return nil, false, err
+		}
+		if err != nil {
+			return nil, false, ErrInvalidImage
+		}
+		if err := img.Put(Media(), key, contentType); err != nil {
+			return nil, false, err
+		}
+		data = img.Data
+	} else if err := Media().Put(key, bytes.NewReader(data), contentType); err != nil {
+		return nil, false, err
+	}
+
+	item.Id = bson.NewObjectId()
+	item.Key = key
+	item.Name = name
+	item.MimeType = contentType
+	item.Size = int64(len(data))
+	item.Width, item.Height = imageDimensions(data)
+	item.CreatedAt = utils.Now()
+	item.CreatedBy = by
+	return item, false, item.Save()
+}
+
+// uniqueMediaKey returns the key, or the key with a number appended when the
+// media library or the media store already has a file with it, like the
+// files uploaded before the library existed.
+func uniqueMediaKey(key string) (string, error) {
+	ext := path.Ext(key)
+	base := strings.TrimSuffix(key, ext)
+	for i := 1; ; i++ {
+		err := store.GetMediaItemByKey(key, new(MediaItem))
+		if err == ErrNotFound {
+			exists, err := Media().Exists(key)
+			if err != nil {
+				return "", err
+			}
+			if !exists {
+				return key, nil
+			}
+		} else if err != nil {
+			return "", err
+		}
+		key = base + "-" + strconv.Itoa(i) + ext
+	}
+}
+
+// imageDimensions returns the size of the image, or zeros if the data is not
+// an image.
+func imageDimensions(data []byte) (int, int) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0
+	}
+	return config.Width, config.Height
+}
+
+// GetMediaPosts returns the posts and pages using the file with the key, or
+// any file under it if the key is a directory.
+func GetMediaPosts(key string) (Posts, error) {
+	ids, err := store.FindMediaPosts(CleanMediaKey(key))
+	if err != nil {
+		return nil, err
+	}
+	posts := make(Posts, 0, len(ids))
+	for _, id := range ids {
+		p := new(Post)
+		if err := p.GetPostById(bson.ObjectIdHex(id)); err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, nil
+}
+
+// DeleteMedia removes the file with the key from the media store, along with
+// the sizes of the images and their records in the media library. A
+// directory is removed with everything under it.
+func DeleteMedia(key string) error {
+	key = CleanMediaKey(key)
+	if err := Media().Delete(key); err != nil {
+		return err
+	}
+	if err := DeleteImageSizes(Media(), key); err != nil {
+		return err
+	}
+	return store.DeleteMediaItems(key)
+}
+
+// mediaUrlRe matches the URLs of the files of the media store in a text,
+// capturing their keys.
+func mediaUrlRe() *regexp.Regexp {
+	bases := []string{regexp.QuoteMeta(Media().Url(""))}
+	if base := Media().Url(""); strings.HasPrefix(base, "/") {
+		bases = append([]string{regexp.QuoteMeta(AbsoluteUrl(base))}, bases...)
+	}
+	return regexp.MustCompile(`(?:` + strings.Join(bases, "|") + `)([^\s"'()<>\[\]]+)`)
+}
+
+// mediaKeys returns the keys of the files of the media store the texts
+// refer to. The sizes of the images stand for the images themselves.
+func mediaKeys(texts ...string) []string {
+	re := mediaUrlRe()
+	seen := make(map[string]bool)
+	var keys []string
+	for _, text := range texts {
+		for _, m := range re.FindAllStringSubmatch(text, -1) {
+			key := m[1]
+			if u, err := url.Parse(key); err == nil {
+				key = u.Path
+			}
+			key = imageOriginalKey(CleanMediaKey(key))
+			if key != "" && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// saveMediaUsage records the files of the media store used by the post.
+func (p *Post) saveMediaUsage() error {
+	return store.SetPostMedia(p.Id.Hex(), mediaKeys(p.Markdown, p.Html, p.Image))
+}
+
+// IndexMedia records the files of the local store missing from the media
+// library, like those uploaded before it existed, and records the files
+// used by every post. It returns the keys of the files recorded.
+func IndexMedia(s *LocalMediaStore) ([]string, error) {
+	var keys []string
+	err := s.Walk(func(key, file string) error {
+		if IsImageSizeKey(key) {
+			return nil
+		}
+		if err := store.GetMediaItemByKey(key, new(MediaItem)); err != ErrNotFound {
+			return err
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		contentType := mime.TypeByExtension(path.Ext(key))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		item := &MediaItem{
+			Id:        bson.NewObjectId(),
+			Key:       key,
+			Name:      path.Base(key),
+			MimeType:  contentType,
+			Size:      int64(len(data)),
+			Sha256:    hex.EncodeToString(sum[:]),
+			CreatedAt: utils.Now(),
+		}
+		item.Width, item.Height = imageDimensions(data)
+		if err := item.Save(); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var posts Posts
+	if err := store.FindPosts(PostQuery{}, &posts); err != nil {
+		return nil, err
+	}
+	for _, p := range posts {
+		if err := p.saveMediaUsage(); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
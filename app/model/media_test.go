@@ -0,0 +1,151 @@
+package model
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMediaLibrary(t *testing.T) {
+	Convey("Initialize the media library", t, func() {
+		Initialize("sqlite://:memory:", true)
+		dir, _ := ioutil.TempDir("", "dingo-media")
+		So(InitializeMedia("", dir), ShouldBeNil)
+
+		var img bytes.Buffer
+		png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 320, 200)))
+
+		Convey("Record the uploads", func() {
+			item, duplicate, err := SaveUpload("photo.png", img.Bytes(), "uploader")
+			So(err, ShouldBeNil)
+			So(duplicate, ShouldBeFalse)
+			So(item.Key, ShouldEqual, "photo.png")
+			So(item.MimeType, ShouldEqual, "image/png")
+			So(item.Width, ShouldEqual, 320)
+			So(item.Height, ShouldEqual, 200)
+			So(item.Sha256, ShouldHaveLength, 64)
+			So(item.CreatedBy, ShouldEqual, "uploader")
+
+			Convey("Give back the file when the same content is uploaded again", func() {
+				again, duplicate, err := SaveUpload("other.png", img.Bytes(), "uploader")
+				So(err, ShouldBeNil)
+				So(duplicate, ShouldBeTrue)
+				So(again.Id, ShouldEqual, item.Id)
+				_, err = os.Stat(filepath.Join(dir, "other.png"))
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+
+			Convey("Never replace a file missing from the library", func() {
+				legacy := filepath.Join(dir, "legacy.txt")
+				So(ioutil.WriteFile(legacy, []byte("legacy"), 0644), ShouldBeNil)
+				other, _, err := SaveUpload("legacy.txt", []byte("other"), "uploader")
+				So(err, ShouldBeNil)
+				So(other.Key, ShouldEqual, "legacy-1.txt")
+				data, _ := ioutil.ReadFile(legacy)
+				So(string(data), ShouldEqual, "legacy")
+			})
+
+			Convey("Never replace a file with another content", func() {
+				other, _, err := SaveUpload("photo.png", append(img.Bytes(), 0), "uploader")
+				So(err, ShouldBeNil)
+				So(other.Key, ShouldEqual, "photo-1.png")
+			})
+
+			Convey("Refuse invalid images", func() {
+				_, _, err := SaveUpload("fake.png", []byte("not an image"), "uploader")
+				So(err, ShouldEqual, ErrInvalidImage)
+				_, _, err = SaveUpload("huge.png", hugePng(100000, 100000), "uploader")
+				So(err, ShouldEqual, ErrImageTooLarge)
+			})
+
+			Convey("Search the library", func() {
+				SaveUpload("notes.txt", []byte("notes"), "uploader")
+				item.Alt = "A sunset"
+				So(item.Save(), ShouldBeNil)
+
+				items, pager, err := GetMediaItems("", 1, 1)
+				So(err, ShouldBeNil)
+				So(pager.Pages, ShouldEqual, 2)
+				So(items, ShouldHaveLength, 1)
+				So(items[0].Key, ShouldEqual, "notes.txt")
+
+				items, _, err = GetMediaItems("sunset", 1, 10)
+				So(err, ShouldBeNil)
+				So(items, ShouldHaveLength, 1)
+				So(items[0].Id, ShouldEqual, item.Id)
+			})
+
+			Convey("Track the posts using the files", func() {
+				NewSetting("site_url", "http://example.com", "blog").Save()
+				p := mockPost()
+				p.Markdown = "![](/upload/photo@medium.png) [notes](http://example.com/upload/notes.txt)"
+				So(p.Save(), ShouldBeNil)
+				page := mockPost()
+				page.Slug = "about"
+				page.Markdown = "Nothing uploaded"
+				page.Image = "/upload/photo.png"
+				So(page.Save(), ShouldBeNil)
+
+				posts, err := GetMediaPosts("photo.png")
+				So(err, ShouldBeNil)
+				So(posts, ShouldHaveLength, 2)
+				posts, err = GetMediaPosts("notes.txt")
+				So(err, ShouldBeNil)
+				So(posts, ShouldHaveLength, 1)
+				posts, err = GetMediaPosts("")
+				So(err, ShouldBeNil)
+				So(posts, ShouldHaveLength, 0)
+
+				p.Markdown = "No more files"
+				So(p.Save(), ShouldBeNil)
+				posts, _ = GetMediaPosts("photo.png")
+				So(posts, ShouldHaveLength, 1)
+				So(DeletePostById(page.Id.Hex()), ShouldBeNil)
+				posts, _ = GetMediaPosts("photo.png")
+				So(posts, ShouldHaveLength, 0)
+			})
+
+			Convey("Delete the files along with their sizes and records", func() {
+				So(DeleteMedia("photo.png"), ShouldBeNil)
+				files, _ := ioutil.ReadDir(dir)
+				So(files, ShouldBeEmpty)
+				_, err := GetMediaItemByKey("photo.png")
+				So(err, ShouldEqual, ErrNotFound)
+			})
+		})
+
+		Convey("Record the files uploaded before the library", func() {
+			Media().Put("2018/old.txt", strings.NewReader("old"), "text/plain")
+			Media().Put("2018/old@thumbnail.png", bytes.NewReader(img.Bytes()), "image/png")
+			p := mockPost()
+			p.Markdown = "[old](/upload/2018/old.txt)"
+			So(store.InsertPost(p), ShouldBeNil)
+
+			keys, err := IndexMedia(Media().(*LocalMediaStore))
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"2018/old.txt"})
+			item, err := GetMediaItemByKey("2018/old.txt")
+			So(err, ShouldBeNil)
+			So(item.Size, ShouldEqual, 3)
+			posts, err := GetMediaPosts("2018/")
+			So(err, ShouldBeNil)
+			So(posts, ShouldHaveLength, 1)
+
+			keys, err = IndexMedia(Media().(*LocalMediaStore))
+			So(err, ShouldBeNil)
+			So(keys, ShouldBeEmpty)
+		})
+
+		Reset(func() {
+			os.RemoveAll(dir)
+			InitializeMedia("", "upload")
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"html/template"
 	"log"
 	"strings"
 	"time"
@@ -17,12 +18,13 @@ func init() {
 	messageGenerator = make(map[string]func(v interface{}) string)
 	messageGenerator["comment"] = generateCommentMessage
 	messageGenerator["backup"] = generateBackupMessage
+	messageGenerator["publish"] = generatePublishMessage
 }
 
 // A Message is a simple bit of info, used to alert the admin on the admin
 // panel about things like new comments, etc.
 type Message struct {
-	Id        bson.ObjectId `bson:"_id"`
+	Id        bson.ObjectId `bson:"_id" meddler:"Id,objectid"`
 	Type      string        //`meddler:"type"`
 	Data      string        //`meddler:"data"`
 	IsRead    bool          //`meddler:"is_read"`
@@ -55,9 +57,7 @@ func NewMessage(tp string, data interface{}) *Message {
 
 // Insert saves a message to the DB.
 func (m *Message) Insert() error {
-
-	err := mesSession.Clone().DB(DBName).C("messages").Insert(m)
-	return err
+	return store.InsertMessage(m)
 }
 
 // SetMessageGenerator maps a message generator's name to a function.
@@ -67,8 +67,7 @@ func SetMessageGenerator(name string, fn func(v interface{}) string) {
 
 // GetUnreadMessages gets all unread messages from the DB.
 func (m *Messages) GetUnreadMessages() {
-	err := mesSession.Clone().DB(DBName).C("messages").Find(bson.M{"isread": false}).Sort("-createdat").Limit(10).All(m)
-
+	err := store.FindUnreadMessages(10, m)
 	if err != nil {
 		panic(err)
 	}
@@ -87,15 +86,15 @@ func generateCommentMessage(co interface{}) string {
 	}
 	var s string
 	if len(c.Parent) == 0 {
-		s = "<p>" + c.Author + " commented on post <i>" + string(post.Title) + "</i>: </p><p>"
+		s = "<p>" + template.HTMLEscapeString(c.Author) + " commented on post <i>" + template.HTMLEscapeString(post.Title) + "</i>: </p><p>"
 		s += utils.Html2Str(c.Content) + "</p>"
 	} else {
 		pc := &Comment{Id: bson.ObjectIdHex(c.Parent)}
 		err = pc.GetCommentById()
 		if err != nil {
-			s = "<p>" + c.Author + " commented on post <i>" + string(post.Title) + "</i>: </p><p>"
+			s = "<p>" + template.HTMLEscapeString(c.Author) + " commented on post <i>" + template.HTMLEscapeString(post.Title) + "</i>: </p><p>"
 		} else {
-			s = "<p>" + c.Author + " replied " + pc.Author + "'s comment on <i>" + string(post.Title) + "</i>: </p><p>"
+			s = "<p>" + template.HTMLEscapeString(c.Author) + " replied " + template.HTMLEscapeString(pc.Author) + "'s comment on <i>" + template.HTMLEscapeString(post.Title) + "</i>: </p><p>"
 			s += utils.Html2Str(c.Content) + "</p>"
 		}
 	}
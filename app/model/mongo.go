@@ -0,0 +1,627 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// mongoStore is the MongoDB storage backend.
+type mongoStore struct {
+	session *mgo.Session
+}
+
+func newMongoStore(dbUrl string) (*mongoStore, error) {
+	session, err := mgo.Dial(dbUrl)
+	if err != nil {
+		return nil, err
+	}
+	session.SetMode(mgo.Monotonic, true)
+	return &mongoStore{session: session}, nil
+}
+
+// with runs fn against the named collection, using a fresh copy of the
+// session that is closed afterwards.
+func (s *mongoStore) with(name string, fn func(c *mgo.Collection) error) error {
+	session := s.session.Clone()
+	defer session.Close()
+	err := fn(session.DB(DBName).C(name))
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *mongoStore) Setup() (bool, error) {
+	dbnames, err := s.session.DatabaseNames()
+	if err != nil {
+		return false, err
+	}
+
+	dbExists := false
+	for _, n := range dbnames {
+		if n == DBName {
+			dbExists = true
+		}
+	}
+
+	for _, idx := range shema_indexes {
+		err := s.with(idx.name, func(c *mgo.Collection) error {
+			return c.EnsureIndex(idx.idx)
+		})
+		if err != nil {
+			return dbExists, err
+		}
+	}
+	return dbExists, s.with("comments", migrateCommentStatus)
+}
+
+// migrateCommentStatus sets the moderation status of the comments saved with
+// the approved and spam flags it replaces.
+func migrateCommentStatus(c *mgo.Collection) error {
+	noStatus := bson.M{"$exists": false}
+	updates := []struct {
+		selector, update bson.M
+	}{
+		{bson.M{"status": noStatus, "spam": true}, bson.M{"$set": bson.M{"status": CommentSpam}, "$rename": bson.M{"spamreason": "reason"}}},
+		{bson.M{"status": noStatus, "approved": true}, bson.M{"$set": bson.M{"status": CommentApproved}}},
+		{bson.M{"status": noStatus}, bson.M{"$set": bson.M{"status": CommentPending}}},
+		{bson.M{"approved": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"approved": "", "spam": "", "spamreason": ""}}},
+	}
+	for _, u := range updates {
+		if _, err := c.UpdateAll(u.selector, u.update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mongoStore) DropDatabase() error {
+	session := s.session.Clone()
+	defer session.Close()
+	return session.DB(DBName).DropDatabase()
+}
+
+func (s *mongoStore) Close() error {
+	s.session.Close()
+	return nil
+}
+
+func postSelector(q PostQuery) bson.M {
+	m := bson.M{}
+	if q.IsPage != nil {
+		m["ispage"] = *q.IsPage
+	}
+	if q.IsPublished != nil {
+		m["ispublished"] = *q.IsPublished
+	}
+	if q.IsScheduled != nil {
+		m["isscheduled"] = *q.IsScheduled
+	}
+	if q.PublishedBefore != nil {
+		m["publishedat"] = bson.M{"$lte": *q.PublishedBefore}
+	}
+	if q.TagSlug != "" {
+		m["tags.slug"] = q.TagSlug
+	}
+	if q.CreatedBy != "" {
+		m["createdby"] = q.CreatedBy
+	}
+	if q.Search != "" {
+		m["$text"] = bson.M{"$search": q.Search}
+	}
+	return m
+}
+
+func (s *mongoStore) InsertPost(p *Post) error {
+	return s.with("posts", func(c *mgo.Collection) error {
+		return c.Insert(p)
+	})
+}
+
+func (s *mongoStore) UpsertPost(p *Post) error {
+	return s.with("posts", func(c *mgo.Collection) error {
+		_, err := c.UpsertId(p.Id, p)
+		return err
+	})
+}
+
+func (s *mongoStore) UpdatePost(p *Post, version int64) error {
+	// The posts saved before they had versions have no version field.
+	selector := bson.M{"_id": p.Id, "version": version}
+	if version == 0 {
+		selector["version"] = bson.M{"$in": []interface{}{0, nil}}
+	}
+	err := s.with("posts", func(c *mgo.Collection) error {
+		return c.Update(selector, p)
+	})
+	if err == ErrNotFound {
+		return ErrConflict
+	}
+	return err
+}
+
+func (s *mongoStore) DeletePost(id bson.ObjectId) error {
+	return s.with("posts", func(c *mgo.Collection) error {
+		return c.RemoveId(id)
+	})
+}
+
+func (s *mongoStore) IncrementCommentNum(id bson.ObjectId) error {
+	return s.with("posts", func(c *mgo.Collection) error {
+		return c.UpdateId(id, bson.M{"$inc": bson.M{"commentnum": 1}})
+	})
+}
+
+func (s *mongoStore) GetPost(id bson.ObjectId, p *Post) error {
+	return s.with("posts", func(c *mgo.Collection) error {
+		return c.FindId(id).One(p)
+	})
+}
+
+func (s *mongoStore) GetPostBySlug(slug string, p *Post) error {
+	return s.with("posts", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"slug": slug}).One(p)
+	})
+}
+
+func (s *mongoStore) CountPosts(q PostQuery) (int64, error) {
+	var count int
+	err := s.with("posts", func(c *mgo.Collection) error {
+		var err error
+		count, err = c.Find(postSelector(q)).Count()
+		return err
+	})
+	return int64(count), err
+}
+
+func (s *mongoStore) FindPosts(q PostQuery, posts *Posts) error {
+	return s.with("posts", func(c *mgo.Collection) error {
+		query := c.Find(postSelector(q)).Sort(getSafeOrderByStmt(q.OrderBy)).Skip(q.Offset)
+		if q.Limit > 0 {
+			query = query.Limit(q.Limit)
+		}
+		return query.All(posts)
+	})
+}
+
+func (s *mongoStore) ReassignPosts(from, to string) error {
+	return s.with("posts", func(c *mgo.Collection) error {
+		_, err := c.UpdateAll(bson.M{"createdby": from}, bson.M{"$set": bson.M{"createdby": to}})
+		return err
+	})
+}
+
+func (s *mongoStore) GetAllTags() (Tags, error) {
+	var tags Tags
+	err := s.with("posts", func(c *mgo.Collection) error {
+		return c.Find(nil).Distinct("tags", &tags)
+	})
+	return tags.GetDistinctBySlug(), err
+}
+
+func commentSelector(q CommentQuery) bson.M {
+	m := bson.M{}
+	if q.PostId != "" {
+		m["postid"] = q.PostId
+	}
+	if q.Parent != nil {
+		m["parent"] = *q.Parent
+	}
+	if len(q.Status) > 0 {
+		m["status"] = bson.M{"$in": q.Status}
+	}
+	if q.Email != "" {
+		m["email"] = q.Email
+	}
+	if q.PathPrefix != "" {
+		m["path"] = bson.RegEx{Pattern: "^" + regexp.QuoteMeta(q.PathPrefix)}
+	}
+	if q.MissingPath {
+		m["parent"] = bson.M{"$ne": ""}
+		m["path"] = bson.M{"$in": []interface{}{"", nil}}
+	}
+	if q.After != nil {
+		op := "$gt"
+		if q.OrderBy == "created_at DESC" {
+			op = "$lt"
+		}
+		m["$or"] = []bson.M{
+			{"createdat": bson.M{op: q.After.CreatedAt}},
+			{"createdat": q.After.CreatedAt, "_id": bson.M{op: q.After.Id}},
+		}
+	}
+	return m
+}
+
+func (s *mongoStore) UpsertComment(cm *Comment) error {
+	return s.with("comments", func(c *mgo.Collection) error {
+		_, err := c.UpsertId(cm.Id, cm)
+		return err
+	})
+}
+
+func (s *mongoStore) DeleteComment(id bson.ObjectId) error {
+	return s.with("comments", func(c *mgo.Collection) error {
+		return c.RemoveId(id)
+	})
+}
+
+func (s *mongoStore) GetComment(id bson.ObjectId, cm *Comment) error {
+	return s.with("comments", func(c *mgo.Collection) error {
+		return c.FindId(id).One(cm)
+	})
+}
+
+func (s *mongoStore) CountComments(q CommentQuery) (int64, error) {
+	var count int
+	err := s.with("comments", func(c *mgo.Collection) error {
+		var err error
+		count, err = c.Find(commentSelector(q)).Count()
+		return err
+	})
+	return int64(count), err
+}
+
+func (s *mongoStore) FindComments(q CommentQuery, comments *Comments) error {
+	return s.with("comments", func(c *mgo.Collection) error {
+		query := c.Find(commentSelector(q))
+		switch q.OrderBy {
+		case "created_at":
+			query = query.Sort("createdat", "_id")
+		case "created_at DESC":
+			query = query.Sort("-createdat", "-_id")
+		}
+		query = query.Skip(q.Offset)
+		if q.Limit > 0 {
+			query = query.Limit(q.Limit)
+		}
+		return query.All(comments)
+	})
+}
+
+func (s *mongoStore) UpsertUser(u *User) error {
+	return s.with("users", func(c *mgo.Collection) error {
+		_, err := c.UpsertId(u.Id, u)
+		return err
+	})
+}
+
+func (s *mongoStore) GetUser(id bson.ObjectId, u *User) error {
+	return s.with("users", func(c *mgo.Collection) error {
+		return c.FindId(id).One(u)
+	})
+}
+
+func (s *mongoStore) GetUserBySlug(slug string, u *User) error {
+	return s.with("users", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"slug": slug}).One(u)
+	})
+}
+
+func (s *mongoStore) GetUserByName(name string, u *User) error {
+	return s.with("users", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"name": name}).One(u)
+	})
+}
+
+func (s *mongoStore) GetUserByEmail(email string, u *User) error {
+	return s.with("users", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"email": email}).One(u)
+	})
+}
+
+func (s *mongoStore) CountUsers() (int64, error) {
+	var count int
+	err := s.with("users", func(c *mgo.Collection) error {
+		var err error
+		count, err = c.Count()
+		return err
+	})
+	return int64(count), err
+}
+
+func (s *mongoStore) FindUsers(offset, limit int, users *Users) error {
+	return s.with("users", func(c *mgo.Collection) error {
+		query := c.Find(nil).Sort("createdat").Skip(offset)
+		if limit > 0 {
+			query = query.Limit(limit)
+		}
+		return query.All(users)
+	})
+}
+
+func (s *mongoStore) DeleteUser(id bson.ObjectId) error {
+	return s.with("users", func(c *mgo.Collection) error {
+		return c.RemoveId(id)
+	})
+}
+
+func (s *mongoStore) UpsertRoleUser(ru *RolesUsers) error {
+	return s.with("rolesusers", func(c *mgo.Collection) error {
+		_, err := c.Upsert(bson.M{"userid": ru.UserId}, ru)
+		return err
+	})
+}
+
+func (s *mongoStore) GetRoleUser(userId string, ru *RolesUsers) error {
+	return s.with("rolesusers", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"userid": userId}).One(ru)
+	})
+}
+
+func (s *mongoStore) DeleteRoleUser(userId string) error {
+	return s.with("rolesusers", func(c *mgo.Collection) error {
+		return c.Remove(bson.M{"userid": userId})
+	})
+}
+
+func (s *mongoStore) UpsertSetting(setting *Setting) error {
+	return s.with("settings", func(c *mgo.Collection) error {
+		_, err := c.Upsert(bson.M{"key": setting.Key}, setting)
+		return err
+	})
+}
+
+func (s *mongoStore) GetSetting(key string, setting *Setting) error {
+	return s.with("settings", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"key": key}).One(setting)
+	})
+}
+
+func (s *mongoStore) FindSettingsByType(t string, settings *Settings) error {
+	return s.with("settings", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"type": t}).All(settings)
+	})
+}
+
+func (s *mongoStore) FindSettings(settings *Settings) error {
+	return s.with("settings", func(c *mgo.Collection) error {
+		return c.Find(nil).Sort("key").All(settings)
+	})
+}
+
+func (s *mongoStore) DeleteSetting(key string) error {
+	return s.with("settings", func(c *mgo.Collection) error {
+		return c.Remove(bson.M{"key": key})
+	})
+}
+
+func (s *mongoStore) UpsertToken(t *Token) error {
+	return s.with("tokens", func(c *mgo.Collection) error {
+		_, err := c.UpsertId(t.Id, t)
+		return err
+	})
+}
+
+func (s *mongoStore) GetTokenByValue(value string, t *Token) error {
+	return s.with("tokens", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"value": value}).One(t)
+	})
+}
+
+func (s *mongoStore) UpsertInvite(i *Invite) error {
+	return s.with("invites", func(c *mgo.Collection) error {
+		_, err := c.UpsertId(i.Id, i)
+		return err
+	})
+}
+
+func (s *mongoStore) GetInviteByValue(value string, i *Invite) error {
+	return s.with("invites", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"value": value}).One(i)
+	})
+}
+
+func (s *mongoStore) DeleteInvite(id bson.ObjectId) error {
+	return s.with("invites", func(c *mgo.Collection) error {
+		return c.RemoveId(id)
+	})
+}
+
+func (s *mongoStore) InsertRevision(r *Revision) error {
+	return s.with("post_revisions", func(c *mgo.Collection) error {
+		return c.Insert(r)
+	})
+}
+
+func (s *mongoStore) GetRevision(id bson.ObjectId, r *Revision) error {
+	return s.with("post_revisions", func(c *mgo.Collection) error {
+		return c.FindId(id).One(r)
+	})
+}
+
+func (s *mongoStore) FindRevisions(postId string, revisions *Revisions) error {
+	return s.with("post_revisions", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"postid": postId}).Sort("-createdat", "-_id").All(revisions)
+	})
+}
+
+func (s *mongoStore) PruneRevisions(postId string, keep int) error {
+	return s.with("post_revisions", func(c *mgo.Collection) error {
+		var old []struct {
+			Id bson.ObjectId `bson:"_id"`
+		}
+		err := c.Find(bson.M{"postid": postId}).Sort("-createdat", "-_id").Select(bson.M{"_id": 1}).Skip(keep).All(&old)
+		if err != nil || len(old) == 0 {
+			return err
+		}
+		ids := make([]bson.ObjectId, len(old))
+		for i := range old {
+			ids[i] = old[i].Id
+		}
+		_, err = c.RemoveAll(bson.M{"_id": bson.M{"$in": ids}})
+		return err
+	})
+}
+
+func (s *mongoStore) InsertMessage(m *Message) error {
+	return s.with("messages", func(c *mgo.Collection) error {
+		return c.Insert(m)
+	})
+}
+
+func (s *mongoStore) UpsertMessage(m *Message) error {
+	return s.with("messages", func(c *mgo.Collection) error {
+		_, err := c.UpsertId(m.Id, m)
+		return err
+	})
+}
+
+func (s *mongoStore) FindUnreadMessages(limit int, messages *Messages) error {
+	return s.with("messages", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"isread": false}).Sort("-createdat").Limit(limit).All(messages)
+	})
+}
+
+func (s *mongoStore) FindMessages(messages *Messages) error {
+	return s.with("messages", func(c *mgo.Collection) error {
+		return c.Find(nil).Sort("-createdat").All(messages)
+	})
+}
+
+func (s *mongoStore) AddPostHits(postId string, day string, hits int64) error {
+	return s.with("post_stats", func(c *mgo.Collection) error {
+		_, err := c.Upsert(bson.M{"postid": postId, "day": day}, bson.M{"$inc": bson.M{"hits": hits}})
+		return err
+	})
+}
+
+func (s *mongoStore) TopPostHits(n int, since string) ([]*PostHits, error) {
+	var hits []*PostHits
+	err := s.with("post_stats", func(c *mgo.Collection) error {
+		return c.Pipe([]bson.M{
+			{"$match": bson.M{"postid": bson.M{"$ne": ""}, "day": bson.M{"$gte": since}}},
+			{"$group": bson.M{"_id": "$postid", "hits": bson.M{"$sum": "$hits"}}},
+			{"$sort": bson.M{"hits": -1}},
+			{"$limit": n},
+		}).All(&hits)
+	})
+	return hits, err
+}
+
+func (s *mongoStore) DailyHits(since string) ([]*DayHits, error) {
+	var hits []*DayHits
+	err := s.with("post_stats", func(c *mgo.Collection) error {
+		return c.Pipe([]bson.M{
+			{"$match": bson.M{"day": bson.M{"$gte": since}}},
+			{"$group": bson.M{"_id": "$day", "hits": bson.M{"$sum": "$hits"}}},
+			{"$sort": bson.M{"_id": 1}},
+		}).All(&hits)
+	})
+	return hits, err
+}
+
+// mediaKeySelector returns the selector matching the key, or every key under
+// it if it is a directory.
+func mediaKeySelector(key string) interface{} {
+	if strings.HasSuffix(key, "/") {
+		return bson.RegEx{Pattern: "^" + regexp.QuoteMeta(key)}
+	}
+	return key
+}
+
+func mediaSelector(q MediaQuery) bson.M {
+	m := bson.M{}
+	if q.Search != "" {
+		re := bson.RegEx{Pattern: regexp.QuoteMeta(q.Search), Options: "i"}
+		m["$or"] = []bson.M{{"name": re}, {"key": re}, {"alt": re}, {"caption": re}}
+	}
+	return m
+}
+
+func (s *mongoStore) UpsertMediaItem(m *MediaItem) error {
+	return s.with("media", func(c *mgo.Collection) error {
+		_, err := c.UpsertId(m.Id, m)
+		return err
+	})
+}
+
+func (s *mongoStore) GetMediaItem(id bson.ObjectId, m *MediaItem) error {
+	return s.with("media", func(c *mgo.Collection) error {
+		return c.FindId(id).One(m)
+	})
+}
+
+func (s *mongoStore) GetMediaItemByKey(key string, m *MediaItem) error {
+	return s.with("media", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"key": key}).One(m)
+	})
+}
+
+func (s *mongoStore) GetMediaItemBySha256(sum string, m *MediaItem) error {
+	return s.with("media", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"sha256": sum}).One(m)
+	})
+}
+
+func (s *mongoStore) DeleteMediaItems(key string) error {
+	return s.with("media", func(c *mgo.Collection) error {
+		_, err := c.RemoveAll(bson.M{"key": mediaKeySelector(key)})
+		return err
+	})
+}
+
+func (s *mongoStore) CountMediaItems(q MediaQuery) (int64, error) {
+	var count int
+	err := s.with("media", func(c *mgo.Collection) error {
+		var err error
+		count, err = c.Find(mediaSelector(q)).Count()
+		return err
+	})
+	return int64(count), err
+}
+
+func (s *mongoStore) FindMediaItems(q MediaQuery, items *MediaItems) error {
+	return s.with("media", func(c *mgo.Collection) error {
+		return c.Find(mediaSelector(q)).Sort("-createdat", "-_id").Skip(q.Offset).Limit(q.Limit).All(items)
+	})
+}
+
+func (s *mongoStore) SetPostMedia(postId string, keys []string) error {
+	return s.with("post_media", func(c *mgo.Collection) error {
+		if _, err := c.RemoveAll(bson.M{"postid": postId}); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := c.Insert(bson.M{"postid": postId, "key": key}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *mongoStore) FindMediaPosts(key string) ([]string, error) {
+	var ids []string
+	err := s.with("post_media", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"key": mediaKeySelector(key)}).Sort("postid").Distinct("postid", &ids)
+	})
+	return ids, err
+}
+
+func (s *mongoStore) UpsertAutosave(a *Autosave) error {
+	return s.with("autosaves", func(c *mgo.Collection) error {
+		_, err := c.Upsert(bson.M{"postid": a.PostId, "userid": a.UserId}, a)
+		return err
+	})
+}
+
+func (s *mongoStore) GetAutosave(postId, userId string, a *Autosave) error {
+	return s.with("autosaves", func(c *mgo.Collection) error {
+		return c.Find(bson.M{"postid": postId, "userid": userId}).One(a)
+	})
+}
+
+func (s *mongoStore) DeleteAutosaves(postId, userId string) error {
+	selector := bson.M{"postid": postId}
+	if userId != "" {
+		selector["userid"] = userId
+	}
+	return s.with("autosaves", func(c *mgo.Collection) error {
+		_, err := c.RemoveAll(selector)
+		return err
+	})
+}
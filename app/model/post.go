@@ -7,14 +7,16 @@ import (
 	"strings"
 	"time"
 
-	"github.com/globalsign/mgo"
-
 	"net/http"
 
 	"github.com/covrom/dingo/app/utils"
 	"github.com/globalsign/mgo/bson"
 )
 
+// publishDateFormat is the format of the publish date sent by the editor, as
+// filled in by a datetime-local input.
+const publishDateFormat = "2006-01-02T15:04"
+
 var safeOrderByStmt = map[string]string{
 	"created_at":        "createdat",
 	"created_at DESC":   "-createdat",
@@ -25,9 +27,11 @@ var safeOrderByStmt = map[string]string{
 }
 
 // A Post contains all the content required to populate a post or page on the
-// blog. It also contains info to help sort and display the post.
+// blog. It also contains info to help sort and display the post. Its Version
+// counts its updates, see Update. Its comments close CommentDays days after it
+// is published, unless it is zero.
 type Post struct {
-	Id              bson.ObjectId `bson:"_id" json:"id"`
+	Id              bson.ObjectId `bson:"_id" json:"id" meddler:"Id,objectid"`
 	Title           string        `json:"title"`
 	Slug            string        `json:"slug"`
 	Markdown        string        `json:"markdown"`
@@ -36,8 +40,10 @@ type Post struct {
 	IsFeatured      bool          `json:"featured"`
 	IsPage          bool          `json:"is_page"` // Using "is_page" instead of "page" since nouns are generally non-bools
 	AllowComment    bool          `json:"allow_comment"`
+	CommentDays     int64         `json:"comment_days"`
 	CommentNum      int64         `json:"comment_num"`
 	IsPublished     bool          `json:"published"`
+	IsScheduled     bool          `json:"scheduled"`
 	Language        string        `json:"language"`
 	MetaTitle       string        `json:"meta_title"`
 	MetaDescription string        `json:"meta_description"`
@@ -45,11 +51,12 @@ type Post struct {
 	CreatedBy       string        `json:"created_by"`
 	UpdatedAt       *time.Time    `json:"updated_at"`
 	UpdatedBy       string        `json:"updated_by"`
-	PublishedAt     *time.Time    `json:"published_at"`
+	PublishedAt     *time.Time    `json:"published_at" meddler:"PublishedAt,localtime"`
 	PublishedBy     string        `json:"published_by"`
-	Tags            Tags          `json:"tags"`
-	Hits            int64         `json:"-" bson:"-"`
-	Category        string        `json:"-" bson:"-"`
+	Tags            Tags          `json:"tags" meddler:"Tags,json"`
+	Version         int64         `json:"version"`
+	Hits            int64         `json:"-" bson:"-" meddler:"-"`
+	Category        string        `json:"-" bson:"-" meddler:"-"`
 }
 
 // Posts is a slice of "Post"s
@@ -79,6 +86,12 @@ func NewPost() *Post {
 	}
 }
 
+// IsPublic reports whether the post can be shown to the readers: it has to be
+// published, with a publish date that is not in the future.
+func (p *Post) IsPublic() bool {
+	return p.IsPublished && (p.PublishedAt == nil || !p.PublishedAt.After(*utils.Now()))
+}
+
 // TagString returns all the tags associated with a post as a single string.
 func (p *Post) TagString() string {
 
@@ -103,10 +116,22 @@ func (p *Post) Author() *User {
 	return user
 }
 
-// Comments returns all the comments associated with the post.
+// Editor returns the User who last updated the post.
+func (p *Post) Editor() *User {
+	return (&Post{CreatedBy: p.UpdatedBy}).Author()
+}
+
+// Comments returns the approved comments of the post, with their replies as
+// Children.
 func (p *Post) Comments() []*Comment {
+	return p.CommentThread(0)
+}
+
+// CommentThread returns the approved comments of the post, with their replies
+// nested up to maxDepth levels, as themes show them.
+func (p *Post) CommentThread(maxDepth int) []*Comment {
 	comments := new(Comments)
-	err := comments.GetCommentsByPostId(p.Id.Hex())
+	err := comments.GetCommentThread(p.Id.Hex(), maxDepth)
 	if err != nil {
 		return nil
 	}
@@ -116,7 +141,18 @@ func (p *Post) Comments() []*Comment {
 // Summary returns the post summary.
 func (p *Post) Summary() string {
 	text := strings.Split(p.Markdown, "<!--more-->")[0]
-	return utils.Markdown2Html(text)
+	if !p.Editor().Can(PermUnfilteredHtml) {
+		return utils.RichPolicy.Sanitize(RenderMarkdown(text))
+	}
+	return RenderMarkdown(text)
+}
+
+// sanitizeHtml removes from the HTML of the post the markup its last editor
+// is not allowed to write.
+func (p *Post) sanitizeHtml() {
+	if !p.Editor().Can(PermUnfilteredHtml) {
+		p.Html = utils.RichPolicy.Sanitize(p.Html)
+	}
 }
 
 // Excerpt returns the post execerpt, with a default length of 255 characters.
@@ -124,7 +160,8 @@ func (p *Post) Excerpt() string {
 	return utils.Html2Excerpt(p.Html, 255)
 }
 
-// Save saves a post to the DB, updating any given tags to include the Post ID.
+// Save saves a post to the DB, updating any given tags to include the Post ID,
+// and writes the saved version as a new revision.
 func (p *Post) Save(tags ...Tag) error {
 	p.Slug = strings.TrimLeft(p.Slug, "/")
 	p.Slug = strings.TrimRight(p.Slug, "/")
@@ -132,13 +169,25 @@ func (p *Post) Save(tags ...Tag) error {
 		return fmt.Errorf("Slug can not be empty or root")
 	}
 
+	// A post published with a date in the future is kept as a draft, and
+	// published by the scheduler when the date arrives.
+	p.IsScheduled = false
 	if p.IsPublished {
-		p.PublishedAt = utils.Now()
+		if p.PublishedAt == nil {
+			p.PublishedAt = utils.Now()
+		}
+		if p.PublishedAt.After(*utils.Now()) {
+			p.IsPublished = false
+			p.IsScheduled = true
+		}
 		p.PublishedBy = p.CreatedBy
 	}
 
 	p.UpdatedAt = utils.Now()
-	p.UpdatedBy = p.CreatedBy
+	if p.UpdatedBy == "" {
+		p.UpdatedBy = p.CreatedBy
+	}
+	p.sanitizeHtml()
 
 	p.Tags = Tags(tags).GetDistinctBySlug()
 
@@ -152,7 +201,7 @@ func (p *Post) Save(tags ...Tag) error {
 			return err
 		}
 	}
-	return nil
+	return p.saveRevision()
 }
 
 // Insert saves a post to the DB.
@@ -161,29 +210,75 @@ func (p *Post) Insert() error {
 		p.Slug = generateNewSlug(p.Slug, 1)
 	}
 
-	err := postSession.Clone().DB(DBName).C("posts").Insert(p)
-
-	return err
+	if err := store.InsertPost(p); err != nil {
+		return err
+	}
+	return p.saveMediaUsage()
 }
 
 
-// Update updates an existing post in the DB.
-func (p *Post) Update() error {
+// A ConflictError is returned when updating a post that was updated by
+// someone else since it was read.
+type ConflictError struct {
+	// Current is the post as it is stored.
+	Current *Post
+}
 
-	// TODO: Apply
+func (e *ConflictError) Error() string {
+	return "the post was changed by someone else"
+}
 
+// Update updates an existing post in the DB. The version of the post has to
+// be the stored one, or a ConflictError is returned: the post was updated
+// since it was read, and updating it would lose the changes.
+func (p *Post) Update() error {
 	currentPost := &Post{Id: p.Id}
 	err := currentPost.GetPostById()
-	if err == mgo.ErrNotFound {
+	if err == ErrNotFound {
 		return p.Insert()
 	}
+	if err != nil {
+		return err
+	}
+	if p.Version != currentPost.Version {
+		return &ConflictError{Current: currentPost}
+	}
 	if p.Slug != currentPost.Slug && !PostChangeSlug(p.Slug) {
 		p.Slug = generateNewSlug(p.Slug, 1)
 	}
 
-	_, err = postSession.Clone().DB(DBName).C("posts").UpsertId(p.Id, p)
+	p.Version++
+	if err := store.UpdatePost(p, currentPost.Version); err != nil {
+		p.Version--
+		if err == ErrConflict && currentPost.GetPostById() == nil {
+			return &ConflictError{Current: currentPost}
+		}
+		return err
+	}
+	return p.saveMediaUsage()
+}
 
-	return err
+// CommentsOpen reports whether the post takes new comments: they are allowed,
+// and the CommentDays since it was published are not over.
+func (p *Post) CommentsOpen() bool {
+	if !p.AllowComment {
+		return false
+	}
+	if p.CommentDays == 0 || p.PublishedAt == nil {
+		return true
+	}
+	return time.Now().Before(p.PublishedAt.AddDate(0, 0, int(p.CommentDays)))
+}
+
+// CountComment adds a comment to the comment count of the post. Only the
+// count is changed in the DB, so that the edits in progress neither conflict
+// nor are overwritten.
+func (p *Post) CountComment() error {
+	if err := store.IncrementCommentNum(p.Id); err != nil {
+		return err
+	}
+	p.CommentNum++
+	return nil
 }
 
 // UpdateFromRequest updates an existing Post in the DB based on the data
@@ -197,10 +292,34 @@ func (p *Post) UpdateFromRequest(r *http.Request) {
 	p.Image = r.FormValue("image")
 	p.Slug = r.FormValue("slug")
 	p.Markdown = r.FormValue("content")
-	p.Html = utils.Markdown2Html(p.Markdown)
+	p.Html = RenderMarkdown(p.Markdown)
 	p.AllowComment = r.FormValue("comment") == "on"
+	p.CommentDays = 0
+	if days, err := strconv.ParseInt(r.FormValue("comment_days"), 10, 64); err == nil && days > 0 {
+		p.CommentDays = days
+	}
 	p.Category = r.FormValue("category")
 	p.IsPublished = r.FormValue("status") == "on"
+	if version, err := strconv.ParseInt(r.FormValue("version"), 10, 64); err == nil {
+		p.Version = version
+	}
+	if publishedAt := r.FormValue("published_at"); publishedAt != "" {
+		if t, err := time.ParseInLocation(publishDateFormat, publishedAt, time.Local); err == nil {
+			p.PublishedAt = &t
+		}
+	} else if p.IsScheduled {
+		// Clearing the date of a scheduled post publishes it right away.
+		p.PublishedAt = nil
+	}
+}
+
+// PublishDate returns the publish date of the post in the format used by the
+// editor, or an empty string if the post has none.
+func (p *Post) PublishDate() string {
+	if p.PublishedAt == nil {
+		return ""
+	}
+	return p.PublishedAt.In(time.Local).Format(publishDateFormat)
 }
 
 func (p *Post) UpdateFromJSON(j []byte) error {
@@ -208,25 +327,48 @@ func (p *Post) UpdateFromJSON(j []byte) error {
 	if err != nil {
 		return err
 	}
-	p.Html = utils.Markdown2Html(p.Markdown)
+	// A scheduled post sent back as it was received stays scheduled.
+	if p.IsScheduled {
+		p.IsPublished = true
+	}
+	p.Html = RenderMarkdown(p.Markdown)
 	return nil
 }
 
+// Publish publishes the post right away, even if it was scheduled. It is
+// saved as a new version, written as a revision, so that the version read
+// before by an editor conflicts as in Update.
 func (p *Post) Publish(by string) error {
 	p.PublishedAt = utils.Now()
 	p.PublishedBy = by
 	p.IsPublished = true
+	p.IsScheduled = false
+	p.UpdatedAt = utils.Now()
+	p.UpdatedBy = by
+	p.sanitizeHtml()
 
-	_, err := postSession.Clone().DB(DBName).C("posts").UpsertId(p.Id, p)
-
-	return err
+	if err := p.Update(); err != nil {
+		return err
+	}
+	return p.saveRevision()
 }
 
 
-// DeletePostById deletes the given Post from the DB.
+// DeletePostById deletes the given Post and its revisions from the DB.
 func DeletePostById(id string) error {
-	err := postSession.Clone().DB(DBName).C("posts").RemoveId(bson.ObjectIdHex(id))
-	return err
+	if !bson.IsObjectIdHex(id) {
+		return ErrNotFound
+	}
+	if err := store.DeletePost(bson.ObjectIdHex(id)); err != nil {
+		return err
+	}
+	if err := store.SetPostMedia(id, nil); err != nil {
+		return err
+	}
+	if err := store.DeleteAutosaves(id, ""); err != nil {
+		return err
+	}
+	return store.PruneRevisions(id, 0)
 }
 
 // GetPostById gets the post based on the Post ID.
@@ -238,91 +380,76 @@ func (post *Post) GetPostById(id ...bson.ObjectId) error {
 		postId = id[0]
 	}
 
-	err := postSession.Clone().DB(DBName).C("posts").FindId(postId).One(post)
-	return err
+	return store.GetPost(postId, post)
 }
 
 // GetPostBySlug gets the post based on the Post Slug.
 func (p *Post) GetPostBySlug(slug string) error {
-	err := postSession.Clone().DB(DBName).C("posts").Find(bson.M{"slug": slug}).One(p)
-	return err
+	return store.GetPostBySlug(slug, p)
 }
 
 // GetPostsByTag returns a new pager based all the Posts associated with a Tag.
 func (p *Posts) GetPostsByTag(tagslug string, page, size int64, onlyPublished bool) (*utils.Pager, error) {
-	var (
-		pager *utils.Pager
-		count int64
-	)
-
-	session := postSession.Clone()
+	q := PostQuery{TagSlug: tagslug}
+	if onlyPublished {
+		q.onlyPublic()
+	}
 
-	cnt, err := session.DB(DBName).C("posts").Find(bson.M{"tags.slug": tagslug}).Count()
+	count, err := store.CountPosts(q)
 	if err != nil {
 		utils.LogOnError(err, "Unable to get posts by tag.", true)
 		return nil, err
 	}
-	count = int64(cnt)
 
-	pager = utils.NewPager(page, size, count)
+	pager := utils.NewPager(page, size, count)
 
 	if !pager.IsValid {
 		return pager, fmt.Errorf("Page not found")
 	}
 
-	if onlyPublished {
-		err = session.DB(DBName).C("posts").Find(bson.M{"tags.slug": tagslug, "ispublished": true}).Sort("-publishedat").Skip(int(pager.Begin)).Limit(int(size)).All(p)
-	} else {
-		err = session.DB(DBName).C("posts").Find(bson.M{"tags.slug": tagslug}).Sort("-publishedat").Skip(int(pager.Begin)).Limit(int(size)).All(p)
-	}
-	return pager, err
+	q.OrderBy = "published_at DESC"
+	q.Offset = int(pager.Begin)
+	q.Limit = int(size)
+	return pager, store.FindPosts(q, p)
 }
 
 // GetAllPostsByTag gets all the Posts with the associated Tag.
 func (p *Posts) GetAllPostsByTag(tag Tag) error {
-	session := postSession.Clone()
-
-	err := session.DB(DBName).C("posts").Find(bson.M{"tags": tag}).Sort("-publishedat").All(p)
-
-	return err
+	return store.FindPosts(PostQuery{TagSlug: tag.Slug, OrderBy: "published_at DESC"}, p)
 }
 
 // GetNumberOfPosts gets the total number of posts in the DB.
 func GetNumberOfPosts(isPage bool, published bool) (int64, error) {
-	session := postSession.Clone()
-
-	var err error
-	var cnt int
+	q := PostQuery{IsPage: boolPtr(isPage)}
 	if published {
-		cnt, err = session.DB(DBName).C("posts").Find(bson.M{"ispage": isPage, "ispublished": true}).Count()
-	} else {
-		cnt, err = session.DB(DBName).C("posts").Find(bson.M{"ispage": isPage}).Count()
+		q.onlyPublic()
 	}
-
-	return int64(cnt), err
+	return store.CountPosts(q)
 }
 
 // GetPostList returns a new pager based on all the posts in the DB.
 func (posts *Posts) GetPostList(page, size int64, isPage bool, onlyPublished bool, orderBy string) (*utils.Pager, error) {
 	var pager *utils.Pager
 	count, err := GetNumberOfPosts(isPage, onlyPublished)
+	if err != nil {
+		return nil, err
+	}
 	pager = utils.NewPager(page, size, count)
 
 	if !pager.IsValid {
 		return pager, fmt.Errorf("Page not found")
 	}
 
-	safeOrderBy := getSafeOrderByStmt(orderBy)
-
-	session := postSession.Clone()
-
+	q := PostQuery{
+		IsPage:  boolPtr(isPage),
+		OrderBy: orderBy,
+		Offset:  int(pager.Begin),
+		Limit:   int(size),
+	}
 	if onlyPublished {
-		err = session.DB(DBName).C("posts").Find(bson.M{"ispage": isPage, "ispublished": true}).Sort(safeOrderBy).Skip(int(pager.Begin)).Limit(int(size)).All(posts)
-	} else {
-		err = session.DB(DBName).C("posts").Find(bson.M{"ispage": isPage}).Sort(safeOrderBy).Skip(int(pager.Begin)).Limit(int(size)).All(posts)
+		q.onlyPublic()
 	}
-
-	return pager, err
+	return pager, store.FindPosts(q, posts)
 }
 
 // GetAllPostList gets all the posts, with the options to get only pages, or
@@ -335,19 +462,11 @@ func (posts *Posts) GetPostList(page, size int64, isPage bool, onlyPublished boo
 //         "published_at"
 //         "published_at DESC"
 func (p *Posts) GetAllPostList(isPage bool, onlyPublished bool, orderBy string) error {
-	session := postSession.Clone()
-
-	var err error
-
-	safeOrderBy := getSafeOrderByStmt(orderBy)
-
+	q := PostQuery{IsPage: boolPtr(isPage), OrderBy: orderBy}
 	if onlyPublished {
-		err = session.DB(DBName).C("posts").Find(bson.M{"ispage": isPage, "ispublished": true}).Sort(safeOrderBy).All(p)
-	} else {
-		err = session.DB(DBName).C("posts").Find(bson.M{"ispage": isPage}).Sort(safeOrderBy).All(p)
+		q.onlyPublic()
 	}
-
-	return err
+	return store.FindPosts(q, p)
 }
 
 // PostChangeSlug checks to see if there is a post associated with the given
@@ -386,16 +505,16 @@ func getSafeOrderByStmt(orderBy string) string {
 }
 
 func GetPublishedPosts(offset, limit int) (Posts, error) {
-
 	var posts Posts
-	err := postSession.Clone().DB(DBName).C("posts").Find(bson.M{"ispublished": true}).Skip(offset).Limit(limit).All(&posts)
-
+	q := PostQuery{Offset: offset, Limit: limit}
+	q.onlyPublic()
+	err := store.FindPosts(q, &posts)
 	return posts, err
 }
 
 func GetUnpublishedPosts(offset, limit int) (Posts, error) {
 	var posts Posts
-	err := postSession.Clone().DB(DBName).C("posts").Find(bson.M{"ispublished": false}).Skip(offset).Limit(limit).All(&posts)
+	err := store.FindPosts(PostQuery{IsPublished: boolPtr(false), Offset: offset, Limit: limit}, &posts)
 	return posts, err
 }
 
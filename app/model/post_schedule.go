@@ -0,0 +1,48 @@
+package model
+
+import (
+	"html/template"
+	"log"
+	"time"
+
+	"github.com/covrom/dingo/app/utils"
+)
+
+// PublishScheduledPosts publishes the scheduled posts whose publish date has
+// arrived, and leaves a message on the dashboard for each of them.
+func PublishScheduledPosts() error {
+	posts := new(Posts)
+	q := PostQuery{IsScheduled: boolPtr(true), PublishedBefore: utils.Now()}
+	if err := store.FindPosts(q, posts); err != nil {
+		return err
+	}
+	for _, p := range *posts {
+		p.IsPublished = true
+		p.IsScheduled = false
+		if err := store.UpsertPost(p); err != nil {
+			return err
+		}
+		if m := NewMessage("publish", p); m != nil {
+			m.Insert()
+		}
+	}
+	return nil
+}
+
+// RunScheduler publishes the scheduled posts every interval, and never
+// returns.
+func RunScheduler(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := PublishScheduledPosts(); err != nil {
+			log.Printf("[Error]: Can not publish scheduled posts: %v", err)
+		}
+	}
+}
+
+func generatePublishMessage(v interface{}) string {
+	p, ok := v.(*Post)
+	if !ok {
+		return ""
+	}
+	return "<p>The scheduled post <i>" + template.HTMLEscapeString(p.Title) + "</i> is now published.</p>"
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPostSchedule(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("sqlite://:memory:", true)
+
+		p := mockPost()
+		publishedAt := time.Now().Add(time.Hour)
+		p.PublishedAt = &publishedAt
+		So(p.Save(), ShouldBeNil)
+
+		Convey("A post published in the future is scheduled", func() {
+			So(p.IsPublished, ShouldBeFalse)
+			So(p.IsScheduled, ShouldBeTrue)
+			So(p.IsPublic(), ShouldBeFalse)
+		})
+
+		Convey("Scheduled posts are hidden from the public", func() {
+			posts := new(Posts)
+			_, err := posts.GetPostList(1, 5, false, true, "published_at DESC")
+			So(err, ShouldBeNil)
+			So(posts.Len(), ShouldEqual, 0)
+
+			count, err := GetNumberOfPosts(false, true)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+
+		Convey("Scheduled posts are not published before their time", func() {
+			So(PublishScheduledPosts(), ShouldBeNil)
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.IsPublished, ShouldBeFalse)
+		})
+
+		Convey("Scheduled posts are published when their time arrives", func() {
+			publishedAt := time.Now().Add(-time.Minute)
+			p.PublishedAt = &publishedAt
+			So(store.UpsertPost(p), ShouldBeNil)
+
+			So(PublishScheduledPosts(), ShouldBeNil)
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.IsPublished, ShouldBeTrue)
+			So(p.IsScheduled, ShouldBeFalse)
+
+			posts := new(Posts)
+			_, err := posts.GetPostList(1, 5, false, true, "published_at DESC")
+			So(err, ShouldBeNil)
+			So(posts.Len(), ShouldEqual, 1)
+
+			messages := new(Messages)
+			messages.GetUnreadMessages()
+			So(*messages, ShouldHaveLength, 1)
+			So((*messages)[0].Type, ShouldEqual, "publish")
+		})
+
+		Convey("Published posts keep their publish date", func() {
+			publishedAt := time.Now().Add(-24 * time.Hour)
+			p.PublishedAt = &publishedAt
+			p.IsPublished = true
+			So(p.Save(), ShouldBeNil)
+			So(p.GetPostById(), ShouldBeNil)
+			So(p.PublishedAt.Unix(), ShouldEqual, publishedAt.Unix())
+			So(p.IsPublic(), ShouldBeTrue)
+		})
+
+		Convey("The publish message escapes the title", func() {
+			p.Title = "<script>alert(1)</script>"
+			So(generatePublishMessage(p), ShouldNotContainSubstring, "<script>")
+		})
+
+		Reset(func() {
+			DropDatabase()
+		})
+	})
+
+	Convey("Publish the scheduled posts out of UTC", t, func() {
+		local := time.Local
+		defer func() { time.Local = local }()
+
+		for _, offset := range []int{-5, 9} {
+			time.Local = time.FixedZone("", offset*3600)
+			Initialize("sqlite://:memory:", true)
+
+			due, later := mockPost(), mockPost()
+			later.Slug = "later"
+			for p, d := range map[*Post]time.Duration{due: -time.Minute, later: time.Hour} {
+				publishedAt := time.Now().Add(d)
+				p.PublishedAt = &publishedAt
+				p.IsPublished = false
+				p.IsScheduled = true
+				So(store.UpsertPost(p), ShouldBeNil)
+			}
+
+			So(PublishScheduledPosts(), ShouldBeNil)
+			So(due.GetPostById(), ShouldBeNil)
+			So(due.IsPublished, ShouldBeTrue)
+			So(due.PublishedAt.Location(), ShouldEqual, time.Local)
+			So(later.GetPostById(), ShouldBeNil)
+			So(later.IsPublished, ShouldBeFalse)
+
+			DropDatabase()
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// statsDayFormat is the layout of the days kept in the post_stats collection.
+const statsDayFormat = "2006-01-02"
+
+// A PostStat holds the number of page views of a post on a given day. Views
+// of pages that are not a post, like the home page, are kept with an empty
+// PostId.
+type PostStat struct {
+	Id     bson.ObjectId `bson:"_id" meddler:"Id,objectid"`
+	PostId string
+	Day    string
+	Hits   int64
+}
+
+// A PostHits is the number of page views of a post over a period of time.
+type PostHits struct {
+	PostId string `bson:"_id"`
+	Hits   int64
+}
+
+// A DayHits is the number of page views of the whole blog on a given day.
+type DayHits struct {
+	Day  string `bson:"_id"`
+	Hits int64
+}
+
+// StatsDay returns the day t belongs to, as kept in the post_stats
+// collection.
+func StatsDay(t time.Time) string {
+	return t.UTC().Format(statsDayFormat)
+}
+
+// GetDailyHits returns the number of page views of every day from the given
+// number of days ago up to today, oldest first. Days without any view are
+// included with zero hits.
+func GetDailyHits(days int) ([]*DayHits, error) {
+	now := time.Now()
+	since := now.AddDate(0, 0, 1-days)
+	found, err := store.DailyHits(StatsDay(since))
+	if err != nil {
+		return nil, err
+	}
+	hits := make(map[string]int64, len(found))
+	for _, d := range found {
+		hits[d.Day] = d.Hits
+	}
+	result := make([]*DayHits, 0, days)
+	for t := since; !t.After(now); t = t.AddDate(0, 0, 1) {
+		day := StatsDay(t)
+		result = append(result, &DayHits{Day: day, Hits: hits[day]})
+	}
+	return result, nil
+}
+
+// GetPopularPosts gets the n published posts with the most page views since
+// the given time, most viewed first. The Hits of each post is set to its
+// number of views over that period.
+func (posts *Posts) GetPopularPosts(n int, since time.Time) error {
+	// Ask for a few more posts, as some of them may have been unpublished
+	// or deleted since.
+	top, err := store.TopPostHits(2*n, StatsDay(since))
+	if err != nil {
+		return err
+	}
+	for _, h := range top {
+		if len(*posts) >= n {
+			break
+		}
+		if !bson.IsObjectIdHex(h.PostId) {
+			continue
+		}
+		p := &Post{Id: bson.ObjectIdHex(h.PostId)}
+		if err := p.GetPostById(); err != nil || !p.IsPublic() || p.IsPage {
+			continue
+		}
+		p.Hits = h.Hits
+		*posts = append(*posts, p)
+	}
+	return nil
+}
+
+type hitKey struct {
+	slug string
+	day  string
+}
+
+// A HitCounter counts page views in memory, and adds them to the post_stats
+// collection on Flush. This way a page view doesn't cost a write to the DB.
+type HitCounter struct {
+	mu   sync.Mutex
+	hits map[hitKey]int64
+}
+
+// NewHitCounter creates a new, empty HitCounter.
+func NewHitCounter() *HitCounter {
+	return &HitCounter{hits: make(map[hitKey]int64)}
+}
+
+// Hit counts a view of the post with the given slug at the given time. An
+// empty slug counts a view of a page that is not a post.
+func (h *HitCounter) Hit(slug string, t time.Time) {
+	h.mu.Lock()
+	h.hits[hitKey{slug, StatsDay(t)}]++
+	h.mu.Unlock()
+}
+
+// Flush writes the views counted so far to the DB, and resets the counter.
+// Views of slugs that do not belong to a post anymore are dropped.
+func (h *HitCounter) Flush() error {
+	h.mu.Lock()
+	hits := h.hits
+	h.hits = make(map[hitKey]int64)
+	h.mu.Unlock()
+
+	postIds := make(map[string]string)
+	var firstErr error
+	for k, n := range hits {
+		id, ok := postIds[k.slug]
+		if !ok && k.slug != "" {
+			p := new(Post)
+			if err := p.GetPostBySlug(k.slug); err == nil {
+				id = p.Id.Hex()
+			}
+			postIds[k.slug] = id
+		}
+		if k.slug != "" && id == "" {
+			continue
+		}
+		if err := store.AddPostHits(id, k.day, n); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Run flushes the counter every interval, and never returns.
+func (h *HitCounter) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := h.Flush(); err != nil {
+			log.Printf("[Error]: Can not save page views: %v", err)
+		}
+	}
+}
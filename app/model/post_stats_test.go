@@ -0,0 +1,59 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPostStats(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("sqlite://:memory:", true)
+
+		p := mockPost()
+		So(p.Save(), ShouldBeNil)
+
+		Convey("Count page views", func() {
+			h := NewHitCounter()
+			now := time.Now()
+			h.Hit(p.Slug, now)
+			h.Hit(p.Slug, now)
+			h.Hit(p.Slug, now.AddDate(0, 0, -1))
+			h.Hit("", now)
+			h.Hit("no-such-post", now)
+			So(h.Flush(), ShouldBeNil)
+
+			h.Hit(p.Slug, now)
+			So(h.Flush(), ShouldBeNil)
+
+			Convey("Get the popular posts", func() {
+				posts := new(Posts)
+				err := posts.GetPopularPosts(5, now.AddDate(0, 0, -7))
+				So(err, ShouldBeNil)
+				So(posts.Len(), ShouldEqual, 1)
+				So(posts.Get(0).Id, ShouldEqual, p.Id)
+				So(posts.Get(0).Hits, ShouldEqual, 4)
+
+				posts = new(Posts)
+				err = posts.GetPopularPosts(5, now)
+				So(err, ShouldBeNil)
+				So(posts.Get(0).Hits, ShouldEqual, 3)
+			})
+
+			Convey("Get the daily page views", func() {
+				days, err := GetDailyHits(7)
+				So(err, ShouldBeNil)
+				So(days, ShouldHaveLength, 7)
+				So(days[6].Day, ShouldEqual, StatsDay(now))
+				So(days[6].Hits, ShouldEqual, 4)
+				So(days[5].Hits, ShouldEqual, 1)
+				So(days[0].Hits, ShouldEqual, 0)
+			})
+		})
+
+		Reset(func() {
+			DropDatabase()
+		})
+	})
+}
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPostVersion(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("sqlite://:memory:", true)
+
+		p := mockPost()
+		So(p.Save(), ShouldBeNil)
+		So(p.Version, ShouldEqual, 0)
+
+		Convey("Each update makes a new version", func() {
+			p.Title = "Second"
+			So(p.Save(), ShouldBeNil)
+			So(p.Version, ShouldEqual, 1)
+			stored := &Post{Id: p.Id}
+			So(stored.GetPostById(), ShouldBeNil)
+			So(stored.Version, ShouldEqual, 1)
+			So(stored.Title, ShouldEqual, "Second")
+		})
+
+		Convey("Updating an old version conflicts", func() {
+			mine := &Post{Id: p.Id}
+			So(mine.GetPostById(), ShouldBeNil)
+			theirs := &Post{Id: p.Id}
+			So(theirs.GetPostById(), ShouldBeNil)
+
+			theirs.Markdown = "their change"
+			theirs.UpdatedBy = "them"
+			So(theirs.Save(), ShouldBeNil)
+
+			mine.Markdown = "my change"
+			err := mine.Save()
+			So(err, ShouldHaveSameTypeAs, &ConflictError{})
+			current := err.(*ConflictError).Current
+			So(current.Markdown, ShouldEqual, "their change")
+			So(current.Version, ShouldEqual, 1)
+			So(mine.Version, ShouldEqual, 0)
+
+			revisions, _ := p.GetRevisions()
+			So(revisions.Len(), ShouldEqual, 2)
+
+			Convey("Overwrite the change from its version", func() {
+				mine.Version = current.Version
+				So(mine.Save(), ShouldBeNil)
+				So(mine.GetPostById(), ShouldBeNil)
+				So(mine.Markdown, ShouldEqual, "my change")
+				So(mine.Version, ShouldEqual, 2)
+			})
+		})
+
+		Convey("Publishing makes a new version", func() {
+			open := &Post{Id: p.Id}
+			So(open.GetPostById(), ShouldBeNil)
+			published := &Post{Id: p.Id}
+			So(published.GetPostById(), ShouldBeNil)
+			So(published.Publish("them"), ShouldBeNil)
+			So(published.Version, ShouldEqual, 1)
+
+			stored := &Post{Id: p.Id}
+			So(stored.GetPostById(), ShouldBeNil)
+			So(stored.IsPublished, ShouldBeTrue)
+			So(stored.PublishedBy, ShouldEqual, "them")
+			So(stored.Version, ShouldEqual, 1)
+			revisions, _ := p.GetRevisions()
+			So(revisions.Len(), ShouldEqual, 2)
+
+			open.Markdown = "my change"
+			So(open.Save(), ShouldHaveSameTypeAs, &ConflictError{})
+			So(stored.GetPostById(), ShouldBeNil)
+			So(stored.IsPublished, ShouldBeTrue)
+		})
+
+		Convey("Comments do not make a new version", func() {
+			So(p.CountComment(), ShouldBeNil)
+			stored := &Post{Id: p.Id}
+			So(stored.GetPostById(), ShouldBeNil)
+			So(stored.CommentNum, ShouldEqual, 1)
+			So(stored.Version, ShouldEqual, 0)
+		})
+
+		Convey("Comments keep the edits saved since the post was read", func() {
+			read := &Post{Id: p.Id}
+			So(read.GetPostById(), ShouldBeNil)
+			edited := &Post{Id: p.Id}
+			So(edited.GetPostById(), ShouldBeNil)
+			edited.Markdown = "edited"
+			So(edited.Save(), ShouldBeNil)
+
+			So(read.CountComment(), ShouldBeNil)
+			So(read.CountComment(), ShouldBeNil)
+			stored := &Post{Id: p.Id}
+			So(stored.GetPostById(), ShouldBeNil)
+			So(stored.Markdown, ShouldEqual, "edited")
+			So(stored.Version, ShouldEqual, 1)
+			So(stored.CommentNum, ShouldEqual, 2)
+
+			So((&Post{Id: bson.NewObjectId()}).CountComment(), ShouldEqual, ErrNotFound)
+		})
+
+		Convey("Autosave a draft", func() {
+			a := &Autosave{PostId: p.Id.Hex(), UserId: "someone", Markdown: "draft"}
+			So(a.Save(), ShouldBeNil)
+			So(a.IsNewer(p), ShouldBeTrue)
+
+			saved, err := GetAutosave(p.Id.Hex(), "someone")
+			So(err, ShouldBeNil)
+			So(saved.Markdown, ShouldEqual, "draft")
+			_, err = GetAutosave(p.Id.Hex(), "another")
+			So(err, ShouldEqual, ErrNotFound)
+
+			stored := &Post{Id: p.Id}
+			So(stored.GetPostById(), ShouldBeNil)
+			So(stored.Markdown, ShouldEqual, p.Markdown)
+
+			a.Markdown = "newer draft"
+			So(a.Save(), ShouldBeNil)
+			saved, _ = GetAutosave(p.Id.Hex(), "someone")
+			So(saved.Markdown, ShouldEqual, "newer draft")
+
+			Convey("Discard the draft", func() {
+				So(DeleteAutosave(p.Id.Hex(), "someone"), ShouldBeNil)
+				_, err := GetAutosave(p.Id.Hex(), "someone")
+				So(err, ShouldEqual, ErrNotFound)
+			})
+
+			Convey("Deleting the post deletes its drafts", func() {
+				So(DeletePostById(p.Id.Hex()), ShouldBeNil)
+				_, err := GetAutosave(p.Id.Hex(), "someone")
+				So(err, ShouldEqual, ErrNotFound)
+			})
+		})
+	})
+
+	Convey("Add the version to the posts of an older SQLite database", t, func() {
+		dir, _ := ioutil.TempDir("", "dingo-sqlite")
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "dingo.db")
+		db, err := sql.Open("sqlite3", file)
+		So(err, ShouldBeNil)
+		_, err = db.Exec(strings.Replace(sqliteSchema, ",\n\tVersion         INTEGER NOT NULL DEFAULT 0", "", 1))
+		So(err, ShouldBeNil)
+		_, err = db.Exec("INSERT INTO posts (Id, Title) VALUES ('5a0000000000000000000001', 'Old')")
+		So(err, ShouldBeNil)
+		db.Close()
+
+		s, err := newSQLiteStore(file)
+		So(err, ShouldBeNil)
+		defer s.Close()
+		existed, err := s.Setup()
+		So(err, ShouldBeNil)
+		So(existed, ShouldBeTrue)
+		var version int64
+		So(s.db.QueryRow("SELECT Version FROM posts").Scan(&version), ShouldBeNil)
+		So(version, ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/globalsign/mgo/bson"
+)
+
+const (
+	// previewPurpose is the purpose claim of the tokens of the preview links.
+	previewPurpose = "preview"
+	// defaultPreviewTTL is how many hours the preview links last when the
+	// "preview_ttl" setting is not a number.
+	defaultPreviewTTL = 72
+	// previewRevokedSetting is the key of the setting holding when every
+	// preview link was last revoked. The links of a post are revoked by the
+	// setting with the id of the post appended.
+	previewRevokedSetting = "preview_revoked"
+)
+
+// ErrInvalidPreview is returned for a preview link that is forged, expired,
+// revoked or made for another post.
+var ErrInvalidPreview = fmt.Errorf("invalid preview link")
+
+// A Preview is a link to see a post before it is published, without logging
+// in. The link holds a token signed with the JWT key pair, which expires
+// after the hours of the "preview_ttl" setting.
+type Preview struct {
+	PostId    string
+	Token     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Url returns the path of the preview link.
+func (p *Preview) Url() string {
+	return "/preview/" + p.PostId + "/?token=" + p.Token
+}
+
+// previewTTL returns how long the preview links last.
+func previewTTL() time.Duration {
+	return time.Duration(GetSettingInt("preview_ttl", defaultPreviewTTL)) * time.Hour
+}
+
+// NewPreview returns a new preview link of the post, made by the user with
+// the given id.
+func NewPreview(p *Post, by string) (*Preview, error) {
+	now := time.Now()
+	preview := &Preview{
+		PostId:    p.Id.Hex(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(previewTTL()),
+	}
+	claims := jwt.MapClaims{
+		"Purpose":   previewPurpose,
+		"PostID":    preview.PostId,
+		"CreatedBy": by,
+		"iat":       now.Unix(),
+		"exp":       preview.ExpiresAt.Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.GetSigningMethod("RS256"), claims).SignedString(signKey)
+	if err != nil {
+		return nil, err
+	}
+	preview.Token = token
+	return preview, nil
+}
+
+// GetPreviewPost returns the post of a preview link, checking that its token
+// is valid for the post with the id.
+func GetPreviewPost(id, token string) (*Post, error) {
+	t, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return verifyKey, nil
+	})
+	if err != nil || !t.Valid {
+		return nil, ErrInvalidPreview
+	}
+	claims := t.Claims.(jwt.MapClaims)
+	purpose, _ := claims["Purpose"].(string)
+	postId, _ := claims["PostID"].(string)
+	issuedAt, _ := claims["iat"].(float64)
+	if purpose != previewPurpose || postId != id || !bson.IsObjectIdHex(id) {
+		return nil, ErrInvalidPreview
+	}
+	if previewRevoked(id, int64(issuedAt)) {
+		return nil, ErrInvalidPreview
+	}
+	p := &Post{Id: bson.ObjectIdHex(id)}
+	if err := p.GetPostById(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// previewRevoked reports whether the links of the post issued at the given
+// time were revoked since.
+func previewRevoked(postId string, issuedAt int64) bool {
+	for _, key := range []string{previewRevokedSetting, previewRevokedSetting + "_" + postId} {
+		revokedAt, err := strconv.ParseInt(GetSettingValue(key), 10, 64)
+		if err == nil && issuedAt <= revokedAt {
+			return true
+		}
+	}
+	return false
+}
+
+// RevokePreviews revokes every preview link made so far for the post, or
+// for all the posts if postId is empty.
+func RevokePreviews(postId, by string) error {
+	key := previewRevokedSetting
+	if postId != "" {
+		key += "_" + postId
+	}
+	s := NewSetting(key, strconv.FormatInt(time.Now().Unix(), 10), "preview")
+	s.UpdatedBy = by
+	return s.Save()
+}
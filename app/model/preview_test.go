@@ -0,0 +1,95 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPreview(t *testing.T) {
+	testPrivKey := filepath.Join(os.TempDir(), "ding-test.rsa")
+	testPubKey := filepath.Join(os.TempDir(), "ding-test.rsa.pub")
+	InitializeKey(testPrivKey, testPubKey)
+
+	Convey("Initialize database", t, func() {
+		Initialize("sqlite://:memory:", true)
+
+		p := mockPost()
+		p.IsPublished = false
+		So(p.Save(), ShouldBeNil)
+		other := mockPost()
+		other.Slug = "other-post"
+		So(other.Save(), ShouldBeNil)
+
+		preview, err := NewPreview(p, "someone")
+		So(err, ShouldBeNil)
+		So(preview.Url(), ShouldStartWith, "/preview/"+p.Id.Hex()+"/?token=")
+		So(preview.ExpiresAt.Sub(preview.CreatedAt), ShouldEqual, defaultPreviewTTL*time.Hour)
+
+		Convey("A preview link shows the unpublished post", func() {
+			post, err := GetPreviewPost(p.Id.Hex(), preview.Token)
+			So(err, ShouldBeNil)
+			So(post.Title, ShouldEqual, p.Title)
+		})
+
+		Convey("A preview link only shows its post", func() {
+			_, err := GetPreviewPost(other.Id.Hex(), preview.Token)
+			So(err, ShouldEqual, ErrInvalidPreview)
+		})
+
+		Convey("A forged or expired link is refused", func() {
+			_, err := GetPreviewPost(p.Id.Hex(), preview.Token+"x")
+			So(err, ShouldEqual, ErrInvalidPreview)
+
+			expired, _ := jwt.NewWithClaims(jwt.GetSigningMethod("RS256"), jwt.MapClaims{
+				"Purpose": previewPurpose,
+				"PostID":  p.Id.Hex(),
+				"iat":     time.Now().Add(-2 * time.Hour).Unix(),
+				"exp":     time.Now().Add(-time.Hour).Unix(),
+			}).SignedString(signKey)
+			_, err = GetPreviewPost(p.Id.Hex(), expired)
+			So(err, ShouldEqual, ErrInvalidPreview)
+		})
+
+		Convey("A login token is not a preview link", func() {
+			u := NewUser("preview@example.com", "Preview")
+			token, err := NewJWT(u)
+			So(err, ShouldBeNil)
+			_, err = GetPreviewPost(p.Id.Hex(), token.Token)
+			So(err, ShouldEqual, ErrInvalidPreview)
+		})
+
+		Convey("A preview link does not log in", func() {
+			_, err := ValidateJWT(preview.Token)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Revoke the links of the post", func() {
+			So(RevokePreviews(p.Id.Hex(), "someone"), ShouldBeNil)
+			_, err := GetPreviewPost(p.Id.Hex(), preview.Token)
+			So(err, ShouldEqual, ErrInvalidPreview)
+
+			otherPreview, _ := NewPreview(other, "someone")
+			_, err = GetPreviewPost(other.Id.Hex(), otherPreview.Token)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Revoke every link", func() {
+			So(RevokePreviews("", "someone"), ShouldBeNil)
+			_, err := GetPreviewPost(p.Id.Hex(), preview.Token)
+			So(err, ShouldEqual, ErrInvalidPreview)
+		})
+
+		Convey("The links made after a revocation work", func() {
+			revokedAt := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+			So(NewSetting(previewRevokedSetting, revokedAt, "preview").Save(), ShouldBeNil)
+			_, err := GetPreviewPost(p.Id.Hex(), preview.Token)
+			So(err, ShouldBeNil)
+		})
+	})
+}
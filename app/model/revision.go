@@ -0,0 +1,114 @@
+package model
+
+import (
+	"time"
+
+	"github.com/covrom/dingo/app/utils"
+	"github.com/globalsign/mgo/bson"
+)
+
+// defaultRevisionRetention is the number of revisions kept for each post when
+// the "revisions_num" setting is not a number.
+const defaultRevisionRetention = 50
+
+// A Revision is a version of a post, written each time the post is saved.
+// Revisions are never changed once written.
+type Revision struct {
+	Id        bson.ObjectId `bson:"_id" json:"id" meddler:"Id,objectid"`
+	PostId    string        `json:"post_id"`
+	Title     string        `json:"title"`
+	Markdown  string        `json:"markdown"`
+	Tags      Tags          `json:"tags" meddler:"Tags,json"`
+	CreatedAt *time.Time    `json:"created_at"`
+	CreatedBy string        `json:"created_by"`
+}
+
+// Revisions is a slice of "Revision"s.
+type Revisions []*Revision
+
+// Len returns the amount of "Revision"s.
+func (r Revisions) Len() int {
+	return len(r)
+}
+
+// Get returns the Revision at the given index.
+func (r Revisions) Get(i int) *Revision {
+	return r[i]
+}
+
+// Author returns the User who saved the revision.
+func (r *Revision) Author() *User {
+	return (&Post{CreatedBy: r.CreatedBy}).Author()
+}
+
+// GetRevisionById gets the revision based on the Revision ID.
+func (r *Revision) GetRevisionById() error {
+	return store.GetRevision(r.Id, r)
+}
+
+// Diff returns the line diff of the markdown from the given revision to this
+// one.
+func (r *Revision) Diff(from *Revision) []utils.DiffLine {
+	return utils.LineDiff(from.Markdown, r.Markdown)
+}
+
+// GetRevisions gets all the revisions of the post, newest first.
+func (p *Post) GetRevisions() (Revisions, error) {
+	var revisions Revisions
+	err := store.FindRevisions(p.Id.Hex(), &revisions)
+	return revisions, err
+}
+
+// GetRevision gets a revision of the post. A revision of another post is not
+// found.
+func (p *Post) GetRevision(id string) (*Revision, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrNotFound
+	}
+	r := &Revision{Id: bson.ObjectIdHex(id)}
+	if err := r.GetRevisionById(); err != nil {
+		return nil, err
+	}
+	if r.PostId != p.Id.Hex() {
+		return nil, ErrNotFound
+	}
+	return r, nil
+}
+
+// RestoreRevision brings the title, content and tags of the post back to the
+// given revision, and saves the post. The restore is itself saved as a new
+// revision.
+func (p *Post) RestoreRevision(r *Revision, by string) error {
+	p.Title = r.Title
+	p.Markdown = r.Markdown
+	p.Html = RenderMarkdown(p.Markdown)
+	p.UpdatedBy = by
+	return p.Save(r.Tags...)
+}
+
+// saveRevision writes the current version of the post as a new revision, and
+// deletes the revisions past the retention count.
+func (p *Post) saveRevision() error {
+	r := &Revision{
+		Id:        bson.NewObjectId(),
+		PostId:    p.Id.Hex(),
+		Title:     p.Title,
+		Markdown:  p.Markdown,
+		Tags:      p.Tags,
+		CreatedAt: utils.Now(),
+		CreatedBy: p.UpdatedBy,
+	}
+	if err := store.InsertRevision(r); err != nil {
+		return err
+	}
+	if keep := revisionRetention(); keep > 0 {
+		return store.PruneRevisions(p.Id.Hex(), keep)
+	}
+	return nil
+}
+
+// revisionRetention returns the number of revisions kept for each post, as
+// set by the "revisions_num" setting. Zero keeps every revision.
+func revisionRetention() int {
+	return GetSettingInt("revisions_num", defaultRevisionRetention)
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/covrom/dingo/app/utils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRevisions(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("sqlite://:memory:", true)
+
+		p := mockPost()
+		p.Markdown = "first"
+		So(p.Save(), ShouldBeNil)
+		p.Markdown = "first\nsecond"
+		So(p.Save(NewTag("Go", "go")), ShouldBeNil)
+
+		Convey("Each save writes a revision", func() {
+			revisions, err := p.GetRevisions()
+			So(err, ShouldBeNil)
+			So(revisions.Len(), ShouldEqual, 2)
+			So(revisions.Get(0).Markdown, ShouldEqual, "first\nsecond")
+			So(revisions.Get(0).Tags, ShouldHaveLength, 1)
+			So(revisions.Get(1).Markdown, ShouldEqual, "first")
+
+			Convey("Diff two revisions", func() {
+				diff := revisions.Get(0).Diff(revisions.Get(1))
+				So(diff, ShouldResemble, []utils.DiffLine{
+					{Kind: utils.DiffEqual, Text: "first"},
+					{Kind: utils.DiffInsert, Text: "second"},
+				})
+			})
+
+			Convey("Restore a revision", func() {
+				So(p.RestoreRevision(revisions.Get(1), "someone"), ShouldBeNil)
+				So(p.GetPostById(), ShouldBeNil)
+				So(p.Markdown, ShouldEqual, "first")
+				So(p.Tags, ShouldBeEmpty)
+
+				revisions, err := p.GetRevisions()
+				So(err, ShouldBeNil)
+				So(revisions.Len(), ShouldEqual, 3)
+				So(revisions.Get(0).CreatedBy, ShouldEqual, "someone")
+			})
+
+			Convey("Revisions of other posts are not found", func() {
+				other := &Post{Id: Tmp_id_2}
+				_, err := other.GetRevision(revisions.Get(0).Id.Hex())
+				So(err, ShouldEqual, ErrNotFound)
+			})
+		})
+
+		Convey("Old revisions are pruned", func() {
+			So(NewSetting("revisions_num", "2", "blog").Save(), ShouldBeNil)
+			p.Markdown = "third"
+			So(p.Save(), ShouldBeNil)
+			revisions, err := p.GetRevisions()
+			So(err, ShouldBeNil)
+			So(revisions.Len(), ShouldEqual, 2)
+			So(revisions.Get(1).Markdown, ShouldEqual, "first\nsecond")
+		})
+
+		Convey("Deleting a post deletes its revisions", func() {
+			So(DeletePostById(p.Id.Hex()), ShouldBeNil)
+			revisions, err := p.GetRevisions()
+			So(err, ShouldBeNil)
+			So(revisions.Len(), ShouldEqual, 0)
+		})
+
+		Reset(func() {
+			DropDatabase()
+		})
+	})
+}
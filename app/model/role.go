@@ -0,0 +1,181 @@
+package model
+
+import (
+	"errors"
+	"strconv"
+)
+
+// The roles a user can have. The Owner is the user who set up the blog, and
+// is the only one allowed to hand over the ownership.
+const (
+	RoleAdministrator = 1
+	RoleEditor        = 2
+	RoleAuthor        = 3
+	RoleOwner         = 4
+)
+
+// ErrOwnerDemotion is returned when trying to take the Owner role away from
+// the Owner.
+var ErrOwnerDemotion = errors.New("the owner of the blog can not be demoted")
+
+// ErrUnknownRole is returned when trying to give a user a role that does not
+// exist.
+var ErrUnknownRole = errors.New("unknown role")
+
+var roleNames = map[int]string{
+	RoleAdministrator: "Administrator",
+	RoleEditor:        "Editor",
+	RoleAuthor:        "Author",
+	RoleOwner:         "Owner",
+}
+
+// RoleName returns the human readable name of the given role.
+func RoleName(role int) string {
+	return roleNames[role]
+}
+
+// A Permission is something only some of the roles are allowed to do.
+type Permission string
+
+const (
+	// PermPostEdit allows to write posts, and to edit, publish and delete
+	// the posts written by the user.
+	PermPostEdit Permission = "post.edit"
+	// PermPostEditOthers allows to edit, publish and delete the posts
+	// written by other users.
+	PermPostEditOthers Permission = "post.edit_others"
+	// PermPageEdit allows to write, edit and delete pages.
+	PermPageEdit Permission = "page.edit"
+	// PermCommentModerate allows to reply to, approve and delete comments.
+	PermCommentModerate Permission = "comment.moderate"
+	// PermTagManage allows to rename, merge and delete the tags of all the
+	// posts.
+	PermTagManage Permission = "tag.manage"
+	// PermFileUpload allows to browse and upload files.
+	PermFileUpload Permission = "file.upload"
+	// PermFileDelete allows to delete uploaded files.
+	PermFileDelete Permission = "file.delete"
+	// PermSettingEdit allows to view and change the blog settings.
+	PermSettingEdit Permission = "setting.edit"
+	// PermUserManage allows to manage the other users and their roles.
+	PermUserManage Permission = "user.manage"
+	// PermBackup allows to download backups of the whole blog.
+	PermBackup Permission = "site.backup"
+	// PermRestore allows to restore backups, which bring back the users and
+	// their roles along with the content of the blog.
+	PermRestore Permission = "site.restore"
+	// PermImport allows to import the exports of other blog engines.
+	PermImport Permission = "site.import"
+	// PermPreviewRevoke allows to revoke the preview links of the posts.
+	PermPreviewRevoke Permission = "preview.revoke"
+	// PermUnfilteredHtml allows to write any HTML in the posts. The HTML of
+	// the posts saved by the other users is sanitized.
+	PermUnfilteredHtml Permission = "post.unfiltered_html"
+)
+
+// permissions is the permission matrix, listing the roles that have each
+// permission.
+var permissions = map[Permission][]int{
+	PermPostEdit:        {RoleOwner, RoleAdministrator, RoleEditor, RoleAuthor},
+	PermPostEditOthers:  {RoleOwner, RoleAdministrator, RoleEditor},
+	PermPageEdit:        {RoleOwner, RoleAdministrator, RoleEditor},
+	PermCommentModerate: {RoleOwner, RoleAdministrator, RoleEditor},
+	PermTagManage:       {RoleOwner, RoleAdministrator, RoleEditor},
+	PermFileUpload:      {RoleOwner, RoleAdministrator, RoleEditor, RoleAuthor},
+	PermFileDelete:      {RoleOwner, RoleAdministrator},
+	PermSettingEdit:     {RoleOwner, RoleAdministrator},
+	PermUserManage:      {RoleOwner, RoleAdministrator},
+	PermBackup:          {RoleOwner, RoleAdministrator},
+	PermRestore:         {RoleOwner},
+	PermImport:          {RoleOwner, RoleAdministrator},
+	PermPreviewRevoke:   {RoleOwner, RoleAdministrator},
+	PermUnfilteredHtml:  {RoleOwner, RoleAdministrator},
+}
+
+// RoleCan reports whether the given role has the given permission.
+func RoleCan(role int, perm Permission) bool {
+	for _, r := range permissions[perm] {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the user has the given permission.
+func (u *User) Can(perm Permission) bool {
+	return RoleCan(u.Role, perm)
+}
+
+// CanEditPost reports whether the user is allowed to edit, publish and
+// delete the given post or page.
+func (u *User) CanEditPost(p *Post) bool {
+	if p.IsPage {
+		return u.Can(PermPageEdit)
+	}
+	if p.CreatedBy == u.Id.Hex() {
+		return u.Can(PermPostEdit)
+	}
+	return u.Can(PermPostEditOthers)
+}
+
+// RoleName returns the human readable name of the role of the user.
+func (u *User) RoleName() string {
+	return RoleName(u.Role)
+}
+
+// ChangeRole gives the user the given role, and saves it to the DB. The role
+// of the Owner can not be changed.
+func (u *User) ChangeRole(role int) error {
+	if _, ok := roleNames[role]; !ok {
+		return ErrUnknownRole
+	}
+	if u.Role == RoleOwner && role != RoleOwner {
+		return ErrOwnerDemotion
+	}
+	u.Role = role
+	return u.saveRole()
+}
+
+// saveRole saves the role of the user in the rolesusers collection. Users
+// without a role are left alone.
+func (u *User) saveRole() error {
+	if u.Role == 0 {
+		return nil
+	}
+	return InsertRoleUser(strconv.Itoa(u.Role), u.Id.Hex())
+}
+
+// loadRole loads the role of the user from the rolesusers collection. Users
+// without a role get the least privileged one, Author.
+func (u *User) loadRole() error {
+	ru := new(RolesUsers)
+	err := store.GetRoleUser(u.Id.Hex(), ru)
+	if err == ErrNotFound {
+		u.Role = RoleAuthor
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	u.Role, err = strconv.Atoi(ru.RoleId)
+	return err
+}
+
+// migrateUserRoles makes the first user the Owner of a blog created before
+// roles were kept in the DB, when no user has a role yet. The other users
+// are Authors until the Owner gives them another role.
+func migrateUserRoles() error {
+	var users Users
+	if err := store.FindUsers(0, 0, &users); err != nil || len(users) == 0 {
+		return err
+	}
+	for _, u := range users {
+		err := store.GetRoleUser(u.Id.Hex(), new(RolesUsers))
+		if err != ErrNotFound {
+			return err
+		}
+	}
+	users[0].Role = RoleOwner
+	return users[0].saveRole()
+}
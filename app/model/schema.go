@@ -18,11 +18,42 @@ var shema_indexes = []shema_struct{
 		Sparse:     true,
 	}},
 
+	shema_struct{"autosaves", mgo.Index{
+		Key:    []string{"postid", "userid"},
+		Unique: true,
+	}},
+
 	shema_struct{"comments", mgo.Index{
 		Key: []string{"parent"},
 	}},
 	shema_struct{"comments", mgo.Index{
-		Key: []string{"postid", "parent", "approved"},
+		Key: []string{"postid", "status"},
+	}},
+	shema_struct{"comments", mgo.Index{
+		Key: []string{"status"},
+	}},
+	shema_struct{"comments", mgo.Index{
+		Key: []string{"email", "status"},
+	}},
+	shema_struct{"comments", mgo.Index{
+		Key: []string{"path"},
+	}},
+
+	shema_struct{"media", mgo.Index{
+		Key: []string{"key"},
+	}},
+	shema_struct{"media", mgo.Index{
+		Key: []string{"sha256"},
+	}},
+	shema_struct{"media", mgo.Index{
+		Key: []string{"-createdat"},
+	}},
+
+	shema_struct{"post_media", mgo.Index{
+		Key: []string{"postid"},
+	}},
+	shema_struct{"post_media", mgo.Index{
+		Key: []string{"key"},
 	}},
 
 	shema_struct{"messages", mgo.Index{
@@ -38,11 +69,38 @@ var shema_indexes = []shema_struct{
 	shema_struct{"posts", mgo.Index{
 		Key: []string{"_id", "ispage", "ispublished"},
 	}},
+	shema_struct{"posts", mgo.Index{
+		Key: []string{"isscheduled", "publishedat"},
+	}},
+	shema_struct{"posts", mgo.Index{
+		Key: []string{"$text:title", "$text:markdown", "$text:tags.name"},
+	}},
+
+	shema_struct{"post_revisions", mgo.Index{
+		Key: []string{"postid", "createdat"},
+	}},
+
+	shema_struct{"post_stats", mgo.Index{
+		Key:    []string{"postid", "day"},
+		Unique: true,
+	}},
+	shema_struct{"post_stats", mgo.Index{
+		Key: []string{"day"},
+	}},
+
+	shema_struct{"rolesusers", mgo.Index{
+		Key:    []string{"userid"},
+		Unique: true,
+	}},
 
 	shema_struct{"tokens", mgo.Index{
 		Key: []string{"value"},
 	}},
 
+	shema_struct{"invites", mgo.Index{
+		Key: []string{"value"},
+	}},
+
 	shema_struct{"users", mgo.Index{
 		Key: []string{"slug"},
 	}},
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/covrom/dingo/app/utils"
+)
+
+// searchExcerptLength is the length of the excerpts shown in search results.
+const searchExcerptLength = 255
+
+// SearchTerms splits a search query into the terms looked for in the posts.
+func SearchTerms(q string) []string {
+	return strings.Fields(strings.ToLower(q))
+}
+
+// SearchPostList returns a new pager based on the posts and pages whose
+// title, content or tags match the search query. isPage restricts the search
+// to either posts or pages when it is not nil, and orderBy is one of the
+// options of GetAllPostList.
+func (posts *Posts) SearchPostList(q string, page, size int64, isPage *bool, onlyPublished bool, orderBy string) (*utils.Pager, error) {
+	query := PostQuery{IsPage: isPage, Search: q}
+	if onlyPublished {
+		query.onlyPublic()
+	}
+
+	count, err := store.CountPosts(query)
+	if err != nil {
+		return nil, err
+	}
+	pager := utils.NewPager(page, size, count)
+
+	if !pager.IsValid {
+		return pager, fmt.Errorf("Page not found")
+	}
+
+	query.OrderBy = orderBy
+	query.Offset = int(pager.Begin)
+	query.Limit = int(size)
+	return pager, store.FindPosts(query, posts)
+}
+
+// SearchPosts gets the published posts and pages matching the search query,
+// newest first.
+func SearchPosts(q string, offset, limit int) (Posts, error) {
+	var posts Posts
+	query := PostQuery{Search: q, OrderBy: "published_at DESC", Offset: offset, Limit: limit}
+	query.onlyPublic()
+	err := store.FindPosts(query, &posts)
+	return posts, err
+}
+
+// SearchExcerpt returns the excerpt of the post around the first of the
+// search terms it contains, with the terms highlighted.
+func (p *Post) SearchExcerpt(q string) template.HTML {
+	return utils.Snippet(p.Html, SearchTerms(q), searchExcerptLength)
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"html/template"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSearch(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("sqlite://:memory:", true)
+
+		p := mockPost()
+		p.Title = "About gophers"
+		p.Markdown = "Gophers dig <holes> in 100% of gardens."
+		p.Html = "<p>Gophers dig &lt;holes&gt; in 100% of gardens.</p>"
+		So(p.Save(NewTag("Animals", "animals")), ShouldBeNil)
+
+		draft := NewPost()
+		draft.Title = "Draft about gophers"
+		draft.Slug = "draft"
+		So(draft.Save(), ShouldBeNil)
+
+		Convey("Search the title, content and tags", func() {
+			for _, q := range []string{"GOPHERS", "holes", "animals", "100%", "gophers holes"} {
+				posts, err := SearchPosts(q, 0, 10)
+				So(err, ShouldBeNil)
+				So(posts, ShouldHaveLength, 1)
+				So(posts[0].Id, ShouldEqual, p.Id)
+			}
+
+			posts, err := SearchPosts("gophers cats", 0, 10)
+			So(err, ShouldBeNil)
+			So(posts, ShouldBeEmpty)
+		})
+
+		Convey("Search the drafts", func() {
+			posts := new(Posts)
+			pager, err := posts.SearchPostList("gophers", 1, 10, boolPtr(false), false, "created_at DESC")
+			So(err, ShouldBeNil)
+			So(pager.Total, ShouldEqual, 2)
+			So(posts.Len(), ShouldEqual, 2)
+		})
+
+		Convey("Highlight the search terms", func() {
+			So(p.SearchExcerpt("gophers HOLES"), ShouldEqual,
+				template.HTML("<mark>Gophers</mark> dig &lt;<mark>holes</mark>&gt; in 100% of gardens."))
+		})
+
+		Reset(func() {
+			DropDatabase()
+		})
+	})
+}
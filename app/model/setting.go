@@ -2,13 +2,13 @@ package model
 
 import (
 	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/covrom/dingo/app/utils"
-	"github.com/globalsign/mgo/bson"
 )
 
-
 // A Setting is the data type that stores the blog's configuration options. It
 // is essentially a key-value store for settings, along with a type to help
 // specify the specific type of setting. A type can be either
@@ -44,12 +44,22 @@ func GetNavigators() []*Navigator {
 func SetNavigators(labels, urls []string) error {
 	var navs []*Navigator
 	for i, l := range labels {
-		if len(l) < 1 {
+		navs = append(navs, &Navigator{l, urls[i]})
+	}
+	return SaveNavigators(navs)
+}
+
+// SaveNavigators replaces the site navigation menu with the given links, the
+// ones without a label being left out.
+func SaveNavigators(navs []*Navigator) error {
+	var kept []*Navigator
+	for _, n := range navs {
+		if n == nil || len(n.Label) < 1 {
 			continue
 		}
-		navs = append(navs, &Navigator{l, urls[i]})
+		kept = append(kept, n)
 	}
-	navStr, err := json.Marshal(navs)
+	navStr, err := json.Marshal(kept)
 	if err != nil {
 		return err
 	}
@@ -60,8 +70,7 @@ func SetNavigators(labels, urls []string) error {
 
 // GetSetting checks if a setting exists in the DB.
 func (setting *Setting) GetSetting() error {
-	err := setSession.Clone().DB(DBName).C("settings").Find(bson.M{"key": setting.Key}).One(setting)
-	return err
+	return store.GetSetting(setting.Key, setting)
 }
 
 // GetSettingValue returns the Setting value associated with the given Setting
@@ -73,6 +82,22 @@ func GetSettingValue(k string) string {
 	return setting.Value
 }
 
+// GetSettingInt returns the Setting value associated with the given Setting
+// key as a number, or def if the value is not a number or is negative.
+func GetSettingInt(k string, def int) int {
+	n, err := strconv.Atoi(GetSettingValue(k))
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+// AbsoluteUrl returns the absolute URL of the given path of the blog, based
+// on the "site_url" setting.
+func AbsoluteUrl(path string) string {
+	return strings.TrimRight(GetSettingValue("site_url"), "/") + path
+}
+
 // GetCustomSettings returns all custom settings.
 func GetCustomSettings() *Settings {
 	return GetSettingsByType("custom")
@@ -85,18 +110,28 @@ type Settings []*Setting
 // key can be one of "general", "content", "navigation", or "custom".
 func GetSettingsByType(t string) *Settings {
 	settings := new(Settings)
-	err := setSession.Clone().DB(DBName).C("settings").Find(bson.M{"type": t}).All(settings)
-
+	err := store.FindSettingsByType(t, settings)
 	if err != nil {
 		return nil
 	}
 	return settings
 }
 
+// GetAllSettings returns every setting, whatever its type, ordered by key.
+func GetAllSettings() (Settings, error) {
+	settings := Settings{}
+	err := store.FindSettings(&settings)
+	return settings, err
+}
+
 // Save saves the setting to the DB.
 func (setting *Setting) Save() error {
-	_, err := setSession.Clone().DB(DBName).C("settings").Upsert(bson.M{"key": setting.Key}, setting)
-	return err
+	return store.UpsertSetting(setting)
+}
+
+// Delete deletes the setting from the DB.
+func (setting *Setting) Delete() error {
+	return store.DeleteSetting(setting.Key)
 }
 
 // NewSetting returns a new setting from the given key-value pair.
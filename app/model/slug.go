@@ -4,8 +4,6 @@ import (
 	"strconv"
 	"strings"
 	"unicode"
-
-	"github.com/globalsign/mgo"
 )
 
 // GenerateSlug generates a URL-friendly slug. The table is one of "posts",
@@ -52,7 +50,7 @@ func generateUniqueSlug(slug string, table string, suffix int) string {
 	var err error
 	if table == "tags" { // Not needed at the moment. Tags with the same name should have the same slug.
 		// tag := &Tag{Slug: slugToCheck}
-		err = mgo.ErrNotFound //tag.GetTagBySlug()
+		err = ErrNotFound //tag.GetTagBySlug()
 	} else if table == "posts" {
 		post := new(Post)
 		err = post.GetPostBySlug(slugToCheck)
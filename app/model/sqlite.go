@@ -0,0 +1,939 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/russross/meddler"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS posts (
+	Id              TEXT PRIMARY KEY,
+	Title           TEXT NOT NULL DEFAULT '',
+	Slug            TEXT NOT NULL DEFAULT '',
+	Markdown        TEXT NOT NULL DEFAULT '',
+	Html            TEXT NOT NULL DEFAULT '',
+	Image           TEXT NOT NULL DEFAULT '',
+	IsFeatured      BOOLEAN NOT NULL DEFAULT 0,
+	IsPage          BOOLEAN NOT NULL DEFAULT 0,
+	AllowComment    BOOLEAN NOT NULL DEFAULT 0,
+	CommentNum      INTEGER NOT NULL DEFAULT 0,
+	IsPublished     BOOLEAN NOT NULL DEFAULT 0,
+	IsScheduled     BOOLEAN NOT NULL DEFAULT 0,
+	Language        TEXT NOT NULL DEFAULT '',
+	MetaTitle       TEXT NOT NULL DEFAULT '',
+	MetaDescription TEXT NOT NULL DEFAULT '',
+	CreatedAt       DATETIME,
+	CreatedBy       TEXT NOT NULL DEFAULT '',
+	UpdatedAt       DATETIME,
+	UpdatedBy       TEXT NOT NULL DEFAULT '',
+	PublishedAt     DATETIME,
+	PublishedBy     TEXT NOT NULL DEFAULT '',
+	Tags            TEXT,
+	Version         INTEGER NOT NULL DEFAULT 0,
+	CommentDays     INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS posts_slug ON posts (Slug);
+CREATE INDEX IF NOT EXISTS posts_page_published ON posts (IsPage, IsPublished);
+
+CREATE TABLE IF NOT EXISTS post_tags (
+	PostId TEXT NOT NULL,
+	Name   TEXT NOT NULL,
+	Slug   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS post_tags_post ON post_tags (PostId);
+CREATE INDEX IF NOT EXISTS post_tags_slug ON post_tags (Slug);
+
+CREATE TABLE IF NOT EXISTS comments (
+	Id          TEXT PRIMARY KEY,
+	PostId      TEXT NOT NULL DEFAULT '',
+	Author      TEXT NOT NULL DEFAULT '',
+	Email       TEXT NOT NULL DEFAULT '',
+	Avatar      TEXT NOT NULL DEFAULT '',
+	Website     TEXT NOT NULL DEFAULT '',
+	Ip          TEXT NOT NULL DEFAULT '',
+	CreatedAt   DATETIME,
+	Content     TEXT NOT NULL DEFAULT '',
+	UserAgent   TEXT NOT NULL DEFAULT '',
+	Type        TEXT NOT NULL DEFAULT '',
+	Parent      TEXT NOT NULL DEFAULT '',
+	Path        TEXT NOT NULL DEFAULT '',
+	UserId      TEXT NOT NULL DEFAULT '',
+	Status      TEXT NOT NULL DEFAULT 'pending',
+	Reason      TEXT NOT NULL DEFAULT '',
+	ModeratedBy TEXT NOT NULL DEFAULT '',
+	ModeratedAt DATETIME,
+	Notify      BOOLEAN NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS comments_parent ON comments (Parent);
+CREATE INDEX IF NOT EXISTS comments_status ON comments (Status);
+CREATE INDEX IF NOT EXISTS comments_post_status ON comments (PostId, Status);
+CREATE INDEX IF NOT EXISTS comments_email ON comments (Email, Status);
+CREATE INDEX IF NOT EXISTS comments_path ON comments (Path);
+
+CREATE TABLE IF NOT EXISTS users (
+	Id             TEXT PRIMARY KEY,
+	Name           TEXT NOT NULL DEFAULT '',
+	Slug           TEXT NOT NULL DEFAULT '',
+	HashedPassword TEXT NOT NULL DEFAULT '',
+	Email          TEXT NOT NULL DEFAULT '',
+	Image          TEXT NOT NULL DEFAULT '',
+	Cover          TEXT NOT NULL DEFAULT '',
+	Bio            TEXT NOT NULL DEFAULT '',
+	Website        TEXT NOT NULL DEFAULT '',
+	Location       TEXT NOT NULL DEFAULT '',
+	Accessibility  TEXT NOT NULL DEFAULT '',
+	Status         TEXT NOT NULL DEFAULT '',
+	Language       TEXT NOT NULL DEFAULT '',
+	Lastlogin      DATETIME,
+	CreatedAt      DATETIME,
+	CreatedBy      TEXT NOT NULL DEFAULT '',
+	UpdatedAt      DATETIME,
+	UpdatedBy      TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS users_slug ON users (Slug);
+CREATE INDEX IF NOT EXISTS users_name ON users (Name);
+CREATE INDEX IF NOT EXISTS users_email ON users (Email);
+
+CREATE TABLE IF NOT EXISTS rolesusers (
+	RoleId TEXT NOT NULL,
+	UserId TEXT PRIMARY KEY
+);
+CREATE UNIQUE INDEX IF NOT EXISTS rolesusers_userid ON rolesusers (UserId);
+
+CREATE TABLE IF NOT EXISTS tokens (
+	Id        TEXT PRIMARY KEY,
+	Value     TEXT NOT NULL DEFAULT '',
+	UserId    TEXT NOT NULL DEFAULT '',
+	CreatedAt DATETIME,
+	ExpiredAt DATETIME
+);
+CREATE INDEX IF NOT EXISTS tokens_value ON tokens (Value);
+
+CREATE TABLE IF NOT EXISTS invites (
+	Id        TEXT PRIMARY KEY,
+	Value     TEXT NOT NULL DEFAULT '',
+	Email     TEXT NOT NULL DEFAULT '',
+	Role      INTEGER NOT NULL DEFAULT 0,
+	CreatedAt DATETIME,
+	CreatedBy TEXT NOT NULL DEFAULT '',
+	ExpiredAt DATETIME
+);
+CREATE INDEX IF NOT EXISTS invites_value ON invites (Value);
+
+CREATE TABLE IF NOT EXISTS post_revisions (
+	Id        TEXT PRIMARY KEY,
+	PostId    TEXT NOT NULL DEFAULT '',
+	Title     TEXT NOT NULL DEFAULT '',
+	Markdown  TEXT NOT NULL DEFAULT '',
+	Tags      TEXT,
+	CreatedAt DATETIME,
+	CreatedBy TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS post_revisions_post ON post_revisions (PostId, CreatedAt);
+
+CREATE TABLE IF NOT EXISTS settings (
+	Key       TEXT PRIMARY KEY,
+	Value     TEXT NOT NULL DEFAULT '',
+	Type      TEXT NOT NULL DEFAULT '',
+	CreatedAt DATETIME,
+	CreatedBy TEXT NOT NULL DEFAULT '',
+	UpdatedAt DATETIME,
+	UpdatedBy TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS settings_type ON settings (Type);
+
+CREATE TABLE IF NOT EXISTS messages (
+	Id        TEXT PRIMARY KEY,
+	Type      TEXT NOT NULL DEFAULT '',
+	Data      TEXT NOT NULL DEFAULT '',
+	IsRead    BOOLEAN NOT NULL DEFAULT 0,
+	CreatedAt DATETIME
+);
+CREATE INDEX IF NOT EXISTS messages_isread ON messages (IsRead);
+
+CREATE TABLE IF NOT EXISTS post_stats (
+	Id     TEXT PRIMARY KEY,
+	PostId TEXT NOT NULL DEFAULT '',
+	Day    TEXT NOT NULL DEFAULT '',
+	Hits   INTEGER NOT NULL DEFAULT 0,
+	UNIQUE (PostId, Day)
+);
+CREATE INDEX IF NOT EXISTS post_stats_day ON post_stats (Day);
+
+CREATE TABLE IF NOT EXISTS media (
+	Id        TEXT PRIMARY KEY,
+	Key       TEXT NOT NULL DEFAULT '',
+	Name      TEXT NOT NULL DEFAULT '',
+	MimeType  TEXT NOT NULL DEFAULT '',
+	Size      INTEGER NOT NULL DEFAULT 0,
+	Width     INTEGER NOT NULL DEFAULT 0,
+	Height    INTEGER NOT NULL DEFAULT 0,
+	Alt       TEXT NOT NULL DEFAULT '',
+	Caption   TEXT NOT NULL DEFAULT '',
+	Sha256    TEXT NOT NULL DEFAULT '',
+	CreatedAt DATETIME,
+	CreatedBy TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS media_key ON media (Key);
+CREATE INDEX IF NOT EXISTS media_sha256 ON media (Sha256);
+
+CREATE TABLE IF NOT EXISTS post_media (
+	PostId TEXT NOT NULL,
+	Key    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS post_media_post ON post_media (PostId);
+CREATE INDEX IF NOT EXISTS post_media_key ON post_media (Key);
+
+CREATE TABLE IF NOT EXISTS autosaves (
+	PostId   TEXT NOT NULL,
+	UserId   TEXT NOT NULL,
+	Title    TEXT NOT NULL DEFAULT '',
+	Slug     TEXT NOT NULL DEFAULT '',
+	Image    TEXT NOT NULL DEFAULT '',
+	Markdown TEXT NOT NULL DEFAULT '',
+	Tags     TEXT NOT NULL DEFAULT '',
+	Version  INTEGER NOT NULL DEFAULT 0,
+	SavedAt  DATETIME,
+	PRIMARY KEY (PostId, UserId)
+);
+`
+
+// sqliteTables lists the tables created by sqliteSchema, used to drop the
+// database.
+var sqliteTables = []string{"posts", "post_tags", "comments", "users", "rolesusers", "tokens", "invites", "post_revisions", "settings", "messages", "post_stats", "media", "post_media", "autosaves"}
+
+// sqliteColumns lists the columns added to the tables after they were first
+// released, which Setup adds to the databases created before. The fill
+// statement, if any, sets the new column from the columns it replaces, which
+// are left in the table as older SQLite versions can not drop them. The fill
+// statements run once every column is added, so they can read the columns
+// of the entries listed before theirs.
+var sqliteColumns = []struct{ table, column, def, fill string }{
+	{"comments", "Spam", "BOOLEAN NOT NULL DEFAULT 0", ""},
+	{"comments", "SpamReason", "TEXT NOT NULL DEFAULT ''", ""},
+	{"comments", "Notify", "BOOLEAN NOT NULL DEFAULT 0", ""},
+	{"posts", "Version", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"posts", "CommentDays", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"comments", "Path", "TEXT NOT NULL DEFAULT ''", ""},
+	{"comments", "Status", "TEXT NOT NULL DEFAULT 'pending'",
+		"UPDATE comments SET Status = CASE WHEN Spam THEN 'spam' WHEN Approved THEN 'approved' ELSE 'pending' END, Reason = SpamReason"},
+	{"comments", "Reason", "TEXT NOT NULL DEFAULT ''", ""},
+	{"comments", "ModeratedBy", "TEXT NOT NULL DEFAULT ''", ""},
+	{"comments", "ModeratedAt", "DATETIME", ""},
+}
+
+// sqliteOrderByStmt maps the keys of safeOrderByStmt to SQLite `ORDER BY`
+// clauses.
+var sqliteOrderByStmt = map[string]string{
+	"created_at":        "CreatedAt",
+	"created_at DESC":   "CreatedAt DESC",
+	"updated_at":        "UpdatedAt",
+	"updated_at DESC":   "UpdatedAt DESC",
+	"published_at":      "PublishedAt",
+	"published_at DESC": "PublishedAt DESC",
+}
+
+func init() {
+	meddler.Register("objectid", objectIdMeddler{})
+}
+
+// objectIdMeddler stores a bson.ObjectId as its hex representation.
+type objectIdMeddler struct{}
+
+func (objectIdMeddler) PreRead(fieldAddr interface{}) (interface{}, error) {
+	return new(sql.NullString), nil
+}
+
+func (objectIdMeddler) PostRead(fieldAddr, scanTarget interface{}) error {
+	hex := scanTarget.(*sql.NullString).String
+	id := fieldAddr.(*bson.ObjectId)
+	if bson.IsObjectIdHex(hex) {
+		*id = bson.ObjectIdHex(hex)
+	} else {
+		*id = ""
+	}
+	return nil
+}
+
+func (objectIdMeddler) PreWrite(field interface{}) (interface{}, error) {
+	id, ok := field.(bson.ObjectId)
+	if !ok {
+		return nil, fmt.Errorf("objectIdMeddler.PreWrite: unknown struct field type: %T", field)
+	}
+	return id.Hex(), nil
+}
+
+// sqliteStore is the SQLite storage backend, usually used for small blogs
+// that don't want to run a MongoDB server, and for tests with ":memory:".
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer at a time, and every connection to
+	// ":memory:" would get its own database.
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// notFound converts sql.ErrNoRows into ErrNotFound.
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// upsert inserts src into the table, replacing the row with the same primary
+// key if there is one.
+func upsert(db meddler.DB, table string, src interface{}) error {
+	return insertRow(db, "INSERT OR REPLACE", table, src)
+}
+
+func insertRow(db meddler.DB, verb string, table string, src interface{}) error {
+	names, err := meddler.SQLite.ColumnsQuoted(src, true)
+	if err != nil {
+		return err
+	}
+	placeholders, err := meddler.SQLite.PlaceholdersString(src, true)
+	if err != nil {
+		return err
+	}
+	values, err := meddler.SQLite.Values(src, true)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("%s INTO %s (%s) VALUES (%s)", verb, table, names, placeholders), values...)
+	return err
+}
+
+func (s *sqliteStore) Setup() (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'posts'").Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		if err := s.addColumns(); err != nil {
+			return true, err
+		}
+		// The publish dates used to be kept in the local time zone, which
+		// does not sort, or compare to a date, as text.
+		_, err = s.db.Exec("UPDATE posts SET PublishedAt = strftime('%Y-%m-%d %H:%M:%f+00:00', PublishedAt) WHERE PublishedAt NOT LIKE '%+00:00'")
+		if err != nil {
+			return true, err
+		}
+		// The rolesusers table of the first releases could hold several
+		// roles for a user; the last one saved is kept.
+		_, err = s.db.Exec("DELETE FROM rolesusers WHERE rowid NOT IN (SELECT MAX(rowid) FROM rolesusers GROUP BY UserId)")
+		if err != nil {
+			return true, err
+		}
+	}
+	_, err = s.db.Exec(sqliteSchema)
+	return count > 0, err
+}
+
+// addColumns adds the missing columns of sqliteColumns to the tables.
+func (s *sqliteStore) addColumns() error {
+	// The columns are filled once they are all added.
+	var fills []string
+	for _, c := range sqliteColumns {
+		rows, err := s.db.Query("PRAGMA table_info(" + c.table + ")")
+		if err != nil {
+			return err
+		}
+		exists, found := false, false
+		for rows.Next() {
+			var (
+				cid, notNull, pk int
+				name, typ        string
+				def              sql.NullString
+			)
+			if err := rows.Scan(&cid, &name, &typ, &notNull, &def, &pk); err != nil {
+				rows.Close()
+				return err
+			}
+			exists = true
+			found = found || strings.EqualFold(name, c.column)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if exists && !found {
+			if _, err := s.db.Exec("ALTER TABLE " + c.table + " ADD COLUMN " + c.column + " " + c.def); err != nil {
+				return err
+			}
+			fills = append(fills, c.fill)
+		}
+	}
+	for _, fill := range fills {
+		if fill == "" {
+			continue
+		}
+		if _, err := s.db.Exec(fill); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) DropDatabase() error {
+	for _, t := range sqliteTables {
+		if _, err := s.db.Exec("DROP TABLE IF EXISTS " + t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// postWhere builds the `WHERE` clause and its arguments for a PostQuery.
+func postWhere(q PostQuery) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if q.IsPage != nil {
+		conds = append(conds, "IsPage = ?")
+		args = append(args, *q.IsPage)
+	}
+	if q.IsPublished != nil {
+		conds = append(conds, "IsPublished = ?")
+		args = append(args, *q.IsPublished)
+	}
+	if q.IsScheduled != nil {
+		conds = append(conds, "IsScheduled = ?")
+		args = append(args, *q.IsScheduled)
+	}
+	if q.PublishedBefore != nil {
+		conds = append(conds, "PublishedAt <= ?")
+		args = append(args, q.PublishedBefore.UTC())
+	}
+	if q.TagSlug != "" {
+		conds = append(conds, "Id IN (SELECT PostId FROM post_tags WHERE Slug = ?)")
+		args = append(args, q.TagSlug)
+	}
+	if q.CreatedBy != "" {
+		conds = append(conds, "CreatedBy = ?")
+		args = append(args, q.CreatedBy)
+	}
+	for _, term := range SearchTerms(q.Search) {
+		conds = append(conds, `(Title LIKE ? ESCAPE '\' OR Markdown LIKE ? ESCAPE '\' OR
+			Id IN (SELECT PostId FROM post_tags WHERE Name LIKE ? ESCAPE '\'))`)
+		like := "%" + likeEscaper.Replace(term) + "%"
+		args = append(args, like, like, like)
+	}
+	return where(conds), args
+}
+
+// likeEscaper escapes the wildcards of a `LIKE` pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func where(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+func limitOffset(limit, offset int) string {
+	if limit <= 0 {
+		limit = -1
+	}
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+}
+
+// savePost writes the post and its tags in a single transaction.
+func (s *sqliteStore) savePost(verb string, p *Post) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := writePost(tx, verb, p); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// writePost writes the post and its tags within the transaction.
+func writePost(tx *sql.Tx, verb string, p *Post) error {
+	if err := insertRow(tx, verb, "posts", p); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM post_tags WHERE PostId = ?", p.Id.Hex()); err != nil {
+		return err
+	}
+	for _, t := range p.Tags {
+		if _, err := tx.Exec("INSERT INTO post_tags (PostId, Name, Slug) VALUES (?, ?, ?)", p.Id.Hex(), t.Name, t.Slug); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) InsertPost(p *Post) error {
+	return s.savePost("INSERT", p)
+}
+
+func (s *sqliteStore) UpsertPost(p *Post) error {
+	return s.savePost("INSERT OR REPLACE", p)
+}
+
+func (s *sqliteStore) UpdatePost(p *Post, version int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	// Updating the row locks the database until the post is written.
+	res, err := tx.Exec("UPDATE posts SET Version = Version WHERE Id = ? AND Version = ?", p.Id.Hex(), version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return ErrConflict
+	}
+	if err := writePost(tx, "INSERT OR REPLACE", p); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) DeletePost(id bson.ObjectId) error {
+	res, err := s.db.Exec("DELETE FROM posts WHERE Id = ?", id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	_, err = s.db.Exec("DELETE FROM post_tags WHERE PostId = ?", id.Hex())
+	return err
+}
+
+func (s *sqliteStore) IncrementCommentNum(id bson.ObjectId) error {
+	res, err := s.db.Exec("UPDATE posts SET CommentNum = CommentNum + 1 WHERE Id = ?", id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) GetPost(id bson.ObjectId, p *Post) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, p, "SELECT * FROM posts WHERE Id = ?", id.Hex()))
+}
+
+func (s *sqliteStore) GetPostBySlug(slug string, p *Post) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, p, "SELECT * FROM posts WHERE Slug = ? LIMIT 1", slug))
+}
+
+func (s *sqliteStore) CountPosts(q PostQuery) (int64, error) {
+	var count int64
+	w, args := postWhere(q)
+	err := s.db.QueryRow("SELECT COUNT(*) FROM posts"+w, args...).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStore) FindPosts(q PostQuery, posts *Posts) error {
+	w, args := postWhere(q)
+	orderBy, ok := sqliteOrderByStmt[q.OrderBy]
+	if !ok {
+		orderBy = "PublishedAt DESC"
+	}
+	return meddler.SQLite.QueryAll(s.db, posts, "SELECT * FROM posts"+w+" ORDER BY "+orderBy+limitOffset(q.Limit, q.Offset), args...)
+}
+
+func (s *sqliteStore) ReassignPosts(from, to string) error {
+	_, err := s.db.Exec("UPDATE posts SET CreatedBy = ? WHERE CreatedBy = ?", to, from)
+	return err
+}
+
+func (s *sqliteStore) GetAllTags() (Tags, error) {
+	rows, err := s.db.Query("SELECT DISTINCT Name, Slug FROM post_tags")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tags Tags
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Name, &t.Slug); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags.GetDistinctBySlug(), rows.Err()
+}
+
+// commentWhere builds the `WHERE` clause and its arguments for a
+// CommentQuery.
+func commentWhere(q CommentQuery) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if q.PostId != "" {
+		conds = append(conds, "PostId = ?")
+		args = append(args, q.PostId)
+	}
+	if q.Parent != nil {
+		conds = append(conds, "Parent = ?")
+		args = append(args, *q.Parent)
+	}
+	if len(q.Status) > 0 {
+		conds = append(conds, "Status IN (?"+strings.Repeat(", ?", len(q.Status)-1)+")")
+		for _, status := range q.Status {
+			args = append(args, status)
+		}
+	}
+	if q.Email != "" {
+		conds = append(conds, "Email = ?")
+		args = append(args, q.Email)
+	}
+	if q.PathPrefix != "" {
+		// The paths are made of hex ids and slashes, free of the wildcards
+		// of LIKE.
+		conds = append(conds, "Path LIKE ?")
+		args = append(args, q.PathPrefix+"%")
+	}
+	if q.MissingPath {
+		conds = append(conds, "Parent != '' AND Path = ''")
+	}
+	if q.After != nil {
+		op := ">"
+		if q.OrderBy == "created_at DESC" {
+			op = "<"
+		}
+		conds = append(conds, "(CreatedAt "+op+" ? OR (CreatedAt = ? AND Id "+op+" ?))")
+		args = append(args, q.After.CreatedAt, q.After.CreatedAt, q.After.Id.Hex())
+	}
+	return where(conds), args
+}
+
+func (s *sqliteStore) UpsertComment(c *Comment) error {
+	return upsert(s.db, "comments", c)
+}
+
+func (s *sqliteStore) DeleteComment(id bson.ObjectId) error {
+	res, err := s.db.Exec("DELETE FROM comments WHERE Id = ?", id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) GetComment(id bson.ObjectId, c *Comment) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, c, "SELECT * FROM comments WHERE Id = ?", id.Hex()))
+}
+
+func (s *sqliteStore) CountComments(q CommentQuery) (int64, error) {
+	var count int64
+	w, args := commentWhere(q)
+	err := s.db.QueryRow("SELECT COUNT(*) FROM comments"+w, args...).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStore) FindComments(q CommentQuery, comments *Comments) error {
+	w, args := commentWhere(q)
+	orderBy := ""
+	switch q.OrderBy {
+	case "created_at":
+		orderBy = " ORDER BY CreatedAt, Id"
+	case "created_at DESC":
+		orderBy = " ORDER BY CreatedAt DESC, Id DESC"
+	}
+	return meddler.SQLite.QueryAll(s.db, comments, "SELECT * FROM comments"+w+orderBy+limitOffset(q.Limit, q.Offset), args...)
+}
+
+func (s *sqliteStore) UpsertUser(u *User) error {
+	return upsert(s.db, "users", u)
+}
+
+func (s *sqliteStore) GetUser(id bson.ObjectId, u *User) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, u, "SELECT * FROM users WHERE Id = ?", id.Hex()))
+}
+
+func (s *sqliteStore) GetUserBySlug(slug string, u *User) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, u, "SELECT * FROM users WHERE Slug = ? LIMIT 1", slug))
+}
+
+func (s *sqliteStore) GetUserByName(name string, u *User) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, u, "SELECT * FROM users WHERE Name = ? LIMIT 1", name))
+}
+
+func (s *sqliteStore) GetUserByEmail(email string, u *User) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, u, "SELECT * FROM users WHERE Email = ? LIMIT 1", email))
+}
+
+func (s *sqliteStore) CountUsers() (int64, error) {
+	var count int64
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStore) FindUsers(offset, limit int, users *Users) error {
+	return meddler.SQLite.QueryAll(s.db, users, "SELECT * FROM users ORDER BY CreatedAt"+limitOffset(limit, offset))
+}
+
+func (s *sqliteStore) DeleteUser(id bson.ObjectId) error {
+	res, err := s.db.Exec("DELETE FROM users WHERE Id = ?", id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) UpsertRoleUser(ru *RolesUsers) error {
+	return upsert(s.db, "rolesusers", ru)
+}
+
+func (s *sqliteStore) GetRoleUser(userId string, ru *RolesUsers) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, ru, "SELECT * FROM rolesusers WHERE UserId = ?", userId))
+}
+
+func (s *sqliteStore) DeleteRoleUser(userId string) error {
+	_, err := s.db.Exec("DELETE FROM rolesusers WHERE UserId = ?", userId)
+	return err
+}
+
+func (s *sqliteStore) UpsertSetting(setting *Setting) error {
+	return upsert(s.db, "settings", setting)
+}
+
+func (s *sqliteStore) GetSetting(key string, setting *Setting) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, setting, "SELECT * FROM settings WHERE Key = ?", key))
+}
+
+func (s *sqliteStore) FindSettingsByType(t string, settings *Settings) error {
+	return meddler.SQLite.QueryAll(s.db, settings, "SELECT * FROM settings WHERE Type = ?", t)
+}
+
+func (s *sqliteStore) FindSettings(settings *Settings) error {
+	return meddler.SQLite.QueryAll(s.db, settings, "SELECT * FROM settings ORDER BY Key")
+}
+
+func (s *sqliteStore) DeleteSetting(key string) error {
+	res, err := s.db.Exec("DELETE FROM settings WHERE Key = ?", key)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) UpsertToken(t *Token) error {
+	return upsert(s.db, "tokens", t)
+}
+
+func (s *sqliteStore) GetTokenByValue(value string, t *Token) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, t, "SELECT * FROM tokens WHERE Value = ? LIMIT 1", value))
+}
+
+func (s *sqliteStore) UpsertInvite(i *Invite) error {
+	return upsert(s.db, "invites", i)
+}
+
+func (s *sqliteStore) GetInviteByValue(value string, i *Invite) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, i, "SELECT * FROM invites WHERE Value = ? LIMIT 1", value))
+}
+
+func (s *sqliteStore) DeleteInvite(id bson.ObjectId) error {
+	_, err := s.db.Exec("DELETE FROM invites WHERE Id = ?", id.Hex())
+	return err
+}
+
+func (s *sqliteStore) InsertRevision(r *Revision) error {
+	return insertRow(s.db, "INSERT", "post_revisions", r)
+}
+
+func (s *sqliteStore) GetRevision(id bson.ObjectId, r *Revision) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, r, "SELECT * FROM post_revisions WHERE Id = ?", id.Hex()))
+}
+
+func (s *sqliteStore) FindRevisions(postId string, revisions *Revisions) error {
+	return meddler.SQLite.QueryAll(s.db, revisions, "SELECT * FROM post_revisions WHERE PostId = ? ORDER BY CreatedAt DESC, rowid DESC", postId)
+}
+
+func (s *sqliteStore) PruneRevisions(postId string, keep int) error {
+	_, err := s.db.Exec(`DELETE FROM post_revisions WHERE PostId = ? AND Id NOT IN
+		(SELECT Id FROM post_revisions WHERE PostId = ? ORDER BY CreatedAt DESC, rowid DESC LIMIT ?)`,
+		postId, postId, keep)
+	return err
+}
+
+func (s *sqliteStore) InsertMessage(m *Message) error {
+	return insertRow(s.db, "INSERT", "messages", m)
+}
+
+func (s *sqliteStore) UpsertMessage(m *Message) error {
+	return upsert(s.db, "messages", m)
+}
+
+func (s *sqliteStore) FindUnreadMessages(limit int, messages *Messages) error {
+	return meddler.SQLite.QueryAll(s.db, messages, "SELECT * FROM messages WHERE IsRead = 0 ORDER BY CreatedAt DESC"+limitOffset(limit, 0))
+}
+
+func (s *sqliteStore) FindMessages(messages *Messages) error {
+	return meddler.SQLite.QueryAll(s.db, messages, "SELECT * FROM messages ORDER BY CreatedAt DESC")
+}
+
+func (s *sqliteStore) AddPostHits(postId string, day string, hits int64) error {
+	_, err := s.db.Exec(`INSERT INTO post_stats (Id, PostId, Day, Hits) VALUES (?, ?, ?, ?)
+		ON CONFLICT (PostId, Day) DO UPDATE SET Hits = Hits + excluded.Hits`,
+		bson.NewObjectId().Hex(), postId, day, hits)
+	return err
+}
+
+func (s *sqliteStore) TopPostHits(n int, since string) ([]*PostHits, error) {
+	var hits []*PostHits
+	err := meddler.SQLite.QueryAll(s.db, &hits, `SELECT PostId, SUM(Hits) AS Hits FROM post_stats
+		WHERE PostId != '' AND Day >= ? GROUP BY PostId ORDER BY Hits DESC`+limitOffset(n, 0), since)
+	return hits, err
+}
+
+func (s *sqliteStore) DailyHits(since string) ([]*DayHits, error) {
+	var hits []*DayHits
+	err := meddler.SQLite.QueryAll(s.db, &hits, "SELECT Day, SUM(Hits) AS Hits FROM post_stats WHERE Day >= ? GROUP BY Day ORDER BY Day", since)
+	return hits, err
+}
+
+// mediaKeyWhere returns the condition matching the key, or every key under it
+// if it is a directory.
+func mediaKeyWhere(key string) (string, []interface{}) {
+	if strings.HasSuffix(key, "/") {
+		return `Key LIKE ? ESCAPE '\'`, []interface{}{likeEscaper.Replace(key) + "%"}
+	}
+	return "Key = ?", []interface{}{key}
+}
+
+func mediaWhere(q MediaQuery) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if q.Search != "" {
+		conds = append(conds, `(Name LIKE ? ESCAPE '\' OR Key LIKE ? ESCAPE '\' OR
+			Alt LIKE ? ESCAPE '\' OR Caption LIKE ? ESCAPE '\')`)
+		like := "%" + likeEscaper.Replace(q.Search) + "%"
+		args = append(args, like, like, like, like)
+	}
+	return where(conds), args
+}
+
+func (s *sqliteStore) UpsertMediaItem(m *MediaItem) error {
+	return upsert(s.db, "media", m)
+}
+
+func (s *sqliteStore) GetMediaItem(id bson.ObjectId, m *MediaItem) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, m, "SELECT * FROM media WHERE Id = ?", id.Hex()))
+}
+
+func (s *sqliteStore) GetMediaItemByKey(key string, m *MediaItem) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, m, "SELECT * FROM media WHERE Key = ? LIMIT 1", key))
+}
+
+func (s *sqliteStore) GetMediaItemBySha256(sum string, m *MediaItem) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, m, "SELECT * FROM media WHERE Sha256 = ? LIMIT 1", sum))
+}
+
+func (s *sqliteStore) DeleteMediaItems(key string) error {
+	w, args := mediaKeyWhere(key)
+	_, err := s.db.Exec("DELETE FROM media WHERE "+w, args...)
+	return err
+}
+
+func (s *sqliteStore) CountMediaItems(q MediaQuery) (int64, error) {
+	var count int64
+	w, args := mediaWhere(q)
+	err := s.db.QueryRow("SELECT COUNT(*) FROM media"+w, args...).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStore) FindMediaItems(q MediaQuery, items *MediaItems) error {
+	w, args := mediaWhere(q)
+	return meddler.SQLite.QueryAll(s.db, items, "SELECT * FROM media"+w+" ORDER BY CreatedAt DESC, rowid DESC"+limitOffset(q.Limit, q.Offset), args...)
+}
+
+func (s *sqliteStore) SetPostMedia(postId string, keys []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM post_media WHERE PostId = ?", postId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, key := range keys {
+		if _, err := tx.Exec("INSERT INTO post_media (PostId, Key) VALUES (?, ?)", postId, key); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) FindMediaPosts(key string) ([]string, error) {
+	w, args := mediaKeyWhere(key)
+	rows, err := s.db.Query("SELECT DISTINCT PostId FROM post_media WHERE "+w+" ORDER BY PostId", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *sqliteStore) UpsertAutosave(a *Autosave) error {
+	return upsert(s.db, "autosaves", a)
+}
+
+func (s *sqliteStore) GetAutosave(postId, userId string, a *Autosave) error {
+	return notFound(meddler.SQLite.QueryRow(s.db, a, "SELECT * FROM autosaves WHERE PostId = ? AND UserId = ?", postId, userId))
+}
+
+func (s *sqliteStore) DeleteAutosaves(postId, userId string) error {
+	if userId == "" {
+		_, err := s.db.Exec("DELETE FROM autosaves WHERE PostId = ?", postId)
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM autosaves WHERE PostId = ? AND UserId = ?", postId, userId)
+	return err
+}
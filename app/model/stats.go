@@ -11,8 +11,14 @@ type Statis struct {
 	Files    int
 	Version  int
 	Sessions int
+	// Traffic holds the page views of the last trafficDays days.
+	Traffic []*DayHits
 }
 
+// trafficDays is the number of days shown on the traffic chart of the admin
+// dashboard.
+const trafficDays = 30
+
 // NewStatis returns a new Statis, pulling most info from the DB. The
 // application argumen is required however to determine the number of active
 // sessions.
@@ -26,9 +32,25 @@ func NewStatis(app *golf.Application) *Statis {
 	s.Pages = pageNum
 	s.Sessions = app.SessionManager.Count()
 	s.Comments = commentNum
+	s.Traffic, _ = GetDailyHits(trafficDays)
 	// s.Pages = len(contentsIndex["page"])
 	// s.Files = len(files)
 	// s.Version = GetVersion().Version
 	// s.Readers = len(GetReaders())
 	return s
 }
+
+// TrafficHeight returns the height of the given number of views on the
+// traffic chart, as a percentage of the busiest day.
+func (s *Statis) TrafficHeight(hits int64) int64 {
+	var max int64
+	for _, d := range s.Traffic {
+		if d.Hits > max {
+			max = d.Hits
+		}
+	}
+	if max == 0 {
+		return 0
+	}
+	return hits * 100 / max
+}
@@ -0,0 +1,270 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/covrom/dingo/app/utils"
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrNotFound is returned by a Store when the requested record does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned by a Store when a record was changed by someone else
+// since it was read.
+var ErrConflict = errors.New("conflict")
+
+// store is the storage backend used by every model function. It is set up by
+// Initialize.
+var store Store
+
+// A Store is a storage backend for all the blog data. Dingo ships with a
+// MongoDB backend and a SQLite backend, picked by the scheme of the database
+// URL given to Initialize.
+type Store interface {
+	PostStore
+	CommentStore
+	UserStore
+	SettingStore
+	TokenStore
+	InviteStore
+	RevisionStore
+	MessageStore
+	StatsStore
+	MediaLibraryStore
+	AutosaveStore
+
+	// Setup creates the tables or indexes needed by the backend, and reports
+	// whether the database already existed before the call.
+	Setup() (existed bool, err error)
+	// DropDatabase removes all the data kept by the backend.
+	DropDatabase() error
+	// Close releases the connection to the database.
+	Close() error
+}
+
+// A PostQuery selects posts and pages from a PostStore. Nil fields match any
+// value, and a zero Limit returns every matching post.
+type PostQuery struct {
+	IsPage      *bool
+	IsPublished *bool
+	IsScheduled *bool
+	// PublishedBefore only matches the posts published at or before the
+	// given time.
+	PublishedBefore *time.Time
+	TagSlug         string
+	// CreatedBy only matches the posts written by the user with the given
+	// id.
+	CreatedBy string
+	// Search only matches the posts whose title, content or tags contain
+	// the words of the search query. MongoDB uses its text index, so words
+	// are matched by their stem, while SQLite matches every word as a
+	// substring.
+	Search string
+	// OrderBy is one of the keys of safeOrderByStmt.
+	OrderBy string
+	Offset  int
+	Limit   int
+}
+
+// onlyPublic restricts the query to the posts the readers can see: published,
+// and not dated in the future.
+func (q *PostQuery) onlyPublic() {
+	q.IsPublished = boolPtr(true)
+	q.PublishedBefore = utils.Now()
+}
+
+// A PostStore keeps posts and pages along with their tags.
+type PostStore interface {
+	InsertPost(p *Post) error
+	UpsertPost(p *Post) error
+	// UpdatePost replaces the post if its stored version still is the given
+	// one, and returns ErrConflict otherwise.
+	UpdatePost(p *Post, version int64) error
+	DeletePost(id bson.ObjectId) error
+	// IncrementCommentNum adds one to the comment count of the post, leaving
+	// the rest of the post as it is stored.
+	IncrementCommentNum(id bson.ObjectId) error
+	GetPost(id bson.ObjectId, p *Post) error
+	GetPostBySlug(slug string, p *Post) error
+	CountPosts(q PostQuery) (int64, error)
+	FindPosts(q PostQuery, posts *Posts) error
+	// ReassignPosts gives all the posts created by a user to another one.
+	ReassignPosts(from, to string) error
+	// GetAllTags returns every tag used by at least one post.
+	GetAllTags() (Tags, error)
+}
+
+// A CommentQuery selects comments from a CommentStore. Nil fields match any
+// value, and a zero Limit returns every matching comment.
+type CommentQuery struct {
+	PostId string
+	Parent *string
+	// Status selects the comments in one of these moderation states.
+	Status []string
+	// Email selects the comments left with this email address.
+	Email string
+	// PathPrefix selects the comments whose Path starts with it, that is the
+	// replies at any depth to the comment whose ThreadPath it is.
+	PathPrefix string
+	// MissingPath selects the replies saved before the comments had a Path.
+	MissingPath bool
+	// OrderBy is either "created_at" or "created_at DESC". The comments
+	// created at the same time are sorted by id.
+	OrderBy string
+	// After selects the comments following it in the order of OrderBy, to
+	// page through the comments with a cursor.
+	After  *Comment
+	Offset int
+	Limit  int
+}
+
+// A CommentStore keeps the comments left on posts.
+type CommentStore interface {
+	UpsertComment(c *Comment) error
+	DeleteComment(id bson.ObjectId) error
+	GetComment(id bson.ObjectId, c *Comment) error
+	CountComments(q CommentQuery) (int64, error)
+	FindComments(q CommentQuery, comments *Comments) error
+}
+
+// A UserStore keeps the users of the blog and their roles.
+type UserStore interface {
+	UpsertUser(u *User) error
+	GetUser(id bson.ObjectId, u *User) error
+	GetUserBySlug(slug string, u *User) error
+	GetUserByName(name string, u *User) error
+	GetUserByEmail(email string, u *User) error
+	CountUsers() (int64, error)
+	// FindUsers returns the users ordered by creation date. A zero limit
+	// returns every user.
+	FindUsers(offset, limit int, users *Users) error
+	DeleteUser(id bson.ObjectId) error
+	// UpsertRoleUser sets the role of a user, replacing the previous one.
+	UpsertRoleUser(ru *RolesUsers) error
+	GetRoleUser(userId string, ru *RolesUsers) error
+	DeleteRoleUser(userId string) error
+}
+
+// A SettingStore keeps the key-value settings of the blog.
+type SettingStore interface {
+	UpsertSetting(s *Setting) error
+	GetSetting(key string, s *Setting) error
+	FindSettingsByType(t string, settings *Settings) error
+	// FindSettings returns every setting, whatever its type.
+	FindSettings(settings *Settings) error
+	DeleteSetting(key string) error
+}
+
+// A TokenStore keeps the login tokens of the users.
+type TokenStore interface {
+	UpsertToken(t *Token) error
+	GetTokenByValue(value string, t *Token) error
+}
+
+// An InviteStore keeps the invites sent to new users.
+type InviteStore interface {
+	UpsertInvite(i *Invite) error
+	GetInviteByValue(value string, i *Invite) error
+	DeleteInvite(id bson.ObjectId) error
+}
+
+// A RevisionStore keeps the past versions of the posts.
+type RevisionStore interface {
+	InsertRevision(r *Revision) error
+	GetRevision(id bson.ObjectId, r *Revision) error
+	// FindRevisions returns the revisions of a post, newest first.
+	FindRevisions(postId string, revisions *Revisions) error
+	// PruneRevisions deletes all the revisions of a post but the newest
+	// keep ones.
+	PruneRevisions(postId string, keep int) error
+}
+
+// A MessageStore keeps the messages shown on the admin dashboard.
+type MessageStore interface {
+	InsertMessage(m *Message) error
+	UpsertMessage(m *Message) error
+	FindUnreadMessages(limit int, messages *Messages) error
+	// FindMessages returns every message, newest first.
+	FindMessages(messages *Messages) error
+}
+
+// A StatsStore keeps the daily page views of the posts. Days are formatted
+// by StatsDay, so they can be compared as strings.
+type StatsStore interface {
+	// AddPostHits adds hits to the views of the post on the given day.
+	AddPostHits(postId string, day string, hits int64) error
+	// TopPostHits returns the n posts with the most views since the given
+	// day, most viewed first. Views that do not belong to a post are left
+	// out.
+	TopPostHits(n int, since string) ([]*PostHits, error)
+	// DailyHits returns the views of every day since the given one that has
+	// at least one view, oldest first.
+	DailyHits(since string) ([]*DayHits, error)
+}
+
+// A MediaQuery selects files from a MediaLibraryStore. A zero Limit returns
+// every matching file.
+type MediaQuery struct {
+	// Search only matches the files whose name, key, alt text or caption
+	// contain it, ignoring case.
+	Search string
+	Offset int
+	Limit  int
+}
+
+// A MediaLibraryStore keeps the records of the uploaded files, and the files
+// used by each post. Keys ending with a slash are directories, standing for
+// every file under them.
+type MediaLibraryStore interface {
+	UpsertMediaItem(m *MediaItem) error
+	GetMediaItem(id bson.ObjectId, m *MediaItem) error
+	GetMediaItemByKey(key string, m *MediaItem) error
+	GetMediaItemBySha256(sum string, m *MediaItem) error
+	// DeleteMediaItems deletes the record of the file with the key.
+	DeleteMediaItems(key string) error
+	CountMediaItems(q MediaQuery) (int64, error)
+	// FindMediaItems returns the matching files, newest first.
+	FindMediaItems(q MediaQuery, items *MediaItems) error
+	// SetPostMedia replaces the keys of the files used by the post.
+	SetPostMedia(postId string, keys []string) error
+	// FindMediaPosts returns the ids of the posts using the file with the
+	// key.
+	FindMediaPosts(key string) ([]string, error)
+}
+
+// An AutosaveStore keeps the drafts saved by the editor while a post is
+// written, one per post and user.
+type AutosaveStore interface {
+	// UpsertAutosave replaces the draft of the user for the post.
+	UpsertAutosave(a *Autosave) error
+	GetAutosave(postId, userId string, a *Autosave) error
+	// DeleteAutosaves deletes the draft of the user for the post, or every
+	// draft of the post if userId is empty.
+	DeleteAutosaves(postId, userId string) error
+}
+
+// openStore opens the backend matching the scheme of the given database URL.
+// URLs starting with "sqlite://" are opened with SQLite, while "mongodb://"
+// URLs and plain host names are opened with MongoDB.
+func openStore(dbUrl string) (Store, error) {
+	switch {
+	case strings.HasPrefix(dbUrl, "sqlite://"):
+		return newSQLiteStore(strings.TrimPrefix(dbUrl, "sqlite://"))
+	case strings.HasPrefix(dbUrl, "mongodb://"), !strings.Contains(dbUrl, "://"):
+		return newMongoStore(dbUrl)
+	default:
+		return nil, fmt.Errorf("unsupported database url: %s", dbUrl)
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	Convey("Initialize an in-memory SQLite database", t, func() {
+		err := Initialize("sqlite://:memory:", false)
+		So(err, ShouldBeNil)
+
+		Convey("Welcome data should be created", func() {
+			posts := new(Posts)
+			_, err := posts.GetPostList(1, 5, false, true, "published_at DESC")
+			So(err, ShouldBeNil)
+			So(posts.Len(), ShouldEqual, 1)
+			So(posts.Get(0).TagString(), ShouldEqual, "Welcome, Dingo")
+			So(posts.Get(0).Comments(), ShouldHaveLength, 1)
+			So(GetSettingValue("theme"), ShouldEqual, "default")
+		})
+
+		Convey("Save and find a post by tag", func() {
+			p := mockPost()
+			p.Slug = "sqlite-post"
+			err := p.Save(GenerateTagsFromCommaString("SQLite")...)
+			So(err, ShouldBeNil)
+
+			posts := new(Posts)
+			_, err = posts.GetPostsByTag("sqlite", 1, 5, true)
+			So(err, ShouldBeNil)
+			So(posts.Len(), ShouldEqual, 1)
+			So(posts.Get(0).Id, ShouldEqual, p.Id)
+
+			tags := new(Tags)
+			err = tags.GetAllTags()
+			So(err, ShouldBeNil)
+			So(tags.Len(), ShouldEqual, 3)
+
+			Convey("Delete the post", func() {
+				err := DeletePostById(p.Id.Hex())
+				So(err, ShouldBeNil)
+
+				err = new(Post).GetPostById(p.Id)
+				So(err, ShouldEqual, ErrNotFound)
+			})
+		})
+
+		Convey("Create a user", func() {
+			user := mockUser()
+			err := user.Create(password)
+			So(err, ShouldBeNil)
+
+			u := &User{Email: user.Email}
+			So(u.CheckPassword(password), ShouldBeTrue)
+			So(u.UserEmailExist(), ShouldBeTrue)
+			So((&User{Email: "nobody@example.com"}).UserEmailExist(), ShouldBeFalse)
+		})
+
+		Convey("The first user of a blog without roles becomes the owner", func() {
+			first, second := mockUser(), NewUser("second@example.com", "Second")
+			later := first.CreatedAt.Add(time.Second)
+			second.CreatedAt = &later
+			So(first.Create(password), ShouldBeNil)
+			So(second.Create(password), ShouldBeNil)
+			So(migrateUserRoles(), ShouldBeNil)
+
+			u := &User{Id: first.Id}
+			So(u.GetUserById(), ShouldBeNil)
+			So(u.Role, ShouldEqual, RoleOwner)
+			u = &User{Id: second.Id}
+			So(u.GetUserById(), ShouldBeNil)
+			So(u.Role, ShouldEqual, RoleAuthor)
+
+			So(u.ChangeRole(RoleEditor), ShouldBeNil)
+			So(migrateUserRoles(), ShouldBeNil)
+			So(u.GetUserById(), ShouldBeNil)
+			So(u.Role, ShouldEqual, RoleEditor)
+		})
+
+		Reset(func() {
+			DropDatabase()
+		})
+	})
+
+	Convey("Keep one role per user in an older SQLite database", t, func() {
+		dir, _ := ioutil.TempDir("", "dingo-sqlite")
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "dingo.db")
+		db, err := sql.Open("sqlite3", file)
+		So(err, ShouldBeNil)
+		schema := strings.Replace(sqliteSchema, "UserId TEXT PRIMARY KEY", "UserId TEXT NOT NULL", 1)
+		schema = strings.Replace(schema, "CREATE UNIQUE INDEX IF NOT EXISTS rolesusers_userid", "-- ", 1)
+		_, err = db.Exec(schema)
+		So(err, ShouldBeNil)
+		_, err = db.Exec("INSERT INTO rolesusers (RoleId, UserId) VALUES ('1', 'user'), ('3', 'user')")
+		So(err, ShouldBeNil)
+		db.Close()
+
+		s, err := newSQLiteStore(file)
+		So(err, ShouldBeNil)
+		defer s.Close()
+		_, err = s.Setup()
+		So(err, ShouldBeNil)
+		So(s.UpsertRoleUser(&RolesUsers{RoleId: "2", UserId: "user"}), ShouldBeNil)
+
+		var count int
+		So(s.db.QueryRow("SELECT COUNT(*) FROM rolesusers").Scan(&count), ShouldBeNil)
+		So(count, ShouldEqual, 1)
+		ru := new(RolesUsers)
+		So(s.GetRoleUser("user", ru), ShouldBeNil)
+		So(ru.RoleId, ShouldEqual, "2")
+	})
+
+	Convey("Keep the publish dates of an older SQLite database in UTC", t, func() {
+		dir, _ := ioutil.TempDir("", "dingo-sqlite")
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "dingo.db")
+		db, err := sql.Open("sqlite3", file)
+		So(err, ShouldBeNil)
+		_, err = db.Exec(sqliteSchema)
+		So(err, ShouldBeNil)
+		_, err = db.Exec("INSERT INTO posts (Id, Tags, PublishedAt) VALUES ('5a0000000000000000000001', '[]', '2015-03-04 14:06:07.5+09:00')")
+		So(err, ShouldBeNil)
+		db.Close()
+
+		s, err := newSQLiteStore(file)
+		So(err, ShouldBeNil)
+		defer s.Close()
+		_, err = s.Setup()
+		So(err, ShouldBeNil)
+
+		var publishedAt string
+		So(s.db.QueryRow("SELECT CAST(PublishedAt AS TEXT) FROM posts").Scan(&publishedAt), ShouldBeNil)
+		So(publishedAt, ShouldEqual, "2015-03-04 05:06:07.500+00:00")
+		p := new(Post)
+		So(s.GetPost(bson.ObjectIdHex("5a0000000000000000000001"), p), ShouldBeNil)
+		So(p.PublishedAt.Equal(time.Date(2015, 3, 4, 5, 6, 7, 5e8, time.UTC)), ShouldBeTrue)
+	})
+
+	Convey("Unknown database schemes should be rejected", t, func() {
+		_, err := openStore("postgres://localhost/dingo")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -1,8 +1,10 @@
 package model
 
 import (
+	"errors"
 	"strings"
 
+	"github.com/covrom/dingo/app/utils"
 	"github.com/globalsign/mgo/bson"
 )
 
@@ -85,11 +87,10 @@ func GenerateTagsFromCommaString(input string) (output Tags) {
 
 // GetTagsByPostId finds all the tags with the give PostID
 func (tags *Tags) GetTagsByPostId(postId string) error {
-
-	ts := &struct{ Tags Tags }{}
-	err := postSession.Clone().DB(DBName).C("posts").FindId(bson.ObjectIdHex(postId)).Select(bson.M{"tags": 1}).One(ts)
+	post := new(Post)
+	err := post.GetPostById(bson.ObjectIdHex(postId))
 	if err == nil {
-		*tags = ts.Tags.GetDistinctBySlug()
+		*tags = post.Tags.GetDistinctBySlug()
 	}
 
 	return err
@@ -97,23 +98,96 @@ func (tags *Tags) GetTagsByPostId(postId string) error {
 
 // GetTagBySlug finds the tag based on the Tag's slug value.
 func (tag *Tag) GetTagBySlug() error {
-	ts := &struct{ Tags Tags }{}
-	err := postSession.Clone().DB(DBName).C("posts").Find(bson.M{"tags.slug": tag.Slug}).Select(bson.M{"tags": 1}).Limit(1).One(ts)
-	for _, tst := range ts.Tags {
+	posts := new(Posts)
+	err := store.FindPosts(PostQuery{TagSlug: tag.Slug, Limit: 1}, posts)
+	if err != nil {
+		return err
+	}
+	if posts.Len() == 0 {
+		return ErrNotFound
+	}
+	for _, tst := range posts.Get(0).Tags {
 		if tst.Slug == tag.Slug {
 			*tag = tst
 			break
 		}
 	}
-	return err
+	return nil
 }
 
 // GetAllTags gets all the tags in the DB.
 func (tags *Tags) GetAllTags() error {
-	ts := &struct{ Tags Tags }{}
-	err := postSession.Clone().DB(DBName).C("posts").Find(bson.M{}).Select(bson.M{"tags": 1}).One(ts)
+	all, err := store.GetAllTags()
 	if err == nil {
-		*tags = ts.Tags.GetDistinctBySlug()
+		*tags = all
 	}
 	return err
 }
+
+// ErrTagExists is returned when renaming a tag to the slug of another tag,
+// which it has to be merged into instead.
+var ErrTagExists = errors.New("a tag with that slug already exists")
+
+// RenameTag gives the tag with the slug a new name and slug on every post,
+// and returns the renamed tag. An empty slug is generated from the name.
+func RenameTag(slug string, to Tag) (Tag, error) {
+	if to.Slug == "" {
+		to.Slug = GenerateSlug(to.Name, "tags")
+	}
+	if to.Slug != slug {
+		if err := (&Tag{Slug: to.Slug}).GetTagBySlug(); err == nil {
+			return to, ErrTagExists
+		} else if err != ErrNotFound {
+			return to, err
+		}
+	}
+	return to, replaceTag(slug, &to)
+}
+
+// MergeTags replaces the tag with the slug by the tag with the slug into on
+// every post.
+func MergeTags(slug, into string) error {
+	target := &Tag{Slug: into}
+	if err := target.GetTagBySlug(); err != nil {
+		return err
+	}
+	return replaceTag(slug, target)
+}
+
+// DeleteTag removes the tag with the slug from every post.
+func DeleteTag(slug string) error {
+	return replaceTag(slug, nil)
+}
+
+// replaceTag replaces the tag with the slug by the given tag, or removes it
+// if nil, on every post having it. Each post is saved as a new version,
+// written as a revision, so that the editors open on it conflict rather than
+// bring the tag back. It returns ErrNotFound if no post has the tag.
+func replaceTag(slug string, to *Tag) error {
+	posts := new(Posts)
+	if err := store.FindPosts(PostQuery{TagSlug: slug}, posts); err != nil {
+		return err
+	}
+	if posts.Len() == 0 {
+		return ErrNotFound
+	}
+	for _, p := range *posts {
+		var tags Tags
+		for _, t := range p.Tags {
+			if t.Slug != slug {
+				tags = append(tags, t)
+			} else if to != nil {
+				tags = append(tags, *to)
+			}
+		}
+		p.Tags = tags.GetDistinctBySlug()
+		p.UpdatedAt = utils.Now()
+		if err := p.Update(); err != nil {
+			return err
+		}
+		if err := p.saveRevision(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
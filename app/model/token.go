@@ -12,7 +12,7 @@ import (
 
 // A Token is used to associate a user with a session.
 type Token struct {
-	Id        bson.ObjectId `bson:"_id"`
+	Id        bson.ObjectId `bson:"_id" meddler:"Id,objectid"`
 	Value     string
 	UserId    string
 	CreatedAt *time.Time
@@ -37,22 +37,20 @@ func (t *Token) Save() error {
 	if len(t.Id) == 0 {
 		t.Id = bson.NewObjectId()
 	}
-	_, err := userSession.Clone().DB(DBName).C("tokens").UpsertId(t.Id, t)
-
-	return err
+	return store.UpsertToken(t)
 }
 
 // GetTokenByValue gets a token from the DB based on it's value.
 func (t *Token) GetTokenByValue() error {
-	err := userSession.Clone().DB(DBName).C("tokens").Find(bson.M{"value": t.Value}).One(t)
-	return err
+	return store.GetTokenByValue(t.Value, t)
 }
 
-// IsValid checks whether or not the token is valid.
+// IsValid checks whether or not the token is valid. Tokens of suspended
+// users are not.
 func (t *Token) IsValid() bool {
 	u := &User{Id: bson.ObjectIdHex(t.UserId)}
 	err := u.GetUserById()
-	if err != nil {
+	if err != nil || u.IsSuspended() {
 		return false
 	}
 	return t.ExpiredAt.After(*utils.Now())
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/covrom/dingo/app/utils"
@@ -10,10 +12,10 @@ import (
 
 // A User is a user on the site.
 type User struct {
-	Id             bson.ObjectId `bson:"_id"`
+	Id             bson.ObjectId `bson:"_id" meddler:"Id,objectid"`
 	Name           string
 	Slug           string
-	HashedPassword string
+	HashedPassword string `json:"-"`
 	Email          string
 	Image          string // NULL
 	Cover          string // NULL
@@ -21,14 +23,58 @@ type User struct {
 	Website        string // NULL
 	Location       string // NULL
 	Accessibility  string
-	Status         string
+	Status         string // "" or UserStatusActive, or UserStatusSuspended
 	Language       string
 	Lastlogin      *time.Time
 	CreatedAt      *time.Time
 	CreatedBy      string
 	UpdatedAt      *time.Time
 	UpdatedBy      string
-	Role           int `bson:"-"` //1 = Administrator, 2 = Editor, 3 = Author, 4 = Owner
+	Role           int `bson:"-" meddler:"-"` // Kept in rolesusers: 1 = Administrator, 2 = Editor, 3 = Author, 4 = Owner
+}
+
+// The statuses a user can have. Suspended users can not log in anymore.
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+)
+
+// ErrOwnerChange is returned when trying to suspend or delete the Owner.
+var ErrOwnerChange = errors.New("the owner of the blog can not be suspended or deleted")
+
+// A PublicUser is what anyone may know of a user, as the author of posts.
+type PublicUser struct {
+	Id      bson.ObjectId
+	Name    string
+	Slug    string
+	Image   string
+	Bio     string
+	Website string
+}
+
+// Public returns the PublicUser of the user.
+func (u *User) Public() *PublicUser {
+	return &PublicUser{
+		Id:      u.Id,
+		Name:    u.Name,
+		Slug:    u.Slug,
+		Image:   u.Image,
+		Bio:     u.Bio,
+		Website: u.Website,
+	}
+}
+
+// Users is a slice of "User"s
+type Users []*User
+
+// Len returns the amount of "User"s.
+func (u Users) Len() int {
+	return len(u)
+}
+
+// Get returns the User at the given index.
+func (u Users) Get(i int) *User {
+	return u[i]
 }
 
 var ghostUser = &User{Id: "", Name: "Blog User", Email: "example@example.com"}
@@ -72,9 +118,10 @@ func (u *User) Update() error {
 	if len(u.Slug) == 0 {
 		u.Slug = GenerateSlug(u.Id.Hex()+u.Email, "users")
 	}
-	_, err := userSession.Clone().DB(DBName).C("users").UpsertId(u.Id, u)
-
-	return err
+	if err := store.UpsertUser(u); err != nil {
+		return err
+	}
+	return u.saveRole()
 }
 
 // ChangePassword changes the password for the given user.
@@ -120,27 +167,34 @@ func (u *User) Avatar() string {
 
 // GetUserById finds the user by ID in the DB.
 func (u *User) GetUserById() error {
-	err := userSession.Clone().DB(DBName).C("users").FindId(u.Id).One(u)
-
-	return err
+	if err := store.GetUser(u.Id, u); err != nil {
+		return err
+	}
+	return u.loadRole()
 }
 
 // GetUserBySlug finds the user by their slug in the DB.
 func (u *User) GetUserBySlug() error {
-	err := userSession.Clone().DB(DBName).C("users").Find(bson.M{"slug": u.Slug}).One(u)
-	return err
+	if err := store.GetUserBySlug(u.Slug, u); err != nil {
+		return err
+	}
+	return u.loadRole()
 }
 
 // GetUserByName finds the user by name in the DB.
 func (u *User) GetUserByName() error {
-	err := userSession.Clone().DB(DBName).C("users").Find(bson.M{"name": u.Name}).One(u)
-	return err
+	if err := store.GetUserByName(u.Name, u); err != nil {
+		return err
+	}
+	return u.loadRole()
 }
 
 // GetUserByEmail finds the user by email in the DB.
 func (u *User) GetUserByEmail() error {
-	err := userSession.Clone().DB(DBName).C("users").Find(bson.M{"email": u.Email}).One(u)
-	return err
+	if err := store.GetUserByEmail(u.Email, u); err != nil {
+		return err
+	}
+	return u.loadRole()
 }
 
 // Insert inserts the user into the DB.
@@ -151,32 +205,81 @@ func (u *User) Insert() error {
 	if len(u.Slug) == 0 {
 		u.Slug = GenerateSlug(u.Id.Hex()+u.Email, "users")
 	}
-	_, err := userSession.Clone().DB(DBName).C("users").UpsertId(u.Id, u)
-	return err
+	if err := store.UpsertUser(u); err != nil {
+		return err
+	}
+	return u.saveRole()
 }
 
+// A RolesUsers maps a user to their role.
 type RolesUsers struct {
 	RoleId string
 	UserId string
 }
 
-// InsertRoleUser assigns a role to the given user based on the given Role ID.
+// InsertRoleUser assigns a role to the given user based on the given Role ID,
+// replacing the role the user had before.
 func InsertRoleUser(role_id string, user_id string) error {
-	err := userSession.Clone().DB(DBName).C("rolesusers").Insert(&RolesUsers{RoleId: role_id, UserId: user_id})
-	return err //writeDB.Commit()
+	return store.UpsertRoleUser(&RolesUsers{RoleId: role_id, UserId: user_id})
 }
 
 // UserEmailExist checks to see if the given User's email exists.
 func (u User) UserEmailExist() bool {
-	count, err := userSession.Clone().DB(DBName).C("users").Find(bson.M{"email": u.Email}).Count()
-	if count > 0 || err != nil {
-		return true
-	}
-	return false
+	err := store.GetUserByEmail(u.Email, new(User))
+	return err != ErrNotFound
 }
 
 // GetNumberOfUsers returns the total number of users.
 func GetNumberOfUsers() (int64, error) {
-	count, err := userSession.Clone().DB(DBName).C("users").Find(bson.M{}).Count()
-	return int64(count), err
+	return store.CountUsers()
+}
+
+// IsSuspended reports whether the user has been suspended.
+func (u *User) IsSuspended() bool {
+	return u.Status == UserStatusSuspended
+}
+
+// SetStatus changes the status of the user, and saves it to the DB. The Owner
+// can not be suspended.
+func (u *User) SetStatus(status string) error {
+	if status == UserStatusSuspended && u.Role == RoleOwner {
+		return ErrOwnerChange
+	}
+	u.Status = status
+	return u.Update()
+}
+
+// Delete deletes the user from the DB, after giving their posts to the user
+// with the given ID. The Owner can not be deleted.
+func (u *User) Delete(reassignTo string) error {
+	if u.Role == RoleOwner {
+		return ErrOwnerChange
+	}
+	if err := store.ReassignPosts(u.Id.Hex(), reassignTo); err != nil {
+		return err
+	}
+	if err := store.DeleteRoleUser(u.Id.Hex()); err != nil && err != ErrNotFound {
+		return err
+	}
+	return store.DeleteUser(u.Id)
+}
+
+// GetUserList gets a page of users, ordered by creation date.
+func (users *Users) GetUserList(page, size int64) (*utils.Pager, error) {
+	count, err := GetNumberOfUsers()
+	if err != nil {
+		return nil, err
+	}
+	pager := utils.NewPager(page, size, count)
+	if !pager.IsValid {
+		return pager, fmt.Errorf("Page not found")
+	}
+	return pager, store.FindUsers(int(pager.Begin), int(size), users)
+}
+
+// GetAllUsers gets a slice of users of length <= limit, starting at offset.
+func GetAllUsers(offset, limit int) (Users, error) {
+	var users Users
+	err := store.FindUsers(offset, limit, &users)
+	return users, err
 }
@@ -0,0 +1,94 @@
+package utils
+
+import "strings"
+
+// The kinds of line found in a diff.
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// A DiffLine is a single line of a line diff.
+type DiffLine struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+}
+
+// LineDiff returns the line diff turning a into b, based on their longest
+// common subsequence of lines.
+func LineDiff(a, b string) []DiffLine {
+	x := splitLines(a)
+	y := splitLines(b)
+
+	// The common prefix and suffix are left out of the LCS table, since most
+	// revisions only change a few lines.
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	diff := make([]DiffLine, 0, len(x)+len(y))
+	for _, l := range x[:prefix] {
+		diff = append(diff, DiffLine{DiffEqual, l})
+	}
+	diff = append(diff, lcsDiff(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])...)
+	for _, l := range x[len(x)-suffix:] {
+		diff = append(diff, DiffLine{DiffEqual, l})
+	}
+	return diff
+}
+
+func lcsDiff(x, y []string) []DiffLine {
+	// lcs[i][j] is the length of the LCS of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []DiffLine
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			diff = append(diff, DiffLine{DiffEqual, x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{DiffDelete, x[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{DiffInsert, y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		diff = append(diff, DiffLine{DiffDelete, x[i]})
+	}
+	for ; j < len(y); j++ {
+		diff = append(diff, DiffLine{DiffInsert, y[j]})
+	}
+	return diff
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n")
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestLineDiff(t *testing.T) {
+	Convey("Diff two texts", t, func() {
+		diff := LineDiff("a\nb\nc\nd", "a\nc\nx\nd")
+		So(diff, ShouldResemble, []DiffLine{
+			{DiffEqual, "a"},
+			{DiffDelete, "b"},
+			{DiffEqual, "c"},
+			{DiffInsert, "x"},
+			{DiffEqual, "d"},
+		})
+	})
+
+	Convey("Diff equal texts", t, func() {
+		diff := LineDiff("a\r\nb", "a\nb")
+		So(diff, ShouldResemble, []DiffLine{
+			{DiffEqual, "a"},
+			{DiffEqual, "b"},
+		})
+	})
+
+	Convey("Diff from an empty text", t, func() {
+		diff := LineDiff("", "a")
+		So(diff, ShouldResemble, []DiffLine{
+			{DiffInsert, "a"},
+		})
+	})
+}
@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"unicode"
+)
+
+// A highlightLang tells how the code of a language is split into tokens.
+type highlightLang struct {
+	keywords     map[string]bool
+	lineComments []string
+	// blockComment holds the start and end of the block comments, if the
+	// language has them.
+	blockComment [2]string
+	// quotes are the characters starting and ending the strings.
+	quotes string
+}
+
+func keywords(words string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	cLike = &highlightLang{
+		keywords: keywords(`auto bool break case catch char class const continue default delete do double else enum
+			extends extern false final finally float for goto if implements import int interface long namespace new
+			null nullptr package private protected public return short signed sizeof static struct super switch
+			template this throw throws true try typedef union unsigned using var virtual void volatile while`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+	goLang = &highlightLang{
+		keywords: keywords(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var
+			true false nil iota append cap close copy delete len make new panic print println recover
+			bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string
+			uint uint8 uint16 uint32 uint64 uintptr`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	jsLang = &highlightLang{
+		keywords: keywords(`async await break case catch class const continue debugger default delete do else
+			export extends false finally for from function if import in instanceof interface let new null of
+			return static super switch this throw true try type typeof undefined var void while yield`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	pythonLang = &highlightLang{
+		keywords: keywords(`False None True and as assert async await break class continue def del elif else
+			except finally for from global if import in is lambda nonlocal not or pass raise return self try
+			while with yield`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	}
+	shellLang = &highlightLang{
+		keywords: keywords(`case do done echo elif else esac exit export fi for function if in local read
+			return set shift then unset until while`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	}
+	sqlLang = &highlightLang{
+		keywords: keywords(`ADD ALL ALTER AND AS ASC BETWEEN BY CASE CREATE DEFAULT DELETE DESC DISTINCT DROP ELSE
+			END EXISTS FROM GROUP HAVING IN INDEX INNER INSERT INTO IS JOIN KEY LEFT LIKE LIMIT NOT NULL OFFSET ON
+			OR ORDER OUTER PRIMARY REPLACE RIGHT SELECT SET TABLE THEN UNION UNIQUE UPDATE VALUES WHEN WHERE`),
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+	jsonLang = &highlightLang{
+		keywords: keywords(`true false null`),
+		quotes:   `"`,
+	}
+)
+
+// highlightLangs maps the names given to the fenced code blocks to their
+// language.
+var highlightLangs = map[string]*highlightLang{
+	"go":         goLang,
+	"golang":     goLang,
+	"js":         jsLang,
+	"javascript": jsLang,
+	"ts":         jsLang,
+	"typescript": jsLang,
+	"json":       jsonLang,
+	"py":         pythonLang,
+	"python":     pythonLang,
+	"sh":         shellLang,
+	"bash":       shellLang,
+	"shell":      shellLang,
+	"sql":        sqlLang,
+	"c":          cLike,
+	"cpp":        cLike,
+	"c++":        cLike,
+	"cs":         cLike,
+	"csharp":     cLike,
+	"java":       cLike,
+	"php":        cLike,
+}
+
+// CanHighlight reports whether Highlight knows the language.
+func CanHighlight(lang string) bool {
+	return highlightLangs[strings.ToLower(lang)] != nil
+}
+
+// Highlight returns the code of the language as escaped HTML, with its
+// keywords, strings, comments and numbers wrapped in spans of the hl-keyword,
+// hl-string, hl-comment and hl-number classes. The code of an unknown
+// language is only escaped.
+func Highlight(code, lang string) string {
+	l := highlightLangs[strings.ToLower(lang)]
+	if l == nil {
+		return template.HTMLEscapeString(code)
+	}
+	var b bytes.Buffer
+	span := func(class, text string) {
+		b.WriteString(`<span class="hl-` + class + `">` + template.HTMLEscapeString(text) + `</span>`)
+	}
+	for i := 0; i < len(code); {
+		rest := code[i:]
+		if n := l.comment(rest); n > 0 {
+			span("comment", rest[:n])
+			i += n
+			continue
+		}
+		if strings.IndexByte(l.quotes, rest[0]) >= 0 {
+			n := quotedLength(rest)
+			span("string", rest[:n])
+			i += n
+			continue
+		}
+		r := []rune(rest[:utf8Len(rest)])[0]
+		switch {
+		case unicode.IsDigit(r):
+			n := strings.IndexFunc(rest, func(r rune) bool {
+				return !unicode.IsDigit(r) && !unicode.IsLetter(r) && r != '.' && r != '_'
+			})
+			if n < 0 {
+				n = len(rest)
+			}
+			span("number", rest[:n])
+			i += n
+		case unicode.IsLetter(r) || r == '_':
+			n := strings.IndexFunc(rest, func(r rune) bool {
+				return !unicode.IsDigit(r) && !unicode.IsLetter(r) && r != '_'
+			})
+			if n < 0 {
+				n = len(rest)
+			}
+			word := rest[:n]
+			if l.keywords[word] || (l == sqlLang && l.keywords[strings.ToUpper(word)]) {
+				span("keyword", word)
+			} else {
+				b.WriteString(template.HTMLEscapeString(word))
+			}
+			i += n
+		default:
+			n := utf8Len(rest)
+			b.WriteString(template.HTMLEscapeString(rest[:n]))
+			i += n
+		}
+	}
+	return b.String()
+}
+
+// comment returns the length of the comment the code starts with, or zero.
+func (l *highlightLang) comment(code string) int {
+	for _, start := range l.lineComments {
+		if strings.HasPrefix(code, start) {
+			if n := strings.IndexByte(code, '\n'); n >= 0 {
+				return n
+			}
+			return len(code)
+		}
+	}
+	if start, end := l.blockComment[0], l.blockComment[1]; start != "" && strings.HasPrefix(code, start) {
+		if n := strings.Index(code[len(start):], end); n >= 0 {
+			return len(start) + n + len(end)
+		}
+		return len(code)
+	}
+	return 0
+}
+
+// quotedLength returns the length of the string the code starts with, up to
+// its closing quote, the end of the line or the end of the code. Quotes
+// escaped by a backslash do not close the string.
+func quotedLength(code string) int {
+	quote := code[0]
+	for i := 1; i < len(code); i++ {
+		switch code[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		case '\n':
+			if quote != '`' {
+				return i
+			}
+		}
+	}
+	return len(code)
+}
+
+// utf8Len returns the length in bytes of the first rune of s.
+func utf8Len(s string) int {
+	for i := range s {
+		if i > 0 {
+			return i
+		}
+	}
+	return len(s)
+}
@@ -1,10 +1,13 @@
 package utils
 
 import (
+	"bytes"
 	"github.com/russross/blackfriday"
+	"html"
 	"html/template"
 	"regexp"
 	"strings"
+	"unicode"
 )
 
 // Html2Str converts the given HTML to a string, removing all HTML tags,
@@ -58,6 +61,65 @@ func Html2Excerpt(html string, length int) string {
 	return SubString(Html2Str(html), 0, length)
 }
 
+// Snippet returns the text excerpt from the given HTML that is "length" long and
+// starts a little before the first of the given lowercase terms it contains,
+// with every term highlighted by a <mark> tag. If none of the terms is found,
+// the excerpt is the same as the one returned by Html2Excerpt.
+func Snippet(content string, terms []string, length int) template.HTML {
+	text := []rune(html.UnescapeString(Html2Str(content)))
+	lower := make([]rune, len(text))
+	for i, r := range text {
+		lower[i] = unicode.ToLower(r)
+	}
+	lowerTerms := make([][]rune, 0, len(terms))
+	for _, t := range terms {
+		if t != "" {
+			lowerTerms = append(lowerTerms, []rune(t))
+		}
+	}
+
+	// matchAt returns the length of the term found at i, or zero.
+	matchAt := func(i int) int {
+		for _, t := range lowerTerms {
+			if i+len(t) <= len(lower) && string(lower[i:i+len(t)]) == string(t) {
+				return len(t)
+			}
+		}
+		return 0
+	}
+
+	begin := 0
+	for i := range lower {
+		if matchAt(i) > 0 {
+			begin = i - length/4
+			break
+		}
+	}
+	if begin < 0 {
+		begin = 0
+	}
+	end := begin + length
+	if end > len(text) {
+		end = len(text)
+	}
+
+	var b bytes.Buffer
+	for i := begin; i < end; {
+		n := matchAt(i)
+		if n == 0 {
+			b.WriteString(template.HTMLEscapeString(string(text[i])))
+			i++
+			continue
+		}
+		if i+n > end {
+			n = end - i
+		}
+		b.WriteString("<mark>" + template.HTMLEscapeString(string(text[i:i+n])) + "</mark>")
+		i += n
+	}
+	return template.HTML(b.String())
+}
+
 // Markdown2Html returns the given text as Markdown, using BlackFriday as a
 // markdown compiler.
 func Markdown2Html(text string) string {
@@ -0,0 +1,284 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// htmlNode is an element or a piece of text of an HTML document.
+type htmlNode struct {
+	// Tag is the lowercase name of the element, or empty for text.
+	Tag      string
+	Attr     []xml.Attr
+	Text     string
+	Children []*htmlNode
+}
+
+func (n *htmlNode) attr(name string) string {
+	for _, a := range n.Attr {
+		if strings.ToLower(a.Name.Local) == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// parseHtml parses an HTML fragment with the lenient XML decoder, which
+// closes the void and unclosed elements of HTML.
+func parseHtml(s string) (*htmlNode, error) {
+	d := xml.NewDecoder(strings.NewReader(s))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	root := new(htmlNode)
+	stack := []*htmlNode{root}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return root, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &htmlNode{Tag: strings.ToLower(t.Name.Local), Attr: t.Attr}
+			parent.Children = append(parent.Children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.Children = append(parent.Children, &htmlNode{Text: string(t)})
+		case xml.Comment:
+			// The "more" comment splits the summary of the posts.
+			if strings.TrimSpace(string(t)) == "more" {
+				parent.Children = append(parent.Children, &htmlNode{Tag: "!more"})
+			}
+		}
+	}
+}
+
+// rawHtmlTags are the elements kept as HTML, since markdown has no syntax
+// for them.
+var rawHtmlTags = map[string]bool{
+	"table": true, "iframe": true, "video": true, "audio": true, "object": true,
+	"embed": true, "form": true, "dl": true, "sup": true, "sub": true,
+}
+
+// skippedHtmlTags are the elements dropped with their content.
+var skippedHtmlTags = map[string]bool{"script": true, "style": true, "head": true}
+
+var (
+	blankLinesRe  = regexp.MustCompile(`\n[ \t]*\n(\s*\n)+`)
+	spacesRe      = regexp.MustCompile(`\s+`)
+	indentationRe = regexp.MustCompile(`\n[ \t]+`)
+)
+
+// Html2Markdown converts HTML to markdown. The elements markdown can not
+// express, like tables, are kept as HTML. The HTML is returned unchanged if
+// it can not be parsed.
+func Html2Markdown(s string) string {
+	root, err := parseHtml(s)
+	if err != nil {
+		return s
+	}
+	var buf bytes.Buffer
+	writeMarkdownChildren(&buf, root)
+	md := blankLinesRe.ReplaceAllString(buf.String(), "\n\n")
+	return strings.TrimSpace(md)
+}
+
+func writeMarkdownChildren(buf *bytes.Buffer, n *htmlNode) {
+	for i, c := range n.Children {
+		// The line break after a <br> is already written.
+		if c.Tag == "" && i > 0 && n.Children[i-1].Tag == "br" {
+			c = &htmlNode{Text: strings.TrimLeft(c.Text, " \t\n")}
+		}
+		writeMarkdown(buf, c)
+	}
+}
+
+// markdownOf returns the markdown of the children of the node.
+func markdownOf(n *htmlNode) string {
+	var buf bytes.Buffer
+	writeMarkdownChildren(&buf, n)
+	return buf.String()
+}
+
+// inlineMarkdownOf returns the markdown of the children of the node on a
+// single line.
+func inlineMarkdownOf(n *htmlNode) string {
+	return strings.TrimSpace(spacesRe.ReplaceAllString(markdownOf(n), " "))
+}
+
+// textOf returns the text of the node and its children.
+func textOf(n *htmlNode) string {
+	if n.Tag == "" {
+		return n.Text
+	}
+	var buf bytes.Buffer
+	for _, c := range n.Children {
+		buf.WriteString(textOf(c))
+	}
+	return buf.String()
+}
+
+// markdownEscaper keeps the text from being read as HTML by markdown.
+var markdownEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;")
+
+func writeMarkdown(buf *bytes.Buffer, n *htmlNode) {
+	if n.Tag == "" {
+		// The indentation of the HTML would start code blocks.
+		buf.WriteString(markdownEscaper.Replace(indentationRe.ReplaceAllString(n.Text, "\n")))
+		return
+	}
+	if skippedHtmlTags[n.Tag] {
+		return
+	}
+	if rawHtmlTags[n.Tag] {
+		buf.WriteString("\n\n")
+		writeHtml(buf, n)
+		buf.WriteString("\n\n")
+		return
+	}
+	switch n.Tag {
+	case "!more":
+		buf.WriteString("\n\n<!--more-->\n\n")
+	case "p", "div", "section", "article", "header", "footer", "figure", "figcaption", "main", "aside":
+		buf.WriteString("\n\n")
+		writeMarkdownChildren(buf, n)
+		buf.WriteString("\n\n")
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(n.Tag[1:])
+		buf.WriteString("\n\n" + strings.Repeat("#", level) + " " + inlineMarkdownOf(n) + "\n\n")
+	case "br":
+		buf.WriteString("  \n")
+	case "hr":
+		buf.WriteString("\n\n---\n\n")
+	case "strong", "b":
+		writeMarkdownSpan(buf, "**", n)
+	case "em", "i":
+		writeMarkdownSpan(buf, "*", n)
+	case "del", "s", "strike":
+		writeMarkdownSpan(buf, "~~", n)
+	case "code", "tt", "kbd":
+		code := textOf(n)
+		if strings.Contains(code, "`") {
+			buf.WriteString("`` " + code + " ``")
+		} else {
+			buf.WriteString("`" + code + "`")
+		}
+	case "pre":
+		code := strings.Trim(textOf(n), "\n")
+		buf.WriteString("\n\n```\n" + code + "\n```\n\n")
+	case "a":
+		text := inlineMarkdownOf(n)
+		href := n.attr("href")
+		if href == "" {
+			buf.WriteString(text)
+			return
+		}
+		buf.WriteString("[" + text + "](" + markdownUrl(href))
+		if title := n.attr("title"); title != "" {
+			buf.WriteString(` "` + strings.Replace(title, `"`, `\"`, -1) + `"`)
+		}
+		buf.WriteString(")")
+	case "img":
+		buf.WriteString("![" + n.attr("alt") + "](" + markdownUrl(n.attr("src")) + ")")
+	case "blockquote":
+		buf.WriteString("\n\n" + prefixLines(strings.TrimSpace(blankLinesRe.ReplaceAllString(markdownOf(n), "\n\n")), "> ", "> ") + "\n\n")
+	case "ul", "ol":
+		buf.WriteString("\n\n")
+		i := 1
+		for _, c := range n.Children {
+			if c.Tag != "li" {
+				continue
+			}
+			bullet := "- "
+			if n.Tag == "ol" {
+				bullet = strconv.Itoa(i) + ". "
+			}
+			item := strings.TrimSpace(blankLinesRe.ReplaceAllString(markdownOf(c), "\n\n"))
+			buf.WriteString(prefixLines(item, bullet, strings.Repeat(" ", len(bullet))) + "\n")
+			i++
+		}
+		buf.WriteString("\n")
+	default:
+		writeMarkdownChildren(buf, n)
+	}
+}
+
+// writeMarkdownSpan writes the children of the node between the delimiters,
+// keeping the spaces around the text out of them.
+func writeMarkdownSpan(buf *bytes.Buffer, delim string, n *htmlNode) {
+	text := markdownOf(n)
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		buf.WriteString(text)
+		return
+	}
+	start := strings.Index(text, trimmed)
+	buf.WriteString(text[:start] + delim + trimmed + delim + text[start+len(trimmed):])
+}
+
+// prefixLines prefixes the first line of the text with first, and the other
+// lines with rest, without trailing spaces.
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		switch {
+		case i == 0:
+			lines[i] = first + l
+		case l == "":
+			lines[i] = strings.TrimRight(rest, " ")
+		default:
+			lines[i] = rest + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// markdownUrl escapes the characters of the URL ending a markdown link.
+func markdownUrl(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(u)
+}
+
+// writeHtml writes the node back as HTML.
+func writeHtml(buf *bytes.Buffer, n *htmlNode) {
+	if n.Tag == "" {
+		buf.WriteString(html.EscapeString(n.Text))
+		return
+	}
+	if n.Tag == "!more" {
+		return
+	}
+	buf.WriteString("<" + n.Tag)
+	for _, a := range n.Attr {
+		buf.WriteString(" " + a.Name.Local + `="` + html.EscapeString(a.Value) + `"`)
+	}
+	buf.WriteString(">")
+	if isVoidHtmlTag(n.Tag) {
+		return
+	}
+	for _, c := range n.Children {
+		writeHtml(buf, c)
+	}
+	buf.WriteString("</" + n.Tag + ">")
+}
+
+func isVoidHtmlTag(tag string) bool {
+	for _, t := range xml.HTMLAutoClose {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
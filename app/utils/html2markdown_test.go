@@ -0,0 +1,43 @@
+package utils
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestHtml2Markdown(t *testing.T) {
+	Convey("Convert paragraphs and inline elements", t, func() {
+		md := Html2Markdown(`<h2>Title <em>here</em></h2>
+<p>Some <strong>bold</strong> and <i>italic</i> text with <code>code</code>,<br>
+a <a href="http://example.com/a b" title="Example">link</a> and <img src="/upload/a.png" alt="an image"></p>`)
+		So(md, ShouldEqual, "## Title *here*\n\n"+
+			"Some **bold** and *italic* text with `code`,  \n"+
+			"a [link](http://example.com/a%20b \"Example\") and ![an image](/upload/a.png)")
+	})
+
+	Convey("Convert lists, quotes and code blocks", t, func() {
+		md := Html2Markdown(`<ul>
+  <li>one</li>
+  <li>two
+    <ol><li>nested</li></ol>
+  </li>
+</ul>
+<blockquote><p>quoted</p><p>twice</p></blockquote>
+<pre><code>if a &lt; b {
+    return
+}</code></pre>`)
+		So(md, ShouldEqual, "- one\n- two\n\n  1. nested\n\n"+
+			"> quoted\n>\n> twice\n\n"+
+			"```\nif a < b {\n    return\n}\n```")
+	})
+
+	Convey("Keep text as text", t, func() {
+		So(Html2Markdown("a &lt;b&gt; &amp; c"), ShouldEqual, "a &lt;b> &amp; c")
+		So(Html2Markdown("first\n\n<!--more-->\n\nsecond"), ShouldEqual, "first\n\n<!--more-->\n\nsecond")
+	})
+
+	Convey("Keep what markdown can not express as HTML", t, func() {
+		So(Html2Markdown(`<p>before</p><table><tr><td>cell</td></tr></table><script>alert(1)</script>`),
+			ShouldEqual, "before\n\n<table><tr><td>cell</td></tr></table>")
+	})
+}
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// ResizeImage scales the image down to the given size, averaging the pixels
+// of the image covered by each pixel of the result.
+func ResizeImage(src image.Image, width, height int) *image.RGBA {
+	rgba := toRGBA(src)
+	b := rgba.Bounds()
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	if width != b.Dx() {
+		rgba = resizeAxis(rgba, width, b.Dy(), areaWeights(b.Dx(), width), true)
+	}
+	if height != b.Dy() {
+		rgba = resizeAxis(rgba, width, height, areaWeights(b.Dy(), height), false)
+	}
+	return rgba
+}
+
+// FitSize returns the size of an image of the given size scaled down to fit
+// the maximum width and height, keeping its aspect ratio. A zero maximum is
+// no limit.
+func FitSize(width, height, maxWidth, maxHeight int) (int, int) {
+	if maxWidth > 0 && width > maxWidth {
+		height = (height*maxWidth + width/2) / width
+		width = maxWidth
+	}
+	if maxHeight > 0 && height > maxHeight {
+		width = (width*maxHeight + height/2) / height
+		height = maxHeight
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return width, height
+}
+
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	return rgba
+}
+
+// areaWeight is the first pixel of a line covered by a pixel of the line
+// scaled down, and the weights of the pixels it covers.
+type areaWeight struct {
+	start   int
+	weights []float64
+}
+
+// areaWeights returns the weights of the pixels of a line of size pixels
+// scaled down to n pixels.
+func areaWeights(size, n int) []areaWeight {
+	scale := float64(size) / float64(n)
+	ws := make([]areaWeight, n)
+	for i := range ws {
+		lo, hi := float64(i)*scale, float64(i+1)*scale
+		start := int(lo)
+		ws[i].start = start
+		for j := start; j < size && float64(j) < hi; j++ {
+			from, to := float64(j), float64(j+1)
+			if from < lo {
+				from = lo
+			}
+			if to > hi {
+				to = hi
+			}
+			ws[i].weights = append(ws[i].weights, (to-from)/scale)
+		}
+	}
+	return ws
+}
+
+// resizeAxis resizes the image to width x height along one of its axes,
+// horizontally or vertically.
+func resizeAxis(src *image.RGBA, width, height int, ws []areaWeight, horizontal bool) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	var sum [4]float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var w areaWeight
+			if horizontal {
+				w = ws[x]
+			} else {
+				w = ws[y]
+			}
+			sum = [4]float64{}
+			for k, weight := range w.weights {
+				var i int
+				if horizontal {
+					i = src.PixOffset(w.start+k, y)
+				} else {
+					i = src.PixOffset(x, w.start+k)
+				}
+				for c := 0; c < 4; c++ {
+					sum[c] += float64(src.Pix[i+c]) * weight
+				}
+			}
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				v := sum[c] + 0.5
+				if v > 255 {
+					v = 255
+				}
+				dst.Pix[i+c] = uint8(v)
+			}
+		}
+	}
+	return dst
+}
+
+// JpegOrientation returns the EXIF orientation of a JPEG image, from 1 to 8,
+// or 1 if it has none.
+func JpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		// The image data starts after the start of scan segment.
+		if marker == 0xDA || size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag of the first IFD of the TIFF
+// structure of EXIF data.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < n; k++ {
+		entry := ifd + 2 + k*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+	return 1
+}
+
+// OrientImage rotates and flips the image as given by its EXIF orientation,
+// so that it is displayed the right way without it.
+func OrientImage(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	rgba := toRGBA(src)
+	w, h := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// The pixel of the source displayed at x, y.
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], rgba.Pix[rgba.PixOffset(sx, sy):rgba.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// exifJpeg returns a JPEG image whose EXIF data has the orientation.
+func exifJpeg(img image.Image, orientation byte) []byte {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, nil)
+	data := buf.Bytes()
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1,
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0,
+		0, 0, 0, 0}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := append([]byte{0xFF, 0xE1, 0, byte(len(segment) + 2)}, segment...)
+	return append(append(append([]byte(nil), data[:2]...), app1...), data[2:]...)
+}
+
+func TestResizeImage(t *testing.T) {
+	Convey("Fit sizes in a box", t, func() {
+		w, h := FitSize(4000, 3000, 1200, 0)
+		So(w, ShouldEqual, 1200)
+		So(h, ShouldEqual, 900)
+		w, h = FitSize(3000, 4000, 2400, 2400)
+		So(w, ShouldEqual, 1800)
+		So(h, ShouldEqual, 2400)
+		w, h = FitSize(100, 50, 1200, 1200)
+		So(w, ShouldEqual, 100)
+		So(h, ShouldEqual, 50)
+	})
+
+	Convey("Average the pixels of a scaled down image", t, func() {
+		src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+		for x := 0; x < 4; x++ {
+			for y := 0; y < 2; y++ {
+				if x%2 == 0 {
+					src.Set(x, y, color.RGBA{255, 255, 255, 255})
+				} else {
+					src.Set(x, y, color.RGBA{0, 0, 0, 255})
+				}
+			}
+		}
+		dst := ResizeImage(src, 2, 1)
+		So(dst.Bounds().Dx(), ShouldEqual, 2)
+		So(dst.Bounds().Dy(), ShouldEqual, 1)
+		So(dst.RGBAAt(0, 0), ShouldResemble, color.RGBA{128, 128, 128, 255})
+		So(dst.RGBAAt(1, 0), ShouldResemble, color.RGBA{128, 128, 128, 255})
+	})
+}
+
+func TestOrientImage(t *testing.T) {
+	Convey("Read the EXIF orientation of JPEG images", t, func() {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		So(JpegOrientation(exifJpeg(img, 6)), ShouldEqual, 6)
+		So(JpegOrientation(exifJpeg(img, 9)), ShouldEqual, 1)
+
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, img, nil)
+		So(JpegOrientation(buf.Bytes()), ShouldEqual, 1)
+		So(JpegOrientation([]byte("not a jpeg")), ShouldEqual, 1)
+	})
+
+	Convey("Rotate images as their orientation says", t, func() {
+		// A 2x1 image, red on the left and blue on the right.
+		red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+		src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+		src.Set(0, 0, red)
+		src.Set(1, 0, blue)
+
+		So(OrientImage(src, 1), ShouldEqual, src)
+
+		flipped := OrientImage(src, 2).(*image.RGBA)
+		So(flipped.RGBAAt(0, 0), ShouldResemble, blue)
+
+		// Orientation 6 is displayed rotated 90 degrees clockwise.
+		rotated := OrientImage(src, 6).(*image.RGBA)
+		So(rotated.Bounds().Dx(), ShouldEqual, 1)
+		So(rotated.Bounds().Dy(), ShouldEqual, 2)
+		So(rotated.RGBAAt(0, 0), ShouldResemble, red)
+		So(rotated.RGBAAt(0, 1), ShouldResemble, blue)
+
+		// Orientation 8 is displayed rotated 90 degrees counterclockwise.
+		rotated = OrientImage(src, 8).(*image.RGBA)
+		So(rotated.RGBAAt(0, 0), ShouldResemble, blue)
+		So(rotated.RGBAAt(0, 1), ShouldResemble, red)
+	})
+}
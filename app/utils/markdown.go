@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/russross/blackfriday"
+)
+
+// MediaPrefix starts the links and images pointing to the files of the media
+// store by their key, as in ![Photo](media:photo.png).
+const MediaPrefix = "media:"
+
+// TOCMarker is the paragraph replaced by the table of contents of the text.
+const TOCMarker = "[TOC]"
+
+// MarkdownOptions are the extensions of the Markdown pipeline.
+type MarkdownOptions struct {
+	// Highlight highlights the syntax of the fenced code blocks given a
+	// language.
+	Highlight bool
+	// HeadingIDs gives every heading an id and an anchor linking to it.
+	HeadingIDs bool
+	// TOC replaces the [TOC] paragraphs by the table of contents.
+	TOC bool
+	// Footnotes renders the footnotes, written [^1] and defined as [^1]: Note.
+	Footnotes bool
+	// MediaUrl returns the URL of the file of the media store with the key,
+	// or an empty string if there is no such file. Links and images point
+	// to the files by their media: key, or by a reference named as the key.
+	MediaUrl func(key string) string
+}
+
+// commentExtensions are the extensions of the Markdown of the comments. Line
+// breaks are kept, as the comments are written in a plain text area.
+const commentExtensions = blackfriday.NoIntraEmphasis | blackfriday.FencedCode | blackfriday.Autolink |
+	blackfriday.Strikethrough | blackfriday.HardLineBreak
+
+// CommentMarkdown returns the HTML of the Markdown text of a comment. The
+// comments are meant to have the emphasis, code, links and quotes of
+// Markdown only, the rest of the markup being removed by the comment policy.
+func CommentMarkdown(text string) string {
+	r := blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{Flags: blackfriday.UseXHTML})
+	return string(blackfriday.Run([]byte(text), blackfriday.WithExtensions(commentExtensions), blackfriday.WithRenderer(r)))
+}
+
+// markdownRenderer renders the HTML of the Markdown text with the extensions
+// the default renderer lacks.
+type markdownRenderer struct {
+	*blackfriday.HTMLRenderer
+	opts MarkdownOptions
+}
+
+// RenderNode renders the fenced code blocks with their syntax highlighted and
+// the anchors of the headings, and leaves the other nodes to the default
+// renderer.
+func (r *markdownRenderer) RenderNode(w io.Writer, node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+	switch node.Type {
+	case blackfriday.CodeBlock:
+		lang := codeLanguage(node.Info)
+		if !r.opts.Highlight || !CanHighlight(lang) {
+			break
+		}
+		io.WriteString(w, `<pre><code class="language-`+template.HTMLEscapeString(lang)+`">`)
+		io.WriteString(w, Highlight(string(node.Literal), lang))
+		io.WriteString(w, "</code></pre>\n")
+		return blackfriday.GoToNext
+	case blackfriday.Heading:
+		if !entering && r.opts.HeadingIDs && node.HeadingID != "" {
+			io.WriteString(w, `<a class="heading-anchor" href="#`+template.HTMLEscapeString(node.HeadingID)+`" aria-hidden="true">#</a>`)
+		}
+	}
+	return r.HTMLRenderer.RenderNode(w, node, entering)
+}
+
+// codeLanguage returns the language in the info string of a code block.
+func codeLanguage(info []byte) string {
+	if fields := strings.Fields(string(info)); len(fields) > 0 {
+		return strings.ToLower(fields[0])
+	}
+	return ""
+}
+
+// Markdown returns the HTML of the Markdown text, rendered with the
+// extensions of the options.
+func Markdown(text string, opts MarkdownOptions) string {
+	extensions := blackfriday.CommonExtensions
+	flags := blackfriday.CommonHTMLFlags
+	if opts.HeadingIDs || opts.TOC {
+		extensions |= blackfriday.AutoHeadingIDs
+	}
+	if opts.Footnotes {
+		extensions |= blackfriday.Footnotes
+		flags |= blackfriday.FootnoteReturnLinks
+	}
+	r := &markdownRenderer{
+		HTMLRenderer: blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+			Flags:                      flags,
+			FootnoteReturnLinkContents: "↩",
+		}),
+		opts: opts,
+	}
+	options := []blackfriday.Option{blackfriday.WithExtensions(extensions), blackfriday.WithRenderer(r)}
+	if opts.MediaUrl != nil {
+		options = append(options, blackfriday.WithRefOverride(func(ref string) (*blackfriday.Reference, bool) {
+			if u := opts.MediaUrl(ref); u != "" {
+				return &blackfriday.Reference{Link: u}, true
+			}
+			return nil, false
+		}))
+	}
+	parser := blackfriday.New(options...)
+	ast := parser.Parse([]byte(text))
+
+	var headings []*blackfriday.Node
+	var tocs []*blackfriday.Node
+	ids := make(map[string]int)
+	ast.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering {
+			return blackfriday.GoToNext
+		}
+		switch node.Type {
+		case blackfriday.Heading:
+			if node.HeadingID != "" {
+				node.HeadingID = uniqueHeadingID(ids, node.HeadingID)
+				headings = append(headings, node)
+			}
+		case blackfriday.Paragraph:
+			if opts.TOC && nodeText(node) == TOCMarker {
+				tocs = append(tocs, node)
+			}
+		case blackfriday.Link, blackfriday.Image:
+			dest := string(node.Destination)
+			if opts.MediaUrl != nil && strings.HasPrefix(dest, MediaPrefix) {
+				if u := opts.MediaUrl(strings.TrimPrefix(dest, MediaPrefix)); u != "" {
+					node.Destination = []byte(u)
+				}
+			}
+		}
+		return blackfriday.GoToNext
+	})
+	if len(tocs) > 0 {
+		toc := tableOfContents(headings)
+		for _, p := range tocs {
+			block := blackfriday.NewNode(blackfriday.HTMLBlock)
+			block.Literal = []byte(toc)
+			p.InsertBefore(block)
+			p.Unlink()
+		}
+	}
+
+	var buf bytes.Buffer
+	r.RenderHeader(&buf, ast)
+	ast.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		return r.RenderNode(&buf, node, entering)
+	})
+	r.RenderFooter(&buf, ast)
+	return buf.String()
+}
+
+// uniqueHeadingID returns the id, with a number appended if a previous
+// heading has it already.
+func uniqueHeadingID(ids map[string]int, id string) string {
+	for {
+		n, found := ids[id]
+		if !found {
+			ids[id] = 0
+			return id
+		}
+		ids[id] = n + 1
+		id = id + "-" + strconv.Itoa(n+1)
+	}
+}
+
+// nodeText returns the text of the node and its children, without markup.
+func nodeText(node *blackfriday.Node) string {
+	var b bytes.Buffer
+	node.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && (n.Type == blackfriday.Text || n.Type == blackfriday.Code) {
+			b.Write(n.Literal)
+		}
+		return blackfriday.GoToNext
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// tableOfContents returns the nested lists linking to the headings.
+func tableOfContents(headings []*blackfriday.Node) string {
+	var b bytes.Buffer
+	b.WriteString(`<nav class="toc">`)
+	var levels []int
+	for _, h := range headings {
+		for len(levels) > 0 && levels[len(levels)-1] > h.Level {
+			b.WriteString("</li></ul>")
+			levels = levels[:len(levels)-1]
+		}
+		if len(levels) == 0 || levels[len(levels)-1] < h.Level {
+			b.WriteString("<ul>")
+			levels = append(levels, h.Level)
+		} else {
+			b.WriteString("</li>")
+		}
+		b.WriteString(`<li><a href="#` + template.HTMLEscapeString(h.HeadingID) + `">` + template.HTMLEscapeString(nodeText(h)) + "</a>")
+	}
+	for range levels {
+		b.WriteString("</li></ul>")
+	}
+	b.WriteString("</nav>")
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHighlight(t *testing.T) {
+	Convey("Highlight the tokens of the code", t, func() {
+		html := Highlight("func main() { // start\n\tprintln(\"<hi>\", 42)\n}", "go")
+		So(html, ShouldContainSubstring, `<span class="hl-keyword">func</span> main()`)
+		So(html, ShouldContainSubstring, `<span class="hl-comment">// start</span>`)
+		So(html, ShouldContainSubstring, `<span class="hl-string">&#34;&lt;hi&gt;&#34;</span>`)
+		So(html, ShouldContainSubstring, `<span class="hl-number">42</span>`)
+	})
+
+	Convey("Escaped quotes do not end the strings", t, func() {
+		So(Highlight(`x = 'it\'s' # done`, "python"), ShouldEqual,
+			`x = <span class="hl-string">&#39;it\&#39;s&#39;</span> <span class="hl-comment"># done</span>`)
+	})
+
+	Convey("Only escape the code of an unknown language", t, func() {
+		So(Highlight("if <a>", "brainfuck"), ShouldEqual, "if &lt;a&gt;")
+		So(CanHighlight("Go"), ShouldBeTrue)
+		So(CanHighlight("brainfuck"), ShouldBeFalse)
+	})
+}
+
+func TestMarkdown(t *testing.T) {
+	all := MarkdownOptions{Highlight: true, HeadingIDs: true, TOC: true, Footnotes: true}
+
+	Convey("Without extensions, render as Markdown2Html does", t, func() {
+		text := "# Title\n\n[TOC]\n\n```go\nfunc f() {}\n```\n\nNote[^1]\n\n[^1]: The note.\n"
+		So(Markdown(text, MarkdownOptions{}), ShouldEqual, Markdown2Html(text))
+	})
+
+	Convey("Highlight the fenced code of known languages", t, func() {
+		html := Markdown("```go\nfunc f() {}\n```\n", all)
+		So(html, ShouldEqual, `<pre><code class="language-go"><span class="hl-keyword">func</span> f() {}`+"\n</code></pre>\n")
+
+		html = Markdown("```lisp\n(car x)\n```\n", all)
+		So(html, ShouldEqual, `<pre><code class="language-lisp">(car x)`+"\n</code></pre>\n")
+	})
+
+	Convey("Give the headings unique ids and anchors", t, func() {
+		html := Markdown("# Intro\n\n## Intro\n", all)
+		So(html, ShouldContainSubstring, `<h1 id="intro">Intro<a class="heading-anchor" href="#intro" aria-hidden="true">#</a></h1>`)
+		So(html, ShouldContainSubstring, `<h2 id="intro-1">Intro<a class="heading-anchor" href="#intro-1" aria-hidden="true">#</a></h2>`)
+	})
+
+	Convey("Replace the [TOC] marker by the table of contents", t, func() {
+		html := Markdown("[TOC]\n\n# One\n\n## Two\n\n# Three\n", all)
+		So(html, ShouldStartWith, `<nav class="toc"><ul><li><a href="#one">One</a><ul><li><a href="#two">Two</a></li></ul>`+
+			`</li><li><a href="#three">Three</a></li></ul></nav>`)
+		So(html, ShouldNotContainSubstring, "[TOC]")
+
+		html = Markdown("[TOC]\n\n# One\n", MarkdownOptions{})
+		So(html, ShouldContainSubstring, "<p>[TOC]</p>")
+	})
+
+	Convey("Render the footnotes", t, func() {
+		html := Markdown("Text[^1]\n\n[^1]: The note.\n", all)
+		So(html, ShouldContainSubstring, `<sup class="footnote-ref" id="fnref:1"><a rel="footnote" href="#fn:1">1</a></sup>`)
+		So(html, ShouldContainSubstring, `<li id="fn:1">The note.`)
+		So(html, ShouldContainSubstring, `href="#fnref:1"`)
+	})
+
+	Convey("Point the links to the uploaded media", t, func() {
+		opts := all
+		opts.MediaUrl = func(key string) string {
+			if key == "photo.png" {
+				return "/upload/photo.png"
+			}
+			return ""
+		}
+		html := Markdown("![Photo](media:photo.png) [file][photo.png] [other][missing.png] [x](media:missing.png)\n", opts)
+		So(html, ShouldContainSubstring, `<img src="/upload/photo.png" alt="Photo" />`)
+		So(html, ShouldContainSubstring, `<a href="/upload/photo.png">file</a>`)
+		So(html, ShouldContainSubstring, `[other][missing.png]`)
+		So(html, ShouldContainSubstring, `<a href="media:missing.png">x</a>`)
+	})
+}
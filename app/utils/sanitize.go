@@ -0,0 +1,365 @@
+package utils
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+// An HtmlPolicy lists the elements and attributes an HTML text is allowed to
+// have. Sanitize removes everything else.
+type HtmlPolicy struct {
+	// Elements maps the allowed elements to the attributes they are allowed
+	// to have.
+	Elements map[string][]string
+	// Attributes are allowed on every allowed element.
+	Attributes []string
+	// Protocols are the schemes allowed in the URLs of the links and
+	// images. Relative URLs are always allowed.
+	Protocols []string
+	// LinkRel, if not empty, is the rel attribute of every link, in place of
+	// the one given.
+	LinkRel string
+}
+
+// RichPolicy allows the HTML of the posts written by authors: the formatting
+// of Markdown and of its extensions, without scripts, styles, frames or
+// forms.
+var RichPolicy = &HtmlPolicy{
+	Elements: map[string][]string{
+		"a":          {"href", "title", "rel", "aria-hidden"},
+		"abbr":       {"title"},
+		"b":          nil,
+		"blockquote": {"cite"},
+		"br":         nil,
+		"caption":    nil,
+		"code":       nil,
+		"dd":         nil,
+		"del":        nil,
+		"div":        nil,
+		"dl":         nil,
+		"dt":         nil,
+		"em":         nil,
+		"figcaption": nil,
+		"figure":     nil,
+		"h1":         nil,
+		"h2":         nil,
+		"h3":         nil,
+		"h4":         nil,
+		"h5":         nil,
+		"h6":         nil,
+		"hr":         nil,
+		"i":          nil,
+		"img":        {"src", "alt", "title", "width", "height"},
+		"ins":        nil,
+		"kbd":        nil,
+		"li":         nil,
+		"mark":       nil,
+		"nav":        nil,
+		"ol":         {"start"},
+		"p":          nil,
+		"pre":        nil,
+		"q":          {"cite"},
+		"s":          nil,
+		"small":      nil,
+		"span":       nil,
+		"strong":     nil,
+		"sub":        nil,
+		"sup":        nil,
+		"table":      nil,
+		"tbody":      nil,
+		"td":         {"align", "colspan", "rowspan"},
+		"tfoot":      nil,
+		"th":         {"align", "colspan", "rowspan"},
+		"thead":      nil,
+		"tr":         nil,
+		"u":          nil,
+		"ul":         nil,
+	},
+	Attributes: []string{"id", "class"},
+	Protocols:  []string{"http", "https", "mailto", "tel", "ftp"},
+}
+
+// CommentPolicy allows the little formatting of the comments of the
+// visitors. Their links are not followed by search engines.
+var CommentPolicy = &HtmlPolicy{
+	Elements: map[string][]string{
+		"a":          {"href", "title"},
+		"b":          nil,
+		"blockquote": nil,
+		"br":         nil,
+		"code":       nil,
+		"del":        nil,
+		"em":         nil,
+		"i":          nil,
+		"p":          nil,
+		"pre":        nil,
+		"strong":     nil,
+	},
+	Protocols: []string{"http", "https", "mailto"},
+	LinkRel:   "nofollow ugc",
+}
+
+// voidElements have no content and no end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "keygen": true, "link": true, "meta": true, "param": true, "source": true,
+	"track": true, "wbr": true,
+}
+
+// droppedElements are removed with their content when not allowed, as it is
+// not text meant to be read.
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "noscript": true, "noembed": true,
+	"noframes": true, "textarea": true, "title": true, "xmp": true, "template": true, "svg": true,
+	"math": true, "select": true, "plaintext": true,
+}
+
+// urlAttributes hold URLs, whose scheme is checked.
+var urlAttributes = map[string]bool{"href": true, "src": true, "cite": true}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+// A htmlAttr is an attribute of an element, with its value unescaped.
+type htmlAttr struct {
+	name, value string
+}
+
+// Sanitize returns the HTML keeping only the elements and attributes of the
+// policy, with the elements balanced. Comments, and the scripts and styles
+// with their content, are removed. The text is escaped again, so that no
+// markup is left out of the elements allowed.
+func (p *HtmlPolicy) Sanitize(s string) string {
+	var b bytes.Buffer
+	var open []string
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			b.WriteString(textEscaper.Replace(html.UnescapeString(s)))
+			break
+		}
+		b.WriteString(textEscaper.Replace(html.UnescapeString(s[:i])))
+		s = s[i:]
+
+		switch {
+		case strings.HasPrefix(s, "<!--"):
+			s = skipPast(s[4:], "-->")
+			continue
+		case strings.HasPrefix(s, "<!"), strings.HasPrefix(s, "<?"):
+			s = skipPast(s, ">")
+			continue
+		}
+		name, attrs, rest, ok := parseTag(s)
+		if !ok {
+			b.WriteString("&lt;")
+			s = s[1:]
+			continue
+		}
+		s = rest
+		closing := strings.HasPrefix(name, "/")
+		name = strings.TrimPrefix(name, "/")
+		allowed, isAllowed := p.Elements[name]
+
+		switch {
+		case closing:
+			if !isAllowed {
+				continue
+			}
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] == name {
+					for k := len(open) - 1; k >= j; k-- {
+						b.WriteString("</" + open[k] + ">")
+					}
+					open = open[:j]
+					break
+				}
+			}
+		case !isAllowed:
+			if droppedElements[name] {
+				s = skipPast(s, "</"+name)
+				s = skipPast(s, ">")
+			}
+		default:
+			tag, ok := p.startTag(name, allowed, attrs)
+			if !ok {
+				continue
+			}
+			b.WriteString(tag)
+			if !voidElements[name] {
+				open = append(open, name)
+			}
+		}
+	}
+	for j := len(open) - 1; j >= 0; j-- {
+		b.WriteString("</" + open[j] + ">")
+	}
+	return b.String()
+}
+
+// startTag returns the start tag of the element with the attributes of the
+// policy, and false if the element is not kept as it misses an attribute.
+func (p *HtmlPolicy) startTag(name string, allowed []string, attrs []htmlAttr) (string, bool) {
+	var b bytes.Buffer
+	b.WriteString("<" + name)
+	hasSrc := false
+	for _, a := range attrs {
+		if !containsString(allowed, a.name) && !containsString(p.Attributes, a.name) {
+			continue
+		}
+		if name == "a" && a.name == "rel" && p.LinkRel != "" {
+			continue
+		}
+		if urlAttributes[a.name] && !p.allowedUrl(a.value) {
+			continue
+		}
+		if a.name == "src" {
+			hasSrc = true
+		}
+		b.WriteString(" " + a.name + `="` + attrEscaper.Replace(a.value) + `"`)
+	}
+	if name == "img" && !hasSrc {
+		return "", false
+	}
+	if name == "a" && p.LinkRel != "" {
+		b.WriteString(` rel="` + attrEscaper.Replace(p.LinkRel) + `"`)
+	}
+	if voidElements[name] {
+		b.WriteString(" />")
+	} else {
+		b.WriteString(">")
+	}
+	return b.String(), true
+}
+
+// allowedUrl reports whether the URL is relative, or has one of the schemes
+// of the policy. Browsers ignore the spaces and control characters in the
+// schemes, so they are ignored here too.
+func (p *HtmlPolicy) allowedUrl(u string) bool {
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, u)
+	i := strings.IndexAny(u, ":/?#")
+	if i < 0 || u[i] != ':' {
+		return true
+	}
+	return containsString(p.Protocols, strings.ToLower(u[:i]))
+}
+
+// parseTag parses the start or end tag s begins with. It returns the
+// lowercase name of the element, with a leading slash for an end tag, its
+// attributes and the rest of s, or false if s does not begin with a tag.
+func parseTag(s string) (name string, attrs []htmlAttr, rest string, ok bool) {
+	i := 1
+	if strings.HasPrefix(s, "</") {
+		i = 2
+	}
+	if i >= len(s) || !isAsciiLetter(s[i]) {
+		return "", nil, s, false
+	}
+	j := i
+	for j < len(s) && !isHtmlSpace(s[j]) && s[j] != '/' && s[j] != '>' {
+		j++
+	}
+	name = strings.ToLower(s[:j][1:])
+
+	seen := make(map[string]bool)
+	for {
+		for j < len(s) && (isHtmlSpace(s[j]) || s[j] == '/') {
+			j++
+		}
+		if j >= len(s) {
+			return "", nil, s, false
+		}
+		if s[j] == '>' {
+			return name, attrs, s[j+1:], true
+		}
+		k := j
+		for k < len(s) && !isHtmlSpace(s[k]) && s[k] != '/' && s[k] != '>' && (s[k] != '=' || k == j) {
+			k++
+		}
+		attr := htmlAttr{name: strings.ToLower(s[j:k])}
+		j = k
+		for j < len(s) && isHtmlSpace(s[j]) {
+			j++
+		}
+		if j < len(s) && s[j] == '=' {
+			j++
+			for j < len(s) && isHtmlSpace(s[j]) {
+				j++
+			}
+			if j >= len(s) {
+				return "", nil, s, false
+			}
+			if q := s[j]; q == '"' || q == '\'' {
+				end := strings.IndexByte(s[j+1:], q)
+				if end < 0 {
+					return "", nil, s, false
+				}
+				attr.value = html.UnescapeString(s[j+1 : j+1+end])
+				j += end + 2
+			} else {
+				k := j
+				for k < len(s) && !isHtmlSpace(s[k]) && s[k] != '>' {
+					k++
+				}
+				attr.value = html.UnescapeString(s[j:k])
+				j = k
+			}
+		}
+		// Browsers keep the first of the attributes with the same name.
+		if !seen[attr.name] {
+			seen[attr.name] = true
+			attrs = append(attrs, attr)
+		}
+	}
+}
+
+// skipPast returns s after the first match of the ASCII sep, ignoring the
+// case, or an empty string if s does not have it.
+func skipPast(s, sep string) string {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if asciiEqualFold(s[i:i+len(sep)], sep) {
+			return s[i+len(sep):]
+		}
+	}
+	return ""
+}
+
+func asciiEqualFold(a, b string) bool {
+	for i := 0; i < len(a); i++ {
+		if asciiLower(a[i]) != asciiLower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func asciiLower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func isAsciiLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isHtmlSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
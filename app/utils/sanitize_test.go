@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// xssVectors are known ways to run scripts through HTML filters.
+var xssVectors = []string{
+	`<script>alert(1)</script>`,
+	`<SCRIPT SRC=http://xss.example/xss.js></SCRIPT>`,
+	`<scr<script>ipt>alert(1)</scr</script>ipt>`,
+	`<img src=x onerror=alert(1)>`,
+	`<IMG SRC="javascript:alert('XSS');">`,
+	`<IMG SRC=JaVaScRiPt:alert('XSS')>`,
+	`<IMG SRC=&#106;&#97;&#118;&#97;&#115;&#99;&#114;&#105;&#112;&#116;&#58;&#97;&#108;&#101;&#114;&#116;&#40;&#39;&#88;&#83;&#83;&#39;&#41;>`,
+	`<IMG SRC=&#x6A&#x61&#x76&#x61&#x73&#x63&#x72&#x69&#x70&#x74&#x3A&#x61&#x6C&#x65&#x72&#x74&#x28&#x27&#x58&#x53&#x53&#x27&#x29>`,
+	`<IMG SRC="jav	ascript:alert('XSS');">`,
+	`<IMG SRC="jav&#x0A;ascript:alert('XSS');">`,
+	`<IMG SRC=" &#14;  javascript:alert('XSS');">`,
+	`<a href="javascript:alert(1)">x</a>`,
+	`<a href="  JAVASCRIPT:alert(1)">x</a>`,
+	`<a href="vbscript:msgbox(1)">x</a>`,
+	`<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`,
+	`<a href="#" onclick="alert(1)">x</a>`,
+	`<a href=# onmouseover=alert(1)>x</a>`,
+	`<a/href="javascript:alert(1)">x</a>`,
+	`<svg onload=alert(1)>`,
+	`<svg><script>alert(1)</script></svg>`,
+	`<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`,
+	`<body onload=alert(1)>`,
+	`<iframe src="javascript:alert(1)"></iframe>`,
+	`<iframe srcdoc="<script>alert(1)</script>"></iframe>`,
+	`<object data="javascript:alert(1)"></object>`,
+	`<embed src="javascript:alert(1)">`,
+	`<form action="javascript:alert(1)"><input type=submit></form>`,
+	`<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`,
+	`<link rel="stylesheet" href="javascript:alert(1)">`,
+	`<style>@import 'javascript:alert(1)';</style>`,
+	`<div style="background:url(javascript:alert(1))">x</div>`,
+	`<p style="x:expression(alert(1))">x</p>`,
+	`<!--<img src="--><img src=x onerror=alert(1)//">`,
+	`<p title="</p><script>alert(1)</script>">x</p>`,
+	`<img src="x" alt="x"onerror="alert(1)">`,
+	`<img """><script>alert(1)</script>">`,
+	`<<script>alert(1)//<</script>`,
+	`<details open ontoggle=alert(1)>`,
+	`<input autofocus onfocus=alert(1)>`,
+	`<textarea><script>alert(1)</script></textarea>`,
+	`<base href="javascript:alert(1)//">`,
+	`&lt;script&gt;alert(1)&lt;/script&gt;`,
+	`<plaintext><script>alert(1)</script>`,
+	"<scr\x00ipt>alert(1)</scr\x00ipt>",
+	`<img src=x onerror=alert(1)`,
+}
+
+// dangerous reports whether the sanitized HTML still has a way to run a
+// script, in its elements or in the attributes of its tags.
+func dangerous(html string) bool {
+	lower := strings.ToLower(html)
+	for _, s := range []string{"<script", "<svg", "<iframe", "<object", "<embed", "<form", "<input",
+		"<meta", "<link", "<style", "<body", "<base", "<details", "<textarea", "<math"} {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+	for _, tag := range regexp.MustCompile(`<[^>]*>`).FindAllString(lower, -1) {
+		for _, s := range []string{"javascript:", "vbscript:", "data:", " on", "style="} {
+			if strings.Contains(tag, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestSanitize(t *testing.T) {
+	Convey("Remove the known XSS vectors", t, func() {
+		for _, policy := range []*HtmlPolicy{RichPolicy, CommentPolicy} {
+			for _, vector := range xssVectors {
+				html := policy.Sanitize(vector)
+				So(dangerous(html), ShouldBeFalse)
+				// Sanitizing twice changes nothing more.
+				So(policy.Sanitize(html), ShouldEqual, html)
+			}
+		}
+	})
+
+	Convey("Keep the formatting of the posts", t, func() {
+		html := `<h2 id="intro">Intro<a class="heading-anchor" href="#intro" aria-hidden="true">#</a></h2>` + "\n" +
+			`<p>Text<sup class="footnote-ref" id="fnref:1"><a rel="footnote" href="#fn:1">1</a></sup> <img src="/upload/a.png" alt="A" /></p>` + "\n" +
+			`<pre><code class="language-go"><span class="hl-keyword">func</span> f() {}</code></pre>` + "\n" +
+			`<table><thead><tr><th align="left">A</th></tr></thead><tbody><tr><td align="left">1 &lt; 2 &amp; 3</td></tr></tbody></table>`
+		So(RichPolicy.Sanitize(html), ShouldEqual, html)
+
+		html = Markdown("# Title\n\n[TOC]\n\nText[^1]\n\n```js\nvar a = 1\n```\n\n[^1]: Note\n",
+			MarkdownOptions{HeadingIDs: true, TOC: true, Footnotes: true, Highlight: true})
+		So(RichPolicy.Sanitize(html), ShouldEqual, html)
+	})
+
+	Convey("Keep only the text of the elements not allowed", t, func() {
+		So(RichPolicy.Sanitize(`<p>a <font color="red">b</font> <script>c</script>d</p>`), ShouldEqual, "<p>a b d</p>")
+		So(RichPolicy.Sanitize(`1 < 2 and 3 > 2`), ShouldEqual, "1 &lt; 2 and 3 &gt; 2")
+	})
+
+	Convey("Balance the elements", t, func() {
+		So(RichPolicy.Sanitize(`<p><b>bold<i>both</p>`), ShouldEqual, "<p><b>bold<i>both</i></b></p>")
+		So(RichPolicy.Sanitize(`</div>text</b>`), ShouldEqual, "text")
+	})
+
+	Convey("Keep the links of the comments from search engines", t, func() {
+		So(CommentPolicy.Sanitize(`<a href="https://example.com" rel="me" target="_blank">site</a>`), ShouldEqual,
+			`<a href="https://example.com" rel="nofollow ugc">site</a>`)
+		So(CommentPolicy.Sanitize(`<h1 id="x">Title</h1><img src="/a.png">`), ShouldEqual, "Title")
+		So(CommentPolicy.Sanitize(`<a href="mailto:me@example.com">me</a>`), ShouldEqual,
+			`<a href="mailto:me@example.com" rel="nofollow ugc">me</a>`)
+	})
+}
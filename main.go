@@ -2,17 +2,125 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/covrom/dingo/app"
 )
 
+const usage = `Usage: dingo [flags] [command]
+
+Commands:
+  backup [file]           Write a backup archive of the blog.
+  restore [-merge] file   Restore a backup archive, replacing the content of
+                          the blog unless -merge is given.
+  import [-engine name] [-dry-run] file
+                          Import a WordPress (WXR) or Ghost (JSON) export.
+  export-static [dir]     Render the blog to static files in dir, "public" by
+                          default. Only the files of updated posts are
+                          rewritten when exporting again.
+  migrate-media [-keep]   Move the uploaded files to the -media store, and
+                          rewrite the references to them in the posts.
+  resize-images           Generate the missing sizes of the images of the
+                          upload directory.
+  index-media             Record the files of the upload directory in the
+                          media library, and the files used by the posts.
+  render-posts            Render the HTML of the posts again, after the
+                          Markdown settings changed.
+
+Without a command, the blog is served.
+
+Flags:
+`
+
 func main() {
 	portPtr := flag.String("port", "8000", "The port number to listen to.")
-	dbUrlPtr := flag.String("database", "localhost", "The mongodb database url to use.")
+	dbUrlPtr := flag.String("database", "localhost", "The database url to use, either mongodb://host or sqlite://path.")
 	privKeyPathPtr := flag.String("priv-key", "blog.rsa", "The private key file path for JWT.")
 	pubKeyPathPtr := flag.String("pub-key", "blog.rsa.pub", "The public key file path for JWT.")
+	uploadDirPtr := flag.String("upload", "upload", "The directory of the uploaded files.")
+	mediaUrlPtr := flag.String("media", "", "The store of the uploaded files, the upload directory if empty, or s3://bucket/prefix?endpoint=url&region=name&path_style=true&public_url=url with the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	Dingo.Init(*dbUrlPtr, *privKeyPathPtr, *pubKeyPathPtr)
-	Dingo.Run(*portPtr)
+	switch flag.Arg(0) {
+	case "backup":
+		file, err := Dingo.Backup(*dbUrlPtr, flag.Arg(1), *uploadDirPtr)
+		exitOnError(err)
+		fmt.Printf("Backup written to %s\n", file)
+	case "restore":
+		restoreFlags := flag.NewFlagSet("restore", flag.ExitOnError)
+		mergePtr := restoreFlags.Bool("merge", false, "Merge the backup into the blog instead of replacing its content.")
+		restoreFlags.Parse(flag.Args()[1:])
+		if restoreFlags.NArg() != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		exitOnError(Dingo.Restore(*dbUrlPtr, restoreFlags.Arg(0), *uploadDirPtr, *mergePtr))
+		fmt.Printf("Backup %s restored\n", restoreFlags.Arg(0))
+	case "import":
+		importFlags := flag.NewFlagSet("import", flag.ExitOnError)
+		enginePtr := importFlags.String("engine", "", "The blog engine of the export, wordpress or ghost. Guessed from the file if empty.")
+		dryRunPtr := importFlags.Bool("dry-run", false, "Print what would be imported without saving anything.")
+		importFlags.Parse(flag.Args()[1:])
+		if importFlags.NArg() != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		report, err := Dingo.Import(*dbUrlPtr, importFlags.Arg(0), *enginePtr, *dryRunPtr)
+		exitOnError(err)
+		fmt.Print(report)
+	case "export-static":
+		dir := flag.Arg(1)
+		if dir == "" {
+			dir = "public"
+		}
+		report, err := Dingo.ExportStatic(*dbUrlPtr, dir, *uploadDirPtr)
+		exitOnError(err)
+		fmt.Printf("Blog exported to %s: %s\n", dir, report)
+	case "migrate-media":
+		migrateFlags := flag.NewFlagSet("migrate-media", flag.ExitOnError)
+		keepPtr := migrateFlags.Bool("keep", false, "Keep the local copies of the files.")
+		migrateFlags.Parse(flag.Args()[1:])
+		report, err := Dingo.MigrateMedia(*dbUrlPtr, *mediaUrlPtr, *uploadDirPtr, *keepPtr)
+		exitOnError(err)
+		fmt.Print(report)
+	case "resize-images":
+		keys, err := Dingo.ResizeImages(*dbUrlPtr, *uploadDirPtr)
+		exitOnError(err)
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		fmt.Printf("Images resized: %d\n", len(keys))
+	case "index-media":
+		keys, err := Dingo.IndexMedia(*dbUrlPtr, *uploadDirPtr)
+		exitOnError(err)
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		fmt.Printf("Files recorded: %d\n", len(keys))
+	case "render-posts":
+		slugs, err := Dingo.RenderPosts(*dbUrlPtr, *mediaUrlPtr, *uploadDirPtr)
+		exitOnError(err)
+		for _, slug := range slugs {
+			fmt.Println(slug)
+		}
+		fmt.Printf("Posts rendered: %d\n", len(slugs))
+	case "":
+		Dingo.Init(*dbUrlPtr, *privKeyPathPtr, *pubKeyPathPtr, *mediaUrlPtr, *uploadDirPtr)
+		Dingo.Run(*portPtr, *uploadDirPtr)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -131,20 +131,67 @@ $(document).ready(function() {
 })(jQuery.fn.removeClass);
 
 $(function () {
+    function showError(json) {
+        alertify.error(("Error: " + JSON.parse(json.responseText).msg));
+    }
+    function selectedComments() {
+        return $('.comment-select:checked').map(function () {
+            return $(this).val();
+        }).get();
+    }
+    $('#comment-select-all').on("change", function () {
+        $('.comment-select').prop("checked", $(this).prop("checked"));
+    });
+    $('.comment-bulk').on("click", function () {
+        var ids = selectedComments();
+        if (ids.length === 0) {
+            alertify.error("No comment selected");
+            return false;
+        }
+        $.ajax({
+            type: "put",
+            url: "/admin/comments/",
+            traditional: true,
+            data: {id: ids, status: $(this).data("status"), reason: $('#comment-reason').val()},
+            success: function (json) {
+                alertify.success(json.count + " comments updated");
+                window.location.reload();
+            },
+            error: showError
+        });
+        return false;
+    });
+    $('#comment-bulk-delete').on("click", function () {
+        var ids = selectedComments();
+        if (ids.length === 0) {
+            alertify.error("No comment selected");
+            return false;
+        }
+        alertify.confirm("Are you sure you want to delete " + ids.length + " comments and their replies?", function () {
+            $.ajax({
+                type: "delete",
+                url: "/admin/comments/?" + $.param({id: ids}, true),
+                success: function (json) {
+                    alertify.success(json.count + " comments deleted");
+                    window.location.reload();
+                },
+                error: showError
+            });
+        });
+        return false;
+    });
     $('.comment-delete').on("click", function () {
         var comment = $(this);
-        alertify.confirm("Are you sure you want to delete this post?", function() {
+        alertify.confirm("Are you sure you want to delete this comment?", function() {
             var id = comment.attr("rel");
             $.ajax({
                 type: "delete",
                 url: "/admin/comments/?id=" + id,
                 success: function (json) {
-                    alertify.success("Comment delted");
+                    alertify.success("Comment deleted");
                     $('#comment-' + id).remove();
                 },
-                error: function (json) {
-                    alertify.error(("Error: " + JSON.parse(json.responseText).msg));
-                }
+                error: showError
             });
         });
     });
@@ -153,19 +200,43 @@ $(function () {
         var id = $(this).attr("rel");
         $.ajax({
             type: "put",
-            url: "/admin/comments/?id=" + id,
-            "success":function(json){
-                if(json.status === "success"){
-                    alertify.success("Comment approved");
-                    comment.removeClass("comment-approve").removeClass("mdl-color-text--green").addClass("disabled").attr("disabled", true);
-                    comment.unbind();
-                }else{
-                    alertify.error(("Error: " + JSON.parse(json.responseText).msg));
-                }
-            }
+            url: "/admin/comments/",
+            data: {id: id, status: "approved"},
+            success: function (json) {
+                alertify.success("Comment approved");
+                comment.removeClass("comment-approve").removeClass("mdl-color-text--green").addClass("disabled").attr("disabled", true);
+                comment.unbind();
+            },
+            error: showError
         });
         return false;
     });
+    $('.comment-edit').on("click", function () {
+        var id = $(this).attr("rel");
+        $('#comment-' + id).after($('#comment-edit-block').detach().show());
+        $('#comment-edit-id').val(id);
+        $('#comment-edit-content').val($('#comment-' + id + ' .comment-content').attr("data-content"));
+        return false;
+    });
+    $('#comment-edit-form').on("submit", function () {
+        var id = $('#comment-edit-id').val();
+        $.ajax({
+            type: "put",
+            url: "/admin/comments/",
+            data: $(this).serialize(),
+            success: function (json) {
+                alertify.success("Comment saved");
+                $('#comment-' + id + ' .comment-content').attr("data-content", json.comment.content).html(json.comment.content);
+                $('#comment-edit-block').hide();
+            },
+            error: showError
+        });
+        return false;
+    });
+    $('#comment-edit-close').on("click", function () {
+        $('#comment-edit-block').hide();
+        $('#comment-edit-id').val("");
+    });
     $('.comment-reply').on("click",function(){
         var id = $(this).attr("rel");
         $('#comment-'+id).after($('#comment-block').detach().show());
@@ -177,9 +248,7 @@ $(function () {
             alertify.success("Succesfully replied");
             window.location.href = "/admin/comments/";
         },
-        error: function (json) {
-            alertify.error(("Error: " + JSON.parse(json.responseText).msg));
-        }
+        error: showError
     });
     $('#comment-close').on("click",function(){
         $('#comment-block').hide();
@@ -189,33 +258,73 @@ $(function () {
 });
 
 $(function () {
-  new FormValidator("post-form", [
-      {"name": "slug", "rules": "alpha_dash"}
-  ], function (errors, e) {
-    e.preventDefault();
-    $('.invalid').hide();
-    if (errors.length) {
-      $("#" + errors[0].id + "-invalid").removeClass("hide").show();
-      return;
-    }
+  // savePost saves the post, and asks before overwriting the changes saved
+  // by someone else since the post was opened.
+  function savePost() {
     $('#post-form').ajaxSubmit({
     success: function (json) {
       if (json.status === "success") {
         alertify.success("Content saved", 'success');
-        window.history.pushState({}, "", "/admin/editor/" + json.content.Id + "/");
+        $('#version').val(json.content.version);
+        window.history.pushState({}, "", "/admin/editor/" + json.content.id + "/");
       } else {
         alertify.error(json.msg);
       }
     },
     error: function (json) {
-        alertify.error(("Error: " + JSON.parse(json.responseText).msg));
+      var resp = JSON.parse(json.responseText);
+      if (json.status === 409) {
+        alertify.confirm(resp.msg + " Overwrite their changes?", function () {
+          $('#version').val(resp.current.version);
+          savePost();
+        });
+        return;
+      }
+      alertify.error(("Error: " + resp.msg));
     }
     });
+  }
+  new FormValidator("post-form", [
+      {"name": "slug", "rules": "alpha_dash"}
+  ], function (errors, e) {
+    e.preventDefault();
+    $('.invalid').hide();
+    if (errors.length) {
+      $("#" + errors[0].id + "-invalid").removeClass("hide").show();
+      return;
+    }
+    savePost();
   });
   initUpload("#post-information");
 });
 
 $(function () {
+  // deleteFile deletes the file, and asks again before deleting a file still
+  // used by posts.
+  function deleteFile(me, path, force) {
+    $.ajax({
+      "type": "delete",
+      "url": "/admin/files/?path=" + encodeURIComponent(path) + (force ? "&force=1" : ""),
+      "success": function (json) {
+        me.parent().parent().remove();
+        alertify.success("File deleted");
+      },
+      "error": function (xhr) {
+        if (xhr.status !== 409) {
+          alert("Error: " + xhr.statusText);
+          return;
+        }
+        var json = JSON.parse(xhr.responseText);
+        var titles = $.map(json.posts, function (p) {
+          return $('<div>').text(p.title).html();
+        });
+        alertify.confirm(json.msg + "<br>" + titles.join("<br>") + "<br>Delete it anyway?", function() {
+          deleteFile(me, path, true);
+        });
+      }
+    });
+  }
+
   $("#files_table").on("click", '.delete-file', function(e){
     e.preventDefault();
     
@@ -224,18 +333,7 @@ $(function () {
     var path = me.attr("rel");
     
     alertify.confirm("Are you sure you want to delete this file?", function() {
-      $.ajax({
-        "type": "delete",
-        "url": "/admin/files/?path=" + path,
-        "success": function (json) {
-          if(json.status === "success"){
-            me.parent().parent().remove();
-            alertify.success("File deleted");
-          }else{
-            alert(json.msg);
-          }
-        }
-      });
+      deleteFile(me, path, false);
     });
   });
 });
@@ -392,7 +490,7 @@ $(function () {
 function editorAction(json) {
     var cm = $('.CodeMirror')[0].CodeMirror;
     var doc = cm.getDoc();
-    doc.replaceSelections(["![](/" + json.file.url + ")"]);
+    doc.replaceSelections(["![](" + json.file.url + ")"]);
 }
 
 function filesAction(json) {
@@ -402,8 +500,9 @@ function filesAction(json) {
         + '<td class="mdl-data-table__cell--non-numeric">' + json.file.name + '</td>'
         + '<td class="mdl-data-table__cell--non-numeric">' + json.file.type + '</td>'
         + '<td class="mdl-data-table__cell--non-numeric">'
-          + '<a class="btn btn-small blue" href="/'+ json.file.url +'" target="_blank" title="/' + json.file.name + '">View</a>&nbsp;'
-          + '<a class="btn btn-small red delete-file" href="#" name="' + json.file.name + '" rel="' + json.file.url + '" title="Delete">Delete</a>'
+          + '<a class="btn btn-small blue" href="'+ json.file.url +'" target="_blank" title="/' + json.file.name + '">View</a>&nbsp;'
+          + '<a class="btn btn-small blue" href="/admin/media/' + json.file.id + '/" title="Details">Details</a>&nbsp;'
+          + '<a class="btn btn-small red delete-file" href="#" name="' + json.file.name + '" rel="' + json.file.key + '" title="Delete">Delete</a>'
         + '</td></tr>');
     $('tbody').append($fileLine);
 }
@@ -433,11 +532,17 @@ function initUpload(p) {
                         return
                     }
                     
-                    alertify.success("File has been uploaded.")
-                    bar.html("/" + json.file.url + "&nbsp;&nbsp;&nbsp;(@" + json.file.name + ")");
+                    if (json.duplicate) {
+                        alertify.success("This file was already uploaded.")
+                    } else {
+                        alertify.success("File has been uploaded.")
+                    }
+                    bar.html(json.file.url + "&nbsp;&nbsp;&nbsp;(@" + json.file.name + ")");
                     
                     if ($('.CodeMirror').length == 0) {
-                        filesAction(json);
+                        if (!json.duplicate) {
+                            filesAction(json);
+                        }
                     } else {
                         editorAction(json);
                     }
@@ -1,18 +1,65 @@
 $(function () {
+    function showError(json) {
+        alertify.error(("Error: " + JSON.parse(json.responseText).msg));
+    }
+    function selectedComments() {
+        return $('.comment-select:checked').map(function () {
+            return $(this).val();
+        }).get();
+    }
+    $('#comment-select-all').on("change", function () {
+        $('.comment-select').prop("checked", $(this).prop("checked"));
+    });
+    $('.comment-bulk').on("click", function () {
+        var ids = selectedComments();
+        if (ids.length === 0) {
+            alertify.error("No comment selected");
+            return false;
+        }
+        $.ajax({
+            type: "put",
+            url: "/admin/comments/",
+            traditional: true,
+            data: {id: ids, status: $(this).data("status"), reason: $('#comment-reason').val()},
+            success: function (json) {
+                alertify.success(json.count + " comments updated");
+                window.location.reload();
+            },
+            error: showError
+        });
+        return false;
+    });
+    $('#comment-bulk-delete').on("click", function () {
+        var ids = selectedComments();
+        if (ids.length === 0) {
+            alertify.error("No comment selected");
+            return false;
+        }
+        alertify.confirm("Are you sure you want to delete " + ids.length + " comments and their replies?", function () {
+            $.ajax({
+                type: "delete",
+                url: "/admin/comments/?" + $.param({id: ids}, true),
+                success: function (json) {
+                    alertify.success(json.count + " comments deleted");
+                    window.location.reload();
+                },
+                error: showError
+            });
+        });
+        return false;
+    });
     $('.comment-delete').on("click", function () {
         var comment = $(this);
-        alertify.confirm("Are you sure you want to delete this post?", function() {
+        alertify.confirm("Are you sure you want to delete this comment?", function() {
             var id = comment.attr("rel");
             $.ajax({
                 type: "delete",
                 url: "/admin/comments/?id=" + id,
                 success: function (json) {
-                    alertify.success("Comment delted");
+                    alertify.success("Comment deleted");
                     $('#comment-' + id).remove();
                 },
-                error: function (json) {
-                    alertify.error(("Error: " + JSON.parse(json.responseText).msg));
-                }
+                error: showError
             });
         });
     });
@@ -21,19 +68,43 @@ $(function () {
         var id = $(this).attr("rel");
         $.ajax({
             type: "put",
-            url: "/admin/comments/?id=" + id,
-            "success":function(json){
-                if(json.status === "success"){
-                    alertify.success("Comment approved");
-                    comment.removeClass("comment-approve").removeClass("mdl-color-text--green").addClass("disabled").attr("disabled", true);
-                    comment.unbind();
-                }else{
-                    alertify.error(("Error: " + JSON.parse(json.responseText).msg));
-                }
-            }
+            url: "/admin/comments/",
+            data: {id: id, status: "approved"},
+            success: function (json) {
+                alertify.success("Comment approved");
+                comment.removeClass("comment-approve").removeClass("mdl-color-text--green").addClass("disabled").attr("disabled", true);
+                comment.unbind();
+            },
+            error: showError
         });
         return false;
     });
+    $('.comment-edit').on("click", function () {
+        var id = $(this).attr("rel");
+        $('#comment-' + id).after($('#comment-edit-block').detach().show());
+        $('#comment-edit-id').val(id);
+        $('#comment-edit-content').val($('#comment-' + id + ' .comment-content').attr("data-content"));
+        return false;
+    });
+    $('#comment-edit-form').on("submit", function () {
+        var id = $('#comment-edit-id').val();
+        $.ajax({
+            type: "put",
+            url: "/admin/comments/",
+            data: $(this).serialize(),
+            success: function (json) {
+                alertify.success("Comment saved");
+                $('#comment-' + id + ' .comment-content').attr("data-content", json.comment.content).html(json.comment.content);
+                $('#comment-edit-block').hide();
+            },
+            error: showError
+        });
+        return false;
+    });
+    $('#comment-edit-close').on("click", function () {
+        $('#comment-edit-block').hide();
+        $('#comment-edit-id').val("");
+    });
     $('.comment-reply').on("click",function(){
         var id = $(this).attr("rel");
         $('#comment-'+id).after($('#comment-block').detach().show());
@@ -45,9 +116,7 @@ $(function () {
             alertify.success("Succesfully replied");
             window.location.href = "/admin/comments/";
         },
-        error: function (json) {
-            alertify.error(("Error: " + JSON.parse(json.responseText).msg));
-        }
+        error: showError
     });
     $('#comment-close').on("click",function(){
         $('#comment-block').hide();
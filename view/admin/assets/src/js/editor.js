@@ -1,26 +1,40 @@
 $(function () {
-  new FormValidator("post-form", [
-      {"name": "slug", "rules": "alpha_dash"}
-  ], function (errors, e) {
-    e.preventDefault();
-    $('.invalid').hide();
-    if (errors.length) {
-      $("#" + errors[0].id + "-invalid").removeClass("hide").show();
-      return;
-    }
+  // savePost saves the post, and asks before overwriting the changes saved
+  // by someone else since the post was opened.
+  function savePost() {
     $('#post-form').ajaxSubmit({
     success: function (json) {
       if (json.status === "success") {
         alertify.success("Content saved", 'success');
-        window.history.pushState({}, "", "/admin/editor/" + json.content.Id + "/");
+        $('#version').val(json.content.version);
+        window.history.pushState({}, "", "/admin/editor/" + json.content.id + "/");
       } else {
         alertify.error(json.msg);
       }
     },
     error: function (json) {
-        alertify.error(("Error: " + JSON.parse(json.responseText).msg));
+      var resp = JSON.parse(json.responseText);
+      if (json.status === 409) {
+        alertify.confirm(resp.msg + " Overwrite their changes?", function () {
+          $('#version').val(resp.current.version);
+          savePost();
+        });
+        return;
+      }
+      alertify.error(("Error: " + resp.msg));
     }
     });
+  }
+  new FormValidator("post-form", [
+      {"name": "slug", "rules": "alpha_dash"}
+  ], function (errors, e) {
+    e.preventDefault();
+    $('.invalid').hide();
+    if (errors.length) {
+      $("#" + errors[0].id + "-invalid").removeClass("hide").show();
+      return;
+    }
+    savePost();
   });
   initUpload("#post-information");
 });
@@ -1,4 +1,30 @@
 $(function () {
+  // deleteFile deletes the file, and asks again before deleting a file still
+  // used by posts.
+  function deleteFile(me, path, force) {
+    $.ajax({
+      "type": "delete",
+      "url": "/admin/files/?path=" + encodeURIComponent(path) + (force ? "&force=1" : ""),
+      "success": function (json) {
+        me.parent().parent().remove();
+        alertify.success("File deleted");
+      },
+      "error": function (xhr) {
+        if (xhr.status !== 409) {
+          alert("Error: " + xhr.statusText);
+          return;
+        }
+        var json = JSON.parse(xhr.responseText);
+        var titles = $.map(json.posts, function (p) {
+          return $('<div>').text(p.title).html();
+        });
+        alertify.confirm(json.msg + "<br>" + titles.join("<br>") + "<br>Delete it anyway?", function() {
+          deleteFile(me, path, true);
+        });
+      }
+    });
+  }
+
   $("#files_table").on("click", '.delete-file', function(e){
     e.preventDefault();
     
@@ -7,18 +33,7 @@ $(function () {
     var path = me.attr("rel");
     
     alertify.confirm("Are you sure you want to delete this file?", function() {
-      $.ajax({
-        "type": "delete",
-        "url": "/admin/files/?path=" + path,
-        "success": function (json) {
-          if(json.status === "success"){
-            me.parent().parent().remove();
-            alertify.success("File deleted");
-          }else{
-            alert(json.msg);
-          }
-        }
-      });
+      deleteFile(me, path, false);
     });
   });
 });
@@ -1,7 +1,7 @@
 function editorAction(json) {
     var cm = $('.CodeMirror')[0].CodeMirror;
     var doc = cm.getDoc();
-    doc.replaceSelections(["![](/" + json.file.url + ")"]);
+    doc.replaceSelections(["![](" + json.file.url + ")"]);
 }
 
 function filesAction(json) {
@@ -11,8 +11,9 @@ function filesAction(json) {
         + '<td class="mdl-data-table__cell--non-numeric">' + json.file.name + '</td>'
         + '<td class="mdl-data-table__cell--non-numeric">' + json.file.type + '</td>'
         + '<td class="mdl-data-table__cell--non-numeric">'
-          + '<a class="btn btn-small blue" href="/'+ json.file.url +'" target="_blank" title="/' + json.file.name + '">View</a>&nbsp;'
-          + '<a class="btn btn-small red delete-file" href="#" name="' + json.file.name + '" rel="' + json.file.url + '" title="Delete">Delete</a>'
+          + '<a class="btn btn-small blue" href="'+ json.file.url +'" target="_blank" title="/' + json.file.name + '">View</a>&nbsp;'
+          + '<a class="btn btn-small blue" href="/admin/media/' + json.file.id + '/" title="Details">Details</a>&nbsp;'
+          + '<a class="btn btn-small red delete-file" href="#" name="' + json.file.name + '" rel="' + json.file.key + '" title="Delete">Delete</a>'
         + '</td></tr>');
     $('tbody').append($fileLine);
 }
@@ -42,11 +43,17 @@ function initUpload(p) {
                         return
                     }
                     
-                    alertify.success("File has been uploaded.")
-                    bar.html("/" + json.file.url + "&nbsp;&nbsp;&nbsp;(@" + json.file.name + ")");
+                    if (json.duplicate) {
+                        alertify.success("This file was already uploaded.")
+                    } else {
+                        alertify.success("File has been uploaded.")
+                    }
+                    bar.html(json.file.url + "&nbsp;&nbsp;&nbsp;(@" + json.file.name + ")");
                     
                     if ($('.CodeMirror').length == 0) {
-                        filesAction(json);
+                        if (!json.duplicate) {
+                            filesAction(json);
+                        }
                     } else {
                         editorAction(json);
                     }
@@ -8,6 +8,11 @@ function initComment() {
     if (!$list.length) {
         return;
     }
+    if (!$('#comment-form').length) {
+        // The comments are closed.
+        $list.find('.comment-reply').remove();
+        return;
+    }
     if (localStorage.getItem("comment-author")) {
         $('#author').val(localStorage.getItem("comment-author"));
         $('#email').val(localStorage.getItem("comment-email"));
@@ -40,12 +45,11 @@ function initComment() {
             tpl.find(".comment-avatar").attr("src", json.comment.avatar).attr("alt", json.comment.avatar);
             tpl.find(".comment-name").attr("href", json.comment.website).text(json.comment.author);
             tpl.find(".comment-reply").attr("rel", json.comment.id);
-            tpl.find(".comment-content").html("<p>" + json.comment.content + "</p>");
-            tpl.find(".comment-message").html("Your comment is awaiting moderation.");
-            tpl.attr("id", "comment-" + json.comment.id);
-            if (json.comment.status == "approved") {
-                tpl.find(".comment-check").remove();
+            tpl.find(".comment-content").html(json.comment.content);
+            if (!json.comment.approved) {
+                tpl.find(".comment-message").html("Your comment is awaiting moderation.");
             }
+            tpl.attr("id", "comment-" + json.comment.id);
             var parentId = $('#comment-parent').val();
             if (parentId === '0') {
                 $('#comment-list').append(tpl);
@@ -54,10 +58,25 @@ function initComment() {
             }
             $('#comment-cancel').trigger("click");
             $('#comment-content').val("");
+            $('#comment-preview').empty().addClass("hide");
         } else {
             alert("Can not submit comment!");
         }
     });
+    var previewTimer;
+    $('#comment-content').on("input", function () {
+        clearTimeout(previewTimer);
+        var text = $(this).val();
+        previewTimer = setTimeout(function () {
+            if (!$.trim(text)) {
+                $('#comment-preview').empty().addClass("hide");
+                return;
+            }
+            $.post("/comment/preview/", {comment: text}, function (json) {
+                $('#comment-preview').html(json.html).removeClass("hide");
+            });
+        }, 500);
+    });
     $list.on("click", ".comment-reply", function () {
         var id = $(this).attr("rel");
         var parentComment = $('#comment-' + id);